@@ -0,0 +1,58 @@
+// Command console is an operator console for a running order manager. It
+// places, amends and cancels orders and shows the book and recent trades
+// through the REST API.
+//
+// Without arguments it reads commands interactively. Commands can also be
+// given as arguments, separated by ';', or read from a script with -f, in
+// which case the first failing command stops the run with a non-zero exit.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mfus_OMV1/internal/console"
+	"os"
+	"strings"
+)
+
+func main() {
+	addr := flag.String("addr", "http://localhost:8080", "base URL of the order manager")
+	keyID := flag.String("key", "", "API key ID")
+	secret := flag.String("secret", "", "API key secret")
+	token := flag.String("token", "", "JWT to use instead of an API key")
+	symbol := flag.String("symbol", "BTC-USD", "initial symbol")
+	script := flag.String("f", "", "script file to run, - for stdin")
+	flag.Parse()
+
+	client := console.NewClient(*addr)
+	client.KeyID = *keyID
+	client.Secret = *secret
+	client.Token = *token
+	c := console.New(client, strings.ToUpper(*symbol), os.Stdout)
+
+	var err error
+	switch {
+	case flag.NArg() > 0:
+		err = c.Run(strings.NewReader(strings.ReplaceAll(strings.Join(flag.Args(), " "), ";", "\n")), false)
+	case *script != "":
+		var in io.Reader = os.Stdin
+		if *script != "-" {
+			file, openErr := os.Open(*script)
+			if openErr != nil {
+				log.Fatal(openErr)
+			}
+			defer file.Close()
+			in = file
+		}
+		err = c.Run(in, false)
+	default:
+		fmt.Println("type help for the list of commands")
+		err = c.Run(os.Stdin, true)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,75 @@
+// Command fixclient is a local FIX initiator for trying the FIX gateway
+// without an external venue. It logs on with an API key, sends one limit
+// order, optionally cancels it, and prints every message it receives.
+package main
+
+import (
+	"flag"
+	"log"
+	"mfus_OMV1/internal/fix"
+	"strconv"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9878", "address of the FIX acceptor")
+	compID := flag.String("sender", "CLIENT", "SenderCompID of this initiator")
+	targetCompID := flag.String("target", "MFUS", "CompID of the acceptor")
+	keyID := flag.String("key", "", "API key ID")
+	secret := flag.String("secret", "", "API key secret")
+	symbol := flag.String("symbol", "BTC-USD", "order symbol")
+	side := flag.String("side", "1", "order side, 1 buy or 2 sell")
+	quantity := flag.Int64("qty", 1, "order quantity")
+	price := flag.Float64("price", 0, "limit price")
+	cancel := flag.Bool("cancel", false, "cancel the order after it is acknowledged")
+	wait := flag.Duration("wait", 5*time.Second, "how long to wait for execution reports")
+	flag.Parse()
+
+	initiator, err := fix.Dial(*addr, *compID, *targetCompID, *keyID, *secret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := initiator.Logon(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("logged on to %s as %s", *addr, *compID)
+
+	clOrdID := strconv.FormatInt(time.Now().UnixNano(), 10)
+	order := fix.NewMessage(fix.MsgNewOrderSingle).
+		Set(fix.TagClOrdID, clOrdID).
+		Set(fix.TagSymbol, *symbol).
+		Set(fix.TagSide, *side).
+		SetInt(fix.TagOrderQty, *quantity).
+		Set(fix.TagOrdType, "2").
+		SetFloat(fix.TagPrice, *price).
+		SetTime(fix.TagTransactTime, time.Now())
+	if err := initiator.Send(order); err != nil {
+		log.Fatal(err)
+	}
+
+	deadline := time.Now().Add(*wait)
+	for time.Now().Before(deadline) {
+		message, err := initiator.Receive(time.Until(deadline))
+		if err != nil {
+			break
+		}
+		log.Printf("received %s", message)
+
+		if *cancel && message.Type() == fix.MsgExecutionReport && message.Get(fix.TagExecType) == "0" {
+			cancelRequest := fix.NewMessage(fix.MsgOrderCancelRequest).
+				Set(fix.TagClOrdID, clOrdID+"-C").
+				Set(fix.TagOrigClOrdID, clOrdID).
+				Set(fix.TagOrderID, message.Get(fix.TagOrderID)).
+				Set(fix.TagSymbol, *symbol).
+				Set(fix.TagSide, *side).
+				SetTime(fix.TagTransactTime, time.Now())
+			if err := initiator.Send(cancelRequest); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	if err := initiator.Logout(); err != nil {
+		log.Fatal(err)
+	}
+}
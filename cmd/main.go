@@ -1,20 +1,212 @@
 package main
 
 import (
+	"context"
 	"log"
+	"mfus_OMV1/internal/accounts"
+	"mfus_OMV1/internal/api"
+	"mfus_OMV1/internal/audit"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/internal/config"
+	"mfus_OMV1/internal/fees"
+	"mfus_OMV1/internal/fix"
+	"mfus_OMV1/internal/grpcapi"
+	"mfus_OMV1/internal/health"
+	"mfus_OMV1/internal/leader"
+	"mfus_OMV1/internal/logging"
+	"mfus_OMV1/internal/marketdata"
+	"mfus_OMV1/internal/metrics"
 	"mfus_OMV1/internal/orderbook"
+	"mfus_OMV1/internal/positions"
+	"mfus_OMV1/internal/ratelimit"
+	"mfus_OMV1/internal/shard"
 	"mfus_OMV1/pkg/database"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
+// shutdownReason is sent to WebSocket and FIX sessions when they are closed
+const shutdownReason = "server shutting down"
+
 func main() {
 
-	// create MongoDB client
-	RedisClient, err := database.NewRedisClient()
+	// Load the configuration once, every package reads its settings from it
+	cfg, err := config.Load(os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer RedisClient.Close()
+	if err := logging.Configure(cfg.Log.Level, cfg.Log.Components); err != nil {
+		log.Fatal(err)
+	}
+	logger := logging.Logger("main")
+	fatal := func(msg string, err error) {
+		logger.Error(msg, "error", err)
+		os.Exit(1)
+	}
+	// SIGTERM starts a graceful shutdown, a second signal kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	database.Configure(cfg)
+	orderbook.Configure(cfg)
+	accounts.Configure(cfg)
+	audit.Configure(cfg)
+	auth.Configure(cfg)
+	fees.Configure(cfg)
+	fix.Configure(cfg)
+	leader.Configure(cfg)
+	marketdata.Configure(cfg)
+	positions.Configure(cfg)
+	ratelimit.Configure(cfg)
+	shard.Configure(cfg)
+
+	// The Redis client connects per command, so it is usable before Redis is up
+	RedisClient := database.GetRedisClient()
+
+	// Share rate limit counters with other instances through Redis
+	ratelimit.Init(RedisClient)
+	// Detect replayed request nonces across instances
+	auth.Init(RedisClient)
+	// Elect the instance that matches each symbol
+	leader.Init(RedisClient)
+	// Register this node in the ring the symbols are partitioned over
+	shard.Init(RedisClient)
+
+	// Probes answer from the first moment, the service stays not ready until
+	// every dependency is reachable and the matcher runs
+	health.RegisterReadiness("mongo", health.Ping(database.PingMongo))
+	health.RegisterReadiness("redis", health.Ping(database.PingRedis))
+	health.RegisterReadiness("matcher", orderbook.CheckMatcher)
+	health.RegisterReadiness("journal", orderbook.CheckJournal)
+	// Reading the kill switch on every run also shows a change made on another instance
+	health.RegisterReadiness("kill_switch", health.Ping(orderbook.RefreshKillSwitch))
+	// Shows the symbols this instance leads, a standby is ready all the same
+	health.RegisterReadiness("leader", leader.CheckLeases)
+	health.RegisterReadiness("shard", shard.CheckShard)
+	health.RegisterLiveness("matcher_loop", orderbook.CheckMatcherLoop)
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	go health.Monitor(monitorCtx, cfg.Health.Interval, cfg.Health.Timeout)
+
+	// Serve the REST API alongside the matcher
+	httpServer := &http.Server{Addr: cfg.Server.HTTPAddr, Handler: api.NewRouter()}
+	go func() {
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			fatal("REST API stopped", err)
+		}
+	}()
+
+	// Wait for the dependencies instead of exiting while they start
+	if err := database.WaitFor(ctx, "redis", database.PingRedis); err != nil {
+		fatal("cannot connect to Redis", err)
+	}
+	if err := database.WaitFor(ctx, "mongo", database.PingMongo); err != nil {
+		fatal("cannot connect to MongoDB", err)
+	}
+
+	// Indexes backing the matcher and query APIs
+	mongoClient, err := database.GetMongoClient()
+	if err != nil {
+		fatal("cannot connect to MongoDB", err)
+	}
+	if err := orderbook.EnsureIndexes(context.Background(), mongoClient); err != nil {
+		fatal("cannot create order indexes", err)
+	}
+	if err := marketdata.EnsureIndexes(context.Background(), mongoClient); err != nil {
+		fatal("cannot create market data indexes", err)
+	}
+	if err := fix.EnsureIndexes(context.Background(), mongoClient); err != nil {
+		fatal("cannot create FIX indexes", err)
+	}
+	if err := audit.EnsureIndexes(context.Background(), mongoClient); err != nil {
+		fatal("cannot create audit log indexes", err)
+	}
+	if err := positions.EnsureIndexes(context.Background(), mongoClient); err != nil {
+		fatal("cannot create position indexes", err)
+	}
+	// Normalise status names written before the status state machine
+	if err := orderbook.MigrateOrderStatuses(context.Background(), mongoClient); err != nil {
+		fatal("cannot migrate order statuses", err)
+	}
+
+	// Engine state sampled when /metrics is scraped
+	metrics.Register(orderbook.Collector())
+
+	// Trade consumers
+	positions.Start()
+	marketdata.Start()
+
+	// Serve the gRPC API for low latency clients
+	grpcServer, err := grpcapi.NewServer(cfg.Server)
+	if err != nil {
+		fatal("cannot start gRPC API", err)
+	}
+	go func() {
+		if err := grpcapi.ListenAndServe(grpcServer, cfg.Server.GRPCAddr); err != nil {
+			fatal("gRPC API stopped", err)
+		}
+	}()
+	// and the FIX gateway for institutional clients
+	fixAcceptor := fix.NewAcceptor(cfg.Server.FIXCompID)
+	go func() {
+		if err := fixAcceptor.ListenAndServe(cfg.Server.FIXAddr); err != fix.ErrAcceptorClosed {
+			fatal("FIX gateway stopped", err)
+		}
+	}()
+
+	// The matcher has its own context so that it only stops after order entry
+	matcherCtx, stopMatcher := context.WithCancel(context.Background())
+	matcherDone := make(chan struct{})
+	go leader.Run(matcherCtx)
+	go shard.Run(matcherCtx, orderbook.ActiveSymbols)
+	go func() {
+		orderbook.StartLimitOrderMatch(matcherCtx)
+		close(matcherDone)
+	}()
+	health.SetStarted()
+	logger.Info("order manager started", "http", cfg.Server.HTTPAddr, "grpc", cfg.Server.GRPCAddr, "fix", cfg.Server.FIXAddr,
+		"instance", leader.InstanceID())
+
+	<-ctx.Done()
+	stop()
+	logger.Info("shutting down", "timeout", cfg.Server.ShutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	failed := false
+	check := func(msg string, err error) {
+		if err != nil {
+			logger.Error(msg, "error", err)
+			failed = true
+		}
+	}
+
+	// Refuse new orders, then let the matcher match the orders already accepted
+	health.SetStopping()
+	orderbook.StopOrderEntry()
+	stopMatcher()
+	select {
+	case <-matcherDone:
+	case <-shutdownCtx.Done():
+		check("matcher did not stop", shutdownCtx.Err())
+	}
+	// Hand the last trades to positions and market data and record the books
+	check("cannot drain order books", orderbook.Drain(shutdownCtx, mongoClient))
+	// Let the other nodes take the symbols over without waiting for the leases
+	// and the heartbeat to expire
+	check("cannot leave the ring", shard.Leave(shutdownCtx))
+	check("cannot release leases", leader.Resign(shutdownCtx))
 
-	orderbook.StartLimitOrderMatch()
+	// Flush what is queued for every session before closing it
+	orderbook.CloseWebSockets(shutdownCtx, shutdownReason)
+	check("cannot close FIX sessions", fixAcceptor.Shutdown(shutdownCtx, shutdownReason))
+	check("cannot stop gRPC API", grpcapi.Shutdown(shutdownCtx, grpcServer))
+	check("cannot stop REST API", httpServer.Shutdown(shutdownCtx))
 
+	stopMonitor()
+	check("cannot disconnect databases", database.Disconnect(shutdownCtx))
+	if failed {
+		os.Exit(1)
+	}
+	logger.Info("order manager stopped")
 }
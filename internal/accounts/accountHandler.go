@@ -0,0 +1,108 @@
+package accounts
+
+import (
+	"context"
+	"encoding/json"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/pkg/database"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type depositRequest struct {
+	Asset  string  `json:"asset"`
+	Amount float64 `json:"amount"`
+	Ref    string  `json:"ref"`
+}
+
+func GetBalancesHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	if !auth.AuthorizeUser(w, r, params["userID"]) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	balances, err := GetBalances(ctx, client, params["userID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(balances)
+}
+
+func DepositHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	var req depositRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Asset == "" || req.Amount <= 0 {
+		http.Error(w, "asset and a positive amount are required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = Deposit(ctx, client, params["userID"], strings.ToUpper(req.Asset), req.Amount, req.Ref)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	balances, err := GetBalances(ctx, client, params["userID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(balances)
+}
+
+func GetLedgerHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	if !auth.AuthorizeUser(w, r, params["userID"]) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	entries, err := GetLedger(ctx, client, params["userID"], 500)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entries)
+}
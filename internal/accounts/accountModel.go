@@ -0,0 +1,84 @@
+package accounts
+
+import (
+	"errors"
+	"mfus_OMV1/internal/config"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Define the database and collection names
+var dbName = "orderbook"
+var balancesCollection = "balances"
+var ledgerCollection = "ledger"
+
+// Configure applies the database and collection names of the loaded config
+func Configure(cfg *config.Config) {
+	dbName = cfg.Mongo.Database
+	balancesCollection = cfg.Collections.Balances
+	ledgerCollection = cfg.Collections.Ledger
+}
+
+// Balance buckets held for every owner and asset
+const (
+	Available = "available"
+	Reserved  = "reserved"
+)
+
+// ExternalOwner is the contra account for funds entering or leaving the system
+const ExternalOwner = "@external"
+
+// FeeRevenueOwner collects trading fees and pays out maker rebates
+const FeeRevenueOwner = "@fees"
+
+// isSystemOwner reports whether an owner is one of the system accounts, whose
+// balances may go negative
+func isSystemOwner(owner string) bool {
+	return strings.HasPrefix(owner, "@")
+}
+
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// BalanceModel is the current balance of one asset held by a user
+type BalanceModel struct {
+	ID         primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	UserID     string             `json:"userID" bson:"userID"`
+	Asset      string             `json:"asset" bson:"asset"`
+	Available  float64            `json:"available" bson:"available"`
+	Reserved   float64            `json:"reserved" bson:"reserved"`
+	UpdateTime int64              `json:"updateTime" bson:"updateTime"`
+}
+
+// LedgerEntryModel is one leg of a double-entry ledger transaction.
+// The amounts of all entries sharing a TxID always sum to zero.
+type LedgerEntryModel struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TxID         string             `json:"txID" bson:"txID"`
+	Type         string             `json:"type" bson:"type"`
+	Owner        string             `json:"owner" bson:"owner"`
+	Asset        string             `json:"asset" bson:"asset"`
+	Bucket       string             `json:"bucket" bson:"bucket"`
+	Amount       float64            `json:"amount" bson:"amount"`
+	Ref          string             `json:"ref" bson:"ref"`
+	CreationTime int64              `json:"creationTime" bson:"creationTime"`
+}
+
+// Posting is a single balance movement inside a ledger transaction
+type Posting struct {
+	Owner  string
+	Asset  string
+	Bucket string
+	Amount float64
+}
+
+// SymbolAssets splits a symbol such as "BTC-USD" or "BTC/USD" into its base and quote assets
+func SymbolAssets(symbol string) (string, string, error) {
+	parts := strings.FieldsFunc(symbol, func(r rune) bool {
+		return r == '-' || r == '/' || r == '_'
+	})
+	if len(parts) != 2 {
+		return "", "", errors.New("invalid symbol " + symbol + ", expected BASE-QUOTE")
+	}
+	return strings.ToUpper(parts[0]), strings.ToUpper(parts[1]), nil
+}
@@ -0,0 +1,217 @@
+package accounts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"mfus_OMV1/pkg/database"
+	"mfus_OMV1/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Settlement describes the balance movements caused by one trade
+type Settlement struct {
+	TradeID  string
+	Symbol   string
+	BuyerID  string
+	SellerID string
+	Quantity int64
+	Price    float64
+	// BuyLimitPrice is the price the buyer's funds were reserved at
+	BuyLimitPrice float64
+	// BuyFee is charged in BuyFeeAsset and SellFee in SellFeeAsset, each
+	// either asset of the symbol. Negative fees are rebates.
+	BuyFee       float64
+	BuyFeeAsset  string
+	SellFee      float64
+	SellFeeAsset string
+}
+
+// Deposit credits a user's available balance from the external account
+func Deposit(ctx context.Context, mongoClient *mongo.Client, userID string, asset string, amount float64, ref string) error {
+	if amount <= 0 {
+		return errors.New("deposit amount must be positive")
+	}
+	return Post(ctx, mongoClient, "deposit", ref, []Posting{
+		{Owner: userID, Asset: asset, Bucket: Available, Amount: amount},
+		{Owner: ExternalOwner, Asset: asset, Bucket: Available, Amount: -amount},
+	})
+}
+
+// Reserve moves funds from available to reserved, failing if the user cannot afford it
+func Reserve(ctx context.Context, mongoClient *mongo.Client, userID string, asset string, amount float64, ref string) error {
+	if amount <= 0 {
+		return nil
+	}
+	collection := mongoClient.Database(dbName).Collection(balancesCollection)
+	filter := bson.M{"userID": userID, "asset": asset, Available: bson.M{"$gte": amount}}
+	update := bson.M{
+		"$inc": bson.M{Available: -amount, Reserved: amount},
+		"$set": bson.M{"updateTime": utils.GetCurrentTimestamp()},
+	}
+	return database.Transaction(ctx, mongoClient, func(ctx context.Context) error {
+		result, err := collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 0 {
+			return ErrInsufficientFunds
+		}
+		return insertEntries(ctx, mongoClient, "reserve", ref, []Posting{
+			{Owner: userID, Asset: asset, Bucket: Available, Amount: -amount},
+			{Owner: userID, Asset: asset, Bucket: Reserved, Amount: amount},
+		})
+	})
+}
+
+// Release returns reserved funds to the available balance
+func Release(ctx context.Context, mongoClient *mongo.Client, userID string, asset string, amount float64, ref string) error {
+	if amount <= 0 {
+		return nil
+	}
+	return Post(ctx, mongoClient, "release", ref, []Posting{
+		{Owner: userID, Asset: asset, Bucket: Reserved, Amount: -amount},
+		{Owner: userID, Asset: asset, Bucket: Available, Amount: amount},
+	})
+}
+
+// SettleTrade converts the reservations of both sides of a trade into settled balances
+func SettleTrade(ctx context.Context, mongoClient *mongo.Client, s Settlement) error {
+	base, quote, err := SymbolAssets(s.Symbol)
+	if err != nil {
+		return err
+	}
+	for _, asset := range []string{s.BuyFeeAsset, s.SellFeeAsset} {
+		if asset != base && asset != quote {
+			return errors.New("fee asset " + asset + " is not traded in " + s.Symbol)
+		}
+	}
+	qty := float64(s.Quantity)
+	cost := qty * s.Price
+	reserved := qty * s.BuyLimitPrice
+
+	return Post(ctx, mongoClient, "trade", s.TradeID, []Posting{
+		// Buyer pays quote out of the reservation and gets back any price improvement
+		{Owner: s.BuyerID, Asset: quote, Bucket: Reserved, Amount: -reserved},
+		{Owner: s.BuyerID, Asset: quote, Bucket: Available, Amount: reserved - cost},
+		{Owner: s.SellerID, Asset: quote, Bucket: Available, Amount: cost},
+		// Seller delivers base out of the reservation
+		{Owner: s.SellerID, Asset: base, Bucket: Reserved, Amount: -qty},
+		{Owner: s.BuyerID, Asset: base, Bucket: Available, Amount: qty},
+		// Fees move from each side to the fee revenue account, rebates back
+		{Owner: s.BuyerID, Asset: s.BuyFeeAsset, Bucket: Available, Amount: -s.BuyFee},
+		{Owner: FeeRevenueOwner, Asset: s.BuyFeeAsset, Bucket: Available, Amount: s.BuyFee},
+		{Owner: s.SellerID, Asset: s.SellFeeAsset, Bucket: Available, Amount: -s.SellFee},
+		{Owner: FeeRevenueOwner, Asset: s.SellFeeAsset, Bucket: Available, Amount: s.SellFee},
+	})
+}
+
+// tolerance absorbs the rounding of amounts computed in floating point
+const tolerance = 1e-9
+
+// Post applies a balanced set of postings and records them in the ledger, all
+// of them or none. A debit that would take a user's balance below zero fails
+// the transaction with ErrInsufficientFunds, the system accounts may go
+// negative.
+func Post(ctx context.Context, mongoClient *mongo.Client, txType string, ref string, postings []Posting) error {
+	if err := checkBalanced(postings); err != nil {
+		return err
+	}
+
+	collection := mongoClient.Database(dbName).Collection(balancesCollection)
+	now := utils.GetCurrentTimestamp()
+	return database.Transaction(ctx, mongoClient, func(ctx context.Context) error {
+		for _, p := range postings {
+			if p.Amount == 0 {
+				continue
+			}
+			filter := bson.M{"userID": p.Owner, "asset": p.Asset}
+			guarded := p.Amount < 0 && !isSystemOwner(p.Owner)
+			if guarded {
+				filter[p.Bucket] = bson.M{"$gte": -p.Amount - tolerance}
+			}
+			result, err := collection.UpdateOne(ctx, filter,
+				bson.M{"$inc": bson.M{p.Bucket: p.Amount}, "$set": bson.M{"updateTime": now}},
+				options.Update().SetUpsert(!guarded),
+			)
+			if err != nil {
+				return err
+			}
+			if guarded && result.MatchedCount == 0 {
+				return fmt.Errorf("%w: %s %s of %s", ErrInsufficientFunds, p.Asset, p.Bucket, p.Owner)
+			}
+		}
+		return insertEntries(ctx, mongoClient, txType, ref, postings)
+	})
+}
+
+// checkBalanced fails unless the postings of every asset sum to zero
+func checkBalanced(postings []Posting) error {
+	sums := make(map[string]float64)
+	for _, p := range postings {
+		sums[p.Asset] += p.Amount
+	}
+	for asset, sum := range sums {
+		if math.Abs(sum) > tolerance {
+			return errors.New("unbalanced ledger transaction for " + asset)
+		}
+	}
+	return nil
+}
+
+func insertEntries(ctx context.Context, mongoClient *mongo.Client, txType string, ref string, postings []Posting) error {
+	txID := utils.NewID()
+	now := utils.GetCurrentTimestamp()
+	entries := make([]interface{}, 0, len(postings))
+	for _, p := range postings {
+		if p.Amount == 0 {
+			continue
+		}
+		entries = append(entries, LedgerEntryModel{
+			TxID:         txID,
+			Type:         txType,
+			Owner:        p.Owner,
+			Asset:        p.Asset,
+			Bucket:       p.Bucket,
+			Amount:       p.Amount,
+			Ref:          ref,
+			CreationTime: now,
+		})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	_, err := mongoClient.Database(dbName).Collection(ledgerCollection).InsertMany(ctx, entries)
+	return err
+}
+
+// GetBalances returns every asset balance held by a user
+func GetBalances(ctx context.Context, mongoClient *mongo.Client, userID string) ([]BalanceModel, error) {
+	cursor, err := mongoClient.Database(dbName).Collection(balancesCollection).Find(ctx, bson.M{"userID": userID})
+	if err != nil {
+		return nil, err
+	}
+	balances := make([]BalanceModel, 0)
+	if err := cursor.All(ctx, &balances); err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
+
+// GetLedger returns the ledger entries posted to a user's accounts, newest first
+func GetLedger(ctx context.Context, mongoClient *mongo.Client, userID string, limit int64) ([]LedgerEntryModel, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "creationTime", Value: -1}}).SetLimit(limit)
+	cursor, err := mongoClient.Database(dbName).Collection(ledgerCollection).Find(ctx, bson.M{"owner": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]LedgerEntryModel, 0)
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,156 @@
+package accounts
+
+import (
+	"context"
+	"errors"
+	"mfus_OMV1/internal/config"
+	"mfus_OMV1/pkg/database"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// testMongo points the ledger at a scratch database, which is dropped when
+// the test ends. The test is skipped without a MongoDB, set MFUS_MONGO_URI to
+// run it against a replica set.
+func testMongo(t *testing.T) *mongo.Client {
+	t.Helper()
+	cfg := config.Default()
+	if uri := os.Getenv(config.EnvPrefix + "_MONGO_URI"); uri != "" {
+		cfg.Mongo.URI = uri
+	}
+	cfg.Mongo.Database = "mfus_accounts_test"
+	database.Configure(cfg)
+	Configure(cfg)
+
+	client, err := database.GetMongoClient()
+	if err != nil {
+		t.Skipf("MongoDB unavailable: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Skipf("MongoDB unavailable: %v", err)
+	}
+	t.Cleanup(func() {
+		client.Database(cfg.Mongo.Database).Drop(context.Background())
+	})
+	return client
+}
+
+func TestCheckBalanced(t *testing.T) {
+	tests := []struct {
+		name     string
+		postings []Posting
+		balanced bool
+	}{
+		{"empty", nil, true},
+		{"transfer", []Posting{
+			{Owner: "alice", Asset: "USD", Bucket: Available, Amount: -10},
+			{Owner: "alice", Asset: "USD", Bucket: Reserved, Amount: 10},
+		}, true},
+		{"two assets", []Posting{
+			{Owner: "alice", Asset: "USD", Bucket: Available, Amount: -10},
+			{Owner: "bob", Asset: "USD", Bucket: Available, Amount: 10},
+			{Owner: "bob", Asset: "BTC", Bucket: Reserved, Amount: -1},
+			{Owner: "alice", Asset: "BTC", Bucket: Available, Amount: 1},
+		}, true},
+		{"rounding", []Posting{
+			{Owner: "alice", Asset: "USD", Bucket: Available, Amount: 0.1 + 0.2},
+			{Owner: "bob", Asset: "USD", Bucket: Available, Amount: -0.3},
+		}, true},
+		{"one sided", []Posting{
+			{Owner: "alice", Asset: "USD", Bucket: Available, Amount: 10},
+		}, false},
+		{"balanced across assets only", []Posting{
+			{Owner: "alice", Asset: "USD", Bucket: Available, Amount: -10},
+			{Owner: "alice", Asset: "BTC", Bucket: Available, Amount: 10},
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := checkBalanced(test.postings); (err == nil) != test.balanced {
+				t.Fatalf("checkBalanced() = %v, want balanced %v", err, test.balanced)
+			}
+		})
+	}
+}
+
+func TestSymbolAssets(t *testing.T) {
+	tests := []struct {
+		symbol string
+		base   string
+		quote  string
+		valid  bool
+	}{
+		{"BTC-USD", "BTC", "USD", true},
+		{"eth/usdt", "ETH", "USDT", true},
+		{"SOL_EUR", "SOL", "EUR", true},
+		{"BTCUSD", "", "", false},
+		{"BTC-USD-PERP", "", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.symbol, func(t *testing.T) {
+			base, quote, err := SymbolAssets(test.symbol)
+			if (err == nil) != test.valid || base != test.base || quote != test.quote {
+				t.Fatalf("SymbolAssets(%q) = %q, %q, %v", test.symbol, base, quote, err)
+			}
+		})
+	}
+}
+
+func TestPostingsRefuseOverdraw(t *testing.T) {
+	client := testMongo(t)
+	ctx := context.Background()
+	if err := Deposit(ctx, client, "alice", "USD", 100, "deposit-1"); err != nil {
+		t.Fatalf("deposit: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		apply     func() error
+		overdraw  bool
+		available float64
+		reserved  float64
+	}{
+		{"reserve within the balance", func() error {
+			return Reserve(ctx, client, "alice", "USD", 60, "order-1")
+		}, false, 40, 60},
+		{"reserve more than is available", func() error {
+			return Reserve(ctx, client, "alice", "USD", 41, "order-2")
+		}, true, 40, 60},
+		{"release more than is reserved", func() error {
+			return Release(ctx, client, "alice", "USD", 61, "order-1")
+		}, true, 40, 60},
+		{"transfer more than is available", func() error {
+			return Post(ctx, client, "transfer", "transfer-1", []Posting{
+				{Owner: "alice", Asset: "USD", Bucket: Available, Amount: -50},
+				{Owner: "bob", Asset: "USD", Bucket: Available, Amount: 50},
+			})
+		}, true, 40, 60},
+		{"release what is reserved", func() error {
+			return Release(ctx, client, "alice", "USD", 60, "order-1")
+		}, false, 100, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.apply()
+			if test.overdraw != errors.Is(err, ErrInsufficientFunds) {
+				t.Fatalf("got %v, want insufficient funds %v", err, test.overdraw)
+			}
+			if !test.overdraw && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			balances, err := GetBalances(ctx, client, "alice")
+			if err != nil || len(balances) != 1 {
+				t.Fatalf("balances %v, %v", balances, err)
+			}
+			if balances[0].Available != test.available || balances[0].Reserved != test.reserved {
+				t.Fatalf("balance %v available %v reserved, want %v and %v",
+					balances[0].Available, balances[0].Reserved, test.available, test.reserved)
+			}
+		})
+	}
+}
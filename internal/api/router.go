@@ -0,0 +1,114 @@
+package api
+
+import (
+	"mfus_OMV1/internal/accounts"
+	"mfus_OMV1/internal/audit"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/internal/fees"
+	"mfus_OMV1/internal/health"
+	"mfus_OMV1/internal/logging"
+	"mfus_OMV1/internal/marketdata"
+	"mfus_OMV1/internal/metrics"
+	"mfus_OMV1/internal/orderbook"
+	"mfus_OMV1/internal/positions"
+	"mfus_OMV1/internal/ratelimit"
+	"mfus_OMV1/internal/shard"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// NewRouter registers every REST endpoint of the order manager
+func NewRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.Use(logging.Middleware, metrics.Instrument)
+	r.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+	// Probes are unauthenticated so that the orchestrator can reach them
+	r.HandleFunc("/healthz", health.HealthzHandler).Methods(http.MethodGet)
+	r.HandleFunc("/readyz", health.ReadyzHandler).Methods(http.MethodGet)
+
+	v1 := r.PathPrefix("/v1").Subrouter()
+	// Requests forwarded by a peer carry the caller it authenticated, order
+	// entry is forwarded to the node owning the symbol once authenticated
+	v1.Use(shard.TrustPeers, auth.Authenticate, shard.Gateway(orderbook.RequestSymbol))
+
+	read := func(h http.HandlerFunc) http.HandlerFunc { return auth.Require(auth.PermRead, h) }
+	trade := func(h http.HandlerFunc) http.HandlerFunc { return auth.Require(auth.PermTrade, h) }
+	// Every change made through an admin endpoint is recorded in the audit log
+	admin := func(h http.HandlerFunc) http.HandlerFunc { return auth.Require(auth.PermAdmin, audit.Handler(h)) }
+
+	// Orders
+	v1.HandleFunc("/orders", trade(orderbook.CreateOrderHandler)).Methods(http.MethodPost)
+	v1.HandleFunc("/orders", read(orderbook.GetOrdersHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/orders/open", read(orderbook.GetOpenOrdersHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/orders/{id}", read(orderbook.GetOrderHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/orders/{id}", trade(orderbook.UpdateOpenOrderHandler)).Methods(http.MethodPut)
+	v1.HandleFunc("/orders/{id}", trade(orderbook.DeleteOrderHandler)).Methods(http.MethodDelete)
+	v1.HandleFunc("/orders/{id}/cancel", trade(orderbook.CancelOrderHandler)).Methods(http.MethodPost)
+	v1.HandleFunc("/orders/{id}/fills", read(orderbook.GetOrderFillsHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/orders/{id}/history", read(orderbook.GetOrderHistoryHandler)).Methods(http.MethodGet)
+
+	// Market data
+	v1.HandleFunc("/book/{symbol}", read(orderbook.GetBookHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/candles", read(marketdata.GetCandlesHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/ticker", read(marketdata.GetTickersHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/ticker/{symbol}", read(marketdata.GetTickerHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/candles/rebuild", admin(marketdata.RebuildCandlesHandler)).Methods(http.MethodPost)
+
+	// Trades
+	v1.HandleFunc("/trades", read(orderbook.GetTradesHandler)).Methods(http.MethodGet)
+
+	// Accounts
+	v1.HandleFunc("/accounts/{userID}/balances", read(accounts.GetBalancesHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/accounts/{userID}/deposits", admin(accounts.DepositHandler)).Methods(http.MethodPost)
+	v1.HandleFunc("/accounts/{userID}/ledger", read(accounts.GetLedgerHandler)).Methods(http.MethodGet)
+
+	// Fees
+	v1.HandleFunc("/fees/{symbol}", read(fees.GetScheduleHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/fees/{symbol}", admin(fees.SetScheduleHandler)).Methods(http.MethodPut)
+	v1.HandleFunc("/fees/{symbol}/accounts/{userID}", read(fees.GetAccountTierHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/fees/accounts/{userID}/tier", admin(fees.SetAccountTierHandler)).Methods(http.MethodPut)
+
+	// Rate limits
+	v1.HandleFunc("/ratelimits/{userID}", admin(ratelimit.SetAccountLimitHandler)).Methods(http.MethodPut)
+
+	// Positions
+	v1.HandleFunc("/positions/{userID}", read(positions.GetPositionsHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/positions/{userID}/{symbol}", read(positions.GetPositionsHandler)).Methods(http.MethodGet)
+
+	// API keys, a caller manages its own keys and can only grant, rotate or
+	// revoke permissions it holds
+	v1.HandleFunc("/apikeys", read(auth.CreateKeyHandler)).Methods(http.MethodPost)
+	v1.HandleFunc("/apikeys", read(auth.ListKeysHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/apikeys/{id}/rotate", read(auth.RotateKeyHandler)).Methods(http.MethodPost)
+	v1.HandleFunc("/apikeys/{id}", read(auth.RevokeKeyHandler)).Methods(http.MethodDelete)
+
+	// Logging, levels can be changed per component while running
+	v1.HandleFunc("/admin/log-levels", admin(logging.GetLevelsHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/admin/log-levels/{component}", admin(logging.SetLevelHandler)).Methods(http.MethodPut)
+
+	// Operations, instruments and trading halts
+	v1.HandleFunc("/admin/instruments", admin(orderbook.GetInstrumentsHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/admin/instruments", admin(orderbook.AddInstrumentHandler)).Methods(http.MethodPost)
+	v1.HandleFunc("/admin/symbols/{symbol}/halt", admin(orderbook.HaltSymbolHandler)).Methods(http.MethodPost)
+	v1.HandleFunc("/admin/symbols/{symbol}/resume", admin(orderbook.ResumeSymbolHandler)).Methods(http.MethodPost)
+	v1.HandleFunc("/admin/orders/cancel", admin(orderbook.ForceCancelHandler)).Methods(http.MethodPost)
+	v1.HandleFunc("/admin/snapshots", admin(orderbook.SnapshotHandler)).Methods(http.MethodPost)
+	// The kill switch stops every symbol, only an admin can release it
+	v1.HandleFunc("/admin/killswitch", admin(orderbook.GetKillSwitchHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/admin/killswitch/engage", admin(orderbook.EngageKillSwitchHandler)).Methods(http.MethodPost)
+	v1.HandleFunc("/admin/killswitch/release", admin(orderbook.ReleaseKillSwitchHandler)).Methods(http.MethodPost)
+	// users
+	v1.HandleFunc("/admin/users/{userID}/controls", admin(orderbook.GetAccountControlHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/admin/users/{userID}/suspend", admin(orderbook.SuspendUserHandler)).Methods(http.MethodPost)
+	v1.HandleFunc("/admin/users/{userID}/unsuspend", admin(orderbook.UnsuspendUserHandler)).Methods(http.MethodPost)
+	v1.HandleFunc("/admin/users/{userID}/limits", admin(orderbook.SetRiskLimitsHandler)).Methods(http.MethodPut)
+	// and the log of all of the above
+	v1.HandleFunc("/admin/audit", admin(audit.GetAuditLogHandler)).Methods(http.MethodGet)
+	v1.HandleFunc("/admin/shards", admin(shard.GetShardsHandler)).Methods(http.MethodGet)
+
+	// Streaming
+	v1.HandleFunc("/ws", read(orderbook.WebSocketHandler)).Name(auth.QueryTokenRoute)
+
+	return r
+}
@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mfus_OMV1/pkg/database"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ParseQuery reads an audit log query from URL query parameters
+func ParseQuery(values url.Values) (Query, error) {
+	query := Query{
+		OperatorID: values.Get("operatorID"),
+		Action:     values.Get("action"),
+		Limit:      DefaultQueryLimit,
+	}
+
+	var err error
+	if v := values.Get("from"); v != "" {
+		if query.From, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return Query{}, errors.New("from must be a timestamp in milliseconds")
+		}
+	}
+	if v := values.Get("to"); v != "" {
+		if query.To, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return Query{}, errors.New("to must be a timestamp in milliseconds")
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		if query.Limit, err = strconv.ParseInt(v, 10, 64); err != nil || query.Limit <= 0 {
+			return Query{}, errors.New("limit must be a positive number")
+		}
+		if query.Limit > MaxQueryLimit {
+			query.Limit = MaxQueryLimit
+		}
+	}
+	return query, nil
+}
+
+func GetAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	query, err := ParseQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	entries, err := GetEntries(ctx, client, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/internal/logging"
+	"mfus_OMV1/pkg/database"
+	"mfus_OMV1/utils"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var logger = logging.Logger("audit")
+
+// maxRequestSize bounds the part of a request body kept with an entry
+const maxRequestSize = 16 << 10
+
+// Page size limits of the audit log query
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+// Record appends an entry to the audit log
+func Record(ctx context.Context, mongoClient *mongo.Client, entry EntryModel) error {
+	entry.ID = primitive.NewObjectID()
+	if entry.Timestamp == 0 {
+		entry.Timestamp = utils.GetCurrentTimestamp()
+	}
+	_, err := mongoClient.Database(dbName).Collection(auditCollection).InsertOne(ctx, entry)
+	return err
+}
+
+// GetEntries returns the newest entries matching a query
+func GetEntries(ctx context.Context, mongoClient *mongo.Client, query Query) ([]EntryModel, error) {
+	filter := bson.M{}
+	if query.OperatorID != "" {
+		filter["operatorID"] = query.OperatorID
+	}
+	if query.Action != "" {
+		filter["action"] = query.Action
+	}
+	timestamp := bson.M{}
+	if query.From > 0 {
+		timestamp["$gte"] = query.From
+	}
+	if query.To > 0 {
+		timestamp["$lt"] = query.To
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(limit)
+	cursor, err := mongoClient.Database(dbName).Collection(auditCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]EntryModel, 0)
+	err = cursor.All(ctx, &entries)
+	return entries, err
+}
+
+// EnsureIndexes creates the indexes of the audit log queries
+func EnsureIndexes(ctx context.Context, mongoClient *mongo.Client) error {
+	_, err := mongoClient.Database(dbName).Collection(auditCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "operatorID", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "action", Value: 1}, {Key: "timestamp", Value: -1}}},
+	})
+	return err
+}
+
+// statusRecorder captures the status code written by an audited handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Handler records every state changing request of an admin endpoint together
+// with the operator that made it and the outcome. Reads are not recorded.
+func Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		// Keep the start of the body and hand the whole body on to the handler
+		body, _ := io.ReadAll(io.LimitReader(r.Body, maxRequestSize+1))
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+
+		principal, _ := auth.FromContext(r.Context())
+		entry := EntryModel{
+			Timestamp:     utils.GetCurrentTimestamp(),
+			OperatorID:    principal.UserID,
+			KeyID:         principal.KeyID,
+			CorrelationID: logging.CorrelationID(r.Context()),
+			RemoteAddr:    r.RemoteAddr,
+			Action:        r.Method + " " + r.URL.Path,
+			Path:          r.URL.Path,
+			Params:        mux.Vars(r),
+		}
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				entry.Action = r.Method + " " + template
+			}
+		}
+		if len(body) > maxRequestSize {
+			body = body[:maxRequestSize]
+			entry.Truncated = true
+		}
+		entry.Request = string(body)
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+		entry.Status = recorder.status
+
+		// The action is done, so the entry is written even if the client went away
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		client, err := database.GetMongoClient()
+		if err == nil {
+			err = Record(ctx, client, entry)
+		}
+		if err != nil {
+			logger.ErrorContext(r.Context(), "cannot record admin action", "action", entry.Action,
+				"operator", entry.OperatorID, "path", entry.Path, "status", entry.Status, "error", err)
+			return
+		}
+		logger.InfoContext(r.Context(), "admin action", "action", entry.Action, "operator", entry.OperatorID,
+			"path", entry.Path, "status", entry.Status)
+	}
+}
@@ -0,0 +1,47 @@
+// Package audit keeps the log of operator actions. Entries are only ever
+// inserted: nothing in the order manager updates or deletes them, and the
+// database user of the service should only be granted insert and find on the
+// collection so that the log cannot be rewritten through it either.
+package audit
+
+import (
+	"mfus_OMV1/internal/config"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Define the database and collection names
+var dbName = "orderbook"
+var auditCollection = "audit_log"
+
+// Configure applies the database and collection names of the loaded config
+func Configure(cfg *config.Config) {
+	dbName = cfg.Mongo.Database
+	auditCollection = cfg.Collections.AuditLog
+}
+
+// EntryModel is one recorded operator action
+type EntryModel struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Timestamp     int64              `json:"timestamp" bson:"timestamp"`
+	OperatorID    string             `json:"operatorID" bson:"operatorID"`
+	KeyID         string             `json:"keyID,omitempty" bson:"keyID,omitempty"`
+	CorrelationID string             `json:"correlationID,omitempty" bson:"correlationID,omitempty"`
+	RemoteAddr    string             `json:"remoteAddr" bson:"remoteAddr"`
+	// Action is the method and route template, e.g. "POST /v1/admin/symbols/{symbol}/halt"
+	Action    string            `json:"action" bson:"action"`
+	Path      string            `json:"path" bson:"path"`
+	Params    map[string]string `json:"params,omitempty" bson:"params,omitempty"`
+	Request   string            `json:"request,omitempty" bson:"request,omitempty"`
+	Truncated bool              `json:"truncated,omitempty" bson:"truncated,omitempty"`
+	Status    int               `json:"status" bson:"status"`
+}
+
+// Query filters the audit log, zero values match everything
+type Query struct {
+	OperatorID string
+	Action     string
+	From       int64
+	To         int64
+	Limit      int64
+}
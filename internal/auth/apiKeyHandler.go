@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"mfus_OMV1/pkg/database"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type createKeyRequest struct {
+	UserID      string   `json:"userID"`
+	Label       string   `json:"label"`
+	Permissions []string `json:"permissions"`
+}
+
+// keyWithSecret is only returned on creation and rotation
+type keyWithSecret struct {
+	APIKeyModel
+	Secret string `json:"secret"`
+}
+
+func CreateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := FromContext(r.Context())
+
+	var req createKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" {
+		req.UserID = principal.UserID
+	}
+	if !AuthorizeUser(w, r, req.UserID) {
+		return
+	}
+	// A caller can only hand out permissions it holds itself
+	if missing, ok := covers(principal, req.Permissions); !ok {
+		http.Error(w, "cannot grant "+missing+" permission", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	key, err := CreateKey(ctx, client, req.UserID, req.Label, req.Permissions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(keyWithSecret{APIKeyModel: key, Secret: key.Secret})
+}
+
+func ListKeysHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := FromContext(r.Context())
+	userID := r.URL.Query().Get("userID")
+	if userID == "" {
+		userID = principal.UserID
+	}
+	if !AuthorizeUser(w, r, userID) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	keys, err := ListKeys(ctx, client, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(keys)
+}
+
+func RotateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !authorizeKey(ctx, w, r, params["id"]) {
+		return
+	}
+	key, err := RotateKey(ctx, client, params["id"])
+	if err != nil {
+		if err == ErrKeyNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(keyWithSecret{APIKeyModel: key, Secret: key.Secret})
+}
+
+func RevokeKeyHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !authorizeKey(ctx, w, r, params["id"]) {
+		return
+	}
+	err = RevokeKey(ctx, client, params["id"])
+	if err != nil {
+		if err == ErrKeyNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeKey checks that the caller owns the key or is an admin, and holds
+// every permission of the key. Otherwise a weaker key of the same user could
+// rotate a stronger one and receive its new secret.
+func authorizeKey(ctx context.Context, w http.ResponseWriter, r *http.Request, keyID string) bool {
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	key, err := GetKey(ctx, client, keyID)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return false
+	}
+	if !AuthorizeUser(w, r, key.UserID) {
+		return false
+	}
+	principal, _ := FromContext(r.Context())
+	if missing, ok := covers(principal, key.Permissions); !ok {
+		http.Error(w, "missing "+missing+" permission of the key", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// covers reports whether a principal holds every permission in permissions,
+// and the first one it lacks otherwise
+func covers(principal Principal, permissions []string) (string, bool) {
+	for _, permission := range permissions {
+		if !principal.Has(permission) {
+			return permission, false
+		}
+	}
+	return "", true
+}
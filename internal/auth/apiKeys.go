@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"mfus_OMV1/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrKeyNotFound = errors.New("API key not found")
+
+// CreateKey issues a new key for a user and returns it with its secret
+func CreateKey(ctx context.Context, mongoClient *mongo.Client, userID string, label string, permissions []string) (APIKeyModel, error) {
+	if len(permissions) == 0 {
+		return APIKeyModel{}, errors.New("at least one permission is required")
+	}
+	for _, permission := range permissions {
+		if !validPermission(permission) {
+			return APIKeyModel{}, errors.New("invalid permission " + permission)
+		}
+	}
+
+	keyID, err := randomHex(16)
+	if err != nil {
+		return APIKeyModel{}, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return APIKeyModel{}, err
+	}
+	key := APIKeyModel{
+		KeyID:        "ak_" + keyID,
+		Secret:       secret,
+		UserID:       userID,
+		Label:        label,
+		Permissions:  permissions,
+		Status:       KeyActive,
+		CreationTime: utils.GetCurrentTimestamp(),
+	}
+	_, err = mongoClient.Database(dbName).Collection(apiKeysCollection).InsertOne(ctx, key)
+	if err != nil {
+		return APIKeyModel{}, err
+	}
+	return key, nil
+}
+
+// RotateKey replaces the secret of an active key, the old secret stops working immediately
+func RotateKey(ctx context.Context, mongoClient *mongo.Client, keyID string) (APIKeyModel, error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return APIKeyModel{}, err
+	}
+	var key APIKeyModel
+	err = mongoClient.Database(dbName).Collection(apiKeysCollection).FindOneAndUpdate(ctx,
+		bson.M{"keyID": keyID, "status": KeyActive},
+		bson.M{"$set": bson.M{"secret": secret, "rotationTime": utils.GetCurrentTimestamp()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return APIKeyModel{}, ErrKeyNotFound
+	}
+	return key, err
+}
+
+// RevokeKey permanently disables a key
+func RevokeKey(ctx context.Context, mongoClient *mongo.Client, keyID string) error {
+	result, err := mongoClient.Database(dbName).Collection(apiKeysCollection).UpdateOne(ctx,
+		bson.M{"keyID": keyID, "status": KeyActive},
+		bson.M{"$set": bson.M{"status": KeyRevoked, "revokedTime": utils.GetCurrentTimestamp()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// GetKey returns a key by its public ID
+func GetKey(ctx context.Context, mongoClient *mongo.Client, keyID string) (APIKeyModel, error) {
+	var key APIKeyModel
+	err := mongoClient.Database(dbName).Collection(apiKeysCollection).FindOne(ctx, bson.M{"keyID": keyID}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return APIKeyModel{}, ErrKeyNotFound
+	}
+	return key, err
+}
+
+// ListKeys returns the keys of a user without their secrets
+func ListKeys(ctx context.Context, mongoClient *mongo.Client, userID string) ([]APIKeyModel, error) {
+	cursor, err := mongoClient.Database(dbName).Collection(apiKeysCollection).Find(ctx, bson.M{"userID": userID})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]APIKeyModel, 0)
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"mfus_OMV1/internal/config"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Define the database and collection names
+var dbName = "orderbook"
+var apiKeysCollection = "api_keys"
+
+// Configure applies the database names and the JWT secret of the loaded config
+func Configure(cfg *config.Config) {
+	dbName = cfg.Mongo.Database
+	apiKeysCollection = cfg.Collections.APIKeys
+	jwtSecret = []byte(cfg.Auth.JWTSecret)
+}
+
+// Permissions that can be granted to API keys and JWT principals
+const (
+	PermRead  = "read"
+	PermTrade = "trade"
+	PermAdmin = "admin"
+)
+
+// API key statuses
+const (
+	KeyActive  = "active"
+	KeyRevoked = "revoked"
+)
+
+// APIKeyModel is an API key used to sign requests. The secret never leaves
+// the server after it has been returned on creation or rotation.
+type APIKeyModel struct {
+	ID           primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	KeyID        string             `json:"keyID" bson:"keyID"`
+	Secret       string             `json:"-" bson:"secret"`
+	UserID       string             `json:"userID" bson:"userID"`
+	Label        string             `json:"label" bson:"label"`
+	Permissions  []string           `json:"permissions" bson:"permissions"`
+	Status       string             `json:"status" bson:"status"`
+	CreationTime int64              `json:"creationTime" bson:"creationTime"`
+	RotationTime int64              `json:"rotationTime" bson:"rotationTime"`
+	RevokedTime  int64              `json:"revokedTime" bson:"revokedTime"`
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID      string
+	KeyID       string
+	Permissions []string
+}
+
+type principalKey struct{}
+
+// Has reports whether the principal holds a permission, admin implies every permission
+func (p Principal) Has(permission string) bool {
+	for _, granted := range p.Permissions {
+		if granted == permission || granted == PermAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// WithPrincipal returns a context carrying the authenticated principal
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal of a request authenticated by Authenticate
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+func validPermission(permission string) bool {
+	return permission == PermRead || permission == PermTrade || permission == PermAdmin
+}
@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mfus_OMV1/pkg/database"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Headers of a signed API key request. The signature is the hex encoded
+// HMAC-SHA256 of the timestamp, nonce, method and request URI, each followed
+// by a newline, and then the body.
+const (
+	HeaderAPIKey    = "X-API-KEY"
+	HeaderTimestamp = "X-API-TIMESTAMP"
+	HeaderNonce     = "X-API-NONCE"
+	HeaderSignature = "X-API-SIGNATURE"
+)
+
+// SignatureWindow is how far a request timestamp may drift from server time
+const SignatureWindow = 30 * time.Second
+
+var ErrUnauthenticated = errors.New("authentication required")
+var ErrForbidden = errors.New("access to another user's data is not allowed")
+
+// SignedRequest holds the parts of a request covered by an API key signature
+type SignedRequest struct {
+	KeyID      string
+	Timestamp  string
+	Nonce      string
+	Signature  string
+	Method     string
+	RequestURI string
+	Body       []byte
+}
+
+var redisClient *redis.Client
+
+var nonceMutex sync.Mutex
+var seenNonces = make(map[string]time.Time)
+
+var jwtSecret []byte
+
+// Init stores nonces in Redis so that replays are detected across instances
+func Init(client *redis.Client) {
+	redisClient = client
+}
+
+// Authenticate resolves the principal of a request from a JWT bearer token or
+// a signed API key request and rejects the request when neither is valid. A
+// principal resolved before, e.g. by the node that forwarded the request, is kept.
+func Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := FromContext(r.Context()); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var principal Principal
+		var err error
+		switch {
+		case bearerToken(r) != "":
+			principal, err = AuthenticateToken(bearerToken(r))
+		case r.Header.Get(HeaderAPIKey) != "":
+			principal, err = authenticateAPIKey(r)
+		default:
+			err = ErrUnauthenticated
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// Require rejects requests whose principal lacks a permission
+func Require(permission string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := FromContext(r.Context())
+		if !ok {
+			http.Error(w, ErrUnauthenticated.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !principal.Has(permission) {
+			http.Error(w, "missing "+permission+" permission", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// AuthorizeUser checks that the caller acts on its own data or is an admin.
+// It writes a 403 response and returns false otherwise.
+func AuthorizeUser(w http.ResponseWriter, r *http.Request, userID string) bool {
+	err := CheckUser(r.Context(), userID)
+	if err == ErrUnauthenticated {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// CheckUser is AuthorizeUser for callers that are not HTTP handlers
+func CheckUser(ctx context.Context, userID string) error {
+	principal, ok := FromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	if principal.UserID != userID && !principal.Has(PermAdmin) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// QueryTokenRoute names the route whose WebSocket upgrade may pass the JWT as
+// the token query parameter, browsers cannot set headers on the upgrade
+const QueryTokenRoute = "websocket"
+
+// bearerToken reads the JWT from the Authorization header, or from the token
+// query parameter of a WebSocket upgrade on QueryTokenRoute. Anywhere else a
+// token in the URL would end up in access logs, so it is ignored.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	route := mux.CurrentRoute(r)
+	if route == nil || route.GetName() != QueryTokenRoute || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return ""
+	}
+	return r.URL.Query().Get("token")
+}
+
+// AuthenticateToken resolves the principal of a JWT bearer token
+func AuthenticateToken(token string) (Principal, error) {
+	if len(jwtSecret) == 0 {
+		return Principal{}, errors.New("bearer tokens are not enabled")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired())
+	if err != nil {
+		return Principal{}, err
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return Principal{}, errors.New("token has no subject")
+	}
+	principal := Principal{UserID: subject}
+	if perms, ok := claims["perms"].([]interface{}); ok {
+		for _, perm := range perms {
+			if p, ok := perm.(string); ok && validPermission(p) {
+				principal.Permissions = append(principal.Permissions, p)
+			}
+		}
+	}
+	return principal, nil
+}
+
+func authenticateAPIKey(r *http.Request) (Principal, error) {
+	// Read the body for the signature and put it back for the handler
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return Principal{}, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return AuthenticateSigned(r.Context(), SignedRequest{
+		KeyID:      r.Header.Get(HeaderAPIKey),
+		Timestamp:  r.Header.Get(HeaderTimestamp),
+		Nonce:      r.Header.Get(HeaderNonce),
+		Signature:  r.Header.Get(HeaderSignature),
+		Method:     r.Method,
+		RequestURI: r.URL.RequestURI(),
+		Body:       body,
+	})
+}
+
+// AuthenticateSigned resolves the principal of a request signed with an API key
+func AuthenticateSigned(ctx context.Context, req SignedRequest) (Principal, error) {
+	if req.Nonce == "" || req.Timestamp == "" || req.Signature == "" {
+		return Principal{}, errors.New("signed requests need timestamp, nonce and signature headers")
+	}
+
+	millis, err := strconv.ParseInt(req.Timestamp, 10, 64)
+	if err != nil {
+		return Principal{}, errors.New("invalid request timestamp")
+	}
+	drift := time.Since(time.UnixMilli(millis))
+	if drift > SignatureWindow || drift < -SignatureWindow {
+		return Principal{}, errors.New("request timestamp outside the allowed window")
+	}
+
+	client, err := database.GetMongoClient()
+	if err != nil {
+		return Principal{}, err
+	}
+	var key APIKeyModel
+	err = client.Database(dbName).Collection(apiKeysCollection).FindOne(ctx, bson.M{"keyID": req.KeyID, "status": KeyActive}).Decode(&key)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return Principal{}, errors.New("unknown or revoked API key")
+		}
+		return Principal{}, err
+	}
+
+	if err := verifySigned(ctx, key.Secret, req); err != nil {
+		return Principal{}, err
+	}
+	return Principal{UserID: key.UserID, KeyID: key.KeyID, Permissions: key.Permissions}, nil
+}
+
+// verifySigned checks the signature of a request under the secret of its key
+// and uses up its nonce
+func verifySigned(ctx context.Context, secret string, req SignedRequest) error {
+	if !hmac.Equal([]byte(Sign(secret, req.Timestamp, req.Nonce, req.Method, req.RequestURI, req.Body)), []byte(strings.ToLower(req.Signature))) {
+		return errors.New("invalid request signature")
+	}
+	return UseNonce(ctx, req.KeyID, req.Nonce)
+}
+
+// Sign returns the signature of a request as expected by Authenticate
+func Sign(secret string, timestamp string, nonce string, method string, requestURI string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	// The separator keeps one field from absorbing the start of the next
+	mac.Write([]byte(strings.Join([]string{timestamp, nonce, method, requestURI}, "\n") + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// UseNonce fails when a nonce has already been seen for a key within the
+// signature window, so a signed request cannot be replayed
+func UseNonce(ctx context.Context, keyID string, nonce string) error {
+	key := "nonce:" + keyID + ":" + nonce
+	if redisClient != nil {
+		fresh, err := redisClient.SetNX(ctx, key, 1, 2*SignatureWindow).Result()
+		if err == nil {
+			if !fresh {
+				return errors.New("nonce already used")
+			}
+			return nil
+		}
+	}
+
+	nonceMutex.Lock()
+	defer nonceMutex.Unlock()
+	now := time.Now()
+	for k, seen := range seenNonces {
+		if now.Sub(seen) > 2*SignatureWindow {
+			delete(seenNonces, k)
+		}
+	}
+	if _, ok := seenNonces[key]; ok {
+		return errors.New("nonce already used")
+	}
+	seenNonces[key] = now
+	return nil
+}
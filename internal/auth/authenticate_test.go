@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	base := Sign("secret", "1700000000000", "nonce", "POST", "/v1/orders", []byte(`{"price":1}`))
+	tests := []struct {
+		name      string
+		signature string
+	}{
+		{"other secret", Sign("other", "1700000000000", "nonce", "POST", "/v1/orders", []byte(`{"price":1}`))},
+		{"other timestamp", Sign("secret", "1700000000001", "nonce", "POST", "/v1/orders", []byte(`{"price":1}`))},
+		{"other nonce", Sign("secret", "1700000000000", "nonce2", "POST", "/v1/orders", []byte(`{"price":1}`))},
+		{"other method", Sign("secret", "1700000000000", "nonce", "PUT", "/v1/orders", []byte(`{"price":1}`))},
+		{"other body", Sign("secret", "1700000000000", "nonce", "POST", "/v1/orders", []byte(`{"price":2}`))},
+		// Without a separator these fields would concatenate to the same text
+		{"field moved across a boundary", Sign("secret", "1700000000000", "nonceP", "OST", "/v1/orders", []byte(`{"price":1}`))},
+		{"URI moved into the body", Sign("secret", "1700000000000", "nonce", "POST", "/v1/order", []byte(`s{"price":1}`))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.signature == base {
+				t.Fatal("different requests share a signature")
+			}
+		})
+	}
+}
+
+func TestVerifySigned(t *testing.T) {
+	ctx := context.Background()
+	signed := func(nonce string) SignedRequest {
+		req := SignedRequest{
+			KeyID:      "ak_test",
+			Timestamp:  "1700000000000",
+			Nonce:      nonce,
+			Method:     "POST",
+			RequestURI: "/v1/orders",
+			Body:       []byte(`{"symbol":"BTC-USD"}`),
+		}
+		req.Signature = Sign("secret", req.Timestamp, req.Nonce, req.Method, req.RequestURI, req.Body)
+		return req
+	}
+	tests := []struct {
+		name  string
+		req   func() SignedRequest
+		valid bool
+	}{
+		{"signed", func() SignedRequest { return signed("n1") }, true},
+		{"upper case signature", func() SignedRequest {
+			req := signed("n2")
+			req.Signature = strings.ToUpper(req.Signature)
+			return req
+		}, true},
+		{"replayed nonce", func() SignedRequest { return signed("n1") }, false},
+		{"same nonce of another key", func() SignedRequest {
+			req := signed("n1")
+			req.KeyID = "ak_other"
+			return req
+		}, true},
+		{"tampered body", func() SignedRequest {
+			req := signed("n3")
+			req.Body = []byte(`{"symbol":"ETH-USD"}`)
+			return req
+		}, false},
+		{"tampered URI", func() SignedRequest {
+			req := signed("n4")
+			req.RequestURI = "/v1/orders?userID=other"
+			return req
+		}, false},
+		{"nonce of a refused request is not used up", func() SignedRequest { return signed("n3") }, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := verifySigned(ctx, "secret", test.req()); (err == nil) != test.valid {
+				t.Fatalf("verifySigned() = %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
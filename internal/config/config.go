@@ -0,0 +1,467 @@
+// Package config holds the typed configuration of the order manager. It is
+// loaded once at startup from an optional file, MFUS_ prefixed environment
+// variables and command line flags, in increasing order of precedence.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Config is the configuration of one order manager instance
+type Config struct {
+	Mongo       MongoConfig             `mapstructure:"mongo"`
+	Redis       RedisConfig             `mapstructure:"redis"`
+	Server      ServerConfig            `mapstructure:"server"`
+	Matcher     MatcherConfig           `mapstructure:"matcher"`
+	Leader      LeaderConfig            `mapstructure:"leader"`
+	Shard       ShardConfig             `mapstructure:"shard"`
+	Auth        AuthConfig              `mapstructure:"auth"`
+	Log         LogConfig               `mapstructure:"log"`
+	Health      HealthConfig            `mapstructure:"health"`
+	Collections CollectionsConfig       `mapstructure:"collections"`
+	Defaults    SymbolConfig            `mapstructure:"defaults"`
+	Symbols     map[string]SymbolConfig `mapstructure:"symbols"`
+}
+
+// MongoConfig is the MongoDB connection
+type MongoConfig struct {
+	URI            string        `mapstructure:"uri"`
+	Database       string        `mapstructure:"database"`
+	MinPoolSize    uint64        `mapstructure:"minPoolSize"`
+	MaxPoolSize    uint64        `mapstructure:"maxPoolSize"`
+	ConnectTimeout time.Duration `mapstructure:"connectTimeout"`
+}
+
+// RedisConfig is the Redis connection shared by the matcher, rate limits and nonces
+type RedisConfig struct {
+	Addr     string `mapstructure:"addr"`
+	Password string `mapstructure:"password"`
+	DB       int    `mapstructure:"db"`
+	PoolSize int    `mapstructure:"poolSize"`
+}
+
+// ServerConfig holds the listen addresses of the APIs and how long a graceful
+// shutdown may take before the process exits anyway
+type ServerConfig struct {
+	HTTPAddr string `mapstructure:"httpAddr"`
+	GRPCAddr string `mapstructure:"grpcAddr"`
+	// GRPCCertFile and GRPCKeyFile serve the gRPC API over TLS, GRPCInsecure
+	// allows plaintext without them, e.g. for local development
+	GRPCCertFile    string        `mapstructure:"grpcCertFile"`
+	GRPCKeyFile     string        `mapstructure:"grpcKeyFile"`
+	GRPCInsecure    bool          `mapstructure:"grpcInsecure"`
+	FIXAddr         string        `mapstructure:"fixAddr"`
+	FIXCompID       string        `mapstructure:"fixCompID"`
+	ShutdownTimeout time.Duration `mapstructure:"shutdownTimeout"`
+}
+
+// MatcherConfig tunes the matching loop
+type MatcherConfig struct {
+	Interval  time.Duration `mapstructure:"interval"`
+	TradesKey string        `mapstructure:"tradesKey"`
+	// Pipeline matches each symbol on its own goroutine as orders arrive, the
+	// loop then only reconciles the books with MongoDB every interval. Turned
+	// off, the loop reads the open orders and matches them every interval.
+	Pipeline bool `mapstructure:"pipeline"`
+	// CommandBuffer bounds the orders waiting for the matcher of a symbol, new
+	// orders are refused while it is full. A power of two.
+	CommandBuffer int `mapstructure:"commandBuffer"`
+	// ResultBuffer bounds the results the slowest consumer of a symbol is
+	// behind, the matcher waits while it is full. A power of two.
+	ResultBuffer int `mapstructure:"resultBuffer"`
+}
+
+// LeaderConfig controls the election of the instance that matches each
+// symbol. The leader of a symbol holds a lease in Redis and renews it, the
+// other instances stand by and take the symbol over once the lease expires.
+type LeaderConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// InstanceID names this instance in the leases, empty uses host and pid
+	InstanceID    string        `mapstructure:"instanceID"`
+	LeaseTTL      time.Duration `mapstructure:"leaseTTL"`
+	RenewInterval time.Duration `mapstructure:"renewInterval"`
+	KeyPrefix     string        `mapstructure:"keyPrefix"`
+}
+
+// ShardConfig partitions the symbols between the engine nodes. Each symbol is
+// assigned to one node through a consistent hash ring of the live nodes, the
+// other nodes forward its orders to the owner and stand by for it.
+type ShardConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// AdvertiseURL is where the other nodes forward orders to, e.g.
+	// http://engine-1:8080
+	AdvertiseURL string `mapstructure:"advertiseURL"`
+	// PeerSecret signs the requests a node forwards, so that the owner trusts
+	// the caller the forwarding node authenticated. Every node needs the same.
+	PeerSecret        string        `mapstructure:"peerSecret"`
+	VirtualNodes      int           `mapstructure:"virtualNodes"`
+	HeartbeatTTL      time.Duration `mapstructure:"heartbeatTTL"`
+	RebalanceInterval time.Duration `mapstructure:"rebalanceInterval"`
+	HandoverTimeout   time.Duration `mapstructure:"handoverTimeout"`
+	KeyPrefix         string        `mapstructure:"keyPrefix"`
+}
+
+// AuthConfig holds the bearer token settings, an empty secret disables JWTs
+type AuthConfig struct {
+	JWTSecret string `mapstructure:"jwtSecret"`
+}
+
+// LogConfig holds the default log level and the levels of single components,
+// both can be changed at runtime through the admin API
+type LogConfig struct {
+	Level      string            `mapstructure:"level"`
+	Components map[string]string `mapstructure:"components"`
+}
+
+// HealthConfig tunes the dependency checks and the reconnect backoff
+type HealthConfig struct {
+	Interval        time.Duration `mapstructure:"interval"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+	MaxMatcherDelay time.Duration `mapstructure:"maxMatcherDelay"`
+	MaxJournalLag   time.Duration `mapstructure:"maxJournalLag"`
+	RetryMinDelay   time.Duration `mapstructure:"retryMinDelay"`
+	RetryMaxDelay   time.Duration `mapstructure:"retryMaxDelay"`
+}
+
+// CollectionsConfig names the MongoDB collections
+type CollectionsConfig struct {
+	Orders          string `mapstructure:"orders"`
+	Trades          string `mapstructure:"trades"`
+	StateChanges    string `mapstructure:"stateChanges"`
+	Counters        string `mapstructure:"counters"`
+	Balances        string `mapstructure:"balances"`
+	Ledger          string `mapstructure:"ledger"`
+	Positions       string `mapstructure:"positions"`
+	FeeSchedules    string `mapstructure:"feeSchedules"`
+	FeeAccountTiers string `mapstructure:"feeAccountTiers"`
+	RateLimits      string `mapstructure:"rateLimits"`
+	APIKeys         string `mapstructure:"apiKeys"`
+	Candles         string `mapstructure:"candles"`
+	FIXSessions     string `mapstructure:"fixSessions"`
+	FIXMessages     string `mapstructure:"fixMessages"`
+	Snapshots       string `mapstructure:"snapshots"`
+	Instruments     string `mapstructure:"instruments"`
+	AccountControls string `mapstructure:"accountControls"`
+	AuditLog        string `mapstructure:"auditLog"`
+	KillSwitch      string `mapstructure:"killSwitch"`
+	Fences          string `mapstructure:"fences"`
+	Assignments     string `mapstructure:"assignments"`
+}
+
+// SymbolConfig holds the settings of a symbol. Fields left at zero in the
+// symbols section fall back to the defaults section.
+type SymbolConfig struct {
+	MaxTPS int `mapstructure:"maxTPS"`
+}
+
+// Default returns the configuration used when nothing overrides it
+func Default() *Config {
+	return &Config{
+		Mongo: MongoConfig{
+			URI:            "mongodb://localhost:27017/?replicaSet=rs0",
+			Database:       "orderbook",
+			MaxPoolSize:    100,
+			ConnectTimeout: 10 * time.Second,
+		},
+		Redis: RedisConfig{
+			Addr: "localhost:6379",
+		},
+		Server: ServerConfig{
+			HTTPAddr:        ":8080",
+			GRPCAddr:        ":9090",
+			FIXAddr:         ":9878",
+			FIXCompID:       "MFUS",
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Matcher: MatcherConfig{
+			Interval:      time.Second,
+			TradesKey:     "trades",
+			Pipeline:      true,
+			CommandBuffer: 1024,
+			ResultBuffer:  4096,
+		},
+		Leader: LeaderConfig{
+			Enabled:       true,
+			LeaseTTL:      5 * time.Second,
+			RenewInterval: time.Second,
+			KeyPrefix:     "matcher:leader:",
+		},
+		Shard: ShardConfig{
+			VirtualNodes:      64,
+			HeartbeatTTL:      5 * time.Second,
+			RebalanceInterval: 5 * time.Second,
+			HandoverTimeout:   30 * time.Second,
+			KeyPrefix:         "engine:",
+		},
+		Log: LogConfig{
+			Level:      "info",
+			Components: map[string]string{},
+		},
+		Health: HealthConfig{
+			Interval:        2 * time.Second,
+			Timeout:         time.Second,
+			MaxMatcherDelay: 10 * time.Second,
+			MaxJournalLag:   30 * time.Second,
+			RetryMinDelay:   500 * time.Millisecond,
+			RetryMaxDelay:   30 * time.Second,
+		},
+		Collections: CollectionsConfig{
+			Orders:          "orders",
+			Trades:          "trades",
+			StateChanges:    "orders_state",
+			Counters:        "counters",
+			Balances:        "balances",
+			Ledger:          "ledger",
+			Positions:       "positions",
+			FeeSchedules:    "fee_schedules",
+			FeeAccountTiers: "fee_account_tiers",
+			RateLimits:      "rate_limits",
+			APIKeys:         "api_keys",
+			Candles:         "candles",
+			FIXSessions:     "fix_sessions",
+			FIXMessages:     "fix_messages",
+			Snapshots:       "book_snapshots",
+			Instruments:     "instruments",
+			AccountControls: "account_controls",
+			AuditLog:        "audit_log",
+			KillSwitch:      "kill_switch",
+			Fences:          "matcher_fences",
+			Assignments:     "symbol_assignments",
+		},
+		Defaults: SymbolConfig{
+			MaxTPS: 10,
+		},
+		Symbols: map[string]SymbolConfig{},
+	}
+}
+
+// Symbol returns the settings of a symbol merged with the defaults
+func (c *Config) Symbol(symbol string) SymbolConfig {
+	settings := c.Defaults
+	if override, ok := c.Symbols[strings.ToUpper(symbol)]; ok {
+		if override.MaxTPS != 0 {
+			settings.MaxTPS = override.MaxTPS
+		}
+	}
+	return settings
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(key string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]interface{}{key}, args...)...))
+	}
+
+	if u, err := url.Parse(c.Mongo.URI); err != nil || (u.Scheme != "mongodb" && u.Scheme != "mongodb+srv") || u.Host == "" {
+		invalid("mongo.uri", "must be a mongodb:// or mongodb+srv:// URI, got %q", c.Mongo.URI)
+	}
+	if c.Mongo.Database == "" {
+		invalid("mongo.database", "must not be empty")
+	}
+	if c.Mongo.MaxPoolSize == 0 {
+		invalid("mongo.maxPoolSize", "must be positive")
+	}
+	if c.Mongo.MinPoolSize > c.Mongo.MaxPoolSize {
+		invalid("mongo.minPoolSize", "must not exceed mongo.maxPoolSize (%d)", c.Mongo.MaxPoolSize)
+	}
+	if c.Mongo.ConnectTimeout <= 0 {
+		invalid("mongo.connectTimeout", "must be positive")
+	}
+
+	if _, _, err := net.SplitHostPort(c.Redis.Addr); err != nil {
+		invalid("redis.addr", "must be host:port, got %q", c.Redis.Addr)
+	}
+	if c.Redis.DB < 0 {
+		invalid("redis.db", "must not be negative")
+	}
+	if c.Redis.PoolSize < 0 {
+		invalid("redis.poolSize", "must not be negative")
+	}
+
+	listeners := []struct{ key, addr string }{
+		{"server.httpAddr", c.Server.HTTPAddr},
+		{"server.grpcAddr", c.Server.GRPCAddr},
+		{"server.fixAddr", c.Server.FIXAddr},
+	}
+	seen := make(map[string]string)
+	for _, listener := range listeners {
+		if _, _, err := net.SplitHostPort(listener.addr); err != nil {
+			invalid(listener.key, "must be [host]:port, got %q", listener.addr)
+			continue
+		}
+		if other, ok := seen[listener.addr]; ok {
+			invalid(listener.key, "listens on the same address as %s", other)
+		}
+		seen[listener.addr] = listener.key
+	}
+	if (c.Server.GRPCCertFile == "") != (c.Server.GRPCKeyFile == "") {
+		invalid("server.grpcKeyFile", "must be set together with server.grpcCertFile")
+	}
+	if c.Server.GRPCCertFile == "" && !c.Server.GRPCInsecure {
+		invalid("server.grpcCertFile", "must be set unless server.grpcInsecure allows plaintext")
+	}
+	if c.Server.FIXCompID == "" {
+		invalid("server.fixCompID", "must not be empty")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		invalid("server.shutdownTimeout", "must be positive")
+	}
+
+	if c.Matcher.Interval <= 0 {
+		invalid("matcher.interval", "must be positive")
+	}
+	if c.Matcher.TradesKey == "" {
+		invalid("matcher.tradesKey", "must not be empty")
+	}
+	for _, buffer := range []struct {
+		key  string
+		size int
+	}{{"matcher.commandBuffer", c.Matcher.CommandBuffer}, {"matcher.resultBuffer", c.Matcher.ResultBuffer}} {
+		if buffer.size <= 0 || buffer.size&(buffer.size-1) != 0 {
+			invalid(buffer.key, "must be a power of two")
+		}
+	}
+
+	if c.Leader.LeaseTTL <= 0 {
+		invalid("leader.leaseTTL", "must be positive")
+	}
+	if c.Leader.RenewInterval <= 0 {
+		invalid("leader.renewInterval", "must be positive")
+	}
+	// A leader must get a few renewals through before its lease runs out
+	if c.Leader.RenewInterval*3 > c.Leader.LeaseTTL {
+		invalid("leader.renewInterval", "must be at most a third of leader.leaseTTL (%s)", c.Leader.LeaseTTL)
+	}
+	if c.Leader.KeyPrefix == "" {
+		invalid("leader.keyPrefix", "must not be empty")
+	}
+
+	if c.Shard.Enabled {
+		if !c.Leader.Enabled {
+			invalid("shard.enabled", "requires leader.enabled")
+		}
+		if u, err := url.Parse(c.Shard.AdvertiseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("shard.advertiseURL", "must be an http:// or https:// URL, got %q", c.Shard.AdvertiseURL)
+		} else if u.Path != "" && u.Path != "/" {
+			// Forwarded requests are signed with their path, which a prefix would change
+			invalid("shard.advertiseURL", "must not have a path, got %q", c.Shard.AdvertiseURL)
+		}
+		if c.Shard.PeerSecret == "" {
+			invalid("shard.peerSecret", "must be set when sharding is enabled")
+		}
+	}
+	if c.Shard.VirtualNodes <= 0 {
+		invalid("shard.virtualNodes", "must be positive")
+	}
+	for _, setting := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"shard.heartbeatTTL", c.Shard.HeartbeatTTL},
+		{"shard.rebalanceInterval", c.Shard.RebalanceInterval},
+		{"shard.handoverTimeout", c.Shard.HandoverTimeout},
+	} {
+		if setting.value <= 0 {
+			invalid(setting.key, "must be positive")
+		}
+	}
+	if c.Shard.KeyPrefix == "" {
+		invalid("shard.keyPrefix", "must not be empty")
+	}
+
+	if !validLevel(c.Log.Level) {
+		invalid("log.level", "must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	components := make([]string, 0, len(c.Log.Components))
+	for name := range c.Log.Components {
+		components = append(components, name)
+	}
+	sort.Strings(components)
+	for _, name := range components {
+		if !validLevel(c.Log.Components[name]) {
+			invalid("log.components."+name, "must be debug, info, warn or error, got %q", c.Log.Components[name])
+		}
+	}
+
+	for _, setting := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"health.interval", c.Health.Interval},
+		{"health.timeout", c.Health.Timeout},
+		{"health.maxMatcherDelay", c.Health.MaxMatcherDelay},
+		{"health.maxJournalLag", c.Health.MaxJournalLag},
+		{"health.retryMinDelay", c.Health.RetryMinDelay},
+		{"health.retryMaxDelay", c.Health.RetryMaxDelay},
+	} {
+		if setting.value <= 0 {
+			invalid(setting.key, "must be positive")
+		}
+	}
+	if c.Health.RetryMinDelay > c.Health.RetryMaxDelay {
+		invalid("health.retryMinDelay", "must not exceed health.retryMaxDelay (%s)", c.Health.RetryMaxDelay)
+	}
+	if c.Health.MaxMatcherDelay <= c.Matcher.Interval {
+		invalid("health.maxMatcherDelay", "must exceed matcher.interval (%s)", c.Matcher.Interval)
+	}
+
+	names := []struct{ key, name string }{
+		{"orders", c.Collections.Orders},
+		{"trades", c.Collections.Trades},
+		{"stateChanges", c.Collections.StateChanges},
+		{"counters", c.Collections.Counters},
+		{"balances", c.Collections.Balances},
+		{"ledger", c.Collections.Ledger},
+		{"positions", c.Collections.Positions},
+		{"feeSchedules", c.Collections.FeeSchedules},
+		{"feeAccountTiers", c.Collections.FeeAccountTiers},
+		{"rateLimits", c.Collections.RateLimits},
+		{"apiKeys", c.Collections.APIKeys},
+		{"candles", c.Collections.Candles},
+		{"fixSessions", c.Collections.FIXSessions},
+		{"fixMessages", c.Collections.FIXMessages},
+		{"snapshots", c.Collections.Snapshots},
+		{"instruments", c.Collections.Instruments},
+		{"accountControls", c.Collections.AccountControls},
+		{"auditLog", c.Collections.AuditLog},
+		{"killSwitch", c.Collections.KillSwitch},
+		{"fences", c.Collections.Fences},
+		{"assignments", c.Collections.Assignments},
+	}
+	for _, collection := range names {
+		if collection.name == "" || strings.ContainsAny(collection.name, "$\x00") {
+			invalid("collections."+collection.key, "must be a valid collection name, got %q", collection.name)
+		}
+	}
+
+	if c.Defaults.MaxTPS <= 0 {
+		invalid("defaults.maxTPS", "must be positive")
+	}
+	symbols := make([]string, 0, len(c.Symbols))
+	for symbol := range c.Symbols {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		if c.Symbols[symbol].MaxTPS < 0 {
+			invalid("symbols."+symbol+".maxTPS", "must not be negative")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validLevel(level string) bool {
+	switch strings.ToLower(level) {
+	case "debug", "info", "warn", "error":
+		return true
+	}
+	return false
+}
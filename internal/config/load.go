@@ -0,0 +1,130 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix prefixes the environment variables of every setting, the key path
+// is upper cased with dots replaced by underscores, e.g. MFUS_MONGO_URI
+const EnvPrefix = "MFUS"
+
+// flagKeys maps the command line flags to the settings they override
+var flagKeys = []struct {
+	name  string
+	key   string
+	usage string
+}{
+	{"http-addr", "server.httpAddr", "listen address of the REST API"},
+	{"grpc-addr", "server.grpcAddr", "listen address of the gRPC API"},
+	{"fix-addr", "server.fixAddr", "listen address of the FIX gateway"},
+	{"mongo-uri", "mongo.uri", "MongoDB connection URI"},
+	{"mongo-database", "mongo.database", "MongoDB database name"},
+	{"redis-addr", "redis.addr", "Redis host:port"},
+	{"matcher-interval", "matcher.interval", "delay between matching passes"},
+	{"log-level", "log.level", "default log level, debug, info, warn or error"},
+}
+
+// Load reads the configuration from the file named by --config or
+// MFUS_CONFIG, falling back to an optional config.yaml in the working
+// directory, then applies environment variables and the flags in args.
+// Settings that are not set anywhere keep the values of Default.
+func Load(args []string) (*Config, error) {
+	flags := pflag.NewFlagSet("mfus", pflag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv(EnvPrefix+"_CONFIG"), "configuration file (yaml, json or toml)")
+	for _, f := range flagKeys {
+		flags.String(f.name, "", f.usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	v := viper.New()
+	setDefaults(v, "", reflect.ValueOf(*Default()))
+
+	if *configFile != "" {
+		v.SetConfigFile(*configFile)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", *configFile, err)
+		}
+	} else {
+		v.SetConfigName("config")
+		v.AddConfigPath(".")
+		if err := v.ReadInConfig(); err != nil && !errors.As(err, &viper.ConfigFileNotFoundError{}) {
+			return nil, fmt.Errorf("reading config: %w", err)
+		}
+	}
+
+	// Deployments that predate the config package keep JWT_SECRET in .env
+	if err := loadDotEnv(".env"); err != nil {
+		return nil, err
+	}
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	if err := v.BindEnv("auth.jwtSecret", EnvPrefix+"_AUTH_JWTSECRET", "JWT_SECRET"); err != nil {
+		return nil, err
+	}
+
+	for _, f := range flagKeys {
+		if err := v.BindPFlag(f.key, flags.Lookup(f.name)); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg := &Config{}
+	if err := v.Unmarshal(cfg); err != nil {
+		return nil, fmt.Errorf("decoding config: %w", err)
+	}
+	// Keys are case insensitive, symbols are configured in upper case
+	symbols := make(map[string]SymbolConfig, len(cfg.Symbols))
+	for symbol, settings := range cfg.Symbols {
+		symbols[strings.ToUpper(symbol)] = settings
+	}
+	cfg.Symbols = symbols
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// setDefaults registers every leaf of the default config so that environment
+// variables are picked up for keys that are absent from the file
+func setDefaults(v *viper.Viper, prefix string, value reflect.Value) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		key := prefix + field.Tag.Get("mapstructure")
+		if field.Type.Kind() == reflect.Struct && field.Type.PkgPath() == value.Type().PkgPath() {
+			setDefaults(v, key+".", value.Field(i))
+			continue
+		}
+		v.SetDefault(key, value.Field(i).Interface())
+	}
+}
+
+// loadDotEnv exports the variables of an env file that are not already set
+func loadDotEnv(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	env := viper.New()
+	env.SetConfigFile(path)
+	env.SetConfigType("env")
+	if err := env.ReadInConfig(); err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	for _, key := range env.AllKeys() {
+		name := strings.ToUpper(key)
+		if _, ok := os.LookupEnv(name); !ok {
+			os.Setenv(name, env.GetString(key))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package console
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/internal/orderbook"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the REST API of a running order manager, authenticated with a
+// signed API key or a bearer token
+type Client struct {
+	BaseURL string
+	KeyID   string
+	Secret  string
+	Token   string
+	HTTP    *http.Client
+}
+
+// APIError is a non 2xx response of the REST API
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// NewClient returns a client of the REST API at baseURL, e.g. http://localhost:8080
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		HTTP:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// PlaceOrder submits a new order
+func (c *Client) PlaceOrder(order orderbook.OrderModel) (orderbook.OrderModel, error) {
+	var placed orderbook.OrderModel
+	err := c.do(http.MethodPost, "/v1/orders", nil, order, &placed)
+	return placed, err
+}
+
+// CancelOrder cancels an open order
+func (c *Client) CancelOrder(id string) (orderbook.OrderModel, error) {
+	var cancelled orderbook.OrderModel
+	err := c.do(http.MethodPost, "/v1/orders/"+url.PathEscape(id)+"/cancel", nil, nil, &cancelled)
+	return cancelled, err
+}
+
+// AmendOrder changes the price or quantity of an open order
+func (c *Client) AmendOrder(id string, updates map[string]interface{}) error {
+	return c.do(http.MethodPut, "/v1/orders/"+url.PathEscape(id), nil, updates, nil)
+}
+
+// GetOrder returns one order
+func (c *Client) GetOrder(id string) (orderbook.OrderModel, error) {
+	var order orderbook.OrderModel
+	err := c.do(http.MethodGet, "/v1/orders/"+url.PathEscape(id), nil, nil, &order)
+	return order, err
+}
+
+// OpenOrders returns the open orders of a symbol, oldest first
+func (c *Client) OpenOrders(symbol string) ([]orderbook.OrderModel, error) {
+	orders := make([]orderbook.OrderModel, 0)
+	err := c.do(http.MethodGet, "/v1/orders/open", url.Values{"symbol": {symbol}}, nil, &orders)
+	return orders, err
+}
+
+// Orders returns the most recent orders of a symbol in any state
+func (c *Client) Orders(symbol string, limit int) ([]orderbook.OrderModel, error) {
+	var page orderbook.OrderPage
+	query := url.Values{"symbol": {symbol}, "limit": {strconv.Itoa(limit)}}
+	err := c.do(http.MethodGet, "/v1/orders", query, nil, &page)
+	return page.Orders, err
+}
+
+// Depth returns the aggregated book of a symbol
+func (c *Client) Depth(symbol string, levels int) (orderbook.DepthModel, error) {
+	var depth orderbook.DepthModel
+	query := url.Values{"level": {"2"}, "depth": {strconv.Itoa(levels)}}
+	err := c.do(http.MethodGet, "/v1/book/"+url.PathEscape(symbol), query, nil, &depth)
+	return depth, err
+}
+
+// Trades returns the most recent trades of a symbol visible to the caller
+func (c *Client) Trades(symbol string, limit int) ([]orderbook.TradeHistoryModel, error) {
+	trades := make([]orderbook.TradeHistoryModel, 0)
+	query := url.Values{"symbol": {symbol}, "limit": {strconv.Itoa(limit)}}
+	err := c.do(http.MethodGet, "/v1/trades", query, nil, &trades)
+	return trades, err
+}
+
+func (c *Client) do(method string, path string, query url.Values, body interface{}, out interface{}) error {
+	requestURI := path
+	if len(query) > 0 {
+		requestURI += "?" + query.Encode()
+	}
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.BaseURL+requestURI, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := c.authenticate(req, requestURI, payload); err != nil {
+		return err
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var message bytes.Buffer
+		message.ReadFrom(resp.Body)
+		return &APIError{Status: resp.StatusCode, Message: strings.TrimSpace(message.String())}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) authenticate(req *http.Request, requestURI string, body []byte) error {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+		return nil
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(random)
+	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	req.Header.Set(auth.HeaderAPIKey, c.KeyID)
+	req.Header.Set(auth.HeaderTimestamp, timestamp)
+	req.Header.Set(auth.HeaderNonce, nonce)
+	req.Header.Set(auth.HeaderSignature, auth.Sign(c.Secret, timestamp, nonce, req.Method, requestURI, body))
+	return nil
+}
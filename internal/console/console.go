@@ -0,0 +1,333 @@
+// Package console is an operator console for the order manager. It drives a
+// running engine through its REST API, either interactively or from a script.
+package console
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"mfus_OMV1/internal/orderbook"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ErrExit is returned by Execute for the quit command
+var ErrExit = errors.New("exit")
+
+const usage = `Commands:
+  buy <quantity> <price>                  place a limit buy order on the current symbol
+  sell <quantity> <price>                 place a limit sell order on the current symbol
+  cancel <order id>                       cancel an open order
+  amend <order id> [price=<p>] [qty=<q>]  change the price or quantity of an open order
+  order <order id>                        show one order
+  orders [all] [limit]                    list open orders, or recent orders in any state
+  book [levels]                           show the aggregated book
+  trades [limit]                          show recent trades
+  symbol [symbol]                         show or switch the current symbol
+  help                                    show this help
+  quit                                    leave the console
+Blank lines and lines starting with # are ignored.
+`
+
+// Console executes operator commands against one engine
+type Console struct {
+	Client *Client
+	Symbol string
+	Out    io.Writer
+}
+
+// New returns a console writing its output to out
+func New(client *Client, symbol string, out io.Writer) *Console {
+	return &Console{Client: client, Symbol: symbol, Out: out}
+}
+
+// Run executes one command per line of in. Interactive sessions show a prompt
+// and carry on after a failed command, scripts stop at the first failure.
+func (c *Console) Run(in io.Reader, interactive bool) error {
+	scanner := bufio.NewScanner(in)
+	line := 0
+	for {
+		if interactive {
+			fmt.Fprintf(c.Out, "%s> ", c.Symbol)
+		}
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		line++
+
+		err := c.Execute(scanner.Text())
+		if err == ErrExit {
+			return nil
+		}
+		if err != nil {
+			if !interactive {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			fmt.Fprintln(c.Out, "error:", err)
+		}
+	}
+}
+
+// Execute runs a single command line
+func (c *Console) Execute(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	args := strings.Fields(line)
+	command, args := strings.ToLower(args[0]), args[1:]
+
+	switch command {
+	case "buy", "sell":
+		return c.place(command, args)
+	case "cancel":
+		return c.cancel(args)
+	case "amend":
+		return c.amend(args)
+	case "order":
+		return c.order(args)
+	case "orders":
+		return c.orders(args)
+	case "book":
+		return c.book(args)
+	case "trades":
+		return c.trades(args)
+	case "symbol":
+		if len(args) > 1 {
+			return errors.New("usage: symbol [symbol]")
+		}
+		if len(args) == 1 {
+			c.Symbol = strings.ToUpper(args[0])
+		}
+		fmt.Fprintln(c.Out, c.Symbol)
+		return nil
+	case "help", "?":
+		fmt.Fprint(c.Out, usage)
+		return nil
+	case "quit", "exit":
+		return ErrExit
+	}
+	return fmt.Errorf("unknown command %q, type help for the list of commands", command)
+}
+
+func (c *Console) place(side string, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s <quantity> <price>", side)
+	}
+	quantity, err := parseQuantity(args[0])
+	if err != nil {
+		return err
+	}
+	price, err := parsePrice(args[1])
+	if err != nil {
+		return err
+	}
+	if err := c.requireSymbol(); err != nil {
+		return err
+	}
+
+	order, err := c.Client.PlaceOrder(orderbook.OrderModel{
+		Symbol:   c.Symbol,
+		Side:     side,
+		Type:     "Limit",
+		Quantity: quantity,
+		Price:    price,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "placed %s %s %d @ %s, order %s\n", order.Side, order.Symbol, order.Quantity, formatPrice(order.Price), order.ID.Hex())
+	return nil
+}
+
+func (c *Console) cancel(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: cancel <order id>")
+	}
+	order, err := c.Client.CancelOrder(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "cancelled order %s, %d of %d filled\n", order.ID.Hex(), order.FilledQty, order.Quantity)
+	return nil
+}
+
+func (c *Console) amend(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: amend <order id> [price=<p>] [qty=<q>]")
+	}
+	updates := make(map[string]interface{})
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("expected key=value, got %q", arg)
+		}
+		switch strings.ToLower(key) {
+		case "price":
+			price, err := parsePrice(value)
+			if err != nil {
+				return err
+			}
+			updates["price"] = price
+		case "qty", "quantity":
+			quantity, err := parseQuantity(value)
+			if err != nil {
+				return err
+			}
+			updates["quantity"] = quantity
+		default:
+			return fmt.Errorf("cannot amend %q, only price and qty", key)
+		}
+	}
+	if err := c.Client.AmendOrder(args[0], updates); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "amended order %s\n", args[0])
+	return nil
+}
+
+func (c *Console) order(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: order <order id>")
+	}
+	order, err := c.Client.GetOrder(args[0])
+	if err != nil {
+		return err
+	}
+	c.printOrders([]orderbook.OrderModel{order})
+	return nil
+}
+
+func (c *Console) orders(args []string) error {
+	if err := c.requireSymbol(); err != nil {
+		return err
+	}
+	all := len(args) > 0 && args[0] == "all"
+	if all {
+		args = args[1:]
+	}
+	limit, err := optionalCount(args, 20)
+	if err != nil {
+		return err
+	}
+
+	var orders []orderbook.OrderModel
+	if all {
+		orders, err = c.Client.Orders(c.Symbol, limit)
+	} else {
+		orders, err = c.Client.OpenOrders(c.Symbol)
+		if len(orders) > limit {
+			orders = orders[:limit]
+		}
+	}
+	if err != nil {
+		return err
+	}
+	c.printOrders(orders)
+	return nil
+}
+
+func (c *Console) book(args []string) error {
+	if err := c.requireSymbol(); err != nil {
+		return err
+	}
+	levels, err := optionalCount(args, 10)
+	if err != nil {
+		return err
+	}
+	depth, err := c.Client.Depth(c.Symbol, levels)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.Out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "%s book, sequence %d\t\t\t\n", depth.Symbol, depth.Sequence)
+	fmt.Fprintln(w, "bid qty\tbid\task\task qty\t")
+	for i := 0; i < len(depth.Bids) || i < len(depth.Asks); i++ {
+		var bidQty, bid, ask, askQty string
+		if i < len(depth.Bids) {
+			bidQty, bid = strconv.FormatInt(depth.Bids[i].Quantity, 10), formatPrice(depth.Bids[i].Price)
+		}
+		if i < len(depth.Asks) {
+			ask, askQty = formatPrice(depth.Asks[i].Price), strconv.FormatInt(depth.Asks[i].Quantity, 10)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", bidQty, bid, ask, askQty)
+	}
+	return w.Flush()
+}
+
+func (c *Console) trades(args []string) error {
+	if err := c.requireSymbol(); err != nil {
+		return err
+	}
+	limit, err := optionalCount(args, 20)
+	if err != nil {
+		return err
+	}
+	trades, err := c.Client.Trades(c.Symbol, limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "time\tprice\tqty\tmaker\ttrade id")
+	for _, trade := range trades {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", trade.ExecutedAt.Local().Format(time.TimeOnly), formatPrice(trade.Price), trade.Quantity, trade.MakerSide, trade.Id)
+	}
+	return w.Flush()
+}
+
+func (c *Console) printOrders(orders []orderbook.OrderModel) {
+	w := tabwriter.NewWriter(c.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "order id\tsymbol\tside\tprice\tqty\tfilled\tstatus\tcreated")
+	for _, order := range orders {
+		created := time.UnixMilli(order.CreationTime).Local().Format(time.DateTime)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", order.ID.Hex(), order.Symbol, order.Side, formatPrice(order.Price), order.Quantity, order.FilledQty, order.Status, created)
+	}
+	w.Flush()
+}
+
+func (c *Console) requireSymbol() error {
+	if c.Symbol == "" {
+		return errors.New("no symbol selected, use: symbol <symbol>")
+	}
+	return nil
+}
+
+func parseQuantity(value string) (int64, error) {
+	quantity, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || quantity <= 0 {
+		return 0, fmt.Errorf("quantity must be a positive whole number, got %q", value)
+	}
+	return quantity, nil
+}
+
+func parsePrice(value string) (float64, error) {
+	price, err := strconv.ParseFloat(value, 64)
+	if err != nil || price <= 0 {
+		return 0, fmt.Errorf("price must be a positive number, got %q", value)
+	}
+	return price, nil
+}
+
+// optionalCount reads an optional positive count argument
+func optionalCount(args []string, fallback int) (int, error) {
+	if len(args) == 0 {
+		return fallback, nil
+	}
+	if len(args) > 1 {
+		return 0, errors.New("too many arguments")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("expected a positive number, got %q", args[0])
+	}
+	return n, nil
+}
+
+func formatPrice(price float64) string {
+	return strconv.FormatFloat(price, 'f', -1, 64)
+}
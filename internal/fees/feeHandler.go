@@ -0,0 +1,135 @@
+package fees
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/pkg/database"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type accountTierRequest struct {
+	Tier string `json:"tier"`
+}
+
+func GetScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	schedule, err := GetSchedule(ctx, client, params["symbol"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(schedule)
+}
+
+func SetScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	var schedule FeeScheduleModel
+	err := json.NewDecoder(r.Body).Decode(&schedule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	schedule.Symbol = params["symbol"]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = SetSchedule(ctx, client, schedule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(schedule)
+}
+
+func GetAccountTierHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	if !auth.AuthorizeUser(w, r, params["userID"]) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tier, err := TierFor(ctx, client, params["symbol"], params["userID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	volume, err := TradedVolume(ctx, client, params["userID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"userID":    params["userID"],
+		"symbol":    params["symbol"],
+		"volume30d": volume,
+		"tier":      tier,
+	})
+}
+
+func SetAccountTierHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	var req accountTierRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = SetAccountTier(ctx, client, params["userID"], req.Tier)
+	if errors.Is(err, ErrUnknownTier) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
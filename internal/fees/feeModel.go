@@ -0,0 +1,58 @@
+package fees
+
+import (
+	"mfus_OMV1/internal/config"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Define the database and collection names
+var dbName = "orderbook"
+var schedulesCollection = "fee_schedules"
+var accountTiersCollection = "fee_account_tiers"
+var tradeCollection = "trades"
+
+// Configure applies the database and collection names of the loaded config
+func Configure(cfg *config.Config) {
+	dbName = cfg.Mongo.Database
+	schedulesCollection = cfg.Collections.FeeSchedules
+	accountTiersCollection = cfg.Collections.FeeAccountTiers
+	tradeCollection = cfg.Collections.Trades
+}
+
+// DefaultSymbol is the schedule used for symbols without their own
+const DefaultSymbol = "*"
+
+// FeeTier is one volume tier of a fee schedule. Rates are fractions of the
+// trade notional, a negative maker rate is a rebate paid to the maker.
+type FeeTier struct {
+	Name      string  `json:"name" bson:"name"`
+	MinVolume float64 `json:"minVolume" bson:"minVolume"`
+	MakerRate float64 `json:"makerRate" bson:"makerRate"`
+	TakerRate float64 `json:"takerRate" bson:"takerRate"`
+}
+
+// FeeScheduleModel holds the tiers that apply to a symbol
+type FeeScheduleModel struct {
+	ID     primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Symbol string             `json:"symbol" bson:"symbol"`
+	Tiers  []FeeTier          `json:"tiers" bson:"tiers"`
+}
+
+// AccountTierModel pins a user to a named tier regardless of traded volume
+type AccountTierModel struct {
+	ID     primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	UserID string             `json:"userID" bson:"userID"`
+	Tier   string             `json:"tier" bson:"tier"`
+}
+
+// DefaultSchedule applies when no schedule has been configured in MongoDB
+var DefaultSchedule = FeeScheduleModel{
+	Symbol: DefaultSymbol,
+	Tiers: []FeeTier{
+		{Name: "T0", MinVolume: 0, MakerRate: 0.0010, TakerRate: 0.0020},
+		{Name: "T1", MinVolume: 100000, MakerRate: 0.0008, TakerRate: 0.0018},
+		{Name: "T2", MinVolume: 1000000, MakerRate: 0.0004, TakerRate: 0.0015},
+		{Name: "T3", MinVolume: 10000000, MakerRate: -0.0001, TakerRate: 0.0010},
+	},
+}
@@ -0,0 +1,214 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// VolumeWindow is the look-back period used to place a user in a tier
+const VolumeWindow = 30 * 24 * time.Hour
+
+// volumeCacheTTL bounds how often the trades collection is aggregated per user
+const volumeCacheTTL = time.Minute
+
+type cachedVolume struct {
+	volume float64
+	at     time.Time
+}
+
+var volumeMutex sync.Mutex
+var volumeCache = make(map[string]cachedVolume)
+
+// GetSchedule returns the schedule of a symbol, falling back to the default schedule
+func GetSchedule(ctx context.Context, mongoClient *mongo.Client, symbol string) (FeeScheduleModel, error) {
+	collection := mongoClient.Database(dbName).Collection(schedulesCollection)
+	for _, s := range []string{symbol, DefaultSymbol} {
+		var schedule FeeScheduleModel
+		err := collection.FindOne(ctx, bson.M{"symbol": s}).Decode(&schedule)
+		if err == nil {
+			return schedule, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return FeeScheduleModel{}, err
+		}
+	}
+	return DefaultSchedule, nil
+}
+
+// ErrUnknownTier is returned when a user is pinned to a tier no schedule has
+var ErrUnknownTier = errors.New("unknown fee tier")
+
+// SetSchedule validates and stores the schedule of a symbol
+func SetSchedule(ctx context.Context, mongoClient *mongo.Client, schedule FeeScheduleModel) error {
+	if err := validateSchedule(&schedule); err != nil {
+		return err
+	}
+	_, err := mongoClient.Database(dbName).Collection(schedulesCollection).ReplaceOne(ctx,
+		bson.M{"symbol": schedule.Symbol},
+		schedule,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// validateSchedule checks a schedule and sorts its tiers by volume
+func validateSchedule(schedule *FeeScheduleModel) error {
+	if schedule.Symbol == "" {
+		return errors.New("symbol is required")
+	}
+	if len(schedule.Tiers) == 0 {
+		return errors.New("at least one tier is required")
+	}
+	sort.Slice(schedule.Tiers, func(i, j int) bool {
+		return schedule.Tiers[i].MinVolume < schedule.Tiers[j].MinVolume
+	})
+	if schedule.Tiers[0].MinVolume != 0 {
+		return errors.New("the first tier must start at zero volume")
+	}
+	// The maker and the taker of a trade may be in different tiers, so the
+	// largest rebate must not exceed the smallest taker fee or a trade could
+	// pay out more than it collects. The rebate is paid in the asset and on
+	// the notional the taker pays its fee in, so both rates compare alike.
+	largestRebate, smallestTaker := schedule.Tiers[0], schedule.Tiers[0]
+	for _, tier := range schedule.Tiers {
+		if tier.Name == "" {
+			return errors.New("every tier needs a name")
+		}
+		if tier.TakerRate < 0 {
+			return errors.New("taker rate of tier " + tier.Name + " cannot be negative")
+		}
+		if tier.MakerRate < largestRebate.MakerRate {
+			largestRebate = tier
+		}
+		if tier.TakerRate < smallestTaker.TakerRate {
+			smallestTaker = tier
+		}
+	}
+	if largestRebate.MakerRate < -smallestTaker.TakerRate {
+		return errors.New("maker rebate of tier " + largestRebate.Name + " exceeds the taker rate of tier " + smallestTaker.Name)
+	}
+	return nil
+}
+
+// SetAccountTier pins a user to a named tier, an empty tier removes the
+// override. The tier must be in the default schedule or a stored one.
+func SetAccountTier(ctx context.Context, mongoClient *mongo.Client, userID string, tier string) error {
+	collection := mongoClient.Database(dbName).Collection(accountTiersCollection)
+	if tier == "" {
+		_, err := collection.DeleteOne(ctx, bson.M{"userID": userID})
+		return err
+	}
+	defaults, err := GetSchedule(ctx, mongoClient, DefaultSymbol)
+	if err != nil {
+		return err
+	}
+	if _, ok := namedTier(defaults, tier); !ok {
+		count, err := mongoClient.Database(dbName).Collection(schedulesCollection).CountDocuments(ctx, bson.M{"tiers.name": tier})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("%w %s", ErrUnknownTier, tier)
+		}
+	}
+	_, err = collection.ReplaceOne(ctx,
+		bson.M{"userID": userID},
+		AccountTierModel{UserID: userID, Tier: tier},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// TierFor returns the tier that applies to a user trading a symbol
+func TierFor(ctx context.Context, mongoClient *mongo.Client, symbol string, userID string) (FeeTier, error) {
+	schedule, err := GetSchedule(ctx, mongoClient, symbol)
+	if err != nil {
+		return FeeTier{}, err
+	}
+
+	var override AccountTierModel
+	err = mongoClient.Database(dbName).Collection(accountTiersCollection).FindOne(ctx, bson.M{"userID": userID}).Decode(&override)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return FeeTier{}, err
+	}
+	if err == nil {
+		if tier, ok := namedTier(schedule, override.Tier); ok {
+			return tier, nil
+		}
+	}
+
+	volume, err := TradedVolume(ctx, mongoClient, userID)
+	if err != nil {
+		return FeeTier{}, err
+	}
+	return tierForVolume(schedule, volume), nil
+}
+
+// namedTier returns the tier of a schedule with the given name
+func namedTier(schedule FeeScheduleModel, name string) (FeeTier, bool) {
+	for _, tier := range schedule.Tiers {
+		if tier.Name == name {
+			return tier, true
+		}
+	}
+	return FeeTier{}, false
+}
+
+// tierForVolume returns the highest tier whose minimum volume has been traded
+func tierForVolume(schedule FeeScheduleModel, volume float64) FeeTier {
+	tier := schedule.Tiers[0]
+	for _, t := range schedule.Tiers {
+		if volume >= t.MinVolume && t.MinVolume >= tier.MinVolume {
+			tier = t
+		}
+	}
+	return tier
+}
+
+// TradedVolume returns the quote volume a user traded over the last VolumeWindow
+func TradedVolume(ctx context.Context, mongoClient *mongo.Client, userID string) (float64, error) {
+	volumeMutex.Lock()
+	cached, ok := volumeCache[userID]
+	volumeMutex.Unlock()
+	if ok && time.Since(cached.at) < volumeCacheTTL {
+		return cached.volume, nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"executed_at": bson.M{"$gte": time.Now().Add(-VolumeWindow)},
+			"$or":         bson.A{bson.M{"buy_user_id": userID}, bson.M{"sell_user_id": userID}},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    nil,
+			"volume": bson.M{"$sum": bson.M{"$multiply": bson.A{"$price", "$quantity"}}},
+		}}},
+	}
+	cursor, err := mongoClient.Database(dbName).Collection(tradeCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	var results []struct {
+		Volume float64 `bson:"volume"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return 0, err
+	}
+	volume := 0.0
+	if len(results) > 0 {
+		volume = results[0].Volume
+	}
+
+	volumeMutex.Lock()
+	volumeCache[userID] = cachedVolume{volume: volume, at: time.Now()}
+	volumeMutex.Unlock()
+	return volume, nil
+}
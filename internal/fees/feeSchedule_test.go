@@ -0,0 +1,90 @@
+package fees
+
+import (
+	"testing"
+)
+
+func TestValidateSchedule(t *testing.T) {
+	tests := []struct {
+		name  string
+		tiers []FeeTier
+		valid bool
+	}{
+		{"default", DefaultSchedule.Tiers, true},
+		{"no tiers", nil, false},
+		{"first tier above zero", []FeeTier{
+			{Name: "T0", MinVolume: 10, MakerRate: 0.001, TakerRate: 0.002},
+		}, false},
+		{"unnamed tier", []FeeTier{
+			{Name: "", MinVolume: 0, MakerRate: 0.001, TakerRate: 0.002},
+		}, false},
+		{"negative taker rate", []FeeTier{
+			{Name: "T0", MinVolume: 0, MakerRate: 0.001, TakerRate: -0.001},
+		}, false},
+		{"rebate equal to the taker rate", []FeeTier{
+			{Name: "T0", MinVolume: 0, MakerRate: -0.001, TakerRate: 0.001},
+		}, true},
+		{"rebate above the taker rate of its tier", []FeeTier{
+			{Name: "T0", MinVolume: 0, MakerRate: -0.002, TakerRate: 0.001},
+		}, false},
+		{"rebate above the taker rate of another tier", []FeeTier{
+			{Name: "T0", MinVolume: 0, MakerRate: 0.001, TakerRate: 0.0005},
+			{Name: "VIP", MinVolume: 1000, MakerRate: -0.001, TakerRate: 0.002},
+		}, false},
+		{"tiers out of order", []FeeTier{
+			{Name: "T1", MinVolume: 1000, MakerRate: 0.0005, TakerRate: 0.001},
+			{Name: "T0", MinVolume: 0, MakerRate: 0.001, TakerRate: 0.002},
+		}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule := FeeScheduleModel{Symbol: "BTC-USD", Tiers: append([]FeeTier(nil), test.tiers...)}
+			err := validateSchedule(&schedule)
+			if (err == nil) != test.valid {
+				t.Fatalf("validateSchedule() = %v, want valid %v", err, test.valid)
+			}
+			for i := 1; err == nil && i < len(schedule.Tiers); i++ {
+				if schedule.Tiers[i-1].MinVolume > schedule.Tiers[i].MinVolume {
+					t.Fatalf("tiers not sorted by volume: %v", schedule.Tiers)
+				}
+			}
+		})
+	}
+}
+
+func TestTierForVolume(t *testing.T) {
+	tests := []struct {
+		volume float64
+		tier   string
+	}{
+		{0, "T0"},
+		{99999.99, "T0"},
+		{100000, "T1"},
+		{999999, "T1"},
+		{1000000, "T2"},
+		{50000000, "T3"},
+	}
+	for _, test := range tests {
+		if tier := tierForVolume(DefaultSchedule, test.volume); tier.Name != test.tier {
+			t.Errorf("tierForVolume(%v) = %s, want %s", test.volume, tier.Name, test.tier)
+		}
+	}
+}
+
+func TestNamedTier(t *testing.T) {
+	tests := []struct {
+		name  string
+		found bool
+	}{
+		{"T0", true},
+		{"T3", true},
+		{"t3", false},
+		{"VIP", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if tier, ok := namedTier(DefaultSchedule, test.name); ok != test.found || (ok && tier.Name != test.name) {
+			t.Errorf("namedTier(%q) = %v, %v", test.name, tier, ok)
+		}
+	}
+}
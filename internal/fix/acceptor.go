@@ -0,0 +1,240 @@
+// Package fix is a FIX 4.4 order entry gateway. It accepts initiator sessions,
+// keeps their sequence numbers and sent messages in Mongo so that sessions
+// survive reconnects and restarts, and translates NewOrderSingle,
+// OrderCancelRequest and OrderCancelReplaceRequest into the same order entry
+// calls as the REST and gRPC APIs.
+//
+// Sessions log on with an API key: Username (553) is the key ID and RawData
+// (96) the signature returned by LogonSignature.
+package fix
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/internal/logging"
+	"mfus_OMV1/internal/orderbook"
+	"mfus_OMV1/pkg/database"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var logger = logging.Logger("fix")
+
+// signedMethod stands in for the HTTP method in the signature of a Logon
+const signedMethod = "FIX"
+
+// ErrAcceptorClosed is returned by Serve once Shutdown was called
+var ErrAcceptorClosed = errors.New("fix: acceptor closed")
+
+// Acceptor serves FIX sessions under one CompID
+type Acceptor struct {
+	CompID string
+
+	mutex     sync.Mutex
+	sessions  map[string]*session
+	listeners []net.Listener
+	closing   chan struct{}
+	reason    string
+	conns     sync.WaitGroup
+}
+
+// NewAcceptor returns an acceptor that routes engine executions to its sessions
+func NewAcceptor(compID string) *Acceptor {
+	acceptor := &Acceptor{CompID: compID, sessions: make(map[string]*session), closing: make(chan struct{})}
+	orderbook.OnOrderUpdate(acceptor.orderUpdate)
+	return acceptor
+}
+
+// ListenAndServe accepts FIX connections on a TCP address
+func (a *Acceptor) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return a.Serve(listener)
+}
+
+// Serve accepts FIX connections on a listener
+func (a *Acceptor) Serve(listener net.Listener) error {
+	a.mutex.Lock()
+	if a.stopping() {
+		a.mutex.Unlock()
+		listener.Close()
+		return ErrAcceptorClosed
+	}
+	a.listeners = append(a.listeners, listener)
+	a.mutex.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if a.stopping() {
+				return ErrAcceptorClosed
+			}
+			return err
+		}
+		a.conns.Add(1)
+		go a.serve(conn)
+	}
+}
+
+// Shutdown stops accepting connections and logs every session out with a
+// reason once the execution reports queued for it are sent. Sessions still
+// open when the context ends are disconnected.
+func (a *Acceptor) Shutdown(ctx context.Context, reason string) error {
+	a.mutex.Lock()
+	if !a.stopping() {
+		a.reason = reason
+		close(a.closing)
+	}
+	for _, listener := range a.listeners {
+		listener.Close()
+	}
+	a.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		a.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	a.mutex.Lock()
+	for _, s := range a.sessions {
+		s.conn.Close()
+	}
+	a.mutex.Unlock()
+	return ctx.Err()
+}
+
+// stopping reports whether Shutdown was called
+func (a *Acceptor) stopping() bool {
+	select {
+	case <-a.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *Acceptor) serve(conn net.Conn) {
+	defer a.conns.Done()
+	defer conn.Close()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		logger.Error("cannot serve connection", "error", err)
+		return
+	}
+
+	s := &session{
+		acceptor: a,
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		ctx:      context.Background(),
+		reports:  make(chan *Message, reportQueue),
+	}
+
+	conn.SetReadDeadline(time.Now().Add(logonTimeout))
+	logon, err := ReadMessage(s.reader)
+	if err != nil {
+		logger.Info("connection closed before logon", "remote", conn.RemoteAddr().String(), "error", err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	s.store = &store{client: client, sessionID: sessionID(a.CompID, logon.Get(TagSenderCompID))}
+	defer a.unregister(s)
+	// A Logon that fails before the session is established is answered by closing the connection
+	if err := s.logon(logon); err != nil {
+		if err != errLoggedOut {
+			logger.Warn("logon rejected", "remote", conn.RemoteAddr().String(), "error", err)
+		}
+		return
+	}
+	logger.Info("session logged on", "session", s.id, "user", s.principal.UserID)
+	s.run()
+}
+
+// register makes a session the only connection of its session ID
+func (a *Acceptor) register(s *session) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if _, ok := a.sessions[s.id]; ok {
+		return false
+	}
+	a.sessions[s.id] = s
+	return true
+}
+
+func (a *Acceptor) unregister(s *session) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.sessions[s.id] == s {
+		delete(a.sessions, s.id)
+	}
+}
+
+// orderUpdate sends fills and expiries to every session of the order's owner
+func (a *Acceptor) orderUpdate(event string, order orderbook.OrderModel) {
+	report := engineReport(event, order)
+	if report == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, s := range a.sessions {
+		if s.principal.UserID == order.UserID {
+			// Every session numbers its own copy
+			s.queueReport(&Message{Fields: append([]Field(nil), report.Fields...)})
+		}
+	}
+}
+
+// authenticateLogon resolves the principal of the API key a Logon is signed with
+func authenticateLogon(ctx context.Context, logon *Message) (auth.Principal, error) {
+	if logon.Get(TagUsername) == "" || logon.Get(TagRawData) == "" {
+		return auth.Principal{}, errors.New("Logon needs Username and RawData")
+	}
+	timestamp, nonce, uri, err := logonSigned(logon)
+	if err != nil {
+		return auth.Principal{}, err
+	}
+	return auth.AuthenticateSigned(ctx, auth.SignedRequest{
+		KeyID:      logon.Get(TagUsername),
+		Timestamp:  timestamp,
+		Nonce:      nonce,
+		Signature:  logon.Get(TagRawData),
+		Method:     signedMethod,
+		RequestURI: uri,
+	})
+}
+
+// LogonSignature signs a Logon with an API key secret. The signature covers the
+// SendingTime, MsgSeqNum and both CompIDs, so the Logon must be complete.
+func LogonSignature(secret string, logon *Message) (string, error) {
+	timestamp, nonce, uri, err := logonSigned(logon)
+	if err != nil {
+		return "", err
+	}
+	return auth.Sign(secret, timestamp, nonce, signedMethod, uri, nil), nil
+}
+
+// logonSigned maps a Logon onto the parts of a signed API key request
+func logonSigned(logon *Message) (string, string, string, error) {
+	sendingTime, err := time.Parse(TimestampFormat, logon.Get(TagSendingTime))
+	if err != nil {
+		return "", "", "", errors.New("invalid SendingTime")
+	}
+	timestamp := strconv.FormatInt(sendingTime.UnixNano()/int64(time.Millisecond), 10)
+	nonce := logon.Get(TagMsgSeqNum) + "/" + logon.Get(TagSendingTime)
+	uri := logon.Get(TagSenderCompID) + "/" + logon.Get(TagTargetCompID)
+	return timestamp, nonce, uri, nil
+}
@@ -0,0 +1,190 @@
+package fix
+
+import (
+	"context"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/internal/config"
+	"mfus_OMV1/internal/orderbook"
+	"mfus_OMV1/pkg/database"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const testCompID = "MFUS"
+
+// testMongo points the packages the acceptor uses at a scratch database, which
+// is dropped when the test ends. The test is skipped without a MongoDB, set
+// MFUS_MONGO_URI to run it against one.
+func testMongo(t *testing.T) *mongo.Client {
+	t.Helper()
+	cfg := config.Default()
+	if uri := os.Getenv(config.EnvPrefix + "_MONGO_URI"); uri != "" {
+		cfg.Mongo.URI = uri
+	}
+	cfg.Mongo.Database = "mfus_fix_test"
+	database.Configure(cfg)
+	auth.Configure(cfg)
+	Configure(cfg)
+
+	client, err := database.GetMongoClient()
+	if err != nil {
+		t.Skipf("MongoDB unavailable: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Skipf("MongoDB unavailable: %v", err)
+	}
+	t.Cleanup(func() {
+		client.Database(cfg.Mongo.Database).Drop(context.Background())
+	})
+	return client
+}
+
+// startAcceptor serves an acceptor on a free local port until the test ends
+func startAcceptor(t *testing.T) (*Acceptor, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	acceptor := NewAcceptor(testCompID)
+	go acceptor.Serve(listener)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		acceptor.Shutdown(ctx, "test finished")
+	})
+	return acceptor, listener.Addr().String()
+}
+
+// expect returns the next message that is not a heartbeat and fails unless it
+// has the given type
+func expect(t *testing.T, i *Initiator, msgType string) *Message {
+	t.Helper()
+	for {
+		message, err := i.Receive(5 * time.Second)
+		if err != nil {
+			t.Fatalf("waiting for MsgType %s: %v", msgType, err)
+		}
+		if message.Type() == MsgHeartbeat && msgType != MsgHeartbeat {
+			continue
+		}
+		if message.Type() != msgType {
+			t.Fatalf("expected MsgType %s, got %s", msgType, message)
+		}
+		return message
+	}
+}
+
+func TestAcceptorSession(t *testing.T) {
+	client := testMongo(t)
+	ctx := context.Background()
+	key, err := auth.CreateKey(ctx, client, "fix-test-user", "acceptor test", []string{auth.PermTrade})
+	if err != nil {
+		t.Fatalf("creating API key: %v", err)
+	}
+	acceptor, addr := startAcceptor(t)
+
+	initiator, err := Dial(addr, "CLIENT", testCompID, key.KeyID, key.Secret)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer initiator.conn.Close()
+
+	// Logon, the acceptor answers with its own Logon as message 1
+	if err := initiator.Logon(); err != nil {
+		t.Fatalf("logon: %v", err)
+	}
+
+	// NewOrderSingle is answered with an ExecutionReport, a market order is
+	// rejected by the session before it reaches the engine
+	err = initiator.Send(NewMessage(MsgNewOrderSingle).
+		Set(TagClOrdID, "order-1").
+		Set(TagSymbol, "BTC-USD").
+		Set(TagSide, "1").
+		Set(TagOrdType, "1").
+		SetInt(TagOrderQty, 1))
+	if err != nil {
+		t.Fatalf("sending NewOrderSingle: %v", err)
+	}
+	report := expect(t, initiator, MsgExecutionReport)
+	if report.Get(TagClOrdID) != "order-1" || report.Get(TagExecType) != execRejected ||
+		report.Get(TagOrdStatus) != ordStatus[orderbook.Rejected] {
+		t.Fatalf("unexpected execution report %s", report)
+	}
+	if report.Int(TagMsgSeqNum) != 2 {
+		t.Fatalf("expected the execution report to be message 2, got %d", report.Int(TagMsgSeqNum))
+	}
+
+	// Skipping messages 3 and 4 makes the acceptor ask for them
+	initiator.nextOut = 5
+	if err := initiator.Send(NewMessage(MsgHeartbeat)); err != nil {
+		t.Fatalf("sending heartbeat: %v", err)
+	}
+	resendRequest := expect(t, initiator, MsgResendRequest)
+	if resendRequest.Int(TagBeginSeqNo) != 3 || resendRequest.Int(TagEndSeqNo) != 0 {
+		t.Fatalf("expected a resend request from 3, got %s", resendRequest)
+	}
+
+	// Gap fill 3 to 5, the heartbeat that was ahead of the gap carries no data
+	initiator.nextOut = 3
+	err = initiator.Send(NewMessage(MsgSequenceReset).
+		Set(TagGapFillFlag, "Y").
+		Set(TagPossDupFlag, "Y").
+		SetInt(TagNewSeqNo, 6))
+	if err != nil {
+		t.Fatalf("sending gap fill: %v", err)
+	}
+	initiator.nextOut = 6
+
+	// Asking for everything resends the execution report and gap fills the
+	// admin messages around it
+	if err := initiator.Send(NewMessage(MsgResendRequest).SetInt(TagBeginSeqNo, 1).SetInt(TagEndSeqNo, 0)); err != nil {
+		t.Fatalf("sending resend request: %v", err)
+	}
+	gapFill := expect(t, initiator, MsgSequenceReset)
+	if gapFill.Int(TagMsgSeqNum) != 1 || gapFill.Int(TagNewSeqNo) != 2 || gapFill.Get(TagGapFillFlag) != "Y" {
+		t.Fatalf("expected a gap fill of the logon, got %s", gapFill)
+	}
+	resent := expect(t, initiator, MsgExecutionReport)
+	if resent.Int(TagMsgSeqNum) != 2 || resent.Get(TagPossDupFlag) != "Y" || resent.Get(TagClOrdID) != "order-1" ||
+		!resent.Has(TagOrigSendingTime) {
+		t.Fatalf("expected the execution report as a possible duplicate, got %s", resent)
+	}
+	gapFill = expect(t, initiator, MsgSequenceReset)
+	if gapFill.Int(TagMsgSeqNum) != 3 || gapFill.Int(TagNewSeqNo) != 4 {
+		t.Fatalf("expected a gap fill of the resend request, got %s", gapFill)
+	}
+
+	// The gap is closed, so the next message is processed in sequence
+	if err := initiator.Send(NewMessage(MsgTestRequest).Set(TagTestReqID, "after-gap")); err != nil {
+		t.Fatalf("sending test request: %v", err)
+	}
+	heartbeat := expect(t, initiator, MsgHeartbeat)
+	if heartbeat.Get(TagTestReqID) != "after-gap" || heartbeat.Int(TagMsgSeqNum) != 4 {
+		t.Fatalf("expected heartbeat 4 answering the test request, got %s", heartbeat)
+	}
+
+	// Logout is confirmed and ends the session
+	if err := initiator.Logout(); err != nil {
+		t.Fatalf("logout: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		acceptor.mutex.Lock()
+		open := len(acceptor.sessions)
+		acceptor.mutex.Unlock()
+		if open == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session still registered after logout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,282 @@
+package fix
+
+import (
+	"context"
+	"errors"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/internal/health"
+	"mfus_OMV1/internal/logging"
+	"mfus_OMV1/internal/orderbook"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ExecType values (tag 150)
+const (
+	execNew      = "0"
+	execCanceled = "4"
+	execReplaced = "5"
+	execRejected = "8"
+	execExpired  = "C"
+	execTrade    = "F"
+)
+
+// OrdStatus values (tag 39)
+var ordStatus = map[orderbook.OrderStatus]string{
+	orderbook.Open:      "0",
+	orderbook.Partial:   "1",
+	orderbook.Filled:    "2",
+	orderbook.Cancelled: "4",
+	orderbook.Rejected:  "8",
+	orderbook.Expired:   "C",
+}
+
+// Reject reasons of orders (tag 103) and cancel requests (tag 102)
+const (
+	ordRejUnknownSymbol = "1"
+	ordRejClosed        = "2"
+	ordRejOther         = "99"
+	cxlRejTooLate       = "0"
+	cxlRejUnknownOrder  = "1"
+	cxlRejOther         = "99"
+)
+
+// CxlRejResponseTo values (tag 434)
+const (
+	responseToCancel  = "1"
+	responseToReplace = "2"
+)
+
+// requestTimeout bounds the engine call of one order message
+const requestTimeout = 5 * time.Second
+
+func (s *session) newOrderSingle(message *Message) error {
+	clOrdID := message.Get(TagClOrdID)
+	reject := func(order orderbook.OrderModel, reason string, err error) error {
+		order.ClientOrderID = clOrdID
+		order.Status = orderbook.Rejected
+		report := executionReport(order, execRejected, newExecID()).
+			Set(TagOrdRejReason, reason).
+			Set(TagText, err.Error())
+		return s.send(report)
+	}
+
+	order := orderbook.OrderModel{
+		ClientOrderID: clOrdID,
+		Symbol:        message.Get(TagSymbol),
+		Side:          sideOf(message.Get(TagSide)),
+		Type:          "Limit",
+		Price:         message.Float(TagPrice),
+		Quantity:      int64(message.Float(TagOrderQty)),
+	}
+	switch {
+	case clOrdID == "":
+		return reject(order, ordRejOther, errors.New("ClOrdID is required"))
+	case !s.principal.Has(auth.PermTrade):
+		return reject(order, ordRejOther, errors.New("missing "+auth.PermTrade+" permission"))
+	case order.Symbol == "":
+		return reject(order, ordRejUnknownSymbol, errors.New("Symbol is required"))
+	case message.Get(TagOrdType) != "2":
+		return reject(order, ordRejOther, errors.New("only limit orders (OrdType 2) are supported"))
+	}
+	if expireTime := message.Get(TagExpireTime); expireTime != "" {
+		expiration, err := time.Parse(TimestampFormat, expireTime)
+		if err != nil {
+			return reject(order, ordRejOther, errors.New("invalid ExpireTime"))
+		}
+		order.Expiration = expiration.UnixNano() / int64(time.Millisecond)
+	}
+
+	ctx, cancel := s.requestContext(message)
+	defer cancel()
+	placed, err := orderbook.PlaceOrder(ctx, order)
+	if err != nil {
+		if placed.ID.IsZero() {
+			placed = order
+		}
+		if errors.Is(err, health.ErrNotReady) || errors.Is(err, orderbook.ErrSymbolHalted) ||
+			errors.Is(err, orderbook.ErrKillSwitch) {
+			return reject(placed, ordRejClosed, err)
+		}
+		return reject(placed, ordRejOther, err)
+	}
+	return s.send(executionReport(placed, execNew, newExecID()))
+}
+
+func (s *session) cancelRequest(message *Message) error {
+	order, err := s.findOrder(message)
+	if err != nil {
+		return s.cancelReject(message, order, responseToCancel, err)
+	}
+
+	ctx, cancel := s.requestContext(message)
+	defer cancel()
+	cancelled, err := orderbook.CancelOrder(ctx, order.ID, "cancelled by user")
+	if err != nil {
+		return s.cancelReject(message, order, responseToCancel, err)
+	}
+	cancelled.ClientOrderID = message.Get(TagClOrdID)
+	report := executionReport(cancelled, execCanceled, newExecID()).
+		Set(TagOrigClOrdID, message.Get(TagOrigClOrdID))
+	return s.send(report)
+}
+
+func (s *session) cancelReplaceRequest(message *Message) error {
+	order, err := s.findOrder(message)
+	if err != nil {
+		return s.cancelReject(message, order, responseToReplace, err)
+	}
+	if symbol := message.Get(TagSymbol); symbol != "" && symbol != order.Symbol {
+		return s.cancelReject(message, order, responseToReplace, errors.New("Symbol cannot be replaced"))
+	}
+	if side := message.Get(TagSide); side != "" && sideOf(side) != order.Side {
+		return s.cancelReject(message, order, responseToReplace, errors.New("Side cannot be replaced"))
+	}
+
+	updates := bson.M{"clientOrderID": message.Get(TagClOrdID)}
+	if message.Has(TagPrice) {
+		updates["price"] = message.Float(TagPrice)
+	}
+	if message.Has(TagOrderQty) {
+		updates["quantity"] = int64(message.Float(TagOrderQty))
+	}
+
+	ctx, cancel := s.requestContext(message)
+	defer cancel()
+	replaced, err := orderbook.AmendOrder(ctx, order.ID, updates)
+	if err != nil {
+		return s.cancelReject(message, order, responseToReplace, err)
+	}
+	report := executionReport(replaced, execReplaced, newExecID()).
+		Set(TagOrigClOrdID, message.Get(TagOrigClOrdID))
+	return s.send(report)
+}
+
+// requestContext bounds an engine call made for an inbound message. The
+// correlation ID names the session and sequence number of the message, so
+// every change it causes can be traced back to it.
+func (s *session) requestContext(message *Message) (context.Context, context.CancelFunc) {
+	ctx := logging.WithCorrelationID(s.ctx, s.id+":"+message.Get(TagMsgSeqNum))
+	return context.WithTimeout(ctx, requestTimeout)
+}
+
+// findOrder resolves the order of a cancel or replace request, by OrderID
+// when given and by OrigClOrdID otherwise
+func (s *session) findOrder(message *Message) (orderbook.OrderModel, error) {
+	if message.Get(TagClOrdID) == "" {
+		return orderbook.OrderModel{}, errors.New("ClOrdID is required")
+	}
+	if !s.principal.Has(auth.PermTrade) {
+		return orderbook.OrderModel{}, errors.New("missing " + auth.PermTrade + " permission")
+	}
+
+	ctx, cancel := s.requestContext(message)
+	defer cancel()
+	if id, err := primitive.ObjectIDFromHex(message.Get(TagOrderID)); err == nil {
+		return orderbook.GetOrder(ctx, id)
+	}
+	if message.Get(TagOrigClOrdID) == "" {
+		return orderbook.OrderModel{}, errors.New("OrderID or OrigClOrdID is required")
+	}
+	return orderbook.FindOrderByClientID(ctx, message.Get(TagOrigClOrdID))
+}
+
+// cancelReject answers a cancel or replace request that could not be applied
+func (s *session) cancelReject(message *Message, order orderbook.OrderModel, responseTo string, err error) error {
+	reason := cxlRejOther
+	switch {
+	case errors.Is(err, orderbook.ErrOrderNotFound), errors.Is(err, auth.ErrForbidden):
+		reason = cxlRejUnknownOrder
+	case errors.Is(err, orderbook.ErrIllegalTransition):
+		reason = cxlRejTooLate
+	}
+
+	orderID := "NONE"
+	status := ordStatus[orderbook.Rejected]
+	if !order.ID.IsZero() {
+		orderID = order.ID.Hex()
+		status = ordStatus[order.Status]
+	}
+	reject := NewMessage(MsgOrderCancelReject).
+		Set(TagOrderID, orderID).
+		Set(TagClOrdID, message.Get(TagClOrdID)).
+		Set(TagOrigClOrdID, message.Get(TagOrigClOrdID)).
+		Set(TagOrdStatus, status).
+		Set(TagCxlRejResponseTo, responseTo).
+		Set(TagCxlRejReason, reason).
+		Set(TagText, err.Error())
+	return s.send(reject)
+}
+
+// engineReport turns an order change made outside the session into an
+// execution report, or nil for changes the session already answered
+func engineReport(event string, order orderbook.OrderModel) *Message {
+	switch event {
+	case orderbook.EventFilled:
+		if order.FilledOrder == nil {
+			return nil
+		}
+		// Both sides of a trade may belong to the same user, so the side keeps ExecIDs unique
+		report := executionReport(order, execTrade, order.FilledOrder.OrderID+"-"+order.Side)
+		report.SetFloat(TagLastQty, order.FilledOrder.Quantity)
+		report.SetFloat(TagLastPx, order.FilledOrder.Price)
+		return report
+	case orderbook.EventExpired:
+		return executionReport(order, execExpired, newExecID())
+	}
+	return nil
+}
+
+// executionReport describes the current state of an order
+func executionReport(order orderbook.OrderModel, execType string, execID string) *Message {
+	orderID := "NONE"
+	if !order.ID.IsZero() {
+		orderID = order.ID.Hex()
+	}
+	leaves := order.Quantity - order.FilledQty
+	if order.Status.IsFinal() || leaves < 0 {
+		leaves = 0
+	}
+
+	report := NewMessage(MsgExecutionReport).
+		Set(TagOrderID, orderID).
+		Set(TagExecID, execID).
+		Set(TagExecType, execType).
+		Set(TagOrdStatus, ordStatus[order.Status]).
+		Set(TagSymbol, order.Symbol).
+		Set(TagSide, fixSide(order.Side)).
+		Set(TagOrdType, "2").
+		SetFloat(TagPrice, order.Price).
+		SetInt(TagOrderQty, order.Quantity).
+		SetInt(TagLeavesQty, leaves).
+		SetInt(TagCumQty, order.FilledQty).
+		SetFloat(TagAvgPx, order.FilledAverage).
+		SetTime(TagTransactTime, time.Now())
+	if order.ClientOrderID != "" {
+		report.Set(TagClOrdID, order.ClientOrderID)
+	}
+	return report
+}
+
+func sideOf(side string) string {
+	switch side {
+	case "1":
+		return "Buy"
+	case "2":
+		return "Sell"
+	}
+	return side
+}
+
+func fixSide(side string) string {
+	if side == "Buy" {
+		return "1"
+	}
+	return "2"
+}
+
+func newExecID() string {
+	return primitive.NewObjectID().Hex()
+}
@@ -0,0 +1,128 @@
+package fix
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Initiator is a minimal FIX client for exercising the acceptor locally, e.g.
+// from cmd/fixclient. It keeps its sequence numbers in memory and always logs
+// on with ResetSeqNumFlag, so it is not meant for production sessions.
+type Initiator struct {
+	CompID       string
+	TargetCompID string
+	KeyID        string
+	Secret       string
+	HeartBtInt   time.Duration
+
+	conn    net.Conn
+	reader  *bufio.Reader
+	mutex   sync.Mutex
+	nextOut int64
+}
+
+// Dial connects an initiator to an acceptor
+func Dial(addr string, compID string, targetCompID string, keyID string, secret string) (*Initiator, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Initiator{
+		CompID:       compID,
+		TargetCompID: targetCompID,
+		KeyID:        keyID,
+		Secret:       secret,
+		HeartBtInt:   DefaultHeartBtInt,
+		conn:         conn,
+		reader:       bufio.NewReader(conn),
+		nextOut:      1,
+	}, nil
+}
+
+// Logon signs on with the API key and waits for the acceptor's Logon
+func (i *Initiator) Logon() error {
+	logon := NewMessage(MsgLogon).
+		Set(TagEncryptMethod, "0").
+		SetInt(TagHeartBtInt, int64(i.HeartBtInt/time.Second)).
+		Set(TagResetSeqNumFlag, "Y").
+		Set(TagUsername, i.KeyID)
+
+	i.mutex.Lock()
+	i.header(logon)
+	signature, err := LogonSignature(i.Secret, logon)
+	if err != nil {
+		i.mutex.Unlock()
+		return err
+	}
+	logon.SetInt(TagRawDataLength, int64(len(signature)))
+	logon.Set(TagRawData, signature)
+	err = i.write(logon)
+	i.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	reply, err := i.Receive(10 * time.Second)
+	if err != nil {
+		return err
+	}
+	if reply.Type() != MsgLogon {
+		return errors.New("logon refused: " + reply.Get(TagText))
+	}
+	return nil
+}
+
+// Send numbers and sends a message
+func (i *Initiator) Send(message *Message) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.header(message)
+	return i.write(message)
+}
+
+// Receive returns the next message from the acceptor. Test requests are
+// answered on the way, so callers only see them for information.
+func (i *Initiator) Receive(timeout time.Duration) (*Message, error) {
+	i.conn.SetReadDeadline(time.Now().Add(timeout))
+	message, err := ReadMessage(i.reader)
+	if err != nil {
+		return nil, err
+	}
+	if message.Type() == MsgTestRequest {
+		err = i.Send(NewMessage(MsgHeartbeat).Set(TagTestReqID, message.Get(TagTestReqID)))
+	}
+	return message, err
+}
+
+// Logout signs off and closes the connection
+func (i *Initiator) Logout() error {
+	err := i.Send(NewMessage(MsgLogout))
+	if err == nil {
+		// Wait for the acceptor to confirm, anything else is dropped
+		for {
+			message, receiveErr := i.Receive(5 * time.Second)
+			if receiveErr != nil || message.Type() == MsgLogout {
+				break
+			}
+		}
+	}
+	i.conn.Close()
+	return err
+}
+
+func (i *Initiator) header(message *Message) {
+	message.Set(TagSenderCompID, i.CompID)
+	message.Set(TagTargetCompID, i.TargetCompID)
+	message.SetInt(TagMsgSeqNum, i.nextOut)
+	message.SetTime(TagSendingTime, time.Now())
+	i.nextOut++
+}
+
+func (i *Initiator) write(message *Message) error {
+	i.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := i.conn.Write(message.Encode())
+	return err
+}
@@ -0,0 +1,305 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// BeginString of every message, only FIX 4.4 is spoken
+const BeginString = "FIX.4.4"
+
+// TimestampFormat is the FIX UTCTimestamp format with milliseconds
+const TimestampFormat = "20060102-15:04:05.000"
+
+const soh = '\x01'
+
+// maxBodyLength guards against a corrupt BodyLength allocating a huge buffer
+const maxBodyLength = 1 << 20
+
+// Tags used by the gateway
+const (
+	TagAvgPx             = 6
+	TagBeginSeqNo        = 7
+	TagBeginString       = 8
+	TagBodyLength        = 9
+	TagCheckSum          = 10
+	TagClOrdID           = 11
+	TagCumQty            = 14
+	TagEndSeqNo          = 16
+	TagExecID            = 17
+	TagLastPx            = 31
+	TagLastQty           = 32
+	TagMsgSeqNum         = 34
+	TagMsgType           = 35
+	TagNewSeqNo          = 36
+	TagOrderID           = 37
+	TagOrderQty          = 38
+	TagOrdStatus         = 39
+	TagOrdType           = 40
+	TagOrigClOrdID       = 41
+	TagPossDupFlag       = 43
+	TagPrice             = 44
+	TagRefSeqNum         = 45
+	TagSenderCompID      = 49
+	TagSendingTime       = 52
+	TagSide              = 54
+	TagSymbol            = 55
+	TagTargetCompID      = 56
+	TagText              = 58
+	TagTransactTime      = 60
+	TagRawDataLength     = 95
+	TagRawData           = 96
+	TagEncryptMethod     = 98
+	TagCxlRejReason      = 102
+	TagOrdRejReason      = 103
+	TagHeartBtInt        = 108
+	TagTestReqID         = 112
+	TagOrigSendingTime   = 122
+	TagGapFillFlag       = 123
+	TagExpireTime        = 126
+	TagResetSeqNumFlag   = 141
+	TagExecType          = 150
+	TagLeavesQty         = 151
+	TagRefTagID          = 371
+	TagRefMsgType        = 372
+	TagSessionRejReason  = 373
+	TagBusinessRejReason = 380
+	TagCxlRejResponseTo  = 434
+	TagUsername          = 553
+)
+
+// Message types used by the gateway
+const (
+	MsgHeartbeat                 = "0"
+	MsgTestRequest               = "1"
+	MsgResendRequest             = "2"
+	MsgReject                    = "3"
+	MsgSequenceReset             = "4"
+	MsgLogout                    = "5"
+	MsgExecutionReport           = "8"
+	MsgOrderCancelReject         = "9"
+	MsgLogon                     = "A"
+	MsgNewOrderSingle            = "D"
+	MsgOrderCancelRequest        = "F"
+	MsgOrderCancelReplaceRequest = "G"
+	MsgBusinessMessageReject     = "j"
+)
+
+// headerTags are written first, in this order, after BeginString and BodyLength
+var headerTags = []int{TagMsgType, TagSenderCompID, TagTargetCompID, TagMsgSeqNum, TagPossDupFlag, TagSendingTime, TagOrigSendingTime}
+
+// Field is one tag=value pair
+type Field struct {
+	Tag   int
+	Value string
+}
+
+// Message is a FIX message without its BeginString, BodyLength and CheckSum,
+// which are computed when it is encoded
+type Message struct {
+	Fields []Field
+}
+
+// NewMessage returns an empty message of a type
+func NewMessage(msgType string) *Message {
+	return &Message{Fields: []Field{{Tag: TagMsgType, Value: msgType}}}
+}
+
+// Type returns the MsgType of the message
+func (m *Message) Type() string {
+	return m.Get(TagMsgType)
+}
+
+// Has reports whether a tag is present
+func (m *Message) Has(tag int) bool {
+	for _, field := range m.Fields {
+		if field.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the value of the first occurrence of a tag, or ""
+func (m *Message) Get(tag int) string {
+	for _, field := range m.Fields {
+		if field.Tag == tag {
+			return field.Value
+		}
+	}
+	return ""
+}
+
+// Int returns the value of a tag as an integer, 0 when absent or invalid
+func (m *Message) Int(tag int) int64 {
+	value, _ := strconv.ParseInt(m.Get(tag), 10, 64)
+	return value
+}
+
+// Float returns the value of a tag as a float, 0 when absent or invalid
+func (m *Message) Float(tag int) float64 {
+	value, _ := strconv.ParseFloat(m.Get(tag), 64)
+	return value
+}
+
+// Set replaces the value of a tag, or appends it when absent
+func (m *Message) Set(tag int, value string) *Message {
+	for i := range m.Fields {
+		if m.Fields[i].Tag == tag {
+			m.Fields[i].Value = value
+			return m
+		}
+	}
+	m.Fields = append(m.Fields, Field{Tag: tag, Value: value})
+	return m
+}
+
+// SetInt sets an integer tag
+func (m *Message) SetInt(tag int, value int64) *Message {
+	return m.Set(tag, strconv.FormatInt(value, 10))
+}
+
+// SetFloat sets a decimal tag with the shortest exact representation
+func (m *Message) SetFloat(tag int, value float64) *Message {
+	return m.Set(tag, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// SetTime sets a UTCTimestamp tag
+func (m *Message) SetTime(tag int, value time.Time) *Message {
+	return m.Set(tag, value.UTC().Format(TimestampFormat))
+}
+
+// Remove deletes every occurrence of a tag
+func (m *Message) Remove(tag int) {
+	fields := m.Fields[:0]
+	for _, field := range m.Fields {
+		if field.Tag != tag {
+			fields = append(fields, field)
+		}
+	}
+	m.Fields = fields
+}
+
+// Encode returns the wire form of the message with the header tags first
+func (m *Message) Encode() []byte {
+	var body bytes.Buffer
+	write := func(field Field) {
+		body.WriteString(strconv.Itoa(field.Tag))
+		body.WriteByte('=')
+		body.WriteString(field.Value)
+		body.WriteByte(soh)
+	}
+	isHeader := make(map[int]bool, len(headerTags))
+	for _, tag := range headerTags {
+		isHeader[tag] = true
+		if m.Has(tag) {
+			write(Field{Tag: tag, Value: m.Get(tag)})
+		}
+	}
+	for _, field := range m.Fields {
+		if !isHeader[field.Tag] {
+			write(field)
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "%d=%s%c%d=%d%c", TagBeginString, BeginString, soh, TagBodyLength, body.Len(), soh)
+	out.Write(body.Bytes())
+	fmt.Fprintf(&out, "%d=%03d%c", TagCheckSum, checksum(out.Bytes()), soh)
+	return out.Bytes()
+}
+
+// String renders the message with | separators for logs
+func (m *Message) String() string {
+	return string(bytes.ReplaceAll(m.Encode(), []byte{soh}, []byte{'|'}))
+}
+
+// ReadMessage reads and validates the next message from a stream
+func ReadMessage(r *bufio.Reader) (*Message, error) {
+	begin, err := readField(r)
+	if err != nil {
+		return nil, err
+	}
+	if begin.Tag != TagBeginString || begin.Value != BeginString {
+		return nil, fmt.Errorf("expected %s BeginString, got %d=%s", BeginString, begin.Tag, begin.Value)
+	}
+	length, err := readField(r)
+	if err != nil {
+		return nil, err
+	}
+	bodyLength, err := strconv.Atoi(length.Value)
+	if length.Tag != TagBodyLength || err != nil || bodyLength <= 0 || bodyLength > maxBodyLength {
+		return nil, errors.New("invalid BodyLength")
+	}
+	body := make([]byte, bodyLength)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	trailer, err := readField(r)
+	if err != nil {
+		return nil, err
+	}
+	if trailer.Tag != TagCheckSum {
+		return nil, errors.New("BodyLength does not match the message")
+	}
+
+	raw := fmt.Sprintf("%d=%s%c%d=%d%c%s", TagBeginString, BeginString, soh, TagBodyLength, bodyLength, soh, body)
+	if sum, err := strconv.Atoi(trailer.Value); err != nil || sum != checksum([]byte(raw)) {
+		return nil, errors.New("invalid CheckSum")
+	}
+	return parseBody(body)
+}
+
+// parseBody splits the body of a message into its fields
+func parseBody(body []byte) (*Message, error) {
+	message := &Message{}
+	for _, pair := range bytes.Split(bytes.TrimSuffix(body, []byte{soh}), []byte{soh}) {
+		field, err := parseField(pair)
+		if err != nil {
+			return nil, err
+		}
+		message.Fields = append(message.Fields, field)
+	}
+	if len(message.Fields) == 0 || message.Fields[0].Tag != TagMsgType {
+		return nil, errors.New("MsgType must be the first field of the body")
+	}
+	return message, nil
+}
+
+// ParseMessage parses the wire form of a message, e.g. one kept for resends
+func ParseMessage(raw []byte) (*Message, error) {
+	return ReadMessage(bufio.NewReader(bytes.NewReader(raw)))
+}
+
+func readField(r *bufio.Reader) (Field, error) {
+	pair, err := r.ReadBytes(soh)
+	if err != nil {
+		return Field{}, err
+	}
+	return parseField(pair[:len(pair)-1])
+}
+
+func parseField(pair []byte) (Field, error) {
+	i := bytes.IndexByte(pair, '=')
+	if i <= 0 {
+		return Field{}, fmt.Errorf("malformed field %q", pair)
+	}
+	tag, err := strconv.Atoi(string(pair[:i]))
+	if err != nil {
+		return Field{}, fmt.Errorf("malformed tag %q", pair[:i])
+	}
+	return Field{Tag: tag, Value: string(pair[i+1:])}, nil
+}
+
+func checksum(data []byte) int {
+	sum := 0
+	for _, b := range data {
+		sum += int(b)
+	}
+	return sum % 256
+}
@@ -0,0 +1,461 @@
+package fix
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"mfus_OMV1/internal/auth"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Session timing
+const (
+	logonTimeout      = 10 * time.Second
+	writeTimeout      = 10 * time.Second
+	DefaultHeartBtInt = 30 * time.Second
+	// reportQueue is how many unsolicited execution reports may wait for a session
+	reportQueue = 4096
+)
+
+// Session reject reasons (tag 373)
+const (
+	rejectRequiredTagMissing  = "1"
+	rejectValueIncorrect      = "5"
+	rejectCompIDProblem       = "9"
+	rejectInvalidMsgType      = "11"
+	businessRejectUnsupported = "3"
+)
+
+// adminTypes are gap filled rather than resent
+var adminTypes = map[string]bool{
+	MsgHeartbeat:     true,
+	MsgTestRequest:   true,
+	MsgResendRequest: true,
+	MsgSequenceReset: true,
+	MsgLogout:        true,
+	MsgLogon:         true,
+}
+
+var errLoggedOut = errors.New("session logged out")
+
+// session is one logged on FIX connection
+type session struct {
+	acceptor   *Acceptor
+	conn       net.Conn
+	reader     *bufio.Reader
+	id         string
+	peerCompID string
+	store      *store
+	principal  auth.Principal
+	ctx        context.Context
+	heartBtInt time.Duration
+	reports    chan *Message
+
+	// sendMutex guards the outbound sequence and the persisted state
+	sendMutex sync.Mutex
+	nextOut   int64
+	nextIn    int64
+	lastSent  time.Time
+
+	// Owned by the run loop
+	lastReceived time.Time
+	testReqID    string
+	resendTarget int64
+}
+
+// sessionID names a session by the CompIDs of both sides
+func sessionID(compID string, peerCompID string) string {
+	return compID + ":" + peerCompID
+}
+
+// logon authenticates the first message of a connection and answers it
+func (s *session) logon(logon *Message) error {
+	if logon.Type() != MsgLogon {
+		return errors.New("first message must be a Logon")
+	}
+	if logon.Get(TagTargetCompID) != s.acceptor.CompID || logon.Get(TagSenderCompID) == "" {
+		return errors.New("unknown TargetCompID " + logon.Get(TagTargetCompID))
+	}
+	s.peerCompID = logon.Get(TagSenderCompID)
+	s.id = sessionID(s.acceptor.CompID, s.peerCompID)
+
+	principal, err := authenticateLogon(s.ctx, logon)
+	if err != nil {
+		return err
+	}
+	s.principal = principal
+	s.ctx = auth.WithPrincipal(s.ctx, principal)
+
+	s.heartBtInt = time.Duration(logon.Int(TagHeartBtInt)) * time.Second
+	if s.heartBtInt <= 0 {
+		s.heartBtInt = DefaultHeartBtInt
+	}
+
+	if !s.acceptor.register(s) {
+		return errors.New("session " + s.id + " is already logged on")
+	}
+
+	state, err := s.store.load(s.ctx)
+	if err != nil {
+		return err
+	}
+	s.nextOut, s.nextIn = state.NextOut, state.NextIn
+	reset := logon.Get(TagResetSeqNumFlag) == "Y"
+	if reset {
+		if err := s.store.reset(s.ctx); err != nil {
+			return err
+		}
+		s.nextOut, s.nextIn = 1, 1
+	}
+
+	seq := logon.Int(TagMsgSeqNum)
+	if seq < s.nextIn {
+		s.logout(fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", s.nextIn, seq))
+		return errLoggedOut
+	}
+
+	reply := NewMessage(MsgLogon).
+		Set(TagEncryptMethod, "0").
+		SetInt(TagHeartBtInt, int64(s.heartBtInt/time.Second))
+	if reset {
+		reply.Set(TagResetSeqNumFlag, "Y")
+	}
+	if err := s.send(reply); err != nil {
+		return err
+	}
+
+	if seq > s.nextIn {
+		// Accept the logon but ask for the messages we missed
+		return s.requestResend(seq)
+	}
+	return s.advance(seq + 1)
+}
+
+// run processes messages until the connection ends
+func (s *session) run() {
+	incoming := make(chan *Message)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			message, err := ReadMessage(s.reader)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case incoming <- message:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	s.lastReceived = time.Now()
+	for {
+		var err error
+		select {
+		case message := <-incoming:
+			s.lastReceived = time.Now()
+			err = s.receive(message)
+		case report := <-s.reports:
+			err = s.send(report)
+		case err = <-readErr:
+		case <-ticker.C:
+			err = s.heartbeat()
+		case <-s.acceptor.closing:
+			err = s.close(s.acceptor.reason)
+		}
+		if err != nil {
+			if err != errLoggedOut {
+				logger.Info("session closed", "session", s.id, "error", err)
+			}
+			return
+		}
+	}
+}
+
+// heartbeat keeps the connection alive and detects a silent peer
+func (s *session) heartbeat() error {
+	silence := time.Since(s.lastReceived)
+	if silence > 2*s.heartBtInt+s.heartBtInt/5 {
+		return errors.New("peer stopped responding")
+	}
+	if silence > s.heartBtInt+s.heartBtInt/5 && s.testReqID == "" {
+		s.testReqID = "TEST-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+		return s.send(NewMessage(MsgTestRequest).Set(TagTestReqID, s.testReqID))
+	}
+
+	s.sendMutex.Lock()
+	idle := time.Since(s.lastSent)
+	s.sendMutex.Unlock()
+	if idle >= s.heartBtInt {
+		return s.send(NewMessage(MsgHeartbeat))
+	}
+	return nil
+}
+
+// receive applies the sequence rules to an inbound message and dispatches it
+func (s *session) receive(message *Message) error {
+	if message.Get(TagSenderCompID) != s.peerCompID || message.Get(TagTargetCompID) != s.acceptor.CompID {
+		s.reject(message, rejectCompIDProblem, "CompID problem")
+		s.logout("CompID problem")
+		return errLoggedOut
+	}
+
+	// SequenceReset in reset mode ignores sequence numbers altogether
+	if message.Type() == MsgSequenceReset && message.Get(TagGapFillFlag) != "Y" {
+		newSeq := message.Int(TagNewSeqNo)
+		if newSeq < s.nextIn {
+			s.reject(message, rejectValueIncorrect, "NewSeqNo may not decrease the expected MsgSeqNum")
+			return nil
+		}
+		return s.advance(newSeq)
+	}
+
+	seq := message.Int(TagMsgSeqNum)
+	switch {
+	case seq == 0:
+		s.reject(message, rejectRequiredTagMissing, "MsgSeqNum is required")
+		return nil
+	case seq > s.nextIn:
+		// Ask for the gap, the peer resends everything after it including this message.
+		// Resend requests and logouts are still honoured while the gap is open.
+		switch message.Type() {
+		case MsgResendRequest:
+			if err := s.resend(message.Int(TagBeginSeqNo), message.Int(TagEndSeqNo)); err != nil {
+				return err
+			}
+		case MsgLogout:
+			s.logout("")
+			return errLoggedOut
+		}
+		if s.resendTarget == 0 {
+			return s.requestResend(seq)
+		}
+		return nil
+	case seq < s.nextIn:
+		if message.Get(TagPossDupFlag) == "Y" {
+			return nil
+		}
+		s.logout(fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", s.nextIn, seq))
+		return errLoggedOut
+	}
+
+	next := seq + 1
+	if message.Type() == MsgSequenceReset {
+		if newSeq := message.Int(TagNewSeqNo); newSeq > next {
+			next = newSeq
+		}
+	}
+	if err := s.advance(next); err != nil {
+		return err
+	}
+
+	switch message.Type() {
+	case MsgHeartbeat:
+		if message.Get(TagTestReqID) == s.testReqID {
+			s.testReqID = ""
+		}
+	case MsgTestRequest:
+		return s.send(NewMessage(MsgHeartbeat).Set(TagTestReqID, message.Get(TagTestReqID)))
+	case MsgResendRequest:
+		return s.resend(message.Int(TagBeginSeqNo), message.Int(TagEndSeqNo))
+	case MsgReject:
+		logger.Warn("peer rejected message", "session", s.id, "refSeqNum", message.Get(TagRefSeqNum), "text", message.Get(TagText))
+	case MsgSequenceReset:
+	case MsgLogout:
+		s.logout("")
+		return errLoggedOut
+	case MsgLogon:
+		s.reject(message, rejectInvalidMsgType, "already logged on")
+	case MsgNewOrderSingle:
+		return s.newOrderSingle(message)
+	case MsgOrderCancelRequest:
+		return s.cancelRequest(message)
+	case MsgOrderCancelReplaceRequest:
+		return s.cancelReplaceRequest(message)
+	default:
+		return s.send(NewMessage(MsgBusinessMessageReject).
+			Set(TagRefSeqNum, message.Get(TagMsgSeqNum)).
+			Set(TagRefMsgType, message.Type()).
+			Set(TagBusinessRejReason, businessRejectUnsupported).
+			Set(TagText, "unsupported message type "+message.Type()))
+	}
+	return nil
+}
+
+// advance moves the expected inbound sequence number and persists it
+func (s *session) advance(next int64) error {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	s.nextIn = next
+	if s.resendTarget != 0 && s.nextIn > s.resendTarget {
+		s.resendTarget = 0
+	}
+	return s.store.save(s.ctx, s.nextOut, s.nextIn)
+}
+
+// requestResend asks the peer for every message from the expected one on
+func (s *session) requestResend(received int64) error {
+	s.resendTarget = received
+	return s.send(NewMessage(MsgResendRequest).
+		SetInt(TagBeginSeqNo, s.nextIn).
+		SetInt(TagEndSeqNo, 0))
+}
+
+// resend answers a resend request. Kept application messages are sent again
+// as possible duplicates, admin messages and unknown gaps are gap filled.
+func (s *session) resend(begin int64, end int64) error {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	last := s.nextOut - 1
+	if end == 0 || end > last {
+		end = last
+	}
+	if begin < 1 || begin > end {
+		return nil
+	}
+	messages, err := s.store.messages(s.ctx, begin, end)
+	if err != nil {
+		return err
+	}
+	kept := make(map[int64]SentMessageModel, len(messages))
+	for _, message := range messages {
+		kept[message.Seq] = message
+	}
+
+	gapStart := int64(0)
+	flush := func(next int64) error {
+		if gapStart == 0 {
+			return nil
+		}
+		gapFill := NewMessage(MsgSequenceReset).Set(TagGapFillFlag, "Y").SetInt(TagNewSeqNo, next)
+		err := s.write(gapFill, gapStart, true)
+		gapStart = 0
+		return err
+	}
+	for seq := begin; seq <= end; seq++ {
+		stored, ok := kept[seq]
+		if !ok || adminTypes[stored.MsgType] {
+			if gapStart == 0 {
+				gapStart = seq
+			}
+			continue
+		}
+		if err := flush(seq); err != nil {
+			return err
+		}
+		message, err := ParseMessage(stored.Raw)
+		if err != nil {
+			return err
+		}
+		message.Set(TagOrigSendingTime, message.Get(TagSendingTime))
+		if err := s.write(message, seq, true); err != nil {
+			return err
+		}
+	}
+	return flush(end + 1)
+}
+
+// send assigns the next outbound sequence number, keeps the message for
+// resends and writes it
+func (s *session) send(message *Message) error {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	seq := s.nextOut
+	raw := s.header(message, seq, false).Encode()
+	if err := s.store.saveMessage(s.ctx, seq, message.Type(), raw); err != nil {
+		return err
+	}
+	s.nextOut++
+	if err := s.store.save(s.ctx, s.nextOut, s.nextIn); err != nil {
+		return err
+	}
+	return s.writeRaw(raw)
+}
+
+// write sends a message with a given sequence number, for resends and gap fills
+func (s *session) write(message *Message, seq int64, possDup bool) error {
+	return s.writeRaw(s.header(message, seq, possDup).Encode())
+}
+
+func (s *session) header(message *Message, seq int64, possDup bool) *Message {
+	message.Set(TagSenderCompID, s.acceptor.CompID)
+	message.Set(TagTargetCompID, s.peerCompID)
+	message.SetInt(TagMsgSeqNum, seq)
+	message.SetTime(TagSendingTime, time.Now())
+	if possDup {
+		message.Set(TagPossDupFlag, "Y")
+	}
+	return message
+}
+
+func (s *session) writeRaw(raw []byte) error {
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := s.conn.Write(raw)
+	if err == nil {
+		s.lastSent = time.Now()
+	}
+	return err
+}
+
+// reject sends a session level Reject of an inbound message
+func (s *session) reject(message *Message, reason string, text string) {
+	reject := NewMessage(MsgReject).
+		Set(TagRefSeqNum, message.Get(TagMsgSeqNum)).
+		Set(TagRefMsgType, message.Type()).
+		Set(TagSessionRejReason, reason).
+		Set(TagText, text)
+	if err := s.send(reject); err != nil {
+		logger.Error("cannot send reject", "session", s.id, "error", err)
+	}
+}
+
+// logout says goodbye, the caller closes the connection
+func (s *session) logout(text string) {
+	logout := NewMessage(MsgLogout)
+	if text != "" {
+		logout.Set(TagText, text)
+	}
+	if err := s.send(logout); err != nil {
+		logger.Error("cannot send logout", "session", s.id, "error", err)
+	}
+}
+
+// close sends the execution reports still queued and logs the session out
+func (s *session) close(reason string) error {
+	for {
+		select {
+		case report := <-s.reports:
+			if err := s.send(report); err != nil {
+				return err
+			}
+		default:
+			s.logout(reason)
+			logger.Info("session logged out", "session", s.id, "reason", reason)
+			return errLoggedOut
+		}
+	}
+}
+
+// queueReport hands an unsolicited execution report to the run loop without
+// blocking the engine. A session that cannot keep up is disconnected and has
+// to reconcile its orders, e.g. through the order query API, after logging on again.
+func (s *session) queueReport(report *Message) {
+	select {
+	case s.reports <- report:
+	default:
+		logger.Warn("session cannot keep up with execution reports, disconnecting", "session", s.id)
+		s.conn.Close()
+	}
+}
@@ -0,0 +1,114 @@
+package fix
+
+import (
+	"context"
+	"mfus_OMV1/internal/config"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Define the database and collection names
+var dbName = "orderbook"
+var sessionsCollection = "fix_sessions"
+var messagesCollection = "fix_messages"
+
+// Configure applies the database and collection names of the loaded config
+func Configure(cfg *config.Config) {
+	dbName = cfg.Mongo.Database
+	sessionsCollection = cfg.Collections.FIXSessions
+	messagesCollection = cfg.Collections.FIXMessages
+}
+
+// SessionStateModel holds the sequence numbers of a session across connections
+type SessionStateModel struct {
+	SessionID  string    `bson:"_id"`
+	NextOut    int64     `bson:"nextOut"`
+	NextIn     int64     `bson:"nextIn"`
+	UpdateTime time.Time `bson:"updateTime"`
+}
+
+// SentMessageModel is an outbound message kept to answer resend requests
+type SentMessageModel struct {
+	SessionID string    `bson:"session"`
+	Seq       int64     `bson:"seq"`
+	MsgType   string    `bson:"msgType"`
+	Raw       []byte    `bson:"raw"`
+	SentAt    time.Time `bson:"sentAt"`
+}
+
+// store persists the state of one session
+type store struct {
+	client    *mongo.Client
+	sessionID string
+}
+
+// load returns the sequence numbers of the session, 1 and 1 for a new session
+func (s *store) load(ctx context.Context) (SessionStateModel, error) {
+	state := SessionStateModel{SessionID: s.sessionID, NextOut: 1, NextIn: 1}
+	err := s.client.Database(dbName).Collection(sessionsCollection).FindOne(ctx, bson.M{"_id": s.sessionID}).Decode(&state)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return state, err
+	}
+	return state, nil
+}
+
+func (s *store) save(ctx context.Context, nextOut int64, nextIn int64) error {
+	_, err := s.client.Database(dbName).Collection(sessionsCollection).UpdateOne(ctx,
+		bson.M{"_id": s.sessionID},
+		bson.M{"$set": bson.M{"nextOut": nextOut, "nextIn": nextIn, "updateTime": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// reset starts the session over, dropping the messages kept for resends
+func (s *store) reset(ctx context.Context) error {
+	_, err := s.client.Database(dbName).Collection(messagesCollection).DeleteMany(ctx, bson.M{"session": s.sessionID})
+	if err != nil {
+		return err
+	}
+	return s.save(ctx, 1, 1)
+}
+
+// saveMessage keeps an outbound message, replacing one left with the same
+// sequence number by a send that failed before the state was saved
+func (s *store) saveMessage(ctx context.Context, seq int64, msgType string, raw []byte) error {
+	_, err := s.client.Database(dbName).Collection(messagesCollection).ReplaceOne(ctx,
+		bson.M{"session": s.sessionID, "seq": seq},
+		SentMessageModel{
+			SessionID: s.sessionID,
+			Seq:       seq,
+			MsgType:   msgType,
+			Raw:       raw,
+			SentAt:    time.Now(),
+		},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// messages returns the kept messages with begin <= seq <= end, in sequence
+func (s *store) messages(ctx context.Context, begin int64, end int64) ([]SentMessageModel, error) {
+	cursor, err := s.client.Database(dbName).Collection(messagesCollection).Find(ctx,
+		bson.M{"session": s.sessionID, "seq": bson.M{"$gte": begin, "$lte": end}},
+		options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]SentMessageModel, 0)
+	err = cursor.All(ctx, &messages)
+	return messages, err
+}
+
+// EnsureIndexes creates the indexes of the FIX collections
+func EnsureIndexes(ctx context.Context, mongoClient *mongo.Client) error {
+	_, err := mongoClient.Database(dbName).Collection(messagesCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "session", Value: 1}, {Key: "seq", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
@@ -0,0 +1,171 @@
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/internal/logging"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// signedMethod stands in for the HTTP method in the signature of gRPC calls
+const signedMethod = "GRPC"
+
+// correlationKey is the metadata key of the correlation ID of a call
+var correlationKey = strings.ToLower(logging.HeaderCorrelationID)
+
+// permissions is the permission each RPC requires, RPCs not listed need read
+var permissions = map[string]string{
+	"/orderbook.v1.OrderBook/PlaceOrder":  auth.PermTrade,
+	"/orderbook.v1.OrderBook/AmendOrder":  auth.PermTrade,
+	"/orderbook.v1.OrderBook/CancelOrder": auth.PermTrade,
+}
+
+// unaryAuth authenticates a call. Signed calls sign the canonical form of the
+// request message as the body of a REST request.
+func unaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx = correlate(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(correlationKey, logging.CorrelationID(ctx)))
+	message, ok := req.(proto.Message)
+	if !ok {
+		return nil, status.Error(codes.Internal, "request is not a protobuf message")
+	}
+	body, err := canonical(message)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	ctx, err = authenticate(ctx, info.FullMethod, body)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// canonical writes each field set in a request as its name, "=" and value
+// followed by a newline, in field number order. Protobuf encodings are not
+// stable across implementations, so clients sign this form instead. Strings
+// are written as they are and cannot contain a newline, numbers in decimal
+// without an exponent and booleans as true or false. Requests only have
+// scalar fields.
+func canonical(message proto.Message) ([]byte, error) {
+	reflected := message.ProtoReflect()
+	fields := reflected.Descriptor().Fields()
+	var b strings.Builder
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		if !reflected.Has(field) {
+			continue
+		}
+		if field.IsList() || field.IsMap() {
+			return nil, fmt.Errorf("cannot sign field %s", field.Name())
+		}
+		value := reflected.Get(field)
+		var text string
+		switch field.Kind() {
+		case protoreflect.StringKind:
+			// A newline would let one field pass for several
+			if text = value.String(); strings.Contains(text, "\n") {
+				return nil, fmt.Errorf("field %s cannot contain a newline", field.Name())
+			}
+		case protoreflect.BoolKind:
+			text = strconv.FormatBool(value.Bool())
+		case protoreflect.Int32Kind, protoreflect.Int64Kind, protoreflect.Sint32Kind, protoreflect.Sint64Kind,
+			protoreflect.Sfixed32Kind, protoreflect.Sfixed64Kind:
+			text = strconv.FormatInt(value.Int(), 10)
+		case protoreflect.Uint32Kind, protoreflect.Uint64Kind, protoreflect.Fixed32Kind, protoreflect.Fixed64Kind:
+			text = strconv.FormatUint(value.Uint(), 10)
+		case protoreflect.FloatKind, protoreflect.DoubleKind:
+			text = strconv.FormatFloat(value.Float(), 'f', -1, 64)
+		default:
+			return nil, fmt.Errorf("cannot sign field %s", field.Name())
+		}
+		b.WriteString(string(field.Name()))
+		b.WriteByte('=')
+		b.WriteString(text)
+		b.WriteByte('\n')
+	}
+	return []byte(b.String()), nil
+}
+
+// streamAuth authenticates a stream before its request is read, signed
+// streams sign an empty body. Streams only read, so there is no order to alter.
+func streamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := correlate(ss.Context())
+	ss.SetHeader(metadata.Pairs(correlationKey, logging.CorrelationID(ctx)))
+	ctx, err := authenticate(ctx, info.FullMethod, nil)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// correlate gives a call the correlation ID sent by the client in its
+// metadata, or a new one
+func correlate(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(correlationKey); len(values) > 0 && logging.ValidCorrelationID(values[0]) {
+		return logging.WithCorrelationID(ctx, values[0])
+	}
+	return logging.WithCorrelationID(ctx, logging.NewCorrelationID())
+}
+
+// authenticate resolves the principal of a call from its metadata, with the
+// same credentials as the REST API, and checks the permission of the method
+func authenticate(ctx context.Context, method string, body []byte) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	get := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	var principal auth.Principal
+	var err error
+	switch {
+	case strings.HasPrefix(get("authorization"), "Bearer "):
+		principal, err = auth.AuthenticateToken(strings.TrimPrefix(get("authorization"), "Bearer "))
+	case get(strings.ToLower(auth.HeaderAPIKey)) != "":
+		principal, err = auth.AuthenticateSigned(ctx, auth.SignedRequest{
+			KeyID:      get(strings.ToLower(auth.HeaderAPIKey)),
+			Timestamp:  get(strings.ToLower(auth.HeaderTimestamp)),
+			Nonce:      get(strings.ToLower(auth.HeaderNonce)),
+			Signature:  get(strings.ToLower(auth.HeaderSignature)),
+			Method:     signedMethod,
+			RequestURI: method,
+			Body:       body,
+		})
+	default:
+		err = auth.ErrUnauthenticated
+	}
+	if err != nil {
+		return ctx, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	permission, ok := permissions[method]
+	if !ok {
+		permission = auth.PermRead
+	}
+	if !principal.Has(permission) {
+		return ctx, status.Error(codes.PermissionDenied, "missing "+permission+" permission")
+	}
+	return auth.WithPrincipal(ctx, principal), nil
+}
+
+// authenticatedStream carries the principal to stream handlers
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
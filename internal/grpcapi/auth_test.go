@@ -0,0 +1,111 @@
+package grpcapi
+
+import (
+	"errors"
+	"fmt"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/internal/orderbook"
+	pb "mfus_OMV1/pkg/orderbookpb"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		name    string
+		message proto.Message
+		want    string
+	}{
+		{"place order", &pb.PlaceOrderRequest{Symbol: "BTC-USD", Side: "buy", Type: "limit", Price: 101.5, Quantity: 3},
+			"symbol=BTC-USD\nside=buy\ntype=limit\nprice=101.5\nquantity=3\n"},
+		{"unset fields are left out", &pb.CancelOrderRequest{}, ""},
+		{"optional zero is set", &pb.AmendOrderRequest{Id: "abc", Price: proto.Float64(0)},
+			"id=abc\nprice=0\n"},
+		{"no exponent", &pb.PlaceOrderRequest{Price: 1e21, Quantity: 1},
+			"price=1000000000000000000000\nquantity=1\n"},
+		{"small fraction", &pb.PlaceOrderRequest{Price: 0.00001},
+			"price=0.00001\n"},
+		{"boolean", &pb.QueryOrdersRequest{Symbol: "BTC-USD", Ascending: true, Limit: 10},
+			"symbol=BTC-USD\nascending=true\nlimit=10\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := canonical(test.message)
+			if err != nil || string(got) != test.want {
+				t.Fatalf("canonical() = %q, %v, want %q", got, err, test.want)
+			}
+		})
+	}
+
+	refused := []struct {
+		name    string
+		message proto.Message
+	}{
+		{"newline passing for another field", &pb.PlaceOrderRequest{Symbol: "BTC-USD\nside=buy"}},
+		{"repeated field", &pb.QueryOrdersResponse{Orders: []*pb.Order{{}}}},
+	}
+	for _, test := range refused {
+		t.Run(test.name, func(t *testing.T) {
+			if got, err := canonical(test.message); err == nil {
+				t.Fatalf("canonical() = %q, want an error", got)
+			}
+		})
+	}
+}
+
+func TestCanonicalSignature(t *testing.T) {
+	const method = "/orderbook.v1.OrderBook/PlaceOrder"
+	sign := func(message proto.Message) string {
+		body, err := canonical(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return auth.Sign("secret", "1700000000000", "nonce", signedMethod, method, body)
+	}
+	base := sign(&pb.PlaceOrderRequest{Symbol: "BTC-USD", Side: "buy", Price: 100, Quantity: 2})
+	tests := []struct {
+		name    string
+		message proto.Message
+		same    bool
+	}{
+		{"same fields", &pb.PlaceOrderRequest{Symbol: "BTC-USD", Side: "buy", Price: 100, Quantity: 2}, true},
+		{"other price", &pb.PlaceOrderRequest{Symbol: "BTC-USD", Side: "buy", Price: 100.5, Quantity: 2}, false},
+		{"other quantity", &pb.PlaceOrderRequest{Symbol: "BTC-USD", Side: "buy", Price: 100, Quantity: 20}, false},
+		{"other side", &pb.PlaceOrderRequest{Symbol: "BTC-USD", Side: "sell", Price: 100, Quantity: 2}, false},
+		{"value moved to another field", &pb.PlaceOrderRequest{Symbol: "BTC-USDbuy", Price: 100, Quantity: 2}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if same := sign(test.message) == base; same != test.same {
+				t.Fatalf("same signature %v, want %v", same, test.same)
+			}
+		})
+	}
+}
+
+func TestStatusOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code codes.Code
+	}{
+		{"invalid cursor", orderbook.ErrInvalidCursor, codes.InvalidArgument},
+		{"wrapped invalid cursor", fmt.Errorf("%w: it does not match the requested sort", orderbook.ErrInvalidCursor), codes.InvalidArgument},
+		{"invalid order", &orderbook.InvalidOrderError{Err: errors.New("invalid order price")}, codes.InvalidArgument},
+		{"not found", orderbook.ErrOrderNotFound, codes.NotFound},
+		{"illegal transition", orderbook.ErrIllegalTransition, codes.FailedPrecondition},
+		{"forbidden", auth.ErrForbidden, codes.PermissionDenied},
+		{"overloaded", orderbook.ErrOverloaded, codes.ResourceExhausted},
+		{"storage failure", errors.New("server selection error"), codes.Internal},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := status.Code(statusOf(test.err)); code != test.code {
+				t.Fatalf("statusOf() = %s, want %s", code, test.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package grpcapi
+
+import (
+	"errors"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/internal/health"
+	"mfus_OMV1/internal/orderbook"
+	pb "mfus_OMV1/pkg/orderbookpb"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func toOrder(order orderbook.OrderModel) *pb.Order {
+	result := &pb.Order{
+		Id:            order.ID.Hex(),
+		UserId:        order.UserID,
+		Symbol:        order.Symbol,
+		Price:         order.Price,
+		Quantity:      order.Quantity,
+		RemainingQty:  order.RemainingQty,
+		Side:          order.Side,
+		Type:          order.Type,
+		Status:        order.Status.String(),
+		Expiration:    order.Expiration,
+		CreationTime:  order.CreationTime,
+		UpdateTime:    order.UpdateTime,
+		FilledOrders:  order.FilledOrders,
+		FilledQty:     order.FilledQty,
+		FilledVolume:  order.FilledVolume,
+		FilledAverage: order.FilledAverage,
+	}
+	if order.FilledOrder != nil {
+		result.FilledOrder = &pb.Fill{
+			TradeId:   order.FilledOrder.OrderID,
+			Price:     order.FilledOrder.Price,
+			Quantity:  order.FilledOrder.Quantity,
+			Timestamp: order.FilledOrder.Timestamp,
+		}
+	}
+	return result
+}
+
+func toTrade(trade orderbook.TradeHistoryModel) *pb.Trade {
+	return &pb.Trade{
+		Id:           trade.Id,
+		Symbol:       trade.Symbol,
+		BuyOrderId:   trade.BuyOrder,
+		SellOrderId:  trade.SellOrder,
+		BuyUserId:    trade.BuyUserID,
+		SellUserId:   trade.SellUserID,
+		Quantity:     trade.Quantity,
+		Price:        trade.Price,
+		MakerSide:    trade.MakerSide,
+		BuyFee:       trade.BuyFee,
+		BuyFeeRate:   trade.BuyFeeRate,
+		BuyFeeAsset:  trade.BuyFeeAsset,
+		SellFee:      trade.SellFee,
+		SellFeeRate:  trade.SellFeeRate,
+		SellFeeAsset: trade.SellFeeAsset,
+		ExecutedAt:   trade.ExecutedAt.UnixNano() / int64(time.Millisecond),
+	}
+}
+
+func toDepth(depth orderbook.DepthModel) *pb.Depth {
+	result := &pb.Depth{
+		Symbol:    depth.Symbol,
+		Sequence:  depth.Sequence,
+		Timestamp: depth.Timestamp,
+	}
+	for _, level := range depth.Bids {
+		result.Bids = append(result.Bids, &pb.DepthLevel{Price: level.Price, Quantity: level.Quantity, Count: int32(level.Count)})
+	}
+	for _, level := range depth.Asks {
+		result.Asks = append(result.Asks, &pb.DepthLevel{Price: level.Price, Quantity: level.Quantity, Count: int32(level.Count)})
+	}
+	return result
+}
+
+// statusOf maps an order entry error to its gRPC status, like writeOrderError does for REST
+func statusOf(err error) error {
+	var rateLimited *orderbook.RateLimitError
+	var invalid *orderbook.InvalidOrderError
+	switch {
+	case errors.As(err, &rateLimited):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.As(err, &invalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, orderbook.ErrInvalidCursor):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, orderbook.ErrOrderNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, orderbook.ErrOrderChanged):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, orderbook.ErrIllegalTransition), errors.Is(err, orderbook.ErrSymbolHalted),
+		errors.Is(err, orderbook.ErrKillSwitch):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, orderbook.ErrUserSuspended):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, auth.ErrForbidden):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, orderbook.ErrOverloaded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, health.ErrNotReady):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
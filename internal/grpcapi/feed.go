@@ -0,0 +1,46 @@
+package grpcapi
+
+import "sync"
+
+// feedBuffer is how many updates a stream may lag behind before it is dropped
+const feedBuffer = 1024
+
+// feed fans engine events out to the open streams. Publishing never blocks
+// the engine, a stream that falls behind is closed like a slow WebSocket client.
+type feed struct {
+	mutex       sync.Mutex
+	subscribers map[chan interface{}]bool
+}
+
+func (f *feed) subscribe() chan interface{} {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.subscribers == nil {
+		f.subscribers = make(map[chan interface{}]bool)
+	}
+	updates := make(chan interface{}, feedBuffer)
+	f.subscribers[updates] = true
+	return updates
+}
+
+func (f *feed) unsubscribe(updates chan interface{}) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.subscribers[updates] {
+		delete(f.subscribers, updates)
+		close(updates)
+	}
+}
+
+func (f *feed) publish(update interface{}) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for updates := range f.subscribers {
+		select {
+		case updates <- update:
+		default:
+			delete(f.subscribers, updates)
+			close(updates)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/internal/config"
+	"mfus_OMV1/internal/orderbook"
+	"mfus_OMV1/pkg/database"
+	pb "mfus_OMV1/pkg/orderbookpb"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements the OrderBook gRPC service on top of the same order entry
+// functions as the REST handlers
+type Server struct {
+	pb.UnimplementedOrderBookServer
+}
+
+var orderFeed, tradeFeed, bookFeed feed
+var listenOnce sync.Once
+
+// closing ends the open streams once Shutdown was called
+var closing = make(chan struct{})
+var closeOnce sync.Once
+
+// NewServer returns a gRPC server with the OrderBook service registered. It
+// serves TLS with the configured certificate, plaintext only when allowed.
+func NewServer(cfg config.ServerConfig) (*grpc.Server, error) {
+	listenOnce.Do(func() {
+		orderbook.OnOrderUpdate(func(event string, order orderbook.OrderModel) { orderFeed.publish(order) })
+		orderbook.OnTrade(func(trade orderbook.TradeHistoryModel) { tradeFeed.publish(trade) })
+		orderbook.OnBookUpdate(func(depth orderbook.DepthModel) { bookFeed.publish(depth.Symbol) })
+	})
+
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(unaryAuth), grpc.StreamInterceptor(streamAuth)}
+	if cfg.GRPCCertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(cfg.GRPCCertFile, cfg.GRPCKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	server := grpc.NewServer(opts...)
+	pb.RegisterOrderBookServer(server, &Server{})
+	return server, nil
+}
+
+// ListenAndServe serves the gRPC API on a TCP address
+func ListenAndServe(server *grpc.Server, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return server.Serve(listener)
+}
+
+// Shutdown ends the open streams with Unavailable and waits for the calls in
+// flight. Calls still running when the context ends are cancelled.
+func Shutdown(ctx context.Context, server *grpc.Server) error {
+	closeOnce.Do(func() { close(closing) })
+	done := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		server.Stop()
+		return ctx.Err()
+	}
+}
+
+func (s *Server) PlaceOrder(ctx context.Context, req *pb.PlaceOrderRequest) (*pb.Order, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	order, err := orderbook.PlaceOrder(ctx, orderbook.OrderModel{
+		Symbol:     req.Symbol,
+		Side:       req.Side,
+		Type:       req.Type,
+		Price:      req.Price,
+		Quantity:   req.Quantity,
+		Expiration: req.Expiration,
+	})
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	return toOrder(order), nil
+}
+
+func (s *Server) AmendOrder(ctx context.Context, req *pb.AmendOrderRequest) (*pb.Order, error) {
+	id, err := primitive.ObjectIDFromHex(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	updates := bson.M{}
+	if req.Price != nil {
+		updates["price"] = req.GetPrice()
+	}
+	if req.Quantity != nil {
+		updates["quantity"] = req.GetQuantity()
+	}
+	if req.Expiration != nil {
+		updates["expiration"] = req.GetExpiration()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	order, err := orderbook.AmendOrder(ctx, id, updates)
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	return toOrder(order), nil
+}
+
+func (s *Server) CancelOrder(ctx context.Context, req *pb.CancelOrderRequest) (*pb.Order, error) {
+	id, err := primitive.ObjectIDFromHex(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	order, err := orderbook.CancelOrder(ctx, id, "cancelled by user")
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	return toOrder(order), nil
+}
+
+func (s *Server) GetOrder(ctx context.Context, req *pb.GetOrderRequest) (*pb.Order, error) {
+	id, err := primitive.ObjectIDFromHex(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	order, err := orderbook.GetOrder(ctx, id)
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	return toOrder(order), nil
+}
+
+func (s *Server) QueryOrders(ctx context.Context, req *pb.QueryOrdersRequest) (*pb.QueryOrdersResponse, error) {
+	// Go through the REST parameter parser so both APIs validate queries alike
+	values := url.Values{}
+	set := func(key string, value string) {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	set("userID", req.UserId)
+	set("symbol", req.Symbol)
+	set("side", req.Side)
+	set("status", req.Status)
+	set("type", req.Type)
+	set("sort", req.Sort)
+	set("cursor", req.Cursor)
+	if req.From != 0 {
+		set("from", strconv.FormatInt(req.From, 10))
+	}
+	if req.To != 0 {
+		set("to", strconv.FormatInt(req.To, 10))
+	}
+	if req.Limit != 0 {
+		set("limit", strconv.FormatInt(req.Limit, 10))
+	}
+	if req.Ascending {
+		set("order", "asc")
+	}
+
+	query, err := orderbook.ParseOrderQuery(values)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if principal, _ := auth.FromContext(ctx); !principal.Has(auth.PermAdmin) {
+		query.UserID = principal.UserID
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	page, err := orderbook.QueryOrders(ctx, client, query)
+	if err != nil {
+		return nil, statusOf(err)
+	}
+
+	response := &pb.QueryOrdersResponse{NextCursor: page.NextCursor}
+	for _, order := range page.Orders {
+		response.Orders = append(response.Orders, toOrder(order))
+	}
+	return response, nil
+}
+
+func (s *Server) StreamOrders(req *pb.StreamOrdersRequest, stream pb.OrderBook_StreamOrdersServer) error {
+	principal, _ := auth.FromContext(stream.Context())
+	updates := orderFeed.subscribe()
+	defer orderFeed.unsubscribe(updates)
+
+	return relay(stream.Context(), updates, func(update interface{}) error {
+		order := update.(orderbook.OrderModel)
+		if order.UserID != principal.UserID || (req.Symbol != "" && order.Symbol != req.Symbol) {
+			return nil
+		}
+		return stream.Send(toOrder(order))
+	})
+}
+
+func (s *Server) StreamTrades(req *pb.StreamTradesRequest, stream pb.OrderBook_StreamTradesServer) error {
+	if req.Symbol == "" {
+		return status.Error(codes.InvalidArgument, "symbol is required")
+	}
+	principal, _ := auth.FromContext(stream.Context())
+	updates := tradeFeed.subscribe()
+	defer tradeFeed.unsubscribe(updates)
+
+	return relay(stream.Context(), updates, func(update interface{}) error {
+		trade := update.(orderbook.TradeHistoryModel)
+		if trade.Symbol != req.Symbol {
+			return nil
+		}
+		if !principal.Has(auth.PermAdmin) {
+			trade = orderbook.Anonymise(trade, principal.UserID)
+		}
+		return stream.Send(toTrade(trade))
+	})
+}
+
+func (s *Server) StreamDepth(req *pb.StreamDepthRequest, stream pb.OrderBook_StreamDepthServer) error {
+	if req.Symbol == "" {
+		return status.Error(codes.InvalidArgument, "symbol is required")
+	}
+	levels := int(req.Levels)
+	if levels <= 0 {
+		levels = orderbook.DefaultDepthLevels
+	}
+	if levels > orderbook.MaxDepthLevels {
+		levels = orderbook.MaxDepthLevels
+	}
+
+	ctx, cancel := context.WithTimeout(stream.Context(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	manager, err := orderbook.LookupOrderManager(ctx, client, req.Symbol)
+	if errors.Is(err, orderbook.ErrUnknownSymbol) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	// Subscribe before the snapshot so that no change is missed in between
+	updates := bookFeed.subscribe()
+	defer bookFeed.unsubscribe(updates)
+	if err := stream.Send(toDepth(manager.Depth(levels))); err != nil {
+		return err
+	}
+
+	return relay(stream.Context(), updates, func(update interface{}) error {
+		if update.(string) != req.Symbol {
+			return nil
+		}
+		return stream.Send(toDepth(manager.Depth(levels)))
+	})
+}
+
+// relay passes updates to send until the client goes away or falls behind
+func relay(ctx context.Context, updates chan interface{}, send func(update interface{}) error) error {
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "stream fell behind, resubscribe")
+			}
+			if err := send(update); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		case <-closing:
+			return status.Error(codes.Unavailable, "server shutting down, reconnect")
+		}
+	}
+}
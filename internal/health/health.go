@@ -0,0 +1,212 @@
+// Package health tracks whether the order manager and its dependencies are
+// working. Checks run in the background so that the probes and the order
+// entry gate only read the latest results.
+package health
+
+import (
+	"context"
+	"errors"
+	"mfus_OMV1/internal/logging"
+	"sync"
+	"time"
+)
+
+// ErrNotReady is returned by order entry while a readiness check fails
+var ErrNotReady = errors.New("service is not ready, retry later")
+
+// Check reports the state of one dependency. Details are optional and shown
+// next to the result, e.g. a lag.
+type Check func(ctx context.Context) (details interface{}, err error)
+
+// Check statuses
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Result is the outcome of the last run of a check
+type Result struct {
+	Status    string      `json:"status"`
+	Error     string      `json:"error,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+	LatencyMs float64     `json:"latencyMs"`
+	CheckedAt time.Time   `json:"checkedAt"`
+}
+
+// Halt describes an emergency stop of order entry. It does not fail the
+// probes, the service stays in rotation so that cancels still reach it.
+type Halt struct {
+	Reason     string    `json:"reason,omitempty"`
+	OperatorID string    `json:"operatorID,omitempty"`
+	Since      time.Time `json:"since"`
+}
+
+// Report is the response of the probes
+type Report struct {
+	Status   string            `json:"status"`
+	Stopping bool              `json:"stopping,omitempty"`
+	Halt     *Halt             `json:"halt,omitempty"`
+	Checks   map[string]Result `json:"checks"`
+}
+
+type check struct {
+	name     string
+	run      Check
+	liveness bool
+}
+
+var logger = logging.Logger("health")
+
+var mutex sync.RWMutex
+var checks []check
+var results = make(map[string]Result)
+var started bool
+var stopping bool
+var halt *Halt
+
+// RegisterReadiness adds a check that must pass before the service takes orders
+func RegisterReadiness(name string, run Check) {
+	register(check{name: name, run: run})
+}
+
+// RegisterLiveness adds a check whose failure means the process is stuck and
+// should be restarted. Liveness checks also count for readiness.
+func RegisterLiveness(name string, run Check) {
+	register(check{name: name, run: run, liveness: true})
+}
+
+func register(c check) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	checks = append(checks, c)
+}
+
+// SetStarted marks the end of startup, the service is never ready before
+func SetStarted() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	started = true
+}
+
+// SetStopping marks the start of shutdown, the service is never ready after
+// so that load balancers stop sending it traffic
+func SetStopping() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	stopping = true
+}
+
+// SetHalt shows an emergency stop in the probes, nil clears it
+func SetHalt(h *Halt) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	halt = h
+}
+
+// Monitor runs every check at the interval until the context is done
+func Monitor(ctx context.Context, interval time.Duration, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		RunChecks(ctx, timeout)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RunChecks runs every check once, concurrently, and stores the results
+func RunChecks(ctx context.Context, timeout time.Duration) {
+	mutex.RLock()
+	pending := append([]check(nil), checks...)
+	mutex.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, c := range pending {
+		wg.Add(1)
+		go func(c check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			details, err := c.run(checkCtx)
+			result := Result{
+				Status:    StatusUp,
+				Details:   details,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+				CheckedAt: start,
+			}
+			if err != nil {
+				result.Status = StatusDown
+				result.Error = err.Error()
+			}
+
+			mutex.Lock()
+			previous, seen := results[c.name]
+			results[c.name] = result
+			mutex.Unlock()
+			if seen && previous.Status != result.Status {
+				if err != nil {
+					logger.Warn("check failed", "check", c.name, "error", err)
+				} else {
+					logger.Info("check recovered", "check", c.name)
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+// Ready reports whether startup is complete and every check passed on its last run
+func Ready() bool {
+	return report(false).Status == StatusUp
+}
+
+// Readiness returns the results of every check
+func Readiness() Report {
+	return report(false)
+}
+
+// Liveness returns the results of the liveness checks
+func Liveness() Report {
+	return report(true)
+}
+
+func report(livenessOnly bool) Report {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	r := Report{Status: StatusUp, Halt: halt, Checks: make(map[string]Result)}
+	if !livenessOnly && (!started || stopping) {
+		r.Status = StatusDown
+		r.Stopping = stopping
+	}
+	for _, c := range checks {
+		if livenessOnly && !c.liveness {
+			continue
+		}
+		result, ok := results[c.name]
+		if !ok {
+			// Not run yet, only a liveness probe gives the benefit of the doubt
+			if livenessOnly {
+				continue
+			}
+			result = Result{Status: StatusDown, Error: "not checked yet"}
+		}
+		if result.Status != StatusUp {
+			r.Status = StatusDown
+		}
+		r.Checks[c.name] = result
+	}
+	return r
+}
+
+// Ping adapts a ping function without details to a Check
+func Ping(ping func(ctx context.Context) error) Check {
+	return func(ctx context.Context) (interface{}, error) {
+		return nil, ping(ctx)
+	}
+}
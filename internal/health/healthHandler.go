@@ -0,0 +1,29 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// HealthzHandler is the liveness probe, it fails when the process is stuck
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, Liveness())
+}
+
+// ReadyzHandler is the readiness probe, it fails while a dependency is
+// unavailable and order entry is refused
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, Readiness())
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	status := http.StatusOK
+	if report.Status != StatusUp {
+		status = http.StatusServiceUnavailable
+		w.Header().Set("Retry-After", "1")
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
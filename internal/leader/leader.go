@@ -0,0 +1,248 @@
+// Package leader elects, per symbol, the instance that runs the matcher. The
+// leader holds a lease in Redis and renews it in the background, another
+// instance takes the symbol over once the lease expires. Every lease carries a
+// fencing token drawn from a counter that only grows, the engine records the
+// token next to its writes so that a leader that lost its lease without
+// noticing, e.g. during a long pause, cannot write over its successor.
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mfus_OMV1/internal/config"
+	"mfus_OMV1/internal/logging"
+	"mfus_OMV1/internal/metrics"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Lease is the leadership of one symbol held by this instance
+type Lease struct {
+	Symbol string `json:"symbol"`
+	Token  int64  `json:"token"`
+	// ValidUntil is when this instance stops trusting the lease without a
+	// renewal, a little before it expires in Redis
+	ValidUntil time.Time `json:"validUntil"`
+}
+
+var logger = logging.Logger("leader")
+
+var settings = config.Default().Leader
+var instanceID string
+var redisClient *redis.Client
+
+var mutex sync.Mutex
+var leases = make(map[string]Lease)
+
+// attempts bounds how often a standby asks Redis for the lease of a symbol
+var attempts = make(map[string]time.Time)
+
+// acquireScript takes the lease of a symbol when it is free and renews it when
+// this instance holds it. It returns the fencing token of the lease, or 0 when
+// another instance holds it.
+var acquireScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current then
+	local owner, token = string.match(current, '^(.*)/(%d+)$')
+	if owner == ARGV[1] then
+		redis.call('PEXPIRE', KEYS[1], ARGV[2])
+		return tonumber(token)
+	end
+	return 0
+end
+local token = redis.call('INCR', KEYS[2])
+redis.call('SET', KEYS[1], ARGV[1] .. '/' .. token, 'PX', ARGV[2])
+return token
+`)
+
+// releaseScript deletes a lease unless another instance has taken it since
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Configure applies the loaded config
+func Configure(cfg *config.Config) {
+	settings = cfg.Leader
+	instanceID = settings.InstanceID
+	if instanceID == "" {
+		host, _ := os.Hostname()
+		instanceID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+}
+
+// Init sets the Redis client the leases are kept in
+func Init(client *redis.Client) {
+	redisClient = client
+}
+
+// InstanceID names this instance in the leases
+func InstanceID() string {
+	return instanceID
+}
+
+func leaseKey(symbol string) string {
+	return settings.KeyPrefix + symbol
+}
+
+func tokenKey(symbol string) string {
+	return settings.KeyPrefix + symbol + ":token"
+}
+
+// Lead reports whether this instance leads a symbol and the fencing token of
+// its lease. A symbol without a leader is taken over, a standby asks at most
+// once per renew interval. With election disabled every symbol is led with
+// token 0.
+func Lead(ctx context.Context, symbol string) (int64, bool) {
+	if !settings.Enabled {
+		return 0, true
+	}
+	mutex.Lock()
+	lease, held := leases[symbol]
+	last := attempts[symbol]
+	mutex.Unlock()
+	if held && time.Now().Before(lease.ValidUntil) {
+		return lease.Token, true
+	}
+	if time.Since(last) < settings.RenewInterval {
+		return 0, false
+	}
+	lease, ok := acquire(ctx, symbol)
+	return lease.Token, ok
+}
+
+// acquire takes or renews the lease of a symbol. A lease that cannot be
+// renewed because Redis fails is trusted until it is no longer valid.
+func acquire(ctx context.Context, symbol string) (Lease, bool) {
+	start := time.Now()
+	mutex.Lock()
+	attempts[symbol] = start
+	mutex.Unlock()
+
+	token, err := acquireScript.Run(ctx, redisClient, []string{leaseKey(symbol), tokenKey(symbol)},
+		instanceID, settings.LeaseTTL.Milliseconds()).Int64()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	previous, held := leases[symbol]
+	switch {
+	case err != nil:
+		if held && start.Before(previous.ValidUntil) {
+			logger.Warn("cannot renew lease", "symbol", symbol, "token", previous.Token, "error", err)
+			return previous, true
+		}
+		if held {
+			drop(symbol, "lease expired, cannot renew: "+err.Error())
+		}
+		return Lease{Symbol: symbol}, false
+	case token == 0:
+		if held {
+			drop(symbol, "lease taken by another instance")
+		}
+		return Lease{Symbol: symbol}, false
+	}
+
+	lease := Lease{Symbol: symbol, Token: token, ValidUntil: start.Add(settings.LeaseTTL - settings.RenewInterval)}
+	leases[symbol] = lease
+	if !held || previous.Token != token {
+		logger.Info("leadership acquired", "symbol", symbol, "token", token, "instance", instanceID)
+		metrics.Leader.WithLabelValues(symbol).Set(1)
+	}
+	return lease, true
+}
+
+// drop forgets a lease, mutex must be held
+func drop(symbol string, reason string) {
+	lease, held := leases[symbol]
+	if !held {
+		return
+	}
+	delete(leases, symbol)
+	metrics.Leader.WithLabelValues(symbol).Set(0)
+	logger.Warn("leadership lost", "symbol", symbol, "token", lease.Token, "reason", reason)
+}
+
+// Drop gives up a symbol without touching its lease, e.g. once a newer leader
+// has fenced it off
+func Drop(symbol string, reason string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	drop(symbol, reason)
+}
+
+// Run renews the leases this instance holds until the context ends
+func Run(ctx context.Context) {
+	if !settings.Enabled {
+		return
+	}
+	ticker := time.NewTicker(settings.RenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		for _, lease := range Leases() {
+			acquire(ctx, lease.Symbol)
+		}
+	}
+}
+
+// Release gives up the lease of a symbol so that another instance takes it
+// over without waiting for it to expire. Nothing happens unless it is held.
+func Release(ctx context.Context, symbol string) error {
+	mutex.Lock()
+	lease, held := leases[symbol]
+	mutex.Unlock()
+	if !held {
+		return nil
+	}
+	value := fmt.Sprintf("%s/%d", instanceID, lease.Token)
+	err := releaseScript.Run(ctx, redisClient, []string{leaseKey(symbol)}, value).Err()
+	Drop(symbol, "released")
+	return err
+}
+
+// Resign releases every lease, the matcher must have stopped
+func Resign(ctx context.Context) error {
+	var errs []error
+	for _, lease := range Leases() {
+		if err := Release(ctx, lease.Symbol); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", lease.Symbol, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Leases returns the leases this instance holds ordered by symbol
+func Leases() []Lease {
+	mutex.Lock()
+	defer mutex.Unlock()
+	result := make([]Lease, 0, len(leases))
+	for _, lease := range leases {
+		result = append(result, lease)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Symbol < result[j].Symbol })
+	return result
+}
+
+// Details is shown next to the leader check
+type Details struct {
+	Enabled    bool    `json:"enabled"`
+	InstanceID string  `json:"instanceID"`
+	Leading    []Lease `json:"leading"`
+}
+
+// CheckLeases shows the symbols this instance leads. It never fails, a standby
+// is as ready to take orders as a leader.
+func CheckLeases(ctx context.Context) (interface{}, error) {
+	return Details{Enabled: settings.Enabled, InstanceID: instanceID, Leading: Leases()}, nil
+}
@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// HeaderCorrelationID carries the correlation ID of a request and its response
+const HeaderCorrelationID = "X-Correlation-ID"
+
+// maxCorrelationIDLength bounds IDs supplied by clients
+const maxCorrelationIDLength = 64
+
+type correlationKey struct{}
+
+// WithCorrelationID returns a context carrying a correlation ID
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the correlation ID of a context, empty when it has none
+func CorrelationID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// NewCorrelationID returns a random correlation ID
+func NewCorrelationID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidCorrelationID reports whether a client supplied ID can be kept, it
+// must be short and printable so that it cannot corrupt log lines
+func ValidCorrelationID(id string) bool {
+	if id == "" || len(id) > maxCorrelationIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r <= ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware gives every request a correlation ID, keeping a valid one sent
+// by the client, and returns it in the response headers
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HeaderCorrelationID)
+		if !ValidCorrelationID(id) {
+			id = NewCorrelationID()
+		}
+		w.Header().Set(HeaderCorrelationID, id)
+		next.ServeHTTP(w, r.WithContext(WithCorrelationID(r.Context(), id)))
+	})
+}
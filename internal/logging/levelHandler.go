@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// LevelRequest is the body of a log level change
+type LevelRequest struct {
+	Level string `json:"level"`
+}
+
+// GetLevelsHandler returns the level of every component
+func GetLevelsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Levels())
+}
+
+// SetLevelHandler changes the level of a component, or of every component
+// without its own level when the component is "*"
+func SetLevelHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["component"]
+
+	var req LevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	level, err := ParseLevel(req.Level)
+	if err != nil {
+		http.Error(w, "level must be debug, info, warn or error", http.StatusBadRequest)
+		return
+	}
+	if err := SetLevel(name, level); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	Logger("logging").InfoContext(r.Context(), "log level changed", "target", name, "level", req.Level)
+
+	GetLevelsHandler(w, r)
+}
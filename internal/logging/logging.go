@@ -0,0 +1,174 @@
+// Package logging provides the structured JSON loggers of the order manager.
+// Every component logs through its own logger whose level can be changed at
+// runtime, and records logged with a context carry its correlation ID.
+package logging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+var mutex sync.Mutex
+
+// output is shared by every component so that concurrent records never interleave
+var output io.Writer = &lockedWriter{w: os.Stdout}
+var defaultLevel = slog.LevelInfo
+var overrides = make(map[string]slog.Level)
+var components = make(map[string]*component)
+
+type component struct {
+	level  *slog.LevelVar
+	logger *slog.Logger
+}
+
+// Logger returns the logger of a component, creating it on first use
+func Logger(name string) *slog.Logger {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return lookup(name).logger
+}
+
+// lookup must be called with the mutex held
+func lookup(name string) *component {
+	c, ok := components[name]
+	if !ok {
+		level := new(slog.LevelVar)
+		level.Set(levelOf(name))
+		handler := slog.NewJSONHandler(output, &slog.HandlerOptions{Level: level})
+		c = &component{
+			level:  level,
+			logger: slog.New(contextHandler{handler}).With("component", name),
+		}
+		components[name] = c
+	}
+	return c
+}
+
+// levelOf must be called with the mutex held
+func levelOf(name string) slog.Level {
+	if level, ok := overrides[name]; ok {
+		return level
+	}
+	return defaultLevel
+}
+
+// Configure sets the default level and the per component levels, and routes
+// the standard library logger through the "default" component
+func Configure(level string, componentLevels map[string]string) error {
+	parsed, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	levels := make(map[string]slog.Level, len(componentLevels))
+	for name, value := range componentLevels {
+		if levels[name], err = ParseLevel(value); err != nil {
+			return err
+		}
+	}
+
+	mutex.Lock()
+	defaultLevel = parsed
+	overrides = levels
+	for name, c := range components {
+		c.level.Set(levelOf(name))
+	}
+	std := lookup("default")
+	mutex.Unlock()
+
+	// Whatever still logs through the log package ends up as JSON too
+	log.SetFlags(0)
+	log.SetOutput(slogWriter{std.logger})
+	return nil
+}
+
+// ErrUnknownComponent is returned when changing the level of a component that
+// has no logger
+var ErrUnknownComponent = errors.New("unknown log component")
+
+// SetLevel changes the level of one component at runtime, "*" changes the
+// default and every component without its own level
+func SetLevel(name string, level slog.Level) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if name == "*" {
+		defaultLevel = level
+		for name, c := range components {
+			if _, ok := overrides[name]; !ok {
+				c.level.Set(level)
+			}
+		}
+		return nil
+	}
+	c, ok := components[name]
+	if !ok {
+		return ErrUnknownComponent
+	}
+	overrides[name] = level
+	c.level.Set(level)
+	return nil
+}
+
+// Levels returns the current level of every component, "*" is the default
+func Levels() map[string]string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	levels := map[string]string{"*": strings.ToLower(defaultLevel.String())}
+	for name, c := range components {
+		levels[name] = strings.ToLower(c.level.Level().String())
+	}
+	return levels
+}
+
+// ParseLevel reads one of debug, info, warn or error
+func ParseLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(value))
+	return level, err
+}
+
+// contextHandler adds the correlation ID of the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := CorrelationID(ctx); id != "" {
+		record.AddAttrs(slog.String("correlationID", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// slogWriter adapts the standard library logger to a component logger
+type slogWriter struct {
+	logger *slog.Logger
+}
+
+func (w slogWriter) Write(p []byte) (int, error) {
+	w.logger.Info(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+type lockedWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.w.Write(p)
+}
@@ -0,0 +1,114 @@
+package marketdata
+
+import (
+	"context"
+	"encoding/json"
+	"mfus_OMV1/pkg/database"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Page size limits of the candles endpoint
+const (
+	DefaultCandleLimit = 500
+	MaxCandleLimit     = 1500
+)
+
+func GetCandlesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	symbol := query.Get("symbol")
+	interval := query.Get("interval")
+	if symbol == "" || interval == "" {
+		http.Error(w, "symbol and interval are required", http.StatusBadRequest)
+		return
+	}
+	from, to, ok := parseRange(w, query.Get("from"), query.Get("to"))
+	if !ok {
+		return
+	}
+	limit := int64(DefaultCandleLimit)
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		if n > MaxCandleLimit {
+			n = MaxCandleLimit
+		}
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	candles, err := GetCandles(ctx, client, symbol, interval, from, to, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(candles)
+}
+
+func RebuildCandlesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	symbol := query.Get("symbol")
+	if symbol == "" {
+		http.Error(w, "symbol is required", http.StatusBadRequest)
+		return
+	}
+	from, to, ok := parseRange(w, query.Get("from"), query.Get("to"))
+	if !ok {
+		return
+	}
+	end := time.Now()
+	if to > 0 {
+		end = time.UnixMilli(to)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	count, err := RebuildCandles(ctx, client, symbol, time.UnixMilli(from), end)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"symbol": symbol, "candles": count})
+}
+
+// parseRange reads optional millisecond from and to parameters
+func parseRange(w http.ResponseWriter, fromValue string, toValue string) (int64, int64, bool) {
+	var from, to int64
+	var err error
+	if fromValue != "" {
+		if from, err = strconv.ParseInt(fromValue, 10, 64); err != nil {
+			http.Error(w, "from must be a timestamp in milliseconds", http.StatusBadRequest)
+			return 0, 0, false
+		}
+	}
+	if toValue != "" {
+		if to, err = strconv.ParseInt(toValue, 10, 64); err != nil {
+			http.Error(w, "to must be a timestamp in milliseconds", http.StatusBadRequest)
+			return 0, 0, false
+		}
+	}
+	return from, to, true
+}
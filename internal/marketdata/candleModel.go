@@ -0,0 +1,53 @@
+package marketdata
+
+import (
+	"mfus_OMV1/internal/config"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Define the database and collection names
+var dbName = "orderbook"
+var candlesCollection = "candles"
+var tradeCollection = "trades"
+
+// Configure applies the database and collection names of the loaded config
+func Configure(cfg *config.Config) {
+	dbName = cfg.Mongo.Database
+	candlesCollection = cfg.Collections.Candles
+	tradeCollection = cfg.Collections.Trades
+}
+
+// Intervals maps the supported candle intervals to their length. Every
+// interval divides a day, so daily boundaries are boundaries of all of them.
+var Intervals = map[string]time.Duration{
+	"1m":  time.Minute,
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+	"1h":  time.Hour,
+	"1d":  24 * time.Hour,
+}
+
+// CandleModel is one OHLCV bar. Times are in milliseconds, CloseTime is exclusive.
+type CandleModel struct {
+	ID          primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Symbol      string             `json:"symbol" bson:"symbol"`
+	Interval    string             `json:"interval" bson:"interval"`
+	OpenTime    int64              `json:"openTime" bson:"openTime"`
+	CloseTime   int64              `json:"closeTime" bson:"closeTime"`
+	Open        float64            `json:"open" bson:"open"`
+	High        float64            `json:"high" bson:"high"`
+	Low         float64            `json:"low" bson:"low"`
+	Close       float64            `json:"close" bson:"close"`
+	Volume      float64            `json:"volume" bson:"volume"`
+	QuoteVolume float64            `json:"quoteVolume" bson:"quoteVolume"`
+	TradeCount  int64              `json:"tradeCount" bson:"tradeCount"`
+}
+
+// bucket returns the open time of the bar of an interval that contains t
+func bucket(t time.Time, interval time.Duration) int64 {
+	millis := t.UnixNano() / int64(time.Millisecond)
+	size := int64(interval / time.Millisecond)
+	return millis - millis%size
+}
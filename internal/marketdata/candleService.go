@@ -0,0 +1,183 @@
+package marketdata
+
+import (
+	"context"
+	"errors"
+	"mfus_OMV1/internal/logging"
+	"mfus_OMV1/internal/orderbook"
+	"mfus_OMV1/pkg/database"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var logger = logging.Logger("marketdata")
+
+// Start subscribes the market data services to engine trades
+func Start() {
+	orderbook.OnTrade(ApplyTrade)
+	orderbook.OnTrade(ApplyTickerTrade)
+}
+
+// ApplyTrade folds a trade into the bars of every interval and pushes them live
+func ApplyTrade(trade orderbook.TradeHistoryModel) {
+	client, err := database.GetMongoClient()
+	if err != nil {
+		logger.Error("cannot apply trade to candles", "trade", trade.Id, "error", err)
+		return
+	}
+	for name, interval := range Intervals {
+		candle, err := applyCandle(context.Background(), client, trade, name, interval)
+		if err != nil {
+			logger.Error("cannot update candle", "interval", name, "symbol", trade.Symbol, "error", err)
+			continue
+		}
+		orderbook.Publish("candles_"+name, trade.Symbol, candle)
+	}
+}
+
+// applyCandle upserts the bar that contains a trade. Trades are delivered in
+// execution order per symbol, so the latest trade sets the close.
+func applyCandle(ctx context.Context, mongoClient *mongo.Client, trade orderbook.TradeHistoryModel, name string, interval time.Duration) (CandleModel, error) {
+	openTime := bucket(trade.ExecutedAt, interval)
+	quantity := float64(trade.Quantity)
+
+	var candle CandleModel
+	err := mongoClient.Database(dbName).Collection(candlesCollection).FindOneAndUpdate(ctx,
+		bson.M{"symbol": trade.Symbol, "interval": name, "openTime": openTime},
+		bson.M{
+			"$setOnInsert": bson.M{"open": trade.Price, "closeTime": openTime + int64(interval/time.Millisecond)},
+			"$max":         bson.M{"high": trade.Price},
+			"$min":         bson.M{"low": trade.Price},
+			"$set":         bson.M{"close": trade.Price},
+			"$inc":         bson.M{"volume": quantity, "quoteVolume": quantity * trade.Price, "tradeCount": 1},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&candle)
+	return candle, err
+}
+
+// GetCandles returns the bars of a symbol and interval in [from, to), oldest first
+func GetCandles(ctx context.Context, mongoClient *mongo.Client, symbol string, interval string, from int64, to int64, limit int64) ([]CandleModel, error) {
+	if _, ok := Intervals[interval]; !ok {
+		return nil, errors.New("unsupported interval " + interval)
+	}
+	filter := bson.M{"symbol": symbol, "interval": interval}
+	openTime := bson.M{}
+	if from > 0 {
+		openTime["$gte"] = from
+	}
+	if to > 0 {
+		openTime["$lt"] = to
+	}
+	if len(openTime) > 0 {
+		filter["openTime"] = openTime
+	}
+
+	// Take the most recent bars first so the limit keeps the newest ones
+	opts := options.Find().SetSort(bson.D{{Key: "openTime", Value: -1}}).SetLimit(limit)
+	cursor, err := mongoClient.Database(dbName).Collection(candlesCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	candles := make([]CandleModel, 0)
+	if err := cursor.All(ctx, &candles); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(candles)-1; i < j; i, j = i+1, j-1 {
+		candles[i], candles[j] = candles[j], candles[i]
+	}
+	return candles, nil
+}
+
+// RebuildCandles recomputes every bar of a symbol between two times from the
+// trades collection. The range is widened to whole days so that no bar is
+// only partially rebuilt.
+func RebuildCandles(ctx context.Context, mongoClient *mongo.Client, symbol string, from time.Time, to time.Time) (int, error) {
+	day := Intervals["1d"]
+	start := time.UnixMilli(bucket(from, day))
+	end := time.UnixMilli(bucket(to, day)).Add(day)
+
+	opts := options.Find().SetSort(bson.D{{Key: "executed_at", Value: 1}})
+	cursor, err := mongoClient.Database(dbName).Collection(tradeCollection).Find(ctx, bson.M{
+		"symbol":      symbol,
+		"executed_at": bson.M{"$gte": start, "$lt": end},
+	}, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	type key struct {
+		interval string
+		openTime int64
+	}
+	candles := make(map[key]*CandleModel)
+	for cursor.Next(ctx) {
+		var trade orderbook.TradeHistoryModel
+		if err := cursor.Decode(&trade); err != nil {
+			return 0, err
+		}
+		quantity := float64(trade.Quantity)
+		for name, interval := range Intervals {
+			k := key{name, bucket(trade.ExecutedAt, interval)}
+			candle, ok := candles[k]
+			if !ok {
+				candle = &CandleModel{
+					Symbol:    symbol,
+					Interval:  name,
+					OpenTime:  k.openTime,
+					CloseTime: k.openTime + int64(interval/time.Millisecond),
+					Open:      trade.Price,
+					High:      trade.Price,
+					Low:       trade.Price,
+				}
+				candles[k] = candle
+			}
+			if trade.Price > candle.High {
+				candle.High = trade.Price
+			}
+			if trade.Price < candle.Low {
+				candle.Low = trade.Price
+			}
+			candle.Close = trade.Price
+			candle.Volume += quantity
+			candle.QuoteVolume += quantity * trade.Price
+			candle.TradeCount++
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return 0, err
+	}
+
+	collection := mongoClient.Database(dbName).Collection(candlesCollection)
+	_, err = collection.DeleteMany(ctx, bson.M{
+		"symbol":   symbol,
+		"openTime": bson.M{"$gte": start.UnixMilli(), "$lt": end.UnixMilli()},
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, candle := range candles {
+		_, err := collection.ReplaceOne(ctx,
+			bson.M{"symbol": candle.Symbol, "interval": candle.Interval, "openTime": candle.OpenTime},
+			candle,
+			options.Replace().SetUpsert(true),
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(candles), nil
+}
+
+// EnsureIndexes creates the indexes of the market data collections
+func EnsureIndexes(ctx context.Context, mongoClient *mongo.Client) error {
+	_, err := mongoClient.Database(dbName).Collection(candlesCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "symbol", Value: 1}, {Key: "interval", Value: 1}, {Key: "openTime", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
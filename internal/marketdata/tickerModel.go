@@ -0,0 +1,40 @@
+package marketdata
+
+import "time"
+
+// TickerWindow is the length of the rolling ticker statistics
+const TickerWindow = 24 * time.Hour
+
+// tickerBuckets is the number of one minute buckets in the ticker window
+const tickerBuckets = int(TickerWindow / time.Minute)
+
+// TickerModel holds the rolling 24 hour statistics of a symbol
+type TickerModel struct {
+	Symbol             string  `json:"symbol"`
+	LastPrice          float64 `json:"lastPrice"`
+	OpenPrice          float64 `json:"openPrice"`
+	HighPrice          float64 `json:"highPrice"`
+	LowPrice           float64 `json:"lowPrice"`
+	Volume             float64 `json:"volume"`
+	QuoteVolume        float64 `json:"quoteVolume"`
+	VWAP               float64 `json:"vwap"`
+	PriceChange        float64 `json:"priceChange"`
+	PriceChangePercent float64 `json:"priceChangePercent"`
+	BestBid            float64 `json:"bestBid"`
+	BestAsk            float64 `json:"bestAsk"`
+	TradeCount         int64   `json:"tradeCount"`
+	OpenTime           int64   `json:"openTime"`
+	CloseTime          int64   `json:"closeTime"`
+}
+
+// minuteBucket aggregates the trades of one minute of the ticker window
+type minuteBucket struct {
+	minute      int64
+	open        float64
+	high        float64
+	low         float64
+	close       float64
+	volume      float64
+	quoteVolume float64
+	count       int64
+}
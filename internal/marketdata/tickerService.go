@@ -0,0 +1,193 @@
+package marketdata
+
+import (
+	"context"
+	"mfus_OMV1/internal/orderbook"
+	"mfus_OMV1/pkg/database"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// tickerWindow keeps one bucket per minute of the last 24 hours of a symbol
+type tickerWindow struct {
+	mutex    sync.Mutex
+	symbol   string
+	buckets  [tickerBuckets]minuteBucket
+	loadedAt time.Time
+}
+
+var windowsMutex sync.Mutex
+var windows = make(map[string]*tickerWindow)
+
+// ApplyTickerTrade folds a trade into the rolling window of its symbol and pushes the ticker
+func ApplyTickerTrade(trade orderbook.TradeHistoryModel) {
+	window := windowFor(trade.Symbol)
+	window.mutex.Lock()
+	// Trades executed before the window was seeded are already counted
+	if trade.ExecutedAt.Before(window.loadedAt) {
+		window.mutex.Unlock()
+		return
+	}
+	window.add(trade)
+	window.mutex.Unlock()
+
+	orderbook.Publish("ticker", trade.Symbol, GetTicker(trade.Symbol))
+}
+
+// GetTicker returns the current 24 hour statistics of a symbol
+func GetTicker(symbol string) TickerModel {
+	window := windowFor(symbol)
+	now := time.Now()
+	window.mutex.Lock()
+	ticker := window.snapshot(now)
+	window.mutex.Unlock()
+
+	manager, ok := orderbook.FindOrderManager(symbol)
+	if !ok {
+		return ticker
+	}
+	if ticker.TradeCount == 0 {
+		ticker.LastPrice = manager.LastPrice()
+	}
+	ticker.BestBid, ticker.BestAsk = manager.BestBidAsk()
+	return ticker
+}
+
+// GetTickers returns the statistics of every symbol traded in the last 24 hours
+// or currently managed by the engine
+func GetTickers(ctx context.Context, mongoClient *mongo.Client) ([]TickerModel, error) {
+	symbols, err := mongoClient.Database(dbName).Collection(tradeCollection).Distinct(ctx, "symbol",
+		bson.M{"executed_at": bson.M{"$gte": time.Now().Add(-TickerWindow)}})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, symbol := range symbols {
+		if s, ok := symbol.(string); ok {
+			seen[s] = true
+		}
+	}
+	for _, manager := range orderbook.OrderManagers() {
+		seen[manager.Symbol] = true
+	}
+
+	names := make([]string, 0, len(seen))
+	for symbol := range seen {
+		names = append(names, symbol)
+	}
+	sort.Strings(names)
+
+	tickers := make([]TickerModel, 0, len(names))
+	for _, symbol := range names {
+		tickers = append(tickers, GetTicker(symbol))
+	}
+	return tickers, nil
+}
+
+// windowFor returns the window of a symbol, seeding it from the trades
+// collection on first use
+func windowFor(symbol string) *tickerWindow {
+	windowsMutex.Lock()
+	defer windowsMutex.Unlock()
+	window, ok := windows[symbol]
+	if !ok {
+		window = &tickerWindow{symbol: symbol}
+		window.load()
+		windows[symbol] = window
+	}
+	return window
+}
+
+// load seeds the window with the trades of the last 24 hours
+func (t *tickerWindow) load() {
+	t.loadedAt = time.Now()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := client.Database(dbName).Collection(tradeCollection).Find(ctx,
+		bson.M{"symbol": t.symbol, "executed_at": bson.M{"$gte": t.loadedAt.Add(-TickerWindow), "$lt": t.loadedAt}},
+		options.Find().SetSort(bson.D{{Key: "executed_at", Value: 1}}),
+	)
+	if err != nil {
+		logger.Error("cannot load ticker trades", "symbol", t.symbol, "error", err)
+		return
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var trade orderbook.TradeHistoryModel
+		if err := cursor.Decode(&trade); err != nil {
+			logger.Error("cannot decode ticker trade", "symbol", t.symbol, "error", err)
+			return
+		}
+		t.add(trade)
+	}
+}
+
+func (t *tickerWindow) add(trade orderbook.TradeHistoryModel) {
+	minute := bucket(trade.ExecutedAt, time.Minute)
+	b := &t.buckets[int((minute/int64(time.Minute/time.Millisecond))%int64(tickerBuckets))]
+	if b.minute != minute {
+		*b = minuteBucket{minute: minute, open: trade.Price, high: trade.Price, low: trade.Price}
+	}
+	quantity := float64(trade.Quantity)
+	if trade.Price > b.high {
+		b.high = trade.Price
+	}
+	if trade.Price < b.low {
+		b.low = trade.Price
+	}
+	b.close = trade.Price
+	b.volume += quantity
+	b.quoteVolume += quantity * trade.Price
+	b.count++
+}
+
+// snapshot folds the buckets still inside the window into a ticker
+func (t *tickerWindow) snapshot(now time.Time) TickerModel {
+	closeTime := now.UnixNano() / int64(time.Millisecond)
+	openTime := closeTime - int64(TickerWindow/time.Millisecond)
+	ticker := TickerModel{Symbol: t.symbol, OpenTime: openTime, CloseTime: closeTime}
+
+	var first, last int64
+	for i := range t.buckets {
+		b := &t.buckets[i]
+		if b.count == 0 || b.minute+int64(time.Minute/time.Millisecond) <= openTime {
+			continue
+		}
+		if ticker.TradeCount == 0 || b.minute < first {
+			first = b.minute
+			ticker.OpenPrice = b.open
+		}
+		if ticker.TradeCount == 0 || b.minute > last {
+			last = b.minute
+			ticker.LastPrice = b.close
+		}
+		if ticker.TradeCount == 0 || b.high > ticker.HighPrice {
+			ticker.HighPrice = b.high
+		}
+		if ticker.TradeCount == 0 || b.low < ticker.LowPrice {
+			ticker.LowPrice = b.low
+		}
+		ticker.Volume += b.volume
+		ticker.QuoteVolume += b.quoteVolume
+		ticker.TradeCount += b.count
+	}
+
+	if ticker.Volume > 0 {
+		ticker.VWAP = ticker.QuoteVolume / ticker.Volume
+	}
+	if ticker.OpenPrice > 0 {
+		ticker.PriceChange = ticker.LastPrice - ticker.OpenPrice
+		ticker.PriceChangePercent = ticker.PriceChange / ticker.OpenPrice * 100
+	}
+	return ticker
+}
@@ -0,0 +1,163 @@
+// Package metrics defines the Prometheus metrics of the order manager and
+// serves them on /metrics. Engine metrics carry the symbol they relate to.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "mfus"
+
+// Order reject reasons
+const (
+	ReasonInvalid           = "invalid"
+	ReasonInsufficientFunds = "insufficient_funds"
+	ReasonRateLimited       = "rate_limited"
+	ReasonUnauthenticated   = "unauthenticated"
+	ReasonNotReady          = "not_ready"
+	ReasonHalted            = "halted"
+	ReasonSuspended         = "suspended"
+	ReasonRiskLimit         = "risk_limit"
+	ReasonOverloaded        = "overloaded"
+	ReasonError             = "error"
+)
+
+// latencyBuckets span sub-millisecond cache hits up to multi-second stalls
+var latencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// pipelineBuckets span in-memory hand-offs up to stages waiting on writes
+var pipelineBuckets = []float64{.00001, .000025, .00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .1, 1}
+
+var (
+	OrdersAccepted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orders_accepted_total",
+		Help:      "Orders accepted onto the book.",
+	}, []string{"symbol"})
+
+	OrdersRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orders_rejected_total",
+		Help:      "Orders refused before reaching the book, by reason.",
+	}, []string{"symbol", "reason"})
+
+	Trades = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "trades_total",
+		Help:      "Trades executed by the matcher.",
+	}, []string{"symbol"})
+
+	MatchedVolume = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "matched_volume_total",
+		Help:      "Quantity executed by the matcher.",
+	}, []string{"symbol"})
+
+	MatchedNotional = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "matched_notional_total",
+		Help:      "Quantity times price executed by the matcher.",
+	}, []string{"symbol"})
+
+	BookQuantity = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "book_quantity",
+		Help:      "Open quantity resting on each side of the book.",
+	}, []string{"symbol", "side"})
+
+	BookOrders = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "book_orders",
+		Help:      "Orders resting on each side of the book.",
+	}, []string{"symbol", "side"})
+
+	BookLevels = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "book_levels",
+		Help:      "Price levels on each side of the book.",
+	}, []string{"symbol", "side"})
+
+	Leader = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "1 while this instance leads the matcher of a symbol.",
+	}, []string{"symbol"})
+
+	MatchLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "match_duration_seconds",
+		Help:      "Time of one matching pass over a book, including its writes.",
+		Buckets:   latencyBuckets,
+	}, []string{"symbol"})
+
+	PipelineLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "pipeline_latency_seconds",
+		Help:      "Time from an order reaching the matcher of a symbol until a pipeline stage handled its result.",
+		Buckets:   pipelineBuckets,
+	}, []string{"symbol", "stage"})
+
+	MongoLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongo_command_duration_seconds",
+		Help:      "MongoDB command latency.",
+		Buckets:   latencyBuckets,
+	}, []string{"command"})
+
+	MongoErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mongo_command_errors_total",
+		Help:      "MongoDB commands that failed.",
+	}, []string{"command"})
+
+	RedisLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_command_duration_seconds",
+		Help:      "Redis command latency.",
+		Buckets:   latencyBuckets,
+	}, []string{"command"})
+
+	RedisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redis_command_errors_total",
+		Help:      "Redis commands that failed, missing keys are not errors.",
+	}, []string{"command"})
+
+	HTTPLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "REST API latency by route and status code.",
+		Buckets:   latencyBuckets,
+	}, []string{"method", "route", "code"})
+)
+
+// ObserveMongo records one MongoDB command
+func ObserveMongo(command string, duration time.Duration, failed bool) {
+	MongoLatency.WithLabelValues(command).Observe(duration.Seconds())
+	if failed {
+		MongoErrors.WithLabelValues(command).Inc()
+	}
+}
+
+// ObserveRedis records one Redis command
+func ObserveRedis(command string, duration time.Duration, failed bool) {
+	RedisLatency.WithLabelValues(command).Observe(duration.Seconds())
+	if failed {
+		RedisErrors.WithLabelValues(command).Inc()
+	}
+}
+
+// Register adds collectors that compute their values when scraped
+func Register(collectors ...prometheus.Collector) {
+	prometheus.MustRegister(collectors...)
+}
+
+// Handler serves every registered metric in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
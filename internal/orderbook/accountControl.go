@@ -0,0 +1,147 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mfus_OMV1/utils"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var accountControlsCollection = "account_controls"
+
+// ErrUserSuspended is returned by order entry while the caller's trading is
+// suspended. Cancels are still accepted.
+var ErrUserSuspended = errors.New("trading is suspended for this account")
+
+// ErrRiskLimit is wrapped by the errors of orders that exceed a risk limit
+var ErrRiskLimit = errors.New("risk limit exceeded")
+
+// RiskLimits bound the orders of one user, zero disables a limit
+type RiskLimits struct {
+	MaxOrderQuantity int64   `json:"maxOrderQuantity" bson:"maxOrderQuantity"`
+	MaxOrderNotional float64 `json:"maxOrderNotional" bson:"maxOrderNotional"`
+	MaxOpenOrders    int64   `json:"maxOpenOrders" bson:"maxOpenOrders"`
+}
+
+// AccountControlModel holds the operator controls of a user
+type AccountControlModel struct {
+	UserID        string     `json:"userID" bson:"userID"`
+	Suspended     bool       `json:"suspended" bson:"suspended"`
+	SuspendReason string     `json:"suspendReason,omitempty" bson:"suspendReason,omitempty"`
+	Limits        RiskLimits `json:"limits" bson:"limits"`
+	UpdateTime    int64      `json:"updateTime" bson:"updateTime"`
+}
+
+type cachedControl struct {
+	control AccountControlModel
+	at      time.Time
+}
+
+var controlsMutex sync.Mutex
+var controls = make(map[string]cachedControl)
+
+// GetAccountControl returns the controls of a user, a user without any has
+// the zero value
+func GetAccountControl(ctx context.Context, mongoClient *mongo.Client, userID string) (AccountControlModel, error) {
+	control := AccountControlModel{UserID: userID}
+	err := mongoClient.Database(dbName).Collection(accountControlsCollection).FindOne(ctx, bson.M{"userID": userID}).Decode(&control)
+	if err == mongo.ErrNoDocuments {
+		return control, nil
+	}
+	return control, err
+}
+
+// SetSuspended suspends or resumes the trading of a user
+func SetSuspended(ctx context.Context, mongoClient *mongo.Client, userID string, suspended bool, reason string) (AccountControlModel, error) {
+	if !suspended {
+		reason = ""
+	}
+	return updateAccountControl(ctx, mongoClient, userID, bson.M{"suspended": suspended, "suspendReason": reason})
+}
+
+// SetRiskLimits replaces the risk limits of a user
+func SetRiskLimits(ctx context.Context, mongoClient *mongo.Client, userID string, limits RiskLimits) (AccountControlModel, error) {
+	if limits.MaxOrderQuantity < 0 || limits.MaxOrderNotional < 0 || limits.MaxOpenOrders < 0 {
+		return AccountControlModel{}, invalidOrder(errors.New("risk limits must not be negative"))
+	}
+	return updateAccountControl(ctx, mongoClient, userID, bson.M{"limits": limits})
+}
+
+func updateAccountControl(ctx context.Context, mongoClient *mongo.Client, userID string, set bson.M) (AccountControlModel, error) {
+	var control AccountControlModel
+	set["updateTime"] = utils.GetCurrentTimestamp()
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := mongoClient.Database(dbName).Collection(accountControlsCollection).
+		FindOneAndUpdate(ctx, bson.M{"userID": userID}, bson.M{"$set": set}, opts).Decode(&control)
+	if err != nil {
+		return control, err
+	}
+
+	controlsMutex.Lock()
+	delete(controls, userID)
+	controlsMutex.Unlock()
+	return control, nil
+}
+
+// accountControl returns the cached controls of a user, the previous value is
+// kept while MongoDB fails
+func accountControl(ctx context.Context, mongoClient *mongo.Client, userID string) (AccountControlModel, error) {
+	controlsMutex.Lock()
+	cached, ok := controls[userID]
+	controlsMutex.Unlock()
+	if ok && time.Since(cached.at) < controlsCacheTTL {
+		return cached.control, nil
+	}
+
+	control, err := GetAccountControl(ctx, mongoClient, userID)
+	if err != nil {
+		if ok {
+			return cached.control, nil
+		}
+		return control, fmt.Errorf("loading account controls: %w", err)
+	}
+	controlsMutex.Lock()
+	controls[userID] = cachedControl{control: control, at: time.Now()}
+	controlsMutex.Unlock()
+	return control, nil
+}
+
+// checkAccount rejects orders of suspended users and orders beyond their risk
+// limits. Amends pass newOrder false, they do not add an open order.
+func checkAccount(ctx context.Context, mongoClient *mongo.Client, order OrderModel, newOrder bool) error {
+	control, err := accountControl(ctx, mongoClient, order.UserID)
+	if err != nil {
+		return err
+	}
+	if control.Suspended {
+		if control.SuspendReason != "" {
+			return fmt.Errorf("%w: %s", ErrUserSuspended, control.SuspendReason)
+		}
+		return ErrUserSuspended
+	}
+
+	limits := control.Limits
+	if limits.MaxOrderQuantity > 0 && order.Quantity > limits.MaxOrderQuantity {
+		return invalidOrder(fmt.Errorf("%w: quantity above %d", ErrRiskLimit, limits.MaxOrderQuantity))
+	}
+	if notional := float64(order.Quantity) * order.Price; limits.MaxOrderNotional > 0 && notional > limits.MaxOrderNotional {
+		return invalidOrder(fmt.Errorf("%w: notional above %g", ErrRiskLimit, limits.MaxOrderNotional))
+	}
+	if newOrder && limits.MaxOpenOrders > 0 {
+		open, err := mongoClient.Database(dbName).Collection(ordersCollection).CountDocuments(ctx,
+			bson.M{"userID": order.UserID, "status": openStatusFilter})
+		if err != nil {
+			return err
+		}
+		if open >= limits.MaxOpenOrders {
+			return invalidOrder(fmt.Errorf("%w: %d open orders", ErrRiskLimit, limits.MaxOpenOrders))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+package orderbook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mfus_OMV1/internal/auth"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// adminRequest is the body of the admin actions that take a reason
+type adminRequest struct {
+	Reason string `json:"reason"`
+	// CancelOrders also cancels the open orders of a suspended user
+	CancelOrders bool `json:"cancelOrders"`
+}
+
+// forceCancelRequest selects the open orders an operator cancels, at least
+// one of the filters is required
+type forceCancelRequest struct {
+	OrderID string `json:"orderID"`
+	Symbol  string `json:"symbol"`
+	UserID  string `json:"userID"`
+	Reason  string `json:"reason"`
+}
+
+// killSwitchResult is the response of engaging the kill switch
+type killSwitchResult struct {
+	KillSwitch KillSwitchModel `json:"killSwitch"`
+	Cancelled  []OrderModel    `json:"cancelled"`
+}
+
+// forceCancelReason is recorded on orders cancelled without a reason
+const forceCancelReason = "cancelled by operator"
+
+// decodeAdminRequest reads an optional JSON body
+func decodeAdminRequest(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+func writeAdminResult(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+func GetInstrumentsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	client, err := MongoDBOrderBookCollectionFunc()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	instruments, err := GetInstruments(ctx, client)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAdminResult(w, instruments)
+}
+
+func AddInstrumentHandler(w http.ResponseWriter, r *http.Request) {
+	var instrument InstrumentModel
+	if err := json.NewDecoder(r.Body).Decode(&instrument); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	client, err := MongoDBOrderBookCollectionFunc()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	instrument, err = AddInstrument(ctx, client, instrument)
+	if err != nil {
+		writeOrderError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(instrument)
+}
+
+// HaltSymbolHandler stops order entry and matching of a symbol, cancels and
+// queries keep working
+func HaltSymbolHandler(w http.ResponseWriter, r *http.Request) {
+	setSymbolStatus(w, r, InstrumentHalted)
+}
+
+// ResumeSymbolHandler lets a halted symbol trade again
+func ResumeSymbolHandler(w http.ResponseWriter, r *http.Request) {
+	setSymbolStatus(w, r, InstrumentTrading)
+}
+
+func setSymbolStatus(w http.ResponseWriter, r *http.Request, status string) {
+	var req adminRequest
+	if err := decodeAdminRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	client, err := MongoDBOrderBookCollectionFunc()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	instrument, err := SetInstrumentStatus(ctx, client, mux.Vars(r)["symbol"], status, req.Reason)
+	if err != nil {
+		writeOrderError(w, err)
+		return
+	}
+	writeAdminResult(w, instrument)
+}
+
+// ForceCancelHandler cancels an order, or every open order of a symbol, a
+// user or both, on behalf of an operator
+func ForceCancelHandler(w http.ResponseWriter, r *http.Request) {
+	var req forceCancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter := bson.M{}
+	if req.OrderID != "" {
+		id, err := primitive.ObjectIDFromHex(req.OrderID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter["_id"] = id
+	}
+	if req.Symbol != "" {
+		filter["symbol"] = req.Symbol
+	}
+	if req.UserID != "" {
+		filter["userID"] = req.UserID
+	}
+	if len(filter) == 0 {
+		http.Error(w, "orderID, symbol or userID is required", http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = forceCancelReason
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	cancelled, err := ForceCancelOrders(ctx, filter, req.Reason)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAdminResult(w, cancelled)
+}
+
+func GetAccountControlHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	client, err := MongoDBOrderBookCollectionFunc()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	control, err := GetAccountControl(ctx, client, mux.Vars(r)["userID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAdminResult(w, control)
+}
+
+// SuspendUserHandler stops the order entry of a user, optionally cancelling
+// the user's open orders
+func SuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	setSuspended(w, r, true)
+}
+
+// UnsuspendUserHandler lets a suspended user trade again
+func UnsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	setSuspended(w, r, false)
+}
+
+func setSuspended(w http.ResponseWriter, r *http.Request, suspended bool) {
+	var req adminRequest
+	if err := decodeAdminRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	userID := mux.Vars(r)["userID"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	client, err := MongoDBOrderBookCollectionFunc()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	control, err := SetSuspended(ctx, client, userID, suspended, req.Reason)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if suspended && req.CancelOrders {
+		reason := "account suspended"
+		if req.Reason != "" {
+			reason += ": " + req.Reason
+		}
+		if _, err := ForceCancelOrders(ctx, bson.M{"userID": userID}, reason); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	writeAdminResult(w, control)
+}
+
+func SetRiskLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	var limits RiskLimits
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	client, err := MongoDBOrderBookCollectionFunc()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	control, err := SetRiskLimits(ctx, client, mux.Vars(r)["userID"], limits)
+	if err != nil {
+		writeOrderError(w, err)
+		return
+	}
+	writeAdminResult(w, control)
+}
+
+// SnapshotHandler writes a snapshot of every book and returns them
+func SnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	client, err := MongoDBOrderBookCollectionFunc()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	snapshots, err := WriteSnapshots(ctx, client)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAdminResult(w, snapshots)
+}
+
+func GetKillSwitchHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	client, err := MongoDBOrderBookCollectionFunc()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	state, err := GetKillSwitch(ctx, client)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAdminResult(w, state)
+}
+
+// EngageKillSwitchHandler stops order entry and matching on every symbol,
+// optionally cancelling every open order
+func EngageKillSwitchHandler(w http.ResponseWriter, r *http.Request) {
+	var req adminRequest
+	if err := decodeAdminRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	principal, _ := auth.FromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+	client, err := MongoDBOrderBookCollectionFunc()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	state, cancelled, err := EngageKillSwitch(ctx, client, principal.UserID, req.Reason, req.CancelOrders)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAdminResult(w, killSwitchResult{KillSwitch: state, Cancelled: cancelled})
+}
+
+// ReleaseKillSwitchHandler lets every symbol trade again
+func ReleaseKillSwitchHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	client, err := MongoDBOrderBookCollectionFunc()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	state, err := ReleaseKillSwitch(ctx, client, principal.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAdminResult(w, state)
+}
@@ -0,0 +1,72 @@
+package orderbook
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	queueDepthDesc = prometheus.NewDesc("mfus_queue_depth",
+		"Messages waiting in the order and trade queues of a symbol, and the results each pipeline stage has not handled.",
+		[]string{"symbol", "queue"}, nil)
+	wsSubscribersDesc = prometheus.NewDesc("mfus_websocket_subscribers",
+		"WebSocket clients subscribed to a channel of a symbol.",
+		[]string{"symbol", "channel"}, nil)
+	wsConnectionsDesc = prometheus.NewDesc("mfus_websocket_connections",
+		"Open WebSocket connections.",
+		nil, nil)
+)
+
+// collector reports the state of the managers and the WebSocket feed when scraped
+type collector struct{}
+
+// Collector returns the metrics computed from the engine state at scrape time
+func Collector() prometheus.Collector {
+	return collector{}
+}
+
+func (collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+	ch <- wsSubscribersDesc
+	ch <- wsConnectionsDesc
+}
+
+func (collector) Collect(ch chan<- prometheus.Metric) {
+	for _, manager := range OrderManagers() {
+		orders := 0
+		e := manager.engine.Load()
+		if e != nil {
+			orders = e.commands.Len()
+		}
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(orders), manager.Symbol, "orders")
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(len(manager.tradeChan)), manager.Symbol, "trades")
+		if e == nil {
+			continue
+		}
+		for _, consumer := range e.consumers {
+			ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(consumer.Lag()), manager.Symbol, consumer.Name)
+		}
+	}
+
+	wsMutex.RLock()
+	connections := len(wsClients)
+	subscribers := make(map[string]int)
+	for client := range wsClients {
+		client.mutex.Lock()
+		for topic := range client.topics {
+			subscribers[topic]++
+		}
+		client.mutex.Unlock()
+	}
+	wsMutex.RUnlock()
+
+	ch <- prometheus.MustNewConstMetric(wsConnectionsDesc, prometheus.GaugeValue, float64(connections))
+	for topic, count := range subscribers {
+		channel, symbol, ok := strings.Cut(topic, ":")
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(wsSubscribersDesc, prometheus.GaugeValue, float64(count), symbol, channel)
+	}
+}
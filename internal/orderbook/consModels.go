@@ -1,5 +1,14 @@
 package orderbook
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
 type OrderType int
 
 const (
@@ -14,6 +23,8 @@ const (
 	Sell
 )
 
+// OrderStatus is the lifecycle state of an order. It is stored and
+// serialised by its canonical name.
 type OrderStatus int
 
 const (
@@ -21,4 +32,122 @@ const (
 	Partial
 	Cancelled
 	Filled
+	Expired
+	Rejected
 )
+
+var orderStatusNames = map[OrderStatus]string{
+	Open:      "open",
+	Partial:   "partial",
+	Cancelled: "cancelled",
+	Filled:    "filled",
+	Expired:   "expired",
+	Rejected:  "rejected",
+}
+
+// orderTransitions lists the states each state can move to. Filled,
+// cancelled, expired and rejected orders are final.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	Open:    {Partial, Filled, Cancelled, Expired},
+	Partial: {Partial, Filled, Cancelled, Expired},
+}
+
+// ErrIllegalTransition is returned when an order cannot move to the requested state
+var ErrIllegalTransition = errors.New("illegal order status transition")
+
+// String returns the canonical name of the status
+func (s OrderStatus) String() string {
+	if name, ok := orderStatusNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// IsFinal reports whether no further transition is possible
+func (s OrderStatus) IsFinal() bool {
+	return len(orderTransitions[s]) == 0
+}
+
+// CanTransition reports whether the transition table allows moving to a state
+func (s OrderStatus) CanTransition(to OrderStatus) bool {
+	for _, allowed := range orderTransitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition validates a transition and returns a descriptive error when it is illegal
+func (s OrderStatus) Transition(to OrderStatus) error {
+	if !s.CanTransition(to) {
+		return fmt.Errorf("%w: cannot move order from %s to %s", ErrIllegalTransition, s, to)
+	}
+	return nil
+}
+
+// statusesInto returns the states that are allowed to move to a state
+func statusesInto(to OrderStatus) []OrderStatus {
+	result := make([]OrderStatus, 0)
+	for from := range orderTransitions {
+		if from.CanTransition(to) {
+			result = append(result, from)
+		}
+	}
+	return result
+}
+
+// ParseOrderStatus reads a status name, including the legacy spellings found
+// in older documents such as "Open" and "partially_filled"
+func ParseOrderStatus(name string) (OrderStatus, error) {
+	switch strings.ToLower(name) {
+	case "open", "new", "":
+		return Open, nil
+	case "partial", "partially_filled", "partiallyfilled":
+		return Partial, nil
+	case "cancelled", "canceled":
+		return Cancelled, nil
+	case "filled":
+		return Filled, nil
+	case "expired":
+		return Expired, nil
+	case "rejected":
+		return Rejected, nil
+	}
+	return Open, errors.New("unknown order status " + name)
+}
+
+// MarshalText encodes the status by name for JSON
+func (s OrderStatus) MarshalText() ([]byte, error) {
+	if _, ok := orderStatusNames[s]; !ok {
+		return nil, fmt.Errorf("unknown order status %d", int(s))
+	}
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a status name for JSON
+func (s *OrderStatus) UnmarshalText(text []byte) error {
+	parsed, err := ParseOrderStatus(string(text))
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
+// MarshalBSONValue stores the status by name
+func (s OrderStatus) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if _, ok := orderStatusNames[s]; !ok {
+		return 0, nil, fmt.Errorf("unknown order status %d", int(s))
+	}
+	return bson.MarshalValue(s.String())
+}
+
+// UnmarshalBSONValue reads a stored status name, accepting legacy spellings
+func (s *OrderStatus) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	name, ok := bson.RawValue{Type: t, Value: data}.StringValueOK()
+	if !ok {
+		return fmt.Errorf("cannot decode order status from %s", t)
+	}
+	return s.UnmarshalText([]byte(name))
+}
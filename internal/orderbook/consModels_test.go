@@ -0,0 +1,201 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"mfus_OMV1/internal/config"
+	"mfus_OMV1/pkg/database"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// testMongo points the package at a scratch database, which is dropped when
+// the test ends. The test is skipped without a MongoDB, set MFUS_MONGO_URI to
+// run it against one.
+func testMongo(t *testing.T) *mongo.Client {
+	t.Helper()
+	cfg := config.Default()
+	if uri := os.Getenv(config.EnvPrefix + "_MONGO_URI"); uri != "" {
+		cfg.Mongo.URI = uri
+	}
+	cfg.Mongo.Database = "mfus_orderbook_test"
+	database.Configure(cfg)
+	Configure(cfg)
+
+	client, err := database.GetMongoClient()
+	if err != nil {
+		t.Skipf("MongoDB unavailable: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Skipf("MongoDB unavailable: %v", err)
+	}
+	t.Cleanup(func() {
+		client.Database(cfg.Mongo.Database).Drop(context.Background())
+	})
+	return client
+}
+
+func TestTransition(t *testing.T) {
+	statuses := []OrderStatus{Open, Partial, Cancelled, Filled, Expired, Rejected}
+	legal := map[OrderStatus]map[OrderStatus]bool{
+		Open:    {Partial: true, Filled: true, Cancelled: true, Expired: true},
+		Partial: {Partial: true, Filled: true, Cancelled: true, Expired: true},
+	}
+	for _, from := range statuses {
+		for _, to := range statuses {
+			err := from.Transition(to)
+			if legal[from][to] != (err == nil) {
+				t.Errorf("%s to %s: got %v, want legal %v", from, to, err, legal[from][to])
+			}
+			if err != nil && !errors.Is(err, ErrIllegalTransition) {
+				t.Errorf("%s to %s: error %v is not ErrIllegalTransition", from, to, err)
+			}
+		}
+		if final := len(legal[from]) == 0; from.IsFinal() != final {
+			t.Errorf("%s: final %v, want %v", from, from.IsFinal(), final)
+		}
+	}
+}
+
+func TestStatusesInto(t *testing.T) {
+	tests := []struct {
+		to   OrderStatus
+		from []OrderStatus
+	}{
+		{Open, nil},
+		{Partial, []OrderStatus{Open, Partial}},
+		{Cancelled, []OrderStatus{Open, Partial}},
+		{Rejected, nil},
+	}
+	for _, test := range tests {
+		got := make(map[OrderStatus]bool)
+		for _, from := range statusesInto(test.to) {
+			got[from] = true
+		}
+		if len(got) != len(test.from) {
+			t.Errorf("statusesInto(%s) = %v, want %v", test.to, statusesInto(test.to), test.from)
+			continue
+		}
+		for _, from := range test.from {
+			if !got[from] {
+				t.Errorf("statusesInto(%s) = %v, want %v", test.to, statusesInto(test.to), test.from)
+			}
+		}
+	}
+}
+
+func TestParseOrderStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status OrderStatus
+		valid  bool
+	}{
+		{"open", Open, true},
+		{"Open", Open, true},
+		{"new", Open, true},
+		{"", Open, true},
+		{"partial", Partial, true},
+		{"partially_filled", Partial, true},
+		{"PartiallyFilled", Partial, true},
+		{"canceled", Cancelled, true},
+		{"Cancelled", Cancelled, true},
+		{"filled", Filled, true},
+		{"expired", Expired, true},
+		{"rejected", Rejected, true},
+		{"pending", Open, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, err := ParseOrderStatus(test.name)
+			if (err == nil) != test.valid || (test.valid && status != test.status) {
+				t.Fatalf("ParseOrderStatus(%q) = %s, %v", test.name, status, err)
+			}
+		})
+	}
+}
+
+func TestOrderStatusBSON(t *testing.T) {
+	tests := []struct {
+		stored string
+		status OrderStatus
+	}{
+		{"open", Open},
+		{"Open", Open},
+		{"partially_filled", Partial},
+		{"canceled", Cancelled},
+	}
+	for _, test := range tests {
+		raw, err := bson.Marshal(bson.M{"status": test.stored})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded struct {
+			Status OrderStatus `bson:"status"`
+		}
+		if err := bson.Unmarshal(raw, &decoded); err != nil || decoded.Status != test.status {
+			t.Errorf("decoding %q: %s, %v", test.stored, decoded.Status, err)
+			continue
+		}
+		raw, err = bson.Marshal(decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name := bson.Raw(raw).Lookup("status").StringValue(); name != test.status.String() {
+			t.Errorf("%q stored back as %q, want %q", test.stored, name, test.status.String())
+		}
+	}
+	if _, err := bson.Marshal(struct{ Status OrderStatus }{OrderStatus(42)}); err == nil {
+		t.Error("unknown status stored")
+	}
+}
+
+func TestMigrateOrderStatuses(t *testing.T) {
+	client := testMongo(t)
+	ctx := context.Background()
+	orders := client.Database(dbName).Collection(ordersCollection)
+	changes := client.Database(dbName).Collection(stateChangesCollection)
+	tests := []struct {
+		stored   string
+		migrated string
+	}{
+		{"Open", "open"},
+		{"partially_filled", "partial"},
+		{"canceled", "cancelled"},
+		{"filled", "filled"},
+		{"pending", "pending"},
+	}
+	for _, test := range tests {
+		if _, err := orders.InsertOne(ctx, bson.M{"status": test.stored, "legacy": test.stored}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := changes.InsertOne(ctx, bson.M{"from_state": test.stored, "to_state": test.stored, "legacy": test.stored}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A second run finds nothing left to migrate
+	for run := 0; run < 2; run++ {
+		if err := MigrateOrderStatuses(ctx, client); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+	}
+	for _, test := range tests {
+		var order, change bson.M
+		if err := orders.FindOne(ctx, bson.M{"legacy": test.stored}).Decode(&order); err != nil {
+			t.Fatal(err)
+		}
+		if err := changes.FindOne(ctx, bson.M{"legacy": test.stored}).Decode(&change); err != nil {
+			t.Fatal(err)
+		}
+		if order["status"] != test.migrated || change["from_state"] != test.migrated || change["to_state"] != test.migrated {
+			t.Errorf("%q migrated to %v, %v and %v, want %q",
+				test.stored, order["status"], change["from_state"], change["to_state"], test.migrated)
+		}
+	}
+}
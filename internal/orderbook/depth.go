@@ -0,0 +1,273 @@
+package orderbook
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"mfus_OMV1/internal/metrics"
+	"mfus_OMV1/pkg/database"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Depth limits of the book endpoints and feed
+const (
+	DefaultDepthLevels = 50
+	MaxDepthLevels     = 1000
+)
+
+// DepthLevel is one aggregated price level of an L2 book
+type DepthLevel struct {
+	Price    float64 `json:"price"`
+	Quantity int64   `json:"quantity"`
+	Count    int     `json:"count"`
+}
+
+// DepthModel is an aggregated L2 view of a book
+type DepthModel struct {
+	Symbol    string       `json:"symbol"`
+	Sequence  uint64       `json:"sequence"`
+	Bids      []DepthLevel `json:"bids"`
+	Asks      []DepthLevel `json:"asks"`
+	Timestamp int64        `json:"timestamp"`
+}
+
+// BookOrder is one resting order of an L3 book, identified by an opaque ID
+// that cannot be linked back to the order or its owner
+type BookOrder struct {
+	ID        string  `json:"id"`
+	Price     float64 `json:"price"`
+	Quantity  int64   `json:"quantity"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// BookModel is an L3 view of a book with orders in queue order
+type BookModel struct {
+	Symbol    string      `json:"symbol"`
+	Sequence  uint64      `json:"sequence"`
+	Bids      []BookOrder `json:"bids"`
+	Asks      []BookOrder `json:"asks"`
+	Timestamp int64       `json:"timestamp"`
+}
+
+// updateBook replaces the in-memory book with the resting orders left after a
+// matching pass. The sequence only advances, and the feed is only published,
+// when the book actually changed.
+func (m *OrderManagerModel) updateBook(buyOrders []OrderModel, sellOrders []OrderModel) {
+	if view, ok := m.replaceBook(toList(buyOrders), toList(sellOrders)); ok {
+		m.publishBook(view)
+	}
+}
+
+// replaceBook stores a new view of the book unless it equals the current one.
+// Only the goroutine matching the symbol calls it.
+func (m *OrderManagerModel) replaceBook(bids *list.List, asks *list.List) (*OrderBookModel, bool) {
+	current := m.currentBook()
+	if sameBook(current.Bids, bids) && sameBook(current.Asks, asks) {
+		return current, false
+	}
+	view := &OrderBookModel{Bids: bids, Asks: asks, Sequence: current.Sequence + 1, updated: time.Now()}
+	m.book.Store(view)
+	return view, true
+}
+
+// currentBook returns the latest view of the book
+func (m *OrderManagerModel) currentBook() *OrderBookModel {
+	if view := m.book.Load(); view != nil {
+		return view
+	}
+	return &OrderBookModel{Bids: list.New(), Asks: list.New()}
+}
+
+// publishBook sends a new view of the book to the metrics, the feed and the
+// book listeners
+func (m *OrderManagerModel) publishBook(view *OrderBookModel) {
+	observeSide(m.Symbol, "bid", view.Bids)
+	observeSide(m.Symbol, "ask", view.Asks)
+
+	depth := view.depth(m.Symbol, DefaultDepthLevels)
+	Publish("book", m.Symbol, depth)
+
+	listenersMutex.RLock()
+	listeners := bookListeners
+	listenersMutex.RUnlock()
+	for _, listener := range listeners {
+		listener(depth)
+	}
+}
+
+// Depth returns the aggregated L2 book up to a number of levels per side
+func (m *OrderManagerModel) Depth(levels int) DepthModel {
+	return m.currentBook().depth(m.Symbol, levels)
+}
+
+func (b *OrderBookModel) depth(symbol string, levels int) DepthModel {
+	return DepthModel{
+		Symbol:    symbol,
+		Sequence:  b.Sequence,
+		Bids:      aggregate(b.Bids, levels),
+		Asks:      aggregate(b.Asks, levels),
+		Timestamp: b.updated.UnixNano() / int64(time.Millisecond),
+	}
+}
+
+// Book returns the L3 book up to a number of orders per side
+func (m *OrderManagerModel) Book(orders int) BookModel {
+	view := m.currentBook()
+	return BookModel{
+		Symbol:    m.Symbol,
+		Sequence:  view.Sequence,
+		Bids:      anonymiseOrders(view.Bids, orders),
+		Asks:      anonymiseOrders(view.Asks, orders),
+		Timestamp: view.updated.UnixNano() / int64(time.Millisecond),
+	}
+}
+
+// BestBidAsk returns the top of the book, zero when a side is empty
+func (m *OrderManagerModel) BestBidAsk() (float64, float64) {
+	view := m.currentBook()
+	var bid, ask float64
+	if front := view.Bids.Front(); front != nil {
+		bid = front.Value.(*OrderModel).Price
+	}
+	if front := view.Asks.Front(); front != nil {
+		ask = front.Value.(*OrderModel).Price
+	}
+	return bid, ask
+}
+
+// observeSide publishes the size of one side of the book to the metrics
+func observeSide(symbol string, side string, orders *list.List) {
+	var quantity int64
+	levels := 0
+	var last float64
+	for e := orders.Front(); e != nil; e = e.Next() {
+		order := e.Value.(*OrderModel)
+		quantity += order.Quantity - order.FilledQty
+		if levels == 0 || order.Price != last {
+			levels++
+			last = order.Price
+		}
+	}
+	metrics.BookQuantity.WithLabelValues(symbol, side).Set(float64(quantity))
+	metrics.BookOrders.WithLabelValues(symbol, side).Set(float64(orders.Len()))
+	metrics.BookLevels.WithLabelValues(symbol, side).Set(float64(levels))
+}
+
+func toList(orders []OrderModel) *list.List {
+	l := list.New()
+	for i := range orders {
+		order := orders[i]
+		l.PushBack(&order)
+	}
+	return l
+}
+
+func sameBook(a *list.List, b *list.List) bool {
+	if a.Len() != b.Len() {
+		return false
+	}
+	for x, y := a.Front(), b.Front(); x != nil; x, y = x.Next(), y.Next() {
+		left, right := x.Value.(*OrderModel), y.Value.(*OrderModel)
+		if left.ID != right.ID || left.Price != right.Price || left.Quantity-left.FilledQty != right.Quantity-right.FilledQty {
+			return false
+		}
+	}
+	return true
+}
+
+func aggregate(side *list.List, levels int) []DepthLevel {
+	result := make([]DepthLevel, 0)
+	for e := side.Front(); e != nil; e = e.Next() {
+		order := e.Value.(*OrderModel)
+		remaining := order.Quantity - order.FilledQty
+		if n := len(result); n > 0 && result[n-1].Price == order.Price {
+			result[n-1].Quantity += remaining
+			result[n-1].Count++
+			continue
+		}
+		if len(result) == levels {
+			break
+		}
+		result = append(result, DepthLevel{Price: order.Price, Quantity: remaining, Count: 1})
+	}
+	return result
+}
+
+func anonymiseOrders(side *list.List, orders int) []BookOrder {
+	result := make([]BookOrder, 0)
+	for e := side.Front(); e != nil && len(result) < orders; e = e.Next() {
+		order := e.Value.(*OrderModel)
+		result = append(result, BookOrder{
+			ID:        bookOrderID(order),
+			Price:     order.Price,
+			Quantity:  order.Quantity - order.FilledQty,
+			Timestamp: order.CreationTime,
+		})
+	}
+	return result
+}
+
+// bookOrderID derives a stable opaque ID so clients can follow an order's
+// queue position without learning its real ID
+func bookOrderID(order *OrderModel) string {
+	sum := sha256.Sum256([]byte("book:" + order.ID.Hex()))
+	return hex.EncodeToString(sum[:8])
+}
+
+func GetBookHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	depth := DefaultDepthLevels
+	if v := r.URL.Query().Get("depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "depth must be a positive number", http.StatusBadRequest)
+			return
+		}
+		if n > MaxDepthLevels {
+			n = MaxDepthLevels
+		}
+		depth = n
+	}
+
+	level := r.URL.Query().Get("level")
+	if level != "" && level != "2" && level != "3" {
+		http.Error(w, "level must be 2 or 3", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	manager, err := LookupOrderManager(ctx, client, params["symbol"])
+	if errors.Is(err, ErrUnknownSymbol) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var book interface{}
+	if level == "3" {
+		book = manager.Book(depth)
+	} else {
+		book = manager.Depth(depth)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(book)
+}
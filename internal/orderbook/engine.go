@@ -0,0 +1,548 @@
+package orderbook
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"mfus_OMV1/internal/health"
+	"mfus_OMV1/internal/metrics"
+	"mfus_OMV1/internal/pipeline"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Each symbol has an engine: one goroutine owns the book and matches every
+// order as it arrives. Order entry hands it commands through a bounded queue,
+// and it publishes trades and book views to a ring read by the journal, which
+// writes and settles each trade, then by the risk stage, which feeds the trade
+// listeners such as positions, and the market data stage. A full ring stops
+// the engine and a full queue refuses new orders, so a slow stage pushes back
+// on order entry. The matching loop keeps running and reconciles each book
+// with MongoDB, the source of truth, every interval. Turning matcher.pipeline
+// off goes back to matching in the loop.
+
+// ErrOverloaded is returned when the matcher of a symbol has more orders
+// queued than it holds. It wraps health.ErrNotReady so that clients retry it.
+var ErrOverloaded = fmt.Errorf("%w: matcher of the symbol is overloaded", health.ErrNotReady)
+
+// maxBatch bounds the commands applied before the book view is published
+const maxBatch = 256
+
+// Engine commands
+const (
+	commandPlace = iota
+	commandAmend
+	commandCancel
+	commandSync
+)
+
+// command is one change to the book of an engine
+type command struct {
+	kind  int
+	order OrderModel
+	sync  *bookSync
+	// received is when order entry queued the command
+	received time.Time
+}
+
+// bookSync reconciles the book of an engine with the open orders read from
+// MongoDB and tells it whether to match
+type bookSync struct {
+	orders []OrderModel
+	active bool
+	token  int64
+	// mark is how far the engine and its journal were before the read
+	mark engineMark
+}
+
+// engineMark counts the commands an engine applied and the results its
+// journal was done with
+type engineMark struct {
+	applied   uint64
+	journaled uint64
+}
+
+// result is a trade with the orders it filled, or a new view of the book
+type result struct {
+	trade    TradeHistoryModel
+	buy      OrderModel
+	sell     OrderModel
+	buyFrom  string
+	sellFrom string
+	book     *OrderBookModel
+	received time.Time
+	// persisted is set by the journal once the trade is written, the stages
+	// that follow it ignore the trades that were not
+	persisted bool
+}
+
+// stage handles the results of an engine on its own goroutine
+type stage struct {
+	name   string
+	handle func(r *result)
+}
+
+// bookEntry is an order resting on the book of an engine
+type bookEntry struct {
+	order OrderModel
+	// changed is the command that last placed or amended the order, 0 when a
+	// sync added it
+	changed uint64
+	// filled is the result that last filled the order
+	filled uint64
+}
+
+// goneEntry is an order that left the book, by the result that filled it or
+// the command that cancelled it
+type goneEntry struct {
+	filled    uint64
+	cancelled uint64
+}
+
+// newer reports whether the engine changed an order after a mark
+func (g goneEntry) newer(mark engineMark) bool {
+	return g.filled > mark.journaled || g.cancelled > mark.applied
+}
+
+func (b *bookEntry) newer(mark engineMark) bool {
+	return b.filled > mark.journaled || b.changed > mark.applied
+}
+
+type engine struct {
+	manager   *OrderManagerModel
+	commands  *pipeline.Queue[command]
+	results   *pipeline.Ring[*result]
+	journal   *pipeline.Consumer[*result]
+	consumers []*pipeline.Consumer[*result]
+	applied   atomic.Uint64
+	// stalled is set by the journal when it cannot write a trade, the engine
+	// stops matching until a sync shows what was written
+	stalled atomic.Bool
+	done    chan struct{}
+
+	// The fields below belong to the engine goroutine
+	bids      []*bookEntry
+	asks      []*bookEntry
+	entries   map[primitive.ObjectID]*bookEntry
+	gone      map[primitive.ObjectID]goneEntry
+	published uint64
+	pausedAt  uint64
+	active    bool
+	token     int64
+	changed   bool
+}
+
+// newEngine creates the engine of a manager. The journal sees every result
+// first, the other stages once the journal is done with it.
+func newEngine(m *OrderManagerModel, commands int, results int, journal stage, stages ...stage) *engine {
+	e := &engine{
+		manager:  m,
+		commands: pipeline.NewQueue[command](commands),
+		results:  pipeline.NewRing[*result](results),
+		done:     make(chan struct{}),
+		entries:  make(map[primitive.ObjectID]*bookEntry),
+		gone:     make(map[primitive.ObjectID]goneEntry),
+	}
+	e.journal = e.results.Subscribe(journal.name)
+	e.consumers = append(e.consumers, e.journal)
+	handlers := []stage{journal}
+	for _, s := range stages {
+		e.consumers = append(e.consumers, e.results.Subscribe(s.name, e.journal))
+		handlers = append(handlers, s)
+	}
+	for i, consumer := range e.consumers {
+		go consumer.Run(context.Background(), e.observe(handlers[i]))
+	}
+	return e
+}
+
+// observe records the pipeline latency of each trade a stage handles
+func (e *engine) observe(s stage) func(r *result) {
+	histogram := metrics.PipelineLatency.WithLabelValues(e.manager.Symbol, s.name)
+	return func(r *result) {
+		s.handle(r)
+		if r.book == nil {
+			histogram.Observe(time.Since(r.received).Seconds())
+		}
+	}
+}
+
+// run applies the commands until the queue is closed and drained, then waits
+// for the stages to handle every result
+func (e *engine) run() {
+	defer close(e.done)
+	ctx := context.Background()
+	for {
+		cmd, err := e.commands.Pop(ctx)
+		if err != nil {
+			break
+		}
+		e.apply(cmd)
+		for i := 1; i < maxBatch; i++ {
+			cmd, ok := e.commands.TryPop()
+			if !ok {
+				break
+			}
+			e.apply(cmd)
+		}
+		e.publishBook()
+	}
+	e.results.Close()
+	for _, consumer := range e.consumers {
+		<-consumer.Done()
+	}
+}
+
+// stop refuses new commands and waits until the queued ones are applied and
+// every result is handled, or until the context ends
+func (e *engine) stop(ctx context.Context) error {
+	e.commands.Close()
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d commands not applied: %w", e.commands.Len(), ctx.Err())
+	}
+}
+
+// mark returns how far the engine and its journal are, it is taken before
+// the orders of a sync are read
+func (e *engine) mark() engineMark {
+	return engineMark{applied: e.applied.Load(), journaled: e.journal.Position()}
+}
+
+func (e *engine) apply(cmd command) {
+	index := e.applied.Load() + 1
+	switch cmd.kind {
+	case commandPlace:
+		if _, ok := e.entries[cmd.order.ID]; !ok {
+			e.insert(&bookEntry{order: cmd.order, changed: index})
+		}
+	case commandAmend:
+		if entry, ok := e.entries[cmd.order.ID]; ok {
+			e.remove(entry)
+			entry.order = amendedEntry(entry.order, cmd.order)
+			entry.changed = index
+			e.insert(entry)
+		}
+	case commandCancel:
+		if entry, ok := e.entries[cmd.order.ID]; ok {
+			e.remove(entry)
+		}
+		gone := e.gone[cmd.order.ID]
+		gone.cancelled = index
+		e.gone[cmd.order.ID] = gone
+	case commandSync:
+		e.reconcile(cmd.sync)
+	}
+	e.applied.Add(1)
+	e.match(cmd.received)
+}
+
+// amendedEntry applies an amendment to an order on the book, the fills of the
+// book are kept as they may not be written yet
+func amendedEntry(resting OrderModel, amended OrderModel) OrderModel {
+	amended.Status = resting.Status
+	amended.FilledQty = resting.FilledQty
+	amended.FilledVolume = resting.FilledVolume
+	amended.FilledAverage = resting.FilledAverage
+	amended.FilledOrders = resting.FilledOrders
+	amended.FilledOrder = resting.FilledOrder
+	amended.RemainingQty = amended.Quantity - amended.FilledQty
+	return amended
+}
+
+// reconcile replaces the book with the open orders read from MongoDB. What
+// the engine changed after the mark of the read, a fill the journal had not
+// written or a command order entry sent after its write, is kept as the
+// engine has it.
+func (e *engine) reconcile(s *bookSync) {
+	read := make(map[primitive.ObjectID]OrderModel, len(s.orders))
+	for _, order := range s.orders {
+		read[order.ID] = order
+	}
+	book := make(map[primitive.ObjectID]*bookEntry, len(read))
+	for id, entry := range e.entries {
+		order, ok := read[id]
+		switch {
+		case entry.newer(s.mark):
+			book[id] = entry
+		case ok:
+			entry.order = order
+			book[id] = entry
+		}
+	}
+	for id, order := range read {
+		if _, ok := book[id]; ok {
+			continue
+		}
+		if gone, ok := e.gone[id]; ok && gone.newer(s.mark) {
+			continue
+		}
+		book[id] = &bookEntry{order: order}
+	}
+	for id, gone := range e.gone {
+		if !gone.newer(s.mark) {
+			delete(e.gone, id)
+		}
+	}
+
+	e.entries = book
+	e.bids, e.asks = e.bids[:0], e.asks[:0]
+	for _, entry := range book {
+		if entry.order.Side == "Buy" {
+			e.bids = append(e.bids, entry)
+		} else {
+			e.asks = append(e.asks, entry)
+		}
+	}
+	sort.Slice(e.bids, func(i, j int) bool { return entryBefore(e.bids[i], e.bids[j]) })
+	sort.Slice(e.asks, func(i, j int) bool { return entryBefore(e.asks[i], e.asks[j]) })
+
+	e.active, e.token = s.active, s.token
+	if e.stalled.Load() {
+		e.pause()
+		if s.mark.journaled >= e.pausedAt {
+			e.pausedAt = 0
+			e.stalled.Store(false)
+		}
+	}
+	e.changed = true
+}
+
+// entryBefore orders the entries of one side, the earlier order wins ties
+// within a millisecond
+func entryBefore(a *bookEntry, b *bookEntry) bool {
+	before := sellBefore
+	if a.order.Side == "Buy" {
+		before = buyBefore
+	}
+	if before(&a.order, &b.order) {
+		return true
+	}
+	if before(&b.order, &a.order) {
+		return false
+	}
+	return a.order.ID.Hex() < b.order.ID.Hex()
+}
+
+func (e *engine) side(entry *bookEntry) *[]*bookEntry {
+	if entry.order.Side == "Buy" {
+		return &e.bids
+	}
+	return &e.asks
+}
+
+// insert adds an order behind the orders of its level
+func (e *engine) insert(entry *bookEntry) {
+	side := e.side(entry)
+	i := sort.Search(len(*side), func(i int) bool { return entryBefore(entry, (*side)[i]) })
+	*side = append(*side, nil)
+	copy((*side)[i+1:], (*side)[i:])
+	(*side)[i] = entry
+	e.entries[entry.order.ID] = entry
+	e.changed = true
+}
+
+func (e *engine) remove(entry *bookEntry) {
+	side := e.side(entry)
+	i := sort.Search(len(*side), func(i int) bool { return !entryBefore((*side)[i], entry) })
+	for ; i < len(*side); i++ {
+		if (*side)[i] == entry {
+			*side = append((*side)[:i], (*side)[i+1:]...)
+			break
+		}
+	}
+	delete(e.entries, entry.order.ID)
+	e.changed = true
+}
+
+// pause stops matching until the journal has handled every result so far
+func (e *engine) pause() {
+	if e.pausedAt == 0 {
+		e.pausedAt = e.published
+	}
+}
+
+// match executes the trades of the book while the best orders cross
+func (e *engine) match(received time.Time) {
+	for e.active && len(e.bids) > 0 && len(e.asks) > 0 {
+		if e.stalled.Load() {
+			e.pause()
+			return
+		}
+		buy, sell := e.bids[0], e.asks[0]
+		if buy.order.Price < sell.order.Price {
+			return
+		}
+		trade := newTrade(&buy.order, &sell.order, e.token)
+		r := &result{trade: trade, received: received, buyFrom: buy.order.Status.String(), sellFrom: sell.order.Status.String()}
+		if err := fillOrders(&buy.order, &sell.order, trade); err != nil {
+			// The order left the book without the engine knowing, the next
+			// sync removes it
+			matcherLog.Error("invalid fill", "trade", trade.Id, "error", err)
+			e.stalled.Store(true)
+			e.pause()
+			return
+		}
+		r.buy, r.sell = copyOrder(buy.order), copyOrder(sell.order)
+		e.publish(r)
+
+		for _, entry := range []*bookEntry{buy, sell} {
+			entry.filled = e.published
+			if entry.order.FilledQty == entry.order.Quantity {
+				e.remove(entry)
+				e.gone[entry.order.ID] = goneEntry{filled: e.published}
+			}
+		}
+		e.changed = true
+	}
+}
+
+// copyOrder copies an order so that the engine can fill it again while the
+// stages read the copy
+func copyOrder(order OrderModel) OrderModel {
+	order.FilledOrders = append([]string(nil), order.FilledOrders...)
+	return order
+}
+
+// publish hands a result to the stages, waiting while the slowest is a full
+// ring behind
+func (e *engine) publish(r *result) {
+	if err := e.results.Publish(context.Background(), r); err != nil {
+		matcherLog.Error("cannot publish result", "symbol", e.manager.Symbol, "error", err)
+		return
+	}
+	e.published++
+}
+
+// publishBook publishes a new view of the book once a batch changed it
+func (e *engine) publishBook() {
+	if !e.changed {
+		return
+	}
+	e.changed = false
+	bids, asks := list.New(), list.New()
+	for _, entry := range e.bids {
+		order := entry.order
+		bids.PushBack(&order)
+	}
+	for _, entry := range e.asks {
+		order := entry.order
+		asks.PushBack(&order)
+	}
+	if view, ok := e.manager.replaceBook(bids, asks); ok {
+		e.publish(&result{book: view})
+	}
+}
+
+// startEngine creates and starts the engine of the manager unless it runs
+func (m *OrderManagerModel) startEngine(mongoClient *mongo.Client, redisClient *redis.Client) *engine {
+	if e := m.engine.Load(); e != nil {
+		return e
+	}
+	var e *engine
+	e = newEngine(m, settings.Matcher.CommandBuffer, settings.Matcher.ResultBuffer,
+		stage{"journal", func(r *result) { e.journalTrade(r, mongoClient, redisClient) }},
+		stage{"risk", func(r *result) { e.dispatchTrade(r) }},
+		stage{"marketdata", func(r *result) { e.publishResult(r) }},
+	)
+	m.engine.Store(e)
+	go e.run()
+	return e
+}
+
+// journalTrade writes and settles a trade and the orders it filled, while the
+// engine's fencing token is the latest of the symbol
+func (e *engine) journalTrade(r *result, mongoClient *mongo.Client, redisClient *redis.Client) {
+	if r.book != nil || e.stalled.Load() {
+		return
+	}
+	ctx := context.Background()
+	// A trade whose fees cannot be computed or that cannot be written, e.g.
+	// as it fills an order cancelled before the engine heard of it or the
+	// engine was fenced off, is dropped and stops the engine until a sync
+	if err := applyFees(ctx, mongoClient, &r.trade); err != nil {
+		dropTrade(r.trade, fmt.Errorf("computing fees: %w", err))
+		e.stalled.Store(true)
+		return
+	}
+	if err := writeTrade(ctx, mongoClient, r.trade, r.buy, r.sell); err != nil {
+		dropTrade(r.trade, err)
+		e.stalled.Store(true)
+		return
+	}
+	for _, filled := range []struct {
+		order OrderModel
+		from  string
+	}{{r.buy, r.buyFrom}, {r.sell, r.sellFrom}} {
+		logStateChange(orderContext(filled.order), mongoClient, filled.order.ID, filled.from, filled.order.Status, "trade "+r.trade.Id)
+	}
+	if err := pushTrade(redisClient, r.trade); err != nil {
+		matcherLog.Error("cannot write trade to Redis", "trade", r.trade.Id, "error", err)
+	}
+	r.persisted = true
+}
+
+// dispatchTrade hands a written trade to the trade listeners, which keep
+// positions, candles and the ticker
+func (e *engine) dispatchTrade(r *result) {
+	if r.book == nil && r.persisted {
+		e.manager.dispatch(&r.trade)
+	}
+}
+
+// publishResult feeds a written trade, the orders it filled and the views of
+// the book to the WebSocket feed and the book listeners
+func (e *engine) publishResult(r *result) {
+	m := e.manager
+	if r.book != nil {
+		m.publishBook(r.book)
+		return
+	}
+	if !r.persisted {
+		return
+	}
+	m.recordTrade(r.trade)
+	observeTrade(r.trade)
+	logTrade(r.trade)
+	publishOrder(EventFilled, r.buy)
+	publishOrder(EventFilled, r.sell)
+}
+
+// engineFor returns the engine of a symbol, nil when the symbol has none
+func engineFor(symbol string) *engine {
+	managersMutex.Lock()
+	manager, ok := managers[symbol]
+	managersMutex.Unlock()
+	if !ok {
+		return nil
+	}
+	return manager.engine.Load()
+}
+
+// admitOrder refuses a new order while the engine of its symbol has a full
+// queue, before any funds are reserved for it
+func admitOrder(symbol string) error {
+	if e := engineFor(symbol); e != nil && e.commands.Len() >= e.commands.Cap() {
+		return ErrOverloaded
+	}
+	return nil
+}
+
+// submitOrder hands a change of an order written to MongoDB to the engine of
+// its symbol. A change that cannot be queued is left for the next sync.
+func submitOrder(ctx context.Context, kind int, order OrderModel) {
+	e := engineFor(order.Symbol)
+	if e == nil {
+		return
+	}
+	if err := e.commands.Push(ctx, command{kind: kind, order: order, received: time.Now()}); err != nil {
+		orderLog.WarnContext(ctx, "order left for the next sync of the engine", "order", order.ID.Hex(), "symbol", order.Symbol, "error", err)
+	}
+}
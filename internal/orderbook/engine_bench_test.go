@@ -0,0 +1,292 @@
+package orderbook
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"mfus_OMV1/internal/config"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The benchmarks replay the same order flow against the polling matcher and
+// the pipeline, one order per iteration. Writes to MongoDB are simulated by
+// benchmarkWriteDelay so that they need no database. Every order has the same
+// price and quantity and the sides alternate, so both designs fill every order
+// and execute the same N/2 trades.
+//
+// Each design is run twice: saturated, where ns/op is the cost of an order at
+// full speed, and at benchmarkRate, where the latency from submitting the
+// order that crossed the book to its trade reaching market data is reported.
+// A saturated run only measures its queue backlog, so it reports no latency.
+const (
+	benchmarkSymbol     = "BENCH-USD"
+	benchmarkProducers  = 4
+	benchmarkWriteDelay = 50 * time.Microsecond
+	benchmarkQuantity   = 5
+	// benchmarkRate is the offered load of the latency runs in orders per
+	// second, well below what either design sustains
+	benchmarkRate = 1000
+)
+
+// benchmarkDesign runs a flow through one matcher design and returns the
+// latency of every trade and how many orders were refused at first
+type benchmarkDesign struct {
+	name string
+	run  func(flow *benchmarkFlow) ([]time.Duration, int)
+}
+
+var benchmarkDesigns = []benchmarkDesign{
+	{"polling", func(flow *benchmarkFlow) ([]time.Duration, int) {
+		return benchmarkPolling(config.Default().Matcher.Interval, flow), 0
+	}},
+	{"pipeline", benchmarkPipeline},
+}
+
+func BenchmarkMatcher(b *testing.B) {
+	for _, design := range benchmarkDesigns {
+		design := design
+		b.Run(design.name+"/saturated", func(b *testing.B) {
+			flow := newBenchmarkFlow(b.N, 0)
+			b.ResetTimer()
+			latencies, rejected := design.run(flow)
+			b.StopTimer()
+			b.ReportMetric(float64(len(latencies))/float64(b.N), "trades/op")
+			b.ReportMetric(float64(rejected)/float64(b.N), "rejected/op")
+		})
+		b.Run(fmt.Sprintf("%s/%d-per-s", design.name, benchmarkRate), func(b *testing.B) {
+			flow := newBenchmarkFlow(b.N, benchmarkRate)
+			b.ResetTimer()
+			latencies, rejected := design.run(flow)
+			b.StopTimer()
+			b.ReportMetric(float64(rejected)/float64(b.N), "rejected/op")
+			reportLatencies(b, latencies)
+		})
+	}
+}
+
+// benchmarkFlow is the generated orders, the rate they are offered at, zero
+// for as fast as possible, and when each was submitted
+type benchmarkFlow struct {
+	orders    []OrderModel
+	index     map[primitive.ObjectID]int
+	rate      float64
+	submitted []atomic.Int64
+}
+
+// newBenchmarkFlow generates alternating buy and sell orders that all cross
+func newBenchmarkFlow(n int, rate float64) *benchmarkFlow {
+	flow := &benchmarkFlow{
+		orders:    make([]OrderModel, n),
+		index:     make(map[primitive.ObjectID]int, n),
+		rate:      rate,
+		submitted: make([]atomic.Int64, n),
+	}
+	for i := range flow.orders {
+		side := "Buy"
+		if i%2 == 1 {
+			side = "Sell"
+		}
+		order := OrderModel{
+			ID:           primitive.NewObjectID(),
+			UserID:       fmt.Sprintf("bench-%d", i%100),
+			Symbol:       benchmarkSymbol,
+			Side:         side,
+			Price:        100,
+			Quantity:     benchmarkQuantity,
+			RemainingQty: benchmarkQuantity,
+			Status:       Open,
+		}
+		flow.orders[i] = order
+		flow.index[order.ID] = i
+	}
+	return flow
+}
+
+// submit hands the orders to the producers, which stamp and write each one
+// before passing it on. At a rate, order i is due i/rate after the start.
+func (f *benchmarkFlow) submit(send func(order OrderModel)) {
+	start := time.Now()
+	var wg sync.WaitGroup
+	for p := 0; p < benchmarkProducers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := p; i < len(f.orders); i += benchmarkProducers {
+				if f.rate > 0 {
+					time.Sleep(time.Until(start.Add(time.Duration(float64(i) / f.rate * float64(time.Second)))))
+				}
+				order := f.orders[i]
+				now := time.Now()
+				order.CreationTime = now.UnixMilli()
+				order.UpdateTime = order.CreationTime
+				f.submitted[i].Store(now.UnixNano())
+				simulateWrite()
+				send(order)
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+// latency returns how long ago the later of the two orders of a trade was
+// submitted
+func (f *benchmarkFlow) latency(trade TradeHistoryModel) time.Duration {
+	submitted := int64(0)
+	for _, hex := range []string{trade.BuyOrder, trade.SellOrder} {
+		id, _ := primitive.ObjectIDFromHex(hex)
+		if at := f.submitted[f.index[id]].Load(); at > submitted {
+			submitted = at
+		}
+	}
+	return time.Since(time.Unix(0, submitted))
+}
+
+func simulateWrite() {
+	time.Sleep(benchmarkWriteDelay)
+}
+
+// benchmarkManager creates a manager that is not registered and loads nothing
+func benchmarkManager(symbol string) *OrderManagerModel {
+	manager := &OrderManagerModel{
+		Symbol:    symbol,
+		tradeChan: make(chan *TradeHistoryModel, 1),
+		stopChan:  make(chan struct{}),
+		recorded:  make(map[string]bool),
+	}
+	manager.book.Store(&OrderBookModel{Bids: list.New(), Asks: list.New(), Trades: list.New()})
+	return manager
+}
+
+// benchmarkPolling models the polling matcher: order entry writes orders to a
+// store and a loop reads the open ones every interval, matches them and
+// writes each trade and the orders it filled before the next
+func benchmarkPolling(interval time.Duration, flow *benchmarkFlow) []time.Duration {
+	manager := benchmarkManager(benchmarkSymbol)
+	var mutex sync.Mutex
+	open := make(map[primitive.ObjectID]OrderModel)
+	var latencies []time.Duration
+
+	entered := make(chan struct{})
+	go func() {
+		defer close(entered)
+		flow.submit(func(order OrderModel) {
+			mutex.Lock()
+			open[order.ID] = order
+			mutex.Unlock()
+		})
+	}()
+
+	for finished := false; !finished; {
+		select {
+		case <-entered:
+			finished = true
+		case <-time.After(interval):
+		}
+		for {
+			mutex.Lock()
+			book := &OrderBook{}
+			for _, order := range open {
+				if order.Side == "Buy" {
+					book.BuyOrders = append(book.BuyOrders, order)
+				} else {
+					book.SellOrders = append(book.SellOrders, order)
+				}
+			}
+			mutex.Unlock()
+
+			trades := 0
+			crossBook(book, func(buyOrder *OrderModel, sellOrder *OrderModel) bool {
+				trade := newTrade(buyOrder, sellOrder, 0)
+				if err := fillOrders(buyOrder, sellOrder, trade); err != nil {
+					return false
+				}
+				simulateWrite()
+				mutex.Lock()
+				for _, order := range []*OrderModel{buyOrder, sellOrder} {
+					if order.FilledQty == order.Quantity {
+						delete(open, order.ID)
+					} else {
+						open[order.ID] = *order
+					}
+				}
+				mutex.Unlock()
+				latencies = append(latencies, flow.latency(trade))
+				trades++
+				return true
+			})
+			manager.replaceBook(benchmarkList(book.BuyOrders), benchmarkList(book.SellOrders))
+			// The last pass runs until the book no longer crosses
+			if !finished || trades == 0 {
+				break
+			}
+		}
+	}
+	return latencies
+}
+
+func benchmarkList(orders []OrderModel) *list.List {
+	l := list.New()
+	for i := range orders {
+		l.PushBack(&orders[i])
+	}
+	return l
+}
+
+// benchmarkPipeline runs the engine with stages that simulate the journal
+// writes, order entry waits for a full queue after counting the refusal
+func benchmarkPipeline(flow *benchmarkFlow) ([]time.Duration, int) {
+	manager := benchmarkManager(benchmarkSymbol)
+	defaults := config.Default().Matcher
+	var latencies []time.Duration
+	e := newEngine(manager, defaults.CommandBuffer, defaults.ResultBuffer,
+		stage{"journal", func(r *result) {
+			if r.book == nil {
+				simulateWrite()
+				r.persisted = true
+			}
+		}},
+		stage{"risk", func(r *result) {}},
+		stage{"marketdata", func(r *result) {
+			if r.book == nil && r.persisted {
+				latencies = append(latencies, flow.latency(r.trade))
+			}
+		}},
+	)
+	go e.run()
+	ctx := context.Background()
+	e.commands.Push(ctx, command{kind: commandSync, sync: &bookSync{active: true}})
+
+	var rejected atomic.Int64
+	flow.submit(func(order OrderModel) {
+		cmd := command{kind: commandPlace, order: order, received: time.Now()}
+		if e.commands.TryPush(cmd) {
+			return
+		}
+		rejected.Add(1)
+		e.commands.Push(ctx, cmd)
+	})
+	e.stop(ctx)
+	return latencies, int(rejected.Load())
+}
+
+// reportLatencies reports the trades and their median, 99th percentile and
+// worst latency
+func reportLatencies(b *testing.B, latencies []time.Duration) {
+	b.ReportMetric(float64(len(latencies))/float64(b.N), "trades/op")
+	if len(latencies) == 0 {
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) float64 {
+		return float64(latencies[int(p*float64(len(latencies)-1))].Microseconds())
+	}
+	b.ReportMetric(percentile(0.5), "p50-µs")
+	b.ReportMetric(percentile(0.99), "p99-µs")
+	b.ReportMetric(float64(latencies[len(latencies)-1].Microseconds()), "max-µs")
+}
@@ -0,0 +1,53 @@
+package orderbook
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the matcher and the query APIs rely on.
+// Creating an index that already exists is a no-op, so this runs at every startup.
+func EnsureIndexes(ctx context.Context, mongoClient *mongo.Client) error {
+	indexes := map[string][]mongo.IndexModel{
+		ordersCollection: {
+			// Order query API, per user with the common filters and sorts
+			{Keys: bson.D{{Key: "userID", Value: 1}, {Key: "creationTime", Value: -1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "userID", Value: 1}, {Key: "symbol", Value: 1}, {Key: "status", Value: 1}, {Key: "creationTime", Value: -1}}},
+			{Keys: bson.D{{Key: "symbol", Value: 1}, {Key: "creationTime", Value: -1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "creationTime", Value: -1}}},
+			// Matcher and expiry sweeps
+			{Keys: bson.D{{Key: "side", Value: 1}, {Key: "status", Value: 1}, {Key: "symbol", Value: 1}, {Key: "price", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expiration", Value: 1}}},
+			// Lookup by the ID the client assigned, e.g. the FIX ClOrdID
+			{Keys: bson.D{{Key: "userID", Value: 1}, {Key: "clientOrderID", Value: 1}}},
+		},
+		stateChangesCollection: {
+			{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "seq", Value: 1}}},
+		},
+		tradeCollection: {
+			{Keys: bson.D{{Key: "symbol", Value: 1}, {Key: "executed_at", Value: -1}}},
+			{Keys: bson.D{{Key: "buy_user_id", Value: 1}, {Key: "executed_at", Value: -1}}},
+			{Keys: bson.D{{Key: "sell_user_id", Value: 1}, {Key: "executed_at", Value: -1}}},
+		},
+		instrumentsCollection: {
+			{Keys: bson.D{{Key: "symbol", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		accountControlsCollection: {
+			{Keys: bson.D{{Key: "userID", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		snapshotsCollection: {
+			{Keys: bson.D{{Key: "symbol", Value: 1}, {Key: "taken_at", Value: -1}}},
+		},
+	}
+
+	for collection, models := range indexes {
+		_, err := mongoClient.Database(dbName).Collection(collection).Indexes().CreateMany(ctx, models)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
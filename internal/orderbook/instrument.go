@@ -0,0 +1,222 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mfus_OMV1/internal/accounts"
+	"mfus_OMV1/utils"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var instrumentsCollection = "instruments"
+
+// Instrument statuses
+const (
+	InstrumentTrading = "trading"
+	InstrumentHalted  = "halted"
+)
+
+// ErrSymbolHalted is returned by order entry for a halted symbol
+var ErrSymbolHalted = errors.New("trading is halted for this symbol")
+
+// ErrUnknownSymbol is returned for a symbol that is neither listed nor traded
+// on this instance
+var ErrUnknownSymbol = errors.New("unknown symbol")
+
+// ErrInstrumentExists is returned when an instrument is listed twice
+var ErrInstrumentExists = errors.New("instrument already listed")
+
+// controlsCacheTTL bounds how long other instances keep trading a halted
+// symbol or a suspended user
+const controlsCacheTTL = time.Second
+
+// InstrumentModel is a listed symbol. Symbols that are not listed still trade
+// with the defaults, listing one lets operators bound its order sizes, and a
+// halt lists it implicitly.
+type InstrumentModel struct {
+	ID           primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Symbol       string             `json:"symbol" bson:"symbol"`
+	BaseAsset    string             `json:"baseAsset" bson:"baseAsset"`
+	QuoteAsset   string             `json:"quoteAsset" bson:"quoteAsset"`
+	Status       string             `json:"status" bson:"status"`
+	HaltReason   string             `json:"haltReason,omitempty" bson:"haltReason,omitempty"`
+	MinQuantity  int64              `json:"minQuantity" bson:"minQuantity"`
+	MaxQuantity  int64              `json:"maxQuantity" bson:"maxQuantity"`
+	CreationTime int64              `json:"creationTime" bson:"creationTime"`
+	UpdateTime   int64              `json:"updateTime" bson:"updateTime"`
+}
+
+var instrumentsMutex sync.Mutex
+var instruments map[string]InstrumentModel
+var instrumentsLoaded time.Time
+
+// GetInstruments returns every listed instrument ordered by symbol
+func GetInstruments(ctx context.Context, mongoClient *mongo.Client) ([]InstrumentModel, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "symbol", Value: 1}})
+	cursor, err := mongoClient.Database(dbName).Collection(instrumentsCollection).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]InstrumentModel, 0)
+	err = cursor.All(ctx, &result)
+	return result, err
+}
+
+// AddInstrument lists a new symbol, it starts trading immediately
+func AddInstrument(ctx context.Context, mongoClient *mongo.Client, instrument InstrumentModel) (InstrumentModel, error) {
+	base, quote, err := accounts.SymbolAssets(instrument.Symbol)
+	if err != nil {
+		return instrument, invalidOrder(err)
+	}
+	if instrument.MinQuantity < 0 || instrument.MaxQuantity < 0 {
+		return instrument, invalidOrder(errors.New("quantity bounds must not be negative"))
+	}
+	if instrument.MaxQuantity > 0 && instrument.MinQuantity > instrument.MaxQuantity {
+		return instrument, invalidOrder(errors.New("minQuantity must not exceed maxQuantity"))
+	}
+	instrument.ID = primitive.NewObjectID()
+	instrument.BaseAsset = base
+	instrument.QuoteAsset = quote
+	instrument.Status = InstrumentTrading
+	instrument.HaltReason = ""
+	instrument.CreationTime = utils.GetCurrentTimestamp()
+	instrument.UpdateTime = instrument.CreationTime
+
+	_, err = mongoClient.Database(dbName).Collection(instrumentsCollection).InsertOne(ctx, instrument)
+	if mongo.IsDuplicateKeyError(err) {
+		return instrument, ErrInstrumentExists
+	}
+	if err != nil {
+		return instrument, err
+	}
+	forgetInstruments()
+	return instrument, nil
+}
+
+// SetInstrumentStatus halts or resumes a symbol, listing it when it is not yet
+// listed. The matcher leaves a halted book untouched until it is resumed.
+func SetInstrumentStatus(ctx context.Context, mongoClient *mongo.Client, symbol string, status string, reason string) (InstrumentModel, error) {
+	var instrument InstrumentModel
+	base, quote, err := accounts.SymbolAssets(symbol)
+	if err != nil {
+		return instrument, invalidOrder(err)
+	}
+	if status != InstrumentHalted {
+		reason = ""
+	}
+
+	now := utils.GetCurrentTimestamp()
+	update := bson.M{
+		"$set": bson.M{"status": status, "haltReason": reason, "updateTime": now},
+		"$setOnInsert": bson.M{
+			"baseAsset":    base,
+			"quoteAsset":   quote,
+			"minQuantity":  int64(0),
+			"maxQuantity":  int64(0),
+			"creationTime": now,
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err = mongoClient.Database(dbName).Collection(instrumentsCollection).
+		FindOneAndUpdate(ctx, bson.M{"symbol": symbol}, update, opts).Decode(&instrument)
+	if err != nil {
+		return instrument, err
+	}
+	forgetInstruments()
+
+	matcherLog.InfoContext(ctx, "instrument status changed", "symbol", symbol, "status", status, "reason", reason)
+	Publish("status", symbol, instrument)
+	return instrument, nil
+}
+
+// instrumentOf returns the listing of a symbol, the listings are cached for
+// controlsCacheTTL and the previous ones are kept while MongoDB fails
+func instrumentOf(ctx context.Context, mongoClient *mongo.Client, symbol string) (InstrumentModel, bool, error) {
+	instrumentsMutex.Lock()
+	cached, loaded := instruments, instrumentsLoaded
+	instrumentsMutex.Unlock()
+
+	if cached == nil || time.Since(loaded) >= controlsCacheTTL {
+		listed, err := GetInstruments(ctx, mongoClient)
+		if err != nil && cached == nil {
+			return InstrumentModel{}, false, fmt.Errorf("loading instruments: %w", err)
+		}
+		if err == nil {
+			cached = make(map[string]InstrumentModel, len(listed))
+			for _, instrument := range listed {
+				cached[instrument.Symbol] = instrument
+			}
+			instrumentsMutex.Lock()
+			instruments, instrumentsLoaded = cached, time.Now()
+			instrumentsMutex.Unlock()
+		}
+	}
+	instrument, ok := cached[symbol]
+	return instrument, ok, nil
+}
+
+// LookupOrderManager returns the manager of a symbol that is listed or already
+// traded, so that a lookup of any other symbol does not start a matcher
+func LookupOrderManager(ctx context.Context, mongoClient *mongo.Client, symbol string) (*OrderManagerModel, error) {
+	if manager, ok := FindOrderManager(symbol); ok {
+		return manager, nil
+	}
+	_, listed, err := instrumentOf(ctx, mongoClient, symbol)
+	if err != nil {
+		return nil, err
+	}
+	if !listed {
+		return nil, ErrUnknownSymbol
+	}
+	return GetOrderManager(symbol), nil
+}
+
+// forgetInstruments makes the next lookup read the listings again
+func forgetInstruments() {
+	instrumentsMutex.Lock()
+	instrumentsLoaded = time.Time{}
+	instrumentsMutex.Unlock()
+}
+
+// checkInstrument rejects orders for halted symbols and outside the quantity
+// bounds of the listing
+func checkInstrument(ctx context.Context, mongoClient *mongo.Client, order OrderModel) error {
+	instrument, listed, err := instrumentOf(ctx, mongoClient, order.Symbol)
+	if err != nil || !listed {
+		return err
+	}
+	if instrument.Status == InstrumentHalted {
+		if instrument.HaltReason != "" {
+			return fmt.Errorf("%w: %s", ErrSymbolHalted, instrument.HaltReason)
+		}
+		return ErrSymbolHalted
+	}
+	if instrument.MinQuantity > 0 && order.Quantity < instrument.MinQuantity {
+		return invalidOrder(fmt.Errorf("quantity below the minimum of %d for %s", instrument.MinQuantity, order.Symbol))
+	}
+	if instrument.MaxQuantity > 0 && order.Quantity > instrument.MaxQuantity {
+		return invalidOrder(fmt.Errorf("quantity above the maximum of %d for %s", instrument.MaxQuantity, order.Symbol))
+	}
+	return nil
+}
+
+// halted reports whether the matcher must leave the book of a symbol alone,
+// because it is halted or the kill switch is engaged. A symbol stays halted
+// while its listing or the kill switch cannot be read.
+func halted(ctx context.Context, mongoClient *mongo.Client, symbol string) bool {
+	if state, err := killSwitchState(ctx, mongoClient); err != nil || state.Engaged {
+		return true
+	}
+	instrument, listed, err := instrumentOf(ctx, mongoClient, symbol)
+	if err != nil {
+		return true
+	}
+	return listed && instrument.Status == InstrumentHalted
+}
@@ -0,0 +1,194 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mfus_OMV1/internal/health"
+	"mfus_OMV1/pkg/database"
+	"mfus_OMV1/utils"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var killSwitchCollection = "kill_switch"
+
+// killSwitchID is the only document of the kill switch collection
+const killSwitchID = "global"
+
+// ErrKillSwitch is returned by order entry while the kill switch is engaged.
+// Cancels are still accepted.
+var ErrKillSwitch = errors.New("trading is halted by the kill switch")
+
+// KillSwitchModel is the emergency stop of every symbol. While it is engaged
+// no order or amend is accepted and the matcher leaves every book untouched.
+// It is kept in MongoDB so that it survives a restart and reaches every
+// instance within controlsCacheTTL.
+type KillSwitchModel struct {
+	ID          string `json:"-" bson:"_id"`
+	Engaged     bool   `json:"engaged" bson:"engaged"`
+	Reason      string `json:"reason,omitempty" bson:"reason,omitempty"`
+	EngagedBy   string `json:"engagedBy,omitempty" bson:"engagedBy,omitempty"`
+	EngageTime  int64  `json:"engageTime,omitempty" bson:"engageTime,omitempty"`
+	ReleasedBy  string `json:"releasedBy,omitempty" bson:"releasedBy,omitempty"`
+	ReleaseTime int64  `json:"releaseTime,omitempty" bson:"releaseTime,omitempty"`
+	UpdateTime  int64  `json:"updateTime" bson:"updateTime"`
+}
+
+var killSwitchMutex sync.Mutex
+var killSwitch KillSwitchModel
+var killSwitchLoaded time.Time
+
+// GetKillSwitch reads the kill switch, it is released when it was never engaged
+func GetKillSwitch(ctx context.Context, mongoClient *mongo.Client) (KillSwitchModel, error) {
+	state := KillSwitchModel{ID: killSwitchID}
+	err := mongoClient.Database(dbName).Collection(killSwitchCollection).FindOne(ctx, bson.M{"_id": killSwitchID}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return state, nil
+	}
+	return state, err
+}
+
+// EngageKillSwitch stops order entry and matching on every symbol. It returns
+// once the order entry calls in flight are done, so that cancelOrders also
+// cancels the orders they placed. The cancelled orders are returned.
+func EngageKillSwitch(ctx context.Context, mongoClient *mongo.Client, operatorID string, reason string, cancelOrders bool) (KillSwitchModel, []OrderModel, error) {
+	cancelled := make([]OrderModel, 0)
+	now := utils.GetCurrentTimestamp()
+	state, err := updateKillSwitch(ctx, mongoClient, bson.M{
+		"$set":   bson.M{"engaged": true, "reason": reason, "engagedBy": operatorID, "engageTime": now, "updateTime": now},
+		"$unset": bson.M{"releasedBy": "", "releaseTime": ""},
+	})
+	if err != nil {
+		return state, cancelled, err
+	}
+	matcherLog.WarnContext(ctx, "kill switch engaged", "operator", operatorID, "reason", reason, "cancelOrders", cancelOrders)
+
+	// Calls admitted before the switch was engaged may still insert an order
+	entryMutex.Lock()
+	entryMutex.Unlock()
+
+	if cancelOrders {
+		cancelReason := "cancelled by kill switch"
+		if reason != "" {
+			cancelReason += ": " + reason
+		}
+		cancelled, err = ForceCancelOrders(ctx, bson.M{}, cancelReason)
+		if err != nil {
+			return state, cancelled, fmt.Errorf("kill switch engaged, cancelling orders: %w", err)
+		}
+	}
+	return state, cancelled, nil
+}
+
+// ReleaseKillSwitch lets every symbol trade again, halted symbols stay halted
+func ReleaseKillSwitch(ctx context.Context, mongoClient *mongo.Client, operatorID string) (KillSwitchModel, error) {
+	now := utils.GetCurrentTimestamp()
+	state, err := updateKillSwitch(ctx, mongoClient, bson.M{
+		"$set": bson.M{"engaged": false, "releasedBy": operatorID, "releaseTime": now, "updateTime": now},
+	})
+	if err != nil {
+		return state, err
+	}
+	matcherLog.WarnContext(ctx, "kill switch released", "operator", operatorID)
+	return state, nil
+}
+
+func updateKillSwitch(ctx context.Context, mongoClient *mongo.Client, update bson.M) (KillSwitchModel, error) {
+	var state KillSwitchModel
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := mongoClient.Database(dbName).Collection(killSwitchCollection).
+		FindOneAndUpdate(ctx, bson.M{"_id": killSwitchID}, update, opts).Decode(&state)
+	if err != nil {
+		return state, err
+	}
+	setKillSwitch(state)
+	return state, nil
+}
+
+// RefreshKillSwitch reads the kill switch again. It runs as a health check so
+// that an instance picks up a change made on another one without waiting for
+// an order, and shows it on the probes and the feed.
+func RefreshKillSwitch(ctx context.Context) error {
+	client, err := database.GetMongoClient()
+	if err != nil {
+		return err
+	}
+	state, err := GetKillSwitch(ctx, client)
+	if err != nil {
+		return err
+	}
+	setKillSwitch(state)
+	return nil
+}
+
+// setKillSwitch caches the state and announces a change on the probes and to
+// every WebSocket client
+func setKillSwitch(state KillSwitchModel) {
+	killSwitchMutex.Lock()
+	changed := killSwitchLoaded.IsZero() || state.UpdateTime != killSwitch.UpdateTime
+	killSwitch, killSwitchLoaded = state, time.Now()
+	killSwitchMutex.Unlock()
+	if !changed {
+		return
+	}
+
+	if state.Engaged {
+		health.SetHalt(&health.Halt{
+			Reason:     state.Reason,
+			OperatorID: state.EngagedBy,
+			Since:      time.UnixMilli(state.EngageTime),
+		})
+	} else {
+		health.SetHalt(nil)
+	}
+	Broadcast("killswitch", state)
+}
+
+// killSwitchState returns the cached kill switch, the previous value is kept
+// while MongoDB fails. Until it has been read once order entry is refused.
+func killSwitchState(ctx context.Context, mongoClient *mongo.Client) (KillSwitchModel, error) {
+	killSwitchMutex.Lock()
+	state, loaded := killSwitch, killSwitchLoaded
+	killSwitchMutex.Unlock()
+	if !loaded.IsZero() && time.Since(loaded) < controlsCacheTTL {
+		return state, nil
+	}
+
+	current, err := GetKillSwitch(ctx, mongoClient)
+	if err != nil {
+		if !loaded.IsZero() {
+			return state, nil
+		}
+		return current, fmt.Errorf("loading kill switch: %w", err)
+	}
+	setKillSwitch(current)
+	return current, nil
+}
+
+// engagedKillSwitch returns the last known kill switch if it is engaged, it
+// does not touch MongoDB
+func engagedKillSwitch() (KillSwitchModel, bool) {
+	killSwitchMutex.Lock()
+	defer killSwitchMutex.Unlock()
+	return killSwitch, killSwitch.Engaged
+}
+
+// checkKillSwitch rejects order entry while the kill switch is engaged
+func checkKillSwitch(ctx context.Context, mongoClient *mongo.Client) error {
+	state, err := killSwitchState(ctx, mongoClient)
+	if err != nil {
+		return err
+	}
+	if state.Engaged {
+		if state.Reason != "" {
+			return fmt.Errorf("%w: %s", ErrKillSwitch, state.Reason)
+		}
+		return ErrKillSwitch
+	}
+	return nil
+}
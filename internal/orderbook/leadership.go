@@ -0,0 +1,238 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"mfus_OMV1/internal/leader"
+	"mfus_OMV1/internal/shard"
+	"mfus_OMV1/pkg/database"
+	"mfus_OMV1/utils"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var fencesCollection = "matcher_fences"
+
+// ErrFenced is returned once a newer leader has taken a symbol over
+var ErrFenced = errors.New("symbol is fenced by a newer leader")
+
+// followBatch bounds the trades a standby reads per symbol and pass
+const followBatch = 1000
+
+// FenceModel is the fencing token of the latest leader of a symbol
+type FenceModel struct {
+	Symbol     string `bson:"_id"`
+	Token      int64  `bson:"token"`
+	InstanceID string `bson:"instanceID"`
+	UpdateTime int64  `bson:"updateTime"`
+	// TradeTime is when the leader last wrote a trade under the token
+	TradeTime int64 `bson:"tradeTime"`
+}
+
+// fencedTokens holds the token this instance fenced each symbol with
+var fencedMutex sync.Mutex
+var fencedTokens = make(map[string]int64)
+
+// lead reports whether this instance matches the symbol of the manager and
+// the fencing token to write its trades with. On a standby it follows the
+// trades of the leader instead, a new leader fences the symbol and catches up
+// on the trades of its predecessor before it matches. With sharding only the
+// node the symbol is assigned to campaigns for it.
+func (m *OrderManagerModel) lead(ctx context.Context, mongoClient *mongo.Client) (int64, bool) {
+	if assignment, ok := shard.HandingOver(ctx, mongoClient, m.Symbol); ok {
+		m.handOver(ctx, mongoClient, assignment)
+		return 0, false
+	}
+	if !shard.Owns(ctx, mongoClient, m.Symbol) {
+		if err := leader.Release(ctx, m.Symbol); err != nil {
+			matcherLog.Warn("cannot release lease", "symbol", m.Symbol, "error", err)
+		}
+		if err := m.follow(ctx, mongoClient); err != nil {
+			matcherLog.Warn("cannot follow trades", "symbol", m.Symbol, "error", err)
+		}
+		return 0, false
+	}
+
+	token, ok := leader.Lead(ctx, m.Symbol)
+	if !ok {
+		if err := m.follow(ctx, mongoClient); err != nil {
+			matcherLog.Warn("cannot follow trades", "symbol", m.Symbol, "error", err)
+		}
+		return 0, false
+	}
+
+	fresh, err := fence(ctx, mongoClient, m.Symbol, token)
+	if errors.Is(err, ErrFenced) {
+		leader.Drop(m.Symbol, err.Error())
+		return 0, false
+	}
+	if err != nil {
+		matcherLog.Warn("cannot fence symbol", "symbol", m.Symbol, "token", token, "error", err)
+		return 0, false
+	}
+	if fresh {
+		if err := m.restore(ctx, mongoClient); err != nil {
+			matcherLog.Warn("cannot restore snapshot", "symbol", m.Symbol, "error", err)
+			return 0, false
+		}
+		if err := m.follow(ctx, mongoClient); err != nil {
+			matcherLog.Warn("cannot catch up on trades", "symbol", m.Symbol, "error", err)
+			return 0, false
+		}
+	}
+	return token, true
+}
+
+// fence records the token of a new lease so that trades of older leaders are
+// refused from now on. It reports whether the token was recorded by this call.
+// Token 0 means election is disabled and nothing is fenced.
+func fence(ctx context.Context, mongoClient *mongo.Client, symbol string, token int64) (bool, error) {
+	if token == 0 {
+		return false, nil
+	}
+	fencedMutex.Lock()
+	done := fencedTokens[symbol] == token
+	fencedMutex.Unlock()
+	if done {
+		return false, nil
+	}
+
+	// A higher token makes the filter miss and the upsert collide on _id
+	_, err := mongoClient.Database(dbName).Collection(fencesCollection).UpdateOne(ctx,
+		bson.M{"_id": symbol, "token": bson.M{"$lte": token}},
+		bson.M{"$set": bson.M{"token": token, "instanceID": leader.InstanceID(), "updateTime": utils.GetCurrentTimestamp()}},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, ErrFenced
+	}
+	if err != nil {
+		return false, err
+	}
+
+	fencedMutex.Lock()
+	fencedTokens[symbol] = token
+	fencedMutex.Unlock()
+	matcherLog.Info("symbol fenced", "symbol", symbol, "token", token)
+	return true, nil
+}
+
+// holdFence fails once a newer leader has fenced the symbol. It writes the
+// fence document, so called in the transaction of a trade it makes the trade
+// conflict with a new leader fencing the symbol until the trade commits.
+func holdFence(ctx context.Context, mongoClient *mongo.Client, symbol string, token int64) error {
+	if token == 0 {
+		return nil
+	}
+	result, err := mongoClient.Database(dbName).Collection(fencesCollection).UpdateOne(ctx,
+		bson.M{"_id": symbol, "token": token},
+		bson.M{"$set": bson.M{"tradeTime": utils.GetCurrentTimestamp()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrFenced
+	}
+	return nil
+}
+
+// follow applies the trades journaled by the leader of the symbol since the
+// last one the manager knows, so that a takeover starts from the latest trade.
+// The trade listeners are left alone, the leader feeds positions and market data.
+func (m *OrderManagerModel) follow(ctx context.Context, mongoClient *mongo.Client) error {
+	m.TradeMutex.Lock()
+	since := m.LastTradeTime.Truncate(time.Millisecond)
+	m.TradeMutex.Unlock()
+
+	filter := bson.M{"symbol": m.Symbol}
+	if !since.IsZero() {
+		filter["executed_at"] = bson.M{"$gte": since}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "executed_at", Value: 1}}).SetLimit(followBatch)
+	cursor, err := mongoClient.Database(dbName).Collection(tradeCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	trades := make([]TradeHistoryModel, 0)
+	if err := cursor.All(ctx, &trades); err != nil {
+		return err
+	}
+	for _, trade := range trades {
+		m.recordTrade(trade)
+	}
+	return nil
+}
+
+// handOver stops matching a symbol that moves to another node. The snapshot it
+// writes is where the target starts, the released lease lets the target lead
+// at once.
+func (m *OrderManagerModel) handOver(ctx context.Context, mongoClient *mongo.Client, assignment shard.AssignmentModel) {
+	snapshot, err := m.writeSnapshot(ctx, mongoClient)
+	if err != nil {
+		matcherLog.Warn("cannot hand over symbol", "symbol", m.Symbol, "error", err)
+		return
+	}
+	if err := leader.Release(ctx, m.Symbol); err != nil {
+		matcherLog.Warn("cannot release lease", "symbol", m.Symbol, "error", err)
+	}
+	if err := shard.CompleteHandover(ctx, mongoClient, assignment, snapshot.Sequence); err != nil {
+		matcherLog.Warn("cannot hand over symbol", "symbol", m.Symbol, "error", err)
+	}
+}
+
+// restore seeds the last trade and the totals of the manager from the latest
+// snapshot of the symbol when it is newer than what the manager knows
+func (m *OrderManagerModel) restore(ctx context.Context, mongoClient *mongo.Client) error {
+	var snapshot SnapshotModel
+	err := mongoClient.Database(dbName).Collection(snapshotsCollection).FindOne(ctx, bson.M{"symbol": m.Symbol},
+		options.FindOne().SetSort(bson.D{{Key: "taken_at", Value: -1}})).Decode(&snapshot)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	m.TradeMutex.Lock()
+	defer m.TradeMutex.Unlock()
+	if snapshot.LastTradeID == "" || !snapshot.LastTradeTime.After(m.LastTradeTime) {
+		return nil
+	}
+	m.LastTradeID = snapshot.LastTradeID
+	m.LastTradePrice = snapshot.LastTradePrice
+	m.LastTradeTime = snapshot.LastTradeTime
+	m.TradeCount = snapshot.TradeCount
+	m.TotalTradeVolume = snapshot.TradeVolume
+	m.recorded = map[string]bool{snapshot.LastTradeID: true}
+	matcherLog.Info("restored from snapshot", "symbol", m.Symbol, "sequence", snapshot.Sequence, "lastTrade", snapshot.LastTradeID)
+	return nil
+}
+
+// ActiveSymbols lists the symbols that need a matcher, those with open orders
+// and the listed instruments
+func ActiveSymbols(ctx context.Context) ([]string, error) {
+	mongoClient, err := database.GetMongoClient()
+	if err != nil {
+		return nil, err
+	}
+	open, err := mongoClient.Database(dbName).Collection(ordersCollection).Distinct(ctx, "symbol", bson.M{"status": openStatusFilter})
+	if err != nil {
+		return nil, err
+	}
+	listed, err := mongoClient.Database(dbName).Collection(instrumentsCollection).Distinct(ctx, "symbol", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	symbols := make([]string, 0, len(open)+len(listed))
+	for _, value := range append(open, listed...) {
+		if symbol, ok := value.(string); ok && symbol != "" && !seen[symbol] {
+			seen[symbol] = true
+			symbols = append(symbols, symbol)
+		}
+	}
+	return symbols, nil
+}
@@ -2,12 +2,17 @@ package orderbook
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 
+	"mfus_OMV1/internal/accounts"
+	"mfus_OMV1/internal/leader"
+	"mfus_OMV1/internal/metrics"
+	"mfus_OMV1/internal/shard"
 	"mfus_OMV1/pkg/database"
 	"mfus_OMV1/utils"
+	"os"
 	"sort"
 	"time"
 
@@ -25,64 +30,193 @@ func NewLimitOrder(side string, quantity int64, price float64, timestamp time.Ti
 	}
 }
 
-func StartLimitOrderMatch() {
-
-	// To Do: Need to implement the Redis and MongoDB connection Client
+// StartLimitOrderMatch runs the matching loop until the context ends. A pass
+// that fails, e.g. while MongoDB is unavailable, is retried with backoff
+// instead of stopping the loop. Once the context ends one more pass matches
+// the orders accepted before order entry stopped.
+func StartLimitOrderMatch(ctx context.Context) {
 	mongoClient, err := database.GetMongoClient()
 	if err != nil {
-		log.Fatal(err)
+		matcherLog.Error("cannot create MongoDB client", "error", err)
+		os.Exit(1)
 	}
 
-	defer mongoClient.Disconnect(context.Background())
-
 	// Initialize Redis client
 	redisClient := redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "",
-		DB:       0,
+		Addr:     settings.Redis.Addr,
+		Password: settings.Redis.Password,
+		DB:       settings.Redis.DB,
+		PoolSize: settings.Redis.PoolSize,
+	})
+	defer redisClient.Close()
+	defer stopEngines()
+	redisClient.WrapProcess(func(process func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			start := time.Now()
+			err := process(cmd)
+			metrics.ObserveRedis(cmd.Name(), time.Since(start), err != nil && err != redis.Nil)
+			return err
+		}
 	})
-	fmt.Print(redisClient)
-	// Initialize order book
-	orderBook := OrderBook{
-		BuyOrders:  make([]OrderModel, 0),
-		SellOrders: make([]OrderModel, 0),
-	}
 
-	fmt.Print(orderBook)
 	// Start order matching loop
+	backoff := utils.Backoff{Min: settings.Health.RetryMinDelay, Max: settings.Health.RetryMaxDelay}
 	for {
-		fmt.Println("Run Go routine")
-		// Get the latest buy and sell orders from MongoDB
-		buyOrders, err := getOpenOrders("Buy", mongoClient)
-		if err != nil {
-			log.Printf("Error getting buy orders: %v", err)
+		matcherState.loop()
+		final := ctx.Err() != nil
+		if err := matchPass(mongoClient, redisClient); err != nil {
+			if final {
+				matcherLog.Error("final matching pass failed", "error", err)
+				return
+			}
+			delay := backoff.Next()
+			matcherLog.Error("matching pass failed, retrying", "retryIn", delay.String(), "error", err)
+			sleep(ctx, delay)
 			continue
 		}
-		fmt.Print(buyOrders)
-		sellOrders, err := getOpenOrders("Sell", mongoClient)
-		if err != nil {
-			log.Printf("Error getting sell orders: %v", err)
-			continue
+		backoff.Reset()
+		matcherState.pass()
+		if final {
+			matcherLog.Info("matcher stopped")
+			return
 		}
-		fmt.Print(sellOrders)
 
-		// Update order book with latest buy and sell orders
-		orderBook.BuyOrders = buyOrders
-		orderBook.SellOrders = sellOrders
+		// Wait for a short interval before checking again
+		sleep(ctx, settings.Matcher.Interval)
+	}
+}
 
-		// Match orders
-		matchOrders(&orderBook, redisClient, mongoClient)
+// sleep waits for a duration or until the context ends
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
 
-		// Wait for a short interval before checking again
-		time.Sleep(1 * time.Second)
+// matchPass expires orders, loads the open orders and matches every book once
+func matchPass(mongoClient *mongo.Client, redisClient *redis.Client) error {
+	// Release the reservations of orders that have passed their expiration
+	expireOrders(mongoClient)
+
+	// Note how far each engine is before the orders are read
+	marks := make(map[string]engineMark)
+	for _, manager := range OrderManagers() {
+		if e := manager.engine.Load(); e != nil {
+			marks[manager.Symbol] = e.mark()
+		}
+	}
+
+	// Get the latest buy and sell orders from MongoDB, with sharding only
+	// those of the symbols this node owns
+	owned, sharded, err := shard.Owned(context.Background(), mongoClient)
+	if err != nil {
+		return fmt.Errorf("loading assignments: %w", err)
+	}
+	if !sharded {
+		owned = nil
+	}
+	buyOrders, err := getOpenOrders("Buy", owned, mongoClient)
+	if err != nil {
+		return fmt.Errorf("loading buy orders: %w", err)
+	}
+	sellOrders, err := getOpenOrders("Sell", owned, mongoClient)
+	if err != nil {
+		return fmt.Errorf("loading sell orders: %w", err)
+	}
+	matcherLog.Debug("matching pass", "buyOrders", len(buyOrders), "sellOrders", len(sellOrders))
+
+	// Update order book with latest buy and sell orders, one book per symbol
+	books := make(map[string]*OrderBook)
+	for _, order := range buyOrders {
+		book := bookFor(books, order.Symbol)
+		book.BuyOrders = append(book.BuyOrders, order)
+	}
+	for _, order := range sellOrders {
+		book := bookFor(books, order.Symbol)
+		book.SellOrders = append(book.SellOrders, order)
+	}
+
+	// Match the books this instance leads and publish the resting orders left
+	// on each book, halted books and books led elsewhere are published as they are
+	ctx := context.Background()
+	for symbol, book := range books {
+		manager := GetOrderManager(symbol)
+		token, ok := manager.lead(ctx, mongoClient)
+		active := ok && !halted(ctx, mongoClient, symbol)
+		if settings.Matcher.Pipeline {
+			manager.syncEngine(ctx, mongoClient, redisClient, book, active, token, marks[symbol])
+			continue
+		}
+		if active {
+			start := time.Now()
+			matchOrders(book, token, redisClient, mongoClient)
+			metrics.MatchLatency.WithLabelValues(symbol).Observe(time.Since(start).Seconds())
+		}
+		manager.updateBook(book.BuyOrders, book.SellOrders)
+	}
+	for _, manager := range OrderManagers() {
+		if _, ok := books[manager.Symbol]; !ok {
+			token, ok := manager.lead(ctx, mongoClient)
+			if settings.Matcher.Pipeline {
+				active := ok && !halted(ctx, mongoClient, manager.Symbol)
+				manager.syncEngine(ctx, mongoClient, redisClient, bookFor(books, manager.Symbol), active, token, marks[manager.Symbol])
+				continue
+			}
+			manager.updateBook(nil, nil)
+		}
+	}
+	return nil
+}
+
+// syncEngine hands the open orders of a symbol to its engine, which replaces
+// its book with them and matches while the symbol is active
+func (m *OrderManagerModel) syncEngine(ctx context.Context, mongoClient *mongo.Client, redisClient *redis.Client, book *OrderBook, active bool, token int64, mark engineMark) {
+	orders := make([]OrderModel, 0, len(book.BuyOrders)+len(book.SellOrders))
+	orders = append(append(orders, book.BuyOrders...), book.SellOrders...)
+	e := m.startEngine(mongoClient, redisClient)
+	state := &bookSync{orders: orders, active: active, token: token, mark: mark}
+	if err := e.commands.Push(ctx, command{kind: commandSync, sync: state, received: time.Now()}); err != nil {
+		matcherLog.Error("cannot sync engine", "symbol", m.Symbol, "error", err)
+	}
+}
+
+// stopEngines stops the engine of every symbol once its queued commands are
+// applied and its results handled
+func stopEngines() {
+	ctx, cancel := context.WithTimeout(context.Background(), settings.Server.ShutdownTimeout)
+	defer cancel()
+	for _, manager := range OrderManagers() {
+		if e := manager.engine.Load(); e != nil {
+			if err := e.stop(ctx); err != nil {
+				matcherLog.Error("cannot stop engine", "symbol", manager.Symbol, "error", err)
+			}
+		}
 	}
+}
 
+func bookFor(books map[string]*OrderBook, symbol string) *OrderBook {
+	book, ok := books[symbol]
+	if !ok {
+		book = &OrderBook{
+			BuyOrders:  make([]OrderModel, 0),
+			SellOrders: make([]OrderModel, 0),
+		}
+		books[symbol] = book
+	}
+	return book
 }
 
-func getOpenOrders(side string, mongoClient *mongo.Client) ([]OrderModel, error) {
-	// Connect to "orders" collection in MongoDB and get all open orders for the specified side (buy or sell)
-	collection := mongoClient.Database("orderbook").Collection("orders")
-	filter := bson.M{"side": side, "status": bson.M{"$ne": "cancelled"}}
+func getOpenOrders(side string, symbols []string, mongoClient *mongo.Client) ([]OrderModel, error) {
+	// Connect to "orders" collection in MongoDB and get all open orders for the specified side (buy or sell),
+	// of the given symbols unless symbols is nil
+	collection := mongoClient.Database(dbName).Collection(ordersCollection)
+	filter := bson.M{"side": side, "status": openStatusFilter}
+	if symbols != nil {
+		filter["symbol"] = bson.M{"$in": symbols}
+	}
 	cursor, err := collection.Find(context.Background(), filter)
 	if err != nil {
 		return nil, err
@@ -105,218 +239,286 @@ func getOpenOrders(side string, mongoClient *mongo.Client) ([]OrderModel, error)
 	return orders, nil
 }
 
-func matchOrders(orderBook *OrderBook, redisClient *redis.Client, mongoClient *mongo.Client) {
-	// Sort buy and sell orders in order of highest to lowest price
-	sort.Slice(orderBook.BuyOrders, func(i, j int) bool {
-		return orderBook.BuyOrders[i].Price > orderBook.BuyOrders[j].Price
-	})
-	sort.Slice(orderBook.SellOrders, func(i, j int) bool {
-		return orderBook.SellOrders[i].Price < orderBook.SellOrders[j].Price
-	})
-
-	// Match orders by iterating over buy and sell orders and executing trades
-	for len(orderBook.BuyOrders) > 0 && len(orderBook.SellOrders) > 0 {
-		buyOrder := orderBook.BuyOrders[0]
-		sellOrder := orderBook.SellOrders[0]
-
-		// Check if buy order price is greater than or equal to sell order price
-		if buyOrder.Price >= sellOrder.Price {
-			// Determine the quantity of the trade and update the orders accordingly
-			var quantity int64
-			if buyOrder.Quantity-buyOrder.FilledQty <= sellOrder.Quantity-sellOrder.FilledQty {
-				quantity = buyOrder.Quantity - buyOrder.FilledQty
-				buyOrder.FilledQty = buyOrder.Quantity
-				sellOrder.FilledQty += quantity
-				if sellOrder.FilledQty == sellOrder.Quantity {
-					sellOrder.Status = "filled"
-				} else {
-					sellOrder.Status = "partially_filled"
-				}
-				orderBook.SellOrders[0] = sellOrder
-				orderBook.SellOrders = orderBook.SellOrders[1:]
-			} else {
-				quantity = sellOrder.Quantity - sellOrder.FilledQty
-				sellOrder.FilledQty = sellOrder.Quantity
-				buyOrder.FilledQty += quantity
-				if buyOrder.FilledQty == buyOrder.Quantity {
-					buyOrder.Status = "filled"
-				} else {
-					buyOrder.Status = "partially_filled"
-				}
-				orderBook.BuyOrders[0] = buyOrder
-				orderBook.BuyOrders = orderBook.BuyOrders[1:]
-			}
-
-			// Execute the trade by writing to Redis
-			trade := map[string]interface{}{
-				"buy_order_id":  buyOrder.ID,
-				"sell_order_id": sellOrder.ID,
-				"price":         sellOrder.Price,
-				"quantity":      quantity,
-				"timestamp":     time.Now().Unix(),
-			}
-
-			collection := mongoClient.Database("orderbook").Collection("trades")
-			record, err := collection.InsertOne(context.Background(), trade)
-			if err != nil {
-				log.Printf("Error writing trade to Redis: %v", err)
-			}
-
-			fmt.Print(record)
-			err = redisClient.LPush("trades", trade).Err()
-			if err != nil {
-				log.Printf("Error writing trade to Redis: %v", err)
+func expireOrders(mongoClient *mongo.Client) {
+	// Mark open orders whose expiration has passed as expired and release their funds
+	collection := mongoClient.Database(dbName).Collection(ordersCollection)
+	now := utils.GetCurrentTimestamp()
+	filter := bson.M{"status": bson.M{"$in": statusesInto(Expired)}, "expiration": bson.M{"$gt": 0, "$lte": now}}
+	for {
+		var order OrderModel
+		err := collection.FindOneAndUpdate(context.Background(), filter,
+			bson.M{"$set": bson.M{"status": Expired, "updateTime": now}},
+		).Decode(&order)
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				matcherLog.Error("cannot expire orders", "error", err)
 			}
-		} else {
-			// No more orders to match
-			break
+			return
+		}
+		ctx := orderContext(order)
+		logStateChange(ctx, mongoClient, order.ID, order.Status.String(), Expired, "expiration reached")
+		if err := releaseOrder(ctx, mongoClient, order); err != nil {
+			matcherLog.ErrorContext(ctx, "cannot release funds of expired order", "order", order.ID.Hex(), "error", err)
 		}
+		matcherLog.InfoContext(ctx, "order expired", "order", order.ID.Hex(), "symbol", order.Symbol)
+		order.Status = Expired
+		order.UpdateTime = now
+		publishOrder(EventExpired, order)
+		submitOrder(ctx, commandCancel, order)
 	}
-
-	// Update buy and sell orders in MongoDB with new statuses and filled quantities
-	updateOrders(orderBook.BuyOrders, mongoClient)
-	updateOrders(orderBook.SellOrders, mongoClient)
 }
 
-func updateOrders(orders []OrderModel, mongoClient *mongo.Client) {
-	// Connect to "orders" collection in MongoDB and update orders with new status and filled quantity
-	collection := mongoClient.Database("orderbook").Collection("orders")
-	for _, order := range orders {
-		filter := bson.M{"id": order.ID}
-		update := bson.M{"$set": bson.M{"status": order.Status, "filled": order.FilledQty}}
-		_, err := collection.UpdateOne(context.Background(), filter, update)
-		if err != nil {
-			log.Printf("Error updating order %s: %v", order.ID, err)
+// matchOrders executes the trades of a book. Each trade is written only while
+// the fencing token is the latest of the symbol, a leader that was fenced off
+// stops and leaves the rest of the book to its successor.
+func matchOrders(orderBook *OrderBook, token int64, redisClient *redis.Client, mongoClient *mongo.Client) {
+	crossBook(orderBook, func(buyOrder *OrderModel, sellOrder *OrderModel) bool {
+		// The orders are only filled once the fills are written, the book may
+		// be up to an interval old and an order cancelled in the meantime
+		// drops the trade
+		trade := newTrade(buyOrder, sellOrder, token)
+		buy, sell := *buyOrder, *sellOrder
+		if err := fillOrders(&buy, &sell, trade); err != nil {
+			matcherLog.Error("invalid fill", "trade", trade.Id, "error", err)
+			return false
 		}
-	}
+		// A trade is never settled without its fees
+		if err := applyFees(context.Background(), mongoClient, &trade); err != nil {
+			dropTrade(trade, fmt.Errorf("computing fees: %w", err))
+			return false
+		}
+		// Execute the trade by writing it, its fills and its settlement to
+		// MongoDB, then Redis
+		if err := writeTrade(context.Background(), mongoClient, trade, buy, sell); err != nil {
+			dropTrade(trade, err)
+			return false
+		}
+		for _, filled := range []struct{ from, to *OrderModel }{{buyOrder, &buy}, {sellOrder, &sell}} {
+			logStateChange(orderContext(*filled.to), mongoClient, filled.to.ID, filled.from.Status.String(), filled.to.Status, "trade "+trade.Id)
+			publishOrder(EventFilled, *filled.to)
+		}
+		*buyOrder, *sellOrder = buy, sell
+
+		GetOrderManager(trade.Symbol).publishTrade(trade)
+		logTrade(trade)
+		if err := pushTrade(redisClient, trade); err != nil {
+			matcherLog.Error("cannot write trade to Redis", "trade", trade.Id, "error", err)
+		}
+		return true
+	})
 }
 
-func AddOrder(order OrderModel, mongoClient *mongo.Client) error {
-	// Connect to "orders" collection in MongoDB and insert the order
+// settleTrade converts both reservations of a trade into settled balances and
+// collects the fees
+func settleTrade(ctx context.Context, mongoClient *mongo.Client, trade TradeHistoryModel, buyLimitPrice float64) error {
+	return accounts.SettleTrade(ctx, mongoClient, accounts.Settlement{
+		TradeID:       trade.Id,
+		Symbol:        trade.Symbol,
+		BuyerID:       trade.BuyUserID,
+		SellerID:      trade.SellUserID,
+		Quantity:      trade.Quantity,
+		Price:         trade.Price,
+		BuyLimitPrice: buyLimitPrice,
+		BuyFee:        trade.BuyFee,
+		BuyFeeAsset:   trade.BuyFeeAsset,
+		SellFee:       trade.SellFee,
+		SellFeeAsset:  trade.SellFeeAsset,
+	})
+}
 
-	collection := mongoClient.Database("orderbook").Collection("orders")
-	order.ID = primitive.ObjectID{}
-	order.Status = "open"
-	order.Expiration = utils.GetCurrentTimestamp()
-	record, err := collection.InsertOne(context.Background(), order)
+// pushTrade appends a trade to the Redis list of trades
+func pushTrade(redisClient *redis.Client, trade TradeHistoryModel) error {
+	payload, err := json.Marshal(trade)
 	if err != nil {
 		return err
 	}
-	fmt.Println(record)
-	return nil
+	return redisClient.LPush(settings.Matcher.TradesKey, payload).Err()
 }
 
-func cancelOrder(id primitive.ObjectID, mongoClient *mongo.Client) error {
-	// Connect to "orders" collection in MongoDB and update the order status to "cancelled"
+func logTrade(trade TradeHistoryModel) {
+	matcherLog.Info("trade executed", "trade", trade.Id, "symbol", trade.Symbol,
+		"price", trade.Price, "quantity", trade.Quantity, "makerSide", trade.MakerSide,
+		"buyOrder", trade.BuyOrder, "sellOrder", trade.SellOrder,
+		"buyCorrelationID", trade.BuyCorrelationID, "sellCorrelationID", trade.SellCorrelationID)
+}
 
-	collection := mongoClient.Database("orderbook").Collection("orders")
-	record, err := collection.UpdateMany(context.Background(),
-		bson.M{"_id": id},
-		bson.M{"$set": bson.M{"status": "cancelled"}},
-	)
-	if err != nil {
-		return err
+// crossBook sorts a book and hands its best buy and sell orders to execute as
+// long as they cross. execute fills both orders, or returns false to stop.
+// Filled orders leave the book, the rest is left resting on it.
+func crossBook(orderBook *OrderBook, execute func(buyOrder *OrderModel, sellOrder *OrderModel) bool) {
+	sort.SliceStable(orderBook.BuyOrders, func(i, j int) bool {
+		return buyBefore(&orderBook.BuyOrders[i], &orderBook.BuyOrders[j])
+	})
+	sort.SliceStable(orderBook.SellOrders, func(i, j int) bool {
+		return sellBefore(&orderBook.SellOrders[i], &orderBook.SellOrders[j])
+	})
+
+	for len(orderBook.BuyOrders) > 0 && len(orderBook.SellOrders) > 0 {
+		buyOrder := &orderBook.BuyOrders[0]
+		sellOrder := &orderBook.SellOrders[0]
+		if buyOrder.Price < sellOrder.Price {
+			return
+		}
+		if !execute(buyOrder, sellOrder) {
+			return
+		}
+		if buyOrder.FilledQty == buyOrder.Quantity {
+			orderBook.BuyOrders = orderBook.BuyOrders[1:]
+		}
+		if sellOrder.FilledQty == sellOrder.Quantity {
+			orderBook.SellOrders = orderBook.SellOrders[1:]
+		}
 	}
-	fmt.Println(record)
-	return nil
 }
 
-func handleOrder(order OrderModel, mongoClient *mongo.Client) error {
-	// Validate the order
-	err := validateOrder(order)
-	if err != nil {
-		return err
+// buyBefore orders bids from the highest price down, oldest first within a level
+func buyBefore(a *OrderModel, b *OrderModel) bool {
+	if a.Price != b.Price {
+		return a.Price > b.Price
 	}
-	// Add the order to the database
-	err = AddOrder(order, mongoClient)
-	if err != nil {
-		return err
+	return a.CreationTime < b.CreationTime
+}
+
+// sellBefore orders asks from the lowest price up, oldest first within a level
+func sellBefore(a *OrderModel, b *OrderModel) bool {
+	if a.Price != b.Price {
+		return a.Price < b.Price
 	}
+	return a.CreationTime < b.CreationTime
+}
 
+// newTrade crosses the best buy and sell orders of a book for the quantity
+// both have left, at the price of the resting (maker) order. The orders are
+// not changed.
+func newTrade(buyOrder *OrderModel, sellOrder *OrderModel, token int64) TradeHistoryModel {
+	quantity := buyOrder.Quantity - buyOrder.FilledQty
+	if sellRemaining := sellOrder.Quantity - sellOrder.FilledQty; sellRemaining < quantity {
+		quantity = sellRemaining
+	}
+	maker := makerSide(buyOrder, sellOrder)
+	price := sellOrder.Price
+	if maker == "Buy" {
+		price = buyOrder.Price
+	}
+	now := time.Now()
+	return TradeHistoryModel{
+		Id:                primitive.NewObjectID().Hex(),
+		Symbol:            buyOrder.Symbol,
+		BuyOrder:          buyOrder.ID.Hex(),
+		SellOrder:         sellOrder.ID.Hex(),
+		BuyUserID:         buyOrder.UserID,
+		SellUserID:        sellOrder.UserID,
+		BuyCorrelationID:  buyOrder.CorrelationID,
+		SellCorrelationID: sellOrder.CorrelationID,
+		Quantity:          quantity,
+		Price:             price,
+		MakerSide:         maker,
+		ExecutedAt:        now,
+		Timestamp:         now,
+		Fence:             token,
+	}
+}
+
+// fillOrder applies one execution to an order and keeps its fill totals
+// consistent. An order whose state cannot take the fill is left as it is.
+func fillOrder(order *OrderModel, trade TradeHistoryModel) error {
+	next := Partial
+	if order.FilledQty+trade.Quantity == order.Quantity {
+		next = Filled
+	}
+	if err := order.Status.Transition(next); err != nil {
+		return fmt.Errorf("order %s: %w", order.ID.Hex(), err)
+	}
+	order.Status = next
+	order.FilledQty += trade.Quantity
+	order.FilledVolume += float64(trade.Quantity) * trade.Price
+	order.FilledAverage = order.FilledVolume / float64(order.FilledQty)
+	order.FilledOrders = append(order.FilledOrders, trade.Id)
+	// FilledOrder holds the latest execution, keyed by its trade ID
+	order.FilledOrder = &TradeFilledInfoModel{
+		OrderID:   trade.Id,
+		Price:     trade.Price,
+		Quantity:  float64(trade.Quantity),
+		Timestamp: trade.ExecutedAt.UnixNano() / int64(time.Millisecond),
+	}
+	order.RemainingQty = order.Quantity - order.FilledQty
+	order.UpdateTime = utils.GetCurrentTimestamp()
 	return nil
 }
 
-func handleCancel(id string, mongoClient *mongo.Client) error {
-	// Parse the order ID
-	orderID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
+// fillOrders applies a trade to both of its orders, or to neither
+func fillOrders(buyOrder *OrderModel, sellOrder *OrderModel, trade TradeHistoryModel) error {
+	buy, sell := *buyOrder, *sellOrder
+	if err := fillOrder(&buy, trade); err != nil {
 		return err
 	}
-
-	// Cancel the order
-	err = cancelOrder(orderID, mongoClient)
-	if err != nil {
+	if err := fillOrder(&sell, trade); err != nil {
 		return err
 	}
-
+	*buyOrder, *sellOrder = buy, sell
 	return nil
 }
 
-func handleCommand(command string, mongoClient *mongo.Client) error {
-	switch command {
-	case "exit":
-		return errors.New("exit")
-	case "help":
-		fmt.Println("Available commands:")
-		fmt.Println("buy <quantity> <price>")
-		fmt.Println("sell <quantity> <price>")
-		fmt.Println("cancel <order_id>")
-		fmt.Println("exit")
-	default:
-		// Parse the command
-		var order OrderModel
-		var quantity int64
-		var price float64
-		n, err := fmt.Sscanf(command, "%s %f %f", &order.Side, &quantity, &price)
-		if err != nil || n != 3 {
-			return errors.New("invalid command")
+// errStaleFill is returned when an order was cancelled, filled or amended
+// since the matcher read it
+var errStaleFill = errors.New("order changed since the book was read")
+
+// writeTrade writes a trade together with the orders it filled and settles
+// it, all of it or none. Each order is only updated while it is in the state
+// and at the fill it was matched at, and the trade only while its fencing
+// token is the latest of the symbol.
+func writeTrade(ctx context.Context, mongoClient *mongo.Client, trade TradeHistoryModel, buyOrder OrderModel, sellOrder OrderModel) error {
+	return database.Transaction(ctx, mongoClient, func(ctx context.Context) error {
+		if err := holdFence(ctx, mongoClient, trade.Symbol, trade.Fence); err != nil {
+			return err
 		}
-		order.Quantity = quantity
-		order.Price = price
-		// Handle the order
-		err = handleOrder(order, mongoClient)
-		if err != nil {
+		for _, order := range []OrderModel{buyOrder, sellOrder} {
+			if err := updateFill(ctx, mongoClient, order, order.FilledQty-trade.Quantity); err != nil {
+				return err
+			}
+		}
+		if _, err := mongoClient.Database(dbName).Collection(tradeCollection).InsertOne(ctx, trade); err != nil {
 			return err
 		}
-	}
-	return nil
+		// The buyer's funds were reserved at its limit price
+		return settleTrade(ctx, mongoClient, trade, buyOrder.Price)
+	})
 }
 
-func handleInput(inputChan chan string, mongoClient *mongo.Client) {
-	// Read commands from standard input and send them to the input channel
-	for {
-		var command string
-		fmt.Scanln(&command)
-		inputChan <- command
+// dropTrade reports a trade that was not written, a leader that was fenced
+// off gives the symbol up
+func dropTrade(trade TradeHistoryModel, err error) {
+	if errors.Is(err, ErrFenced) {
+		matcherLog.Warn("matching stopped", "symbol", trade.Symbol, "token", trade.Fence, "error", err)
+		leader.Drop(trade.Symbol, err.Error())
+		return
 	}
+	matcherLog.Warn("trade dropped", "trade", trade.Id, "symbol", trade.Symbol, "error", err)
 }
 
-func handleCommands(inputChan chan string, mongoClient *mongo.Client) {
-	// Process commands from the input channel
-	for {
-		select {
-		case command := <-inputChan:
-			err := handleCommand(command, mongoClient)
-			if err != nil {
-				log.Printf("Error handling command %q: %v", command, err)
-			}
-		}
-	}
-}
-
-func validateOrder(order OrderModel) error {
-	if order.Type != "market" && order.Type != "limit" {
-		return errors.New("invalid order type")
-	}
-	if order.Side != "buy" && order.Side != "sell" {
-		return errors.New("invalid order side")
-	}
-	if order.Price <= 0 {
-		return errors.New("invalid order price")
+// updateFill writes the new status and fill totals of an order that was at
+// filledQty before. The price and quantity the matcher filled it at are
+// pinned too, an amend since the book was read must not be filled at the
+// old terms nor settled against the old reservation.
+func updateFill(ctx context.Context, mongoClient *mongo.Client, order OrderModel, filledQty int64) error {
+	collection := mongoClient.Database(dbName).Collection(ordersCollection)
+	filter := bson.M{"_id": order.ID, "status": bson.M{"$in": statusesInto(order.Status)}, "filledQty": filledQty,
+		"price": order.Price, "quantity": order.Quantity}
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+		"status":        order.Status,
+		"filledQty":     order.FilledQty,
+		"remainingQty":  order.RemainingQty,
+		"filledVolume":  order.FilledVolume,
+		"filledAverage": order.FilledAverage,
+		"filledOrders":  order.FilledOrders,
+		"filled_order":  order.FilledOrder,
+		"updateTime":    order.UpdateTime,
+	}})
+	if err != nil {
+		return err
 	}
-	if order.Quantity <= 0 {
-		return errors.New("invalid order quantity")
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("order %s: %w", order.ID.Hex(), errStaleFill)
 	}
-
 	return nil
 }
@@ -0,0 +1,109 @@
+package orderbook
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFillOrder(t *testing.T) {
+	trade := func(id string, quantity int64, price float64) TradeHistoryModel {
+		return TradeHistoryModel{Id: id, Quantity: quantity, Price: price, ExecutedAt: time.UnixMilli(1700000000000)}
+	}
+	tests := []struct {
+		name     string
+		order    OrderModel
+		trade    TradeHistoryModel
+		status   OrderStatus
+		filled   int64
+		average  float64
+		illegal  bool
+		fillsLen int
+	}{
+		{"partial fill of an open order",
+			OrderModel{Quantity: 10, RemainingQty: 10, Status: Open},
+			trade("t1", 4, 100), Partial, 4, 100, false, 1},
+		{"full fill of an open order",
+			OrderModel{Quantity: 10, RemainingQty: 10, Status: Open},
+			trade("t1", 10, 100), Filled, 10, 100, false, 1},
+		{"partial order filled at another price",
+			OrderModel{Quantity: 10, RemainingQty: 6, FilledQty: 4, FilledVolume: 400, FilledAverage: 100,
+				FilledOrders: []string{"t1"}, Status: Partial},
+			trade("t2", 6, 110), Filled, 10, 106, false, 2},
+		{"partial order filled again partially",
+			OrderModel{Quantity: 10, RemainingQty: 6, FilledQty: 4, FilledVolume: 400, FilledAverage: 100,
+				FilledOrders: []string{"t1"}, Status: Partial},
+			trade("t2", 2, 100), Partial, 6, 100, false, 2},
+		{"cancelled order",
+			OrderModel{Quantity: 10, RemainingQty: 10, Status: Cancelled},
+			trade("t1", 4, 100), Cancelled, 0, 0, true, 0},
+		{"filled order",
+			OrderModel{Quantity: 10, FilledQty: 10, FilledVolume: 1000, FilledAverage: 100,
+				FilledOrders: []string{"t1"}, Status: Filled},
+			trade("t2", 1, 100), Filled, 10, 100, true, 1},
+		{"expired order",
+			OrderModel{Quantity: 10, RemainingQty: 10, Status: Expired},
+			trade("t1", 10, 100), Expired, 0, 0, true, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			order := test.order
+			order.ID = primitive.NewObjectID()
+			err := fillOrder(&order, test.trade)
+			if test.illegal != errors.Is(err, ErrIllegalTransition) || (!test.illegal && err != nil) {
+				t.Fatalf("fillOrder() = %v, want illegal %v", err, test.illegal)
+			}
+			if order.Status != test.status || order.FilledQty != test.filled || order.FilledAverage != test.average ||
+				len(order.FilledOrders) != test.fillsLen {
+				t.Fatalf("order after fill %+v", order)
+			}
+			if test.illegal {
+				return
+			}
+			if order.RemainingQty != order.Quantity-order.FilledQty || order.FilledOrder == nil || order.FilledOrder.OrderID != test.trade.Id ||
+				order.FilledOrder.Quantity != float64(test.trade.Quantity) || order.FilledOrder.Timestamp != 1700000000000 {
+				t.Fatalf("order after fill %+v, latest execution %+v", order, order.FilledOrder)
+			}
+		})
+	}
+}
+
+func TestFillOrdersAllOrNothing(t *testing.T) {
+	tests := []struct {
+		name       string
+		buyStatus  OrderStatus
+		sellStatus OrderStatus
+		applied    bool
+	}{
+		{"both open", Open, Open, true},
+		{"buy cancelled", Cancelled, Open, false},
+		{"sell cancelled", Partial, Cancelled, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buy := OrderModel{ID: primitive.NewObjectID(), Side: "Buy", Price: 101, Quantity: 5, Status: test.buyStatus, CreationTime: 1}
+			sell := OrderModel{ID: primitive.NewObjectID(), Side: "Sell", Price: 100, Quantity: 3, Status: test.sellStatus, CreationTime: 2}
+			buyBefore, sellBefore := buy, sell
+			trade := newTrade(&buy, &sell, 0)
+			if trade.Quantity != 3 || trade.Price != 101 || trade.MakerSide != "Buy" {
+				t.Fatalf("trade %+v, want 3 at the maker price 101", trade)
+			}
+			err := fillOrders(&buy, &sell, trade)
+			if (err == nil) != test.applied {
+				t.Fatalf("fillOrders() = %v, want applied %v", err, test.applied)
+			}
+			if test.applied {
+				if buy.FilledQty != 3 || buy.Status != Partial || sell.FilledQty != 3 || sell.Status != Filled {
+					t.Fatalf("buy %+v, sell %+v", buy, sell)
+				}
+				return
+			}
+			if buy.FilledQty != buyBefore.FilledQty || buy.Status != buyBefore.Status ||
+				sell.FilledQty != sellBefore.FilledQty || sell.Status != sellBefore.Status {
+				t.Fatalf("refused trade changed the orders: buy %+v, sell %+v", buy, sell)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package orderbook
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// matcherState records the progress of the matching loop for the health checks
+var matcherState = &matcherProgress{}
+
+type matcherProgress struct {
+	mutex    sync.Mutex
+	lastLoop time.Time
+	lastPass time.Time
+}
+
+// loop marks the start of an iteration, whether or not the pass succeeds
+func (p *matcherProgress) loop() {
+	p.mutex.Lock()
+	p.lastLoop = time.Now()
+	p.mutex.Unlock()
+}
+
+// pass marks a completed matching pass
+func (p *matcherProgress) pass() {
+	p.mutex.Lock()
+	p.lastPass = time.Now()
+	p.mutex.Unlock()
+}
+
+func (p *matcherProgress) times() (time.Time, time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.lastLoop, p.lastPass
+}
+
+// MatcherDetails is shown next to the matcher checks
+type MatcherDetails struct {
+	LastLoop time.Time `json:"lastLoop"`
+	LastPass time.Time `json:"lastPass"`
+}
+
+// CheckMatcherLoop fails when the matching loop stopped iterating. A loop that
+// backs off from a failing dependency still iterates, so the allowed delay
+// includes the longest backoff.
+func CheckMatcherLoop(ctx context.Context) (interface{}, error) {
+	lastLoop, lastPass := matcherState.times()
+	details := MatcherDetails{LastLoop: lastLoop, LastPass: lastPass}
+	if lastLoop.IsZero() {
+		return details, nil
+	}
+	if delay := time.Since(lastLoop); delay > settings.Health.MaxMatcherDelay+settings.Health.RetryMaxDelay {
+		return details, fmt.Errorf("matching loop stalled for %s", delay.Round(time.Millisecond))
+	}
+	return details, nil
+}
+
+// CheckMatcher fails when no matching pass completed recently
+func CheckMatcher(ctx context.Context) (interface{}, error) {
+	lastLoop, lastPass := matcherState.times()
+	details := MatcherDetails{LastLoop: lastLoop, LastPass: lastPass}
+	if lastPass.IsZero() {
+		return details, fmt.Errorf("no matching pass completed yet")
+	}
+	if delay := time.Since(lastPass); delay > settings.Health.MaxMatcherDelay {
+		return details, fmt.Errorf("last matching pass completed %s ago", delay.Round(time.Millisecond))
+	}
+	return details, nil
+}
+
+// JournalDetails describes how far the trade consumers, positions and market
+// data, are behind the trades the matcher journaled
+type JournalDetails struct {
+	Pending    int     `json:"pending"`
+	LagSeconds float64 `json:"lagSeconds"`
+}
+
+// CheckJournal fails when a trade consumer falls too far behind
+func CheckJournal(ctx context.Context) (interface{}, error) {
+	var details JournalDetails
+	var worst string
+	for _, manager := range OrderManagers() {
+		pending, lag := manager.journalLag()
+		details.Pending += pending
+		if lag.Seconds() > details.LagSeconds {
+			details.LagSeconds = lag.Seconds()
+			worst = manager.Symbol
+		}
+	}
+	if lag := time.Duration(details.LagSeconds * float64(time.Second)); lag > settings.Health.MaxJournalLag {
+		return details, fmt.Errorf("trade consumers of %s are %s behind", worst, lag.Round(time.Millisecond))
+	}
+	return details, nil
+}
@@ -0,0 +1,49 @@
+package orderbook
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MigrateOrderStatuses rewrites legacy status spellings such as "Open" and
+// "partially_filled" to their canonical names, in orders and in the state
+// history. It is idempotent and runs at every startup.
+func MigrateOrderStatuses(ctx context.Context, mongoClient *mongo.Client) error {
+	db := mongoClient.Database(dbName)
+	for collection, fields := range map[string][]string{
+		ordersCollection:       {"status"},
+		stateChangesCollection: {"from_state", "to_state"},
+	} {
+		for _, field := range fields {
+			values, err := db.Collection(collection).Distinct(ctx, field, bson.M{})
+			if err != nil {
+				return err
+			}
+			for _, value := range values {
+				name, ok := value.(string)
+				if !ok || name == "" {
+					continue
+				}
+				status, err := ParseOrderStatus(name)
+				if err != nil {
+					orderLog.Warn("leaving unknown status", "status", name, "collection", collection, "field", field)
+					continue
+				}
+				if status.String() == name {
+					continue
+				}
+				result, err := db.Collection(collection).UpdateMany(ctx,
+					bson.M{field: name},
+					bson.M{"$set": bson.M{field: status.String()}},
+				)
+				if err != nil {
+					return err
+				}
+				orderLog.Info("migrated status", "collection", collection, "field", field, "from", name, "to", status.String(), "documents", result.ModifiedCount)
+			}
+		}
+	}
+	return nil
+}
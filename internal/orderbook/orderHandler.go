@@ -3,16 +3,22 @@ package orderbook
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/internal/config"
+	"mfus_OMV1/internal/health"
 	"mfus_OMV1/pkg/database"
-	"mfus_OMV1/utils"
 
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Define the database and collection names
@@ -21,6 +27,25 @@ var ordersCollection = "orders"
 var tradeCollection = "trades"
 var stateChangesCollection = "orders_state"
 
+// settings holds the matcher, Redis and per symbol settings
+var settings = config.Default()
+
+// Configure applies the loaded config, it must be called before the first
+// order manager is created
+func Configure(cfg *config.Config) {
+	settings = cfg
+	dbName = cfg.Mongo.Database
+	ordersCollection = cfg.Collections.Orders
+	tradeCollection = cfg.Collections.Trades
+	stateChangesCollection = cfg.Collections.StateChanges
+	countersCollection = cfg.Collections.Counters
+	snapshotsCollection = cfg.Collections.Snapshots
+	instrumentsCollection = cfg.Collections.Instruments
+	accountControlsCollection = cfg.Collections.AccountControls
+	killSwitchCollection = cfg.Collections.KillSwitch
+	fencesCollection = cfg.Collections.Fences
+}
+
 type OrderHandlers interface {
 }
 
@@ -42,33 +67,11 @@ func CreateOrderHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set default values for order
-	order.Status = "Open"
-	order.CreationTime = utils.GetCurrentTimestamp()
-	order.UpdateTime = utils.GetCurrentTimestamp()
-
-	client, err := database.GetMongoClient()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	result, err := client.Database(dbName).Collection(ordersCollection).InsertOne(context.Background(), order)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Add initial state change
-	stateChange := StateChange{
-		OrderID:   result.InsertedID.(primitive.ObjectID),
-		FromState: "",
-		ToState:   string(Open),
-		CreatedAt: time.Now(),
-	}
-	_, err = client.Database(dbName).Collection(stateChangesCollection).InsertOne(context.Background(), stateChange)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	order, err = PlaceOrder(ctx, order)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeOrderError(w, err)
 		return
 	}
 
@@ -79,34 +82,30 @@ func CreateOrderHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetOrdersHandler(w http.ResponseWriter, r *http.Request) {
-	// Get all orders from MongoDB
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	client, err := database.GetMongoClient()
+	// Query orders from MongoDB, filtered and paginated by the URL parameters
+	query, err := ParseOrderQuery(r.URL.Query())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if principal, _ := auth.FromContext(r.Context()); !principal.Has(auth.PermAdmin) {
+		query.UserID = principal.UserID
+	}
 
-	cursor, err := client.Database(dbName).Collection(ordersCollection).Find(ctx, bson.M{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer cursor.Close(ctx)
-	// Decode orders from cursor
-	var orders []OrderModel
-	for cursor.Next(ctx) {
-		var order OrderModel
 
-		err := cursor.Decode(&order)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		orders = append(orders, order)
+	page, err := QueryOrders(ctx, client, query)
+	if errors.Is(err, ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	if err := cursor.Err(); err != nil {
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -114,7 +113,7 @@ func GetOrdersHandler(w http.ResponseWriter, r *http.Request) {
 	// Return orders
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(orders)
+	json.NewEncoder(w).Encode(page)
 
 }
 
@@ -128,23 +127,11 @@ func GetOrderHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get order from MongoDB
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	var order OrderModel
-
-	client, err := database.GetMongoClient()
+	order, err := GetOrder(ctx, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	err = client.Database(dbName).Collection(ordersCollection).FindOne(ctx, bson.M{"_id": id}).Decode(&order)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			http.Error(w, "Order not found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+		writeOrderError(w, err)
 		return
 	}
 
@@ -163,9 +150,6 @@ func UpdateOpenOrderHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update order status to "cancelled"
-	filter := bson.M{"_id": id, "status": "open"}
-
 	// Parse order from request body
 	var orderUpdates bson.M
 	err = json.NewDecoder(r.Body).Decode(&orderUpdates)
@@ -174,85 +158,55 @@ func UpdateOpenOrderHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set updated_at timestamp
-	orderUpdates["updated_at"] = time.Now()
-
 	// Update order in MongoDB
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	client, err := database.GetMongoClient()
+	amended, err := AmendOrder(ctx, id, orderUpdates)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeOrderError(w, err)
 		return
 	}
 
-	result, err := client.Database(dbName).Collection(ordersCollection).UpdateOne(ctx, filter, bson.M{"$set": orderUpdates})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if result.ModifiedCount == 0 {
-		http.Error(w, "Order not found", http.StatusNotFound)
-		return
-	}
-
-	// Get current state of order
-	var order OrderModel
-	err = client.Database(dbName).Collection(ordersCollection).FindOne(ctx, bson.M{"_id": id}).Decode(&order)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Check if state has changed
-	currentState := order.Status
-	newState := orderUpdates["Status"]
-	if currentState != newState {
-		// Add state change to state changes collection
-		stateChange := StateChange{
-			OrderID:   id,
-			FromState: currentState,
-			ToState:   newState.(string),
-			CreatedAt: time.Now(),
-		}
-		_, err = client.Database(dbName).Collection(stateChangesCollection).InsertOne(ctx, stateChange)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	}
-
 	// Return updated order
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(orderUpdates)
+	json.NewEncoder(w).Encode(amended)
 }
 
 func GetOpenOrdersHandler(w http.ResponseWriter, r *http.Request) {
-	var order OrderModel
-	err := json.NewDecoder(r.Body).Decode(&order)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	filter := bson.M{"status": openStatusFilter}
+	if symbol := r.URL.Query().Get("symbol"); symbol != "" {
+		filter["symbol"] = symbol
 	}
-
-	filter := bson.M{
-		"symbol": order.Symbol,
-		"side":   order.Side,
-		"status": "open",
+	if side := r.URL.Query().Get("side"); side != "" {
+		side, err := normalizeSide(side)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter["side"] = side
+	}
+	if principal, _ := auth.FromContext(r.Context()); !principal.Has(auth.PermAdmin) {
+		filter["userID"] = principal.UserID
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 	client, err := database.GetMongoClient()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	cursor, err := client.Database(dbName).Collection(ordersCollection).Find(context.Background(), filter)
+	opts := options.Find().SetSort(bson.D{{Key: "creationTime", Value: 1}}).SetLimit(MaxQueryLimit)
+	cursor, err := client.Database(dbName).Collection(ordersCollection).Find(ctx, filter, opts)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	var orders []OrderModel
-	if err = cursor.All(context.Background(), &orders); err != nil {
+	orders := make([]OrderModel, 0)
+	if err = cursor.All(ctx, &orders); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -264,63 +218,125 @@ func GetOpenOrdersHandler(w http.ResponseWriter, r *http.Request) {
 
 func DeleteOrderHandler(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	id, _ := primitive.ObjectIDFromHex(params["id"])
-
-	client, err := database.GetMongoClient()
+	id, err := primitive.ObjectIDFromHex(params["id"])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	result, err := client.Database(dbName).Collection(ordersCollection).DeleteOne(context.Background(), bson.M{"_id": id, "status": "open"})
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	deleted, err := DeleteOrder(ctx, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeOrderError(w, err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(result.DeletedCount)
+	if !deleted {
+		json.NewEncoder(w).Encode(0)
+		return
+	}
+	json.NewEncoder(w).Encode(1)
 }
 
-// To Do: Add the CancelOrder function here to handle the In Memory
 func CancelOrderHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	// Get order ID from URL parameters
 	params := mux.Vars(r)
-	orderID := params["id"]
+	orderID, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	order, err := CancelOrder(ctx, orderID, "cancelled by user")
+	if err != nil {
+		writeOrderError(w, err)
+		return
+	}
 
-	// Update order status to "cancelled"
-	filter := bson.M{"_id": orderID, "status": "open"}
-	update := bson.M{"$set": bson.M{"status": "cancelled"}}
+	// Return the updated order
+	json.NewEncoder(w).Encode(order)
+}
+
+func GetOrderHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-
 	client, err := database.GetMongoClient()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	result, err := client.Database(dbName).Collection(ordersCollection).UpdateOne(ctx, filter, update)
-	if err != nil {
+	var order OrderModel
+	err = client.Database(dbName).Collection(ordersCollection).FindOne(ctx, bson.M{"_id": id}).Decode(&order)
+	if err != nil && err != mongo.ErrNoDocuments {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	if result.ModifiedCount == 0 {
-		http.Error(w, "Order not found or already closed", http.StatusNotFound)
+	// Deleted orders keep their history, only admins can read it
+	if err == mongo.ErrNoDocuments {
+		order.UserID = ""
+	}
+	if !auth.AuthorizeUser(w, r, order.UserID) {
 		return
 	}
 
-	// Return the updated order
-	filter = bson.M{"_id": orderID}
-	var order OrderModel
-	err = client.Database(dbName).Collection(ordersCollection).FindOne(ctx, filter).Decode(&order)
+	history, err := GetOrderHistory(ctx, client, id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(order)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(history)
+}
+
+// cancelConflict explains why an existing order could not be cancelled
+func cancelConflict(order OrderModel) error {
+	if err := order.Status.Transition(Cancelled); err != nil {
+		return err
+	}
+	// The order was cancellable when read but changed state before the update
+	return fmt.Errorf("%w: order %s changed state concurrently", ErrIllegalTransition, order.ID.Hex())
+}
+
+// writeOrderError maps an order entry error to its HTTP status
+func writeOrderError(w http.ResponseWriter, err error) {
+	var rateLimited *RateLimitError
+	var invalid *InvalidOrderError
+	switch {
+	case errors.As(err, &rateLimited):
+		w.Header().Set("Retry-After", strconv.Itoa(rateLimited.Seconds()))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.As(err, &invalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrOrderNotFound):
+		http.Error(w, "Order not found", http.StatusNotFound)
+	case errors.Is(err, ErrIllegalTransition), errors.Is(err, ErrSymbolHalted), errors.Is(err, ErrKillSwitch),
+		errors.Is(err, ErrInstrumentExists), errors.Is(err, ErrOrderChanged):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrUserSuspended):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, auth.ErrUnauthenticated):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, auth.ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, health.ErrNotReady):
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
 }
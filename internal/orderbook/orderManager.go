@@ -0,0 +1,270 @@
+package orderbook
+
+import (
+	"container/list"
+	"context"
+	"mfus_OMV1/internal/logging"
+	"mfus_OMV1/internal/metrics"
+	"mfus_OMV1/pkg/database"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Loggers of the engine components
+var (
+	orderLog   = logging.Logger("orders")
+	matcherLog = logging.Logger("matcher")
+	wsLog      = logging.Logger("websocket")
+)
+
+// TradeListener is notified of every trade executed by the matcher
+type TradeListener func(trade TradeHistoryModel)
+
+var managersMutex sync.Mutex
+var managers = make(map[string]*OrderManagerModel)
+
+// Order events passed to order listeners
+const (
+	EventAccepted  = "accepted"
+	EventRejected  = "rejected"
+	EventAmended   = "amended"
+	EventCancelled = "cancelled"
+	EventFilled    = "filled"
+	EventExpired   = "expired"
+)
+
+// OrderListener is notified of every change to an order. For EventFilled the
+// order's FilledOrder holds the execution that caused the change.
+type OrderListener func(event string, order OrderModel)
+
+// BookListener is notified when the resting orders of a symbol change
+type BookListener func(depth DepthModel)
+
+var listenersMutex sync.RWMutex
+var tradeListeners []TradeListener
+var orderListeners []OrderListener
+var bookListeners []BookListener
+
+// OnTrade registers a listener for trades of every symbol
+func OnTrade(listener TradeListener) {
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+	tradeListeners = append(tradeListeners, listener)
+}
+
+// OnOrderUpdate registers a listener for order changes of every user. Listeners
+// are called on the order entry and matching paths and must not block.
+func OnOrderUpdate(listener OrderListener) {
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+	orderListeners = append(orderListeners, listener)
+}
+
+// OnBookUpdate registers a listener for the L2 depth of every symbol. Listeners
+// are called by the matcher and must not block.
+func OnBookUpdate(listener BookListener) {
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+	bookListeners = append(bookListeners, listener)
+}
+
+// publishOrder pushes the new state of an order to its owner and the listeners
+func publishOrder(event string, order OrderModel) {
+	PublishPrivate(order.UserID, "orders", order)
+
+	listenersMutex.RLock()
+	listeners := orderListeners
+	listenersMutex.RUnlock()
+	for _, listener := range listeners {
+		listener(event, order)
+	}
+}
+
+// GetOrderManager returns the manager of a symbol, creating it on first use
+func GetOrderManager(symbol string) *OrderManagerModel {
+	managersMutex.Lock()
+	defer managersMutex.Unlock()
+	manager, ok := managers[symbol]
+	if !ok {
+		manager = NewOrderManager(symbol)
+		managers[symbol] = manager
+	}
+	return manager
+}
+
+// FindOrderManager returns the manager of a symbol without creating one
+func FindOrderManager(symbol string) (*OrderManagerModel, bool) {
+	managersMutex.Lock()
+	defer managersMutex.Unlock()
+	manager, ok := managers[symbol]
+	return manager, ok
+}
+
+// OrderManagers returns the managers of every symbol seen so far
+func OrderManagers() []*OrderManagerModel {
+	managersMutex.Lock()
+	defer managersMutex.Unlock()
+	result := make([]*OrderManagerModel, 0, len(managers))
+	for _, manager := range managers {
+		result = append(result, manager)
+	}
+	return result
+}
+
+// NewOrderManager creates the manager of a symbol and starts dispatching its trades
+func NewOrderManager(symbol string) *OrderManagerModel {
+	ctx, cancel := context.WithCancel(context.Background())
+	manager := &OrderManagerModel{
+		Symbol:       symbol,
+		Interval:     settings.Matcher.Interval,
+		MaxTPS:       settings.Symbol(symbol).MaxTPS,
+		Ctx:          ctx,
+		Cancel:       cancel,
+		Orders:       make(map[string]*OrderModel),
+		BuyOrders:    make([]*OrderModel, 0),
+		SellOrders:   make([]*OrderModel, 0),
+		FilledOrders: make([]*OrderModel, 0),
+		tradeChan:    make(chan *TradeHistoryModel, 1024),
+		stopChan:     make(chan struct{}),
+		recorded:     make(map[string]bool),
+	}
+	manager.book.Store(&OrderBookModel{Bids: list.New(), Asks: list.New(), Trades: list.New()})
+	manager.loadLastTrade()
+	go manager.dispatchTrades()
+	return manager
+}
+
+// loadLastTrade seeds the last trade fields from the trades collection after a restart
+func (m *OrderManagerModel) loadLastTrade() {
+	client, err := database.GetMongoClient()
+	if err != nil {
+		return
+	}
+	var trade TradeHistoryModel
+	err = client.Database(dbName).Collection(tradeCollection).FindOne(context.Background(),
+		bson.M{"symbol": m.Symbol},
+		options.FindOne().SetSort(bson.D{{Key: "executed_at", Value: -1}}),
+	).Decode(&trade)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			orderLog.Error("cannot load last trade", "symbol", m.Symbol, "error", err)
+		}
+		return
+	}
+	m.LastTradeID = trade.Id
+	m.LastTradePrice = trade.Price
+	m.LastTradeTime = trade.ExecutedAt
+	m.recorded[trade.Id] = true
+}
+
+// publishTrade records a trade on the manager and queues it for the listeners.
+// Positions and candles are built from every trade, so the matcher waits while
+// the queue is full rather than dropping one.
+func (m *OrderManagerModel) publishTrade(trade TradeHistoryModel) {
+	m.recordTrade(trade)
+	observeTrade(trade)
+
+	select {
+	case m.tradeChan <- &trade:
+	case <-m.Ctx.Done():
+		orderLog.Error("manager stopped, trade not dispatched", "symbol", m.Symbol, "trade", trade.Id)
+	}
+}
+
+func observeTrade(trade TradeHistoryModel) {
+	metrics.Trades.WithLabelValues(trade.Symbol).Inc()
+	metrics.MatchedVolume.WithLabelValues(trade.Symbol).Add(float64(trade.Quantity))
+	metrics.MatchedNotional.WithLabelValues(trade.Symbol).Add(float64(trade.Quantity) * trade.Price)
+}
+
+// recordTrade moves the last trade and the totals of the manager forward,
+// trades older than the last one or already recorded are ignored
+func (m *OrderManagerModel) recordTrade(trade TradeHistoryModel) {
+	m.TradeMutex.Lock()
+	defer m.TradeMutex.Unlock()
+	// Trades are read back with millisecond precision
+	if m.recorded[trade.Id] || trade.ExecutedAt.Before(m.LastTradeTime.Truncate(time.Millisecond)) {
+		return
+	}
+	if trade.ExecutedAt.After(m.LastTradeTime) {
+		m.recorded = make(map[string]bool)
+	}
+	m.recorded[trade.Id] = true
+	m.LastTradeID = trade.Id
+	m.LastTradePrice = trade.Price
+	m.LastTradeTime = trade.ExecutedAt
+	m.TradeCount++
+	m.TotalTradeVolume += float64(trade.Quantity)
+}
+
+// LastPrice returns the price of the most recent trade of the symbol
+func (m *OrderManagerModel) LastPrice() float64 {
+	m.TradeMutex.Lock()
+	defer m.TradeMutex.Unlock()
+	return m.LastTradePrice
+}
+
+// TradeStats returns the number and volume of trades executed since the manager started
+func (m *OrderManagerModel) TradeStats() (int, float64) {
+	m.TradeMutex.Lock()
+	defer m.TradeMutex.Unlock()
+	return m.TradeCount, m.TotalTradeVolume
+}
+
+// journalLag returns the trades waiting for the listeners or a pipeline stage
+// and how long ago the trade being applied executed
+func (m *OrderManagerModel) journalLag() (int, time.Duration) {
+	m.TradeMutex.Lock()
+	defer m.TradeMutex.Unlock()
+	var lag time.Duration
+	if !m.dispatching.IsZero() {
+		lag = time.Since(m.dispatching)
+	}
+	pending := len(m.tradeChan)
+	if e := m.engine.Load(); e != nil {
+		pending += e.results.Lag()
+	}
+	return pending, lag
+}
+
+// dispatchTrades hands trades to the listeners until the manager is stopped,
+// then flushes the queued trades and cancels the manager's context
+func (m *OrderManagerModel) dispatchTrades() {
+	defer m.Cancel()
+	for {
+		select {
+		case trade := <-m.tradeChan:
+			m.dispatch(trade)
+		case <-m.stopChan:
+			for {
+				select {
+				case trade := <-m.tradeChan:
+					m.dispatch(trade)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (m *OrderManagerModel) dispatch(trade *TradeHistoryModel) {
+	m.TradeMutex.Lock()
+	m.dispatching = trade.ExecutedAt
+	m.TradeMutex.Unlock()
+
+	listenersMutex.RLock()
+	listeners := tradeListeners
+	listenersMutex.RUnlock()
+	for _, listener := range listeners {
+		listener(*trade)
+	}
+
+	m.TradeMutex.Lock()
+	m.dispatching = time.Time{}
+	m.TradeMutex.Unlock()
+}
@@ -0,0 +1,246 @@
+package orderbook
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Page size limits of the order query API
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+// ErrInvalidCursor is returned for a cursor that was not issued for the query
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// sortFields maps the sort query parameter to the document field
+var sortFields = map[string]string{
+	"creationTime": "creationTime",
+	"updateTime":   "updateTime",
+	"price":        "price",
+	"quantity":     "quantity",
+}
+
+// OrderQuery is a filtered, sorted and paginated query on the orders collection
+type OrderQuery struct {
+	UserID     string
+	Symbol     string
+	Side       string
+	Status     string
+	Type       string
+	From       int64
+	To         int64
+	SortField  string
+	Descending bool
+	Limit      int64
+	Cursor     string
+}
+
+// OrderPage is one page of an order query
+type OrderPage struct {
+	Orders     []OrderModel `json:"orders"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+// orderCursor is the decoded form of the opaque cursor. It pins the sort so a
+// cursor cannot be replayed against a different ordering.
+type orderCursor struct {
+	Sort       string             `json:"s"`
+	Descending bool               `json:"d"`
+	Value      interface{}        `json:"v"`
+	ID         primitive.ObjectID `json:"i"`
+}
+
+// ParseOrderQuery reads an order query from URL query parameters
+func ParseOrderQuery(values url.Values) (OrderQuery, error) {
+	query := OrderQuery{
+		UserID:     values.Get("userID"),
+		Symbol:     values.Get("symbol"),
+		Side:       values.Get("side"),
+		Status:     values.Get("status"),
+		Type:       values.Get("type"),
+		SortField:  "creationTime",
+		Descending: true,
+		Limit:      DefaultQueryLimit,
+		Cursor:     values.Get("cursor"),
+	}
+
+	var err error
+	if query.Status != "" {
+		status, err := ParseOrderStatus(query.Status)
+		if err != nil {
+			return OrderQuery{}, err
+		}
+		query.Status = status.String()
+	}
+	if query.Side != "" {
+		if query.Side, err = normalizeSide(query.Side); err != nil {
+			return OrderQuery{}, err
+		}
+	}
+	if v := values.Get("from"); v != "" {
+		if query.From, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return OrderQuery{}, errors.New("from must be a timestamp in milliseconds")
+		}
+	}
+	if v := values.Get("to"); v != "" {
+		if query.To, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return OrderQuery{}, errors.New("to must be a timestamp in milliseconds")
+		}
+	}
+	if v := values.Get("sort"); v != "" {
+		if _, ok := sortFields[v]; !ok {
+			return OrderQuery{}, errors.New("cannot sort by " + v)
+		}
+		query.SortField = v
+	}
+	switch values.Get("order") {
+	case "", "desc":
+	case "asc":
+		query.Descending = false
+	default:
+		return OrderQuery{}, errors.New("order must be asc or desc")
+	}
+	if v := values.Get("limit"); v != "" {
+		if query.Limit, err = strconv.ParseInt(v, 10, 64); err != nil || query.Limit <= 0 {
+			return OrderQuery{}, errors.New("limit must be a positive number")
+		}
+		if query.Limit > MaxQueryLimit {
+			query.Limit = MaxQueryLimit
+		}
+	}
+	return query, nil
+}
+
+// QueryOrders runs an order query and returns one page with the cursor of the next
+func QueryOrders(ctx context.Context, mongoClient *mongo.Client, query OrderQuery) (OrderPage, error) {
+	field := sortFields[query.SortField]
+	filter := bson.M{}
+	for key, value := range map[string]string{
+		"userID": query.UserID,
+		"symbol": query.Symbol,
+		"side":   query.Side,
+		"status": query.Status,
+		"type":   query.Type,
+	} {
+		if value != "" {
+			filter[key] = value
+		}
+	}
+	if query.From > 0 || query.To > 0 {
+		created := bson.M{}
+		if query.From > 0 {
+			created["$gte"] = query.From
+		}
+		if query.To > 0 {
+			created["$lt"] = query.To
+		}
+		filter["creationTime"] = created
+	}
+
+	direction, operator := 1, "$gt"
+	if query.Descending {
+		direction, operator = -1, "$lt"
+	}
+	if query.Cursor != "" {
+		cursor, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return OrderPage{}, err
+		}
+		if cursor.Sort != query.SortField || cursor.Descending != query.Descending {
+			return OrderPage{}, fmt.Errorf("%w: it does not match the requested sort", ErrInvalidCursor)
+		}
+		// Resume strictly after the last document, breaking ties on _id
+		filter["$or"] = bson.A{
+			bson.M{field: bson.M{operator: cursor.Value}},
+			bson.M{field: cursor.Value, "_id": bson.M{operator: cursor.ID}},
+		}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: field, Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(query.Limit + 1)
+	cursor, err := mongoClient.Database(dbName).Collection(ordersCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return OrderPage{}, err
+	}
+	page := OrderPage{Orders: make([]OrderModel, 0)}
+	if err := cursor.All(ctx, &page.Orders); err != nil {
+		return OrderPage{}, err
+	}
+
+	if int64(len(page.Orders)) > query.Limit {
+		page.Orders = page.Orders[:query.Limit]
+		last := page.Orders[len(page.Orders)-1]
+		page.NextCursor, err = encodeCursor(orderCursor{
+			Sort:       query.SortField,
+			Descending: query.Descending,
+			Value:      sortValue(last, query.SortField),
+			ID:         last.ID,
+		})
+		if err != nil {
+			return OrderPage{}, err
+		}
+	}
+	return page, nil
+}
+
+func sortValue(order OrderModel, sortField string) interface{} {
+	switch sortField {
+	case "updateTime":
+		return order.UpdateTime
+	case "price":
+		return order.Price
+	case "quantity":
+		return order.Quantity
+	}
+	return order.CreationTime
+}
+
+func encodeCursor(cursor orderCursor) (string, error) {
+	raw, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// decodeCursor restores a cursor, whose value must have the type of its sort
+// field: a float64 for price and an int64 for the others
+func decodeCursor(value string) (orderCursor, error) {
+	var cursor orderCursor
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&cursor); err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	number, ok := cursor.Value.(json.Number)
+	if _, sortable := sortFields[cursor.Sort]; !ok || !sortable {
+		return cursor, ErrInvalidCursor
+	}
+	if cursor.Sort == "price" {
+		cursor.Value, err = number.Float64()
+	} else {
+		cursor.Value, err = number.Int64()
+	}
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	return cursor, nil
+}
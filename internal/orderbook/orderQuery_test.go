@@ -0,0 +1,112 @@
+package orderbook
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := primitive.NewObjectID()
+	tests := []struct {
+		name  string
+		order OrderModel
+		sort  string
+		value interface{}
+	}{
+		{"creation time", OrderModel{CreationTime: 1700000000123}, "creationTime", int64(1700000000123)},
+		{"update time", OrderModel{UpdateTime: 1700000000456}, "updateTime", int64(1700000000456)},
+		{"quantity", OrderModel{Quantity: 42}, "quantity", int64(42)},
+		{"whole price", OrderModel{Price: 100}, "price", float64(100)},
+		{"fractional price", OrderModel{Price: 0.1 + 0.2}, "price", 0.1 + 0.2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := encodeCursor(orderCursor{
+				Sort:       test.sort,
+				Descending: true,
+				Value:      sortValue(test.order, test.sort),
+				ID:         id,
+			})
+			if err != nil {
+				t.Fatalf("encodeCursor: %v", err)
+			}
+			cursor, err := decodeCursor(encoded)
+			if err != nil {
+				t.Fatalf("decodeCursor: %v", err)
+			}
+			if cursor.Sort != test.sort || !cursor.Descending || cursor.ID != id || cursor.Value != test.value {
+				t.Fatalf("decoded %+v (%T), want value %v (%T)", cursor, cursor.Value, test.value, test.value)
+			}
+		})
+	}
+}
+
+func TestDecodeMalformedCursor(t *testing.T) {
+	raw := func(json string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(json))
+	}
+	id := primitive.NewObjectID().Hex()
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "!!!"},
+		{"not JSON", raw("cursor")},
+		{"string value", raw(`{"s":"creationTime","v":"1700000000000","i":"` + id + `"}`)},
+		{"object value", raw(`{"s":"creationTime","v":{"$gt":0},"i":"` + id + `"}`)},
+		{"missing value", raw(`{"s":"creationTime","i":"` + id + `"}`)},
+		{"boolean value", raw(`{"s":"price","v":true,"i":"` + id + `"}`)},
+		{"fraction for an integer field", raw(`{"s":"quantity","v":1.5,"i":"` + id + `"}`)},
+		{"unknown sort", raw(`{"s":"userID","v":1,"i":"` + id + `"}`)},
+		{"invalid ID", raw(`{"s":"creationTime","v":1,"i":"order"}`)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decodeCursor(test.cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Fatalf("decodeCursor() = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+func TestParseOrderQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		values url.Values
+		valid  bool
+		check  func(OrderQuery) bool
+	}{
+		{"defaults", url.Values{}, true, func(q OrderQuery) bool {
+			return q.SortField == "creationTime" && q.Descending && q.Limit == DefaultQueryLimit
+		}},
+		{"legacy status", url.Values{"status": {"partially_filled"}}, true, func(q OrderQuery) bool {
+			return q.Status == "partial"
+		}},
+		{"side", url.Values{"side": {"sell"}}, true, func(q OrderQuery) bool { return q.Side == "Sell" }},
+		{"ascending price", url.Values{"sort": {"price"}, "order": {"asc"}}, true, func(q OrderQuery) bool {
+			return q.SortField == "price" && !q.Descending
+		}},
+		{"limit capped", url.Values{"limit": {"5000"}}, true, func(q OrderQuery) bool { return q.Limit == MaxQueryLimit }},
+		{"unknown status", url.Values{"status": {"pending"}}, false, nil},
+		{"unknown side", url.Values{"side": {"long"}}, false, nil},
+		{"unknown sort", url.Values{"sort": {"userID"}}, false, nil},
+		{"unknown order", url.Values{"order": {"up"}}, false, nil},
+		{"zero limit", url.Values{"limit": {"0"}}, false, nil},
+		{"from not a number", url.Values{"from": {"yesterday"}}, false, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := ParseOrderQuery(test.values)
+			if (err == nil) != test.valid {
+				t.Fatalf("ParseOrderQuery() = %v, want valid %v", err, test.valid)
+			}
+			if test.check != nil && !test.check(query) {
+				t.Fatalf("unexpected query %+v", query)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package orderbook
+
+import (
+	"context"
+	"encoding/json"
+	"mfus_OMV1/pkg/database"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RequestSymbol names the symbol an order entry request acts on, so that the
+// gateway forwards it to the node owning the symbol. The gateway runs after
+// authentication, so only authenticated callers make it look orders up.
+func RequestSymbol(r *http.Request, body []byte) (string, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "", false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return "", false
+	}
+	switch template {
+	case "/v1/orders":
+		var order struct {
+			Symbol string `json:"symbol"`
+		}
+		if r.Method != http.MethodPost || json.Unmarshal(body, &order) != nil || order.Symbol == "" {
+			return "", false
+		}
+		return order.Symbol, true
+	case "/v1/orders/{id}", "/v1/orders/{id}/cancel":
+		id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			return "", false
+		}
+		client, err := database.GetMongoClient()
+		if err != nil {
+			return "", false
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+		defer cancel()
+		var order OrderModel
+		opts := options.FindOne().SetProjection(bson.M{"symbol": 1})
+		if err := client.Database(dbName).Collection(ordersCollection).FindOne(ctx, bson.M{"_id": id}, opts).Decode(&order); err != nil {
+			return "", false
+		}
+		return order.Symbol, true
+	}
+	return "", false
+}
@@ -0,0 +1,519 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"mfus_OMV1/internal/accounts"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/internal/health"
+	"mfus_OMV1/internal/logging"
+	"mfus_OMV1/internal/metrics"
+	"mfus_OMV1/pkg/database"
+	"mfus_OMV1/utils"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The order entry functions below are shared by every gateway (REST, gRPC)
+// so that validation, risk checks and the engine path are the same for all
+// of them. The caller is always the principal carried by the context.
+
+var ErrOrderNotFound = errors.New("order not found")
+
+// ErrOrderChanged is returned when an order was filled or cancelled while it
+// was being amended
+var ErrOrderChanged = errors.New("order changed while it was amended, retry")
+
+// amendableFields are the fields an amendment may change
+var amendableFields = map[string]bool{"price": true, "quantity": true, "clientOrderID": true, "expiration": true}
+
+// InvalidOrderError is returned when an order or amendment is refused before
+// it reaches the book, e.g. it is malformed or cannot be funded
+type InvalidOrderError struct {
+	Err error
+}
+
+func (e *InvalidOrderError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InvalidOrderError) Unwrap() error {
+	return e.Err
+}
+
+func invalidOrder(err error) error {
+	return &InvalidOrderError{Err: err}
+}
+
+// PlaceOrder validates a new order, reserves its funds and hands it to the
+// matcher. Orders that cannot be funded are stored as rejected and returned
+// together with the error.
+func PlaceOrder(ctx context.Context, order OrderModel) (OrderModel, error) {
+	placed, err := placeOrder(ctx, order)
+	if err != nil {
+		reason := rejectReason(err)
+		metrics.OrdersRejected.WithLabelValues(placed.Symbol, reason).Inc()
+		orderLog.InfoContext(ctx, "order rejected", "symbol", placed.Symbol, "side", placed.Side, "reason", reason, "error", err)
+	} else {
+		metrics.OrdersAccepted.WithLabelValues(placed.Symbol).Inc()
+		orderLog.InfoContext(ctx, "order accepted", "order", placed.ID.Hex(), "symbol", placed.Symbol,
+			"side", placed.Side, "price", placed.Price, "quantity", placed.Quantity, "user", placed.UserID)
+	}
+	return placed, err
+}
+
+// rejectReason classifies an order entry error for the metrics
+func rejectReason(err error) string {
+	var rateLimited *RateLimitError
+	var invalid *InvalidOrderError
+	switch {
+	case errors.As(err, &rateLimited):
+		return metrics.ReasonRateLimited
+	case errors.Is(err, ErrSymbolHalted), errors.Is(err, ErrKillSwitch):
+		return metrics.ReasonHalted
+	case errors.Is(err, ErrUserSuspended):
+		return metrics.ReasonSuspended
+	case errors.Is(err, ErrRiskLimit):
+		return metrics.ReasonRiskLimit
+	case errors.Is(err, accounts.ErrInsufficientFunds):
+		return metrics.ReasonInsufficientFunds
+	case errors.As(err, &invalid):
+		return metrics.ReasonInvalid
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return metrics.ReasonUnauthenticated
+	case errors.Is(err, ErrOverloaded):
+		return metrics.ReasonOverloaded
+	case errors.Is(err, health.ErrNotReady):
+		return metrics.ReasonNotReady
+	}
+	return metrics.ReasonError
+}
+
+func placeOrder(ctx context.Context, order OrderModel) (OrderModel, error) {
+	// Orders always belong to the authenticated caller
+	principal, ok := auth.FromContext(ctx)
+	if !ok {
+		return order, auth.ErrUnauthenticated
+	}
+	order.UserID = principal.UserID
+	order.CorrelationID = logging.CorrelationID(ctx)
+	// New risk is refused until the dependencies and the matcher are healthy
+	if !health.Ready() {
+		return order, health.ErrNotReady
+	}
+	if !beginEntry() {
+		return order, ErrShuttingDown
+	}
+	defer endEntry()
+
+	if err := validateOrder(&order); err != nil {
+		return order, invalidOrder(err)
+	}
+
+	// Set default values for order
+	order.ID = primitive.NewObjectID()
+	order.Status = Open
+	order.RemainingQty = order.Quantity
+	order.FilledQty = 0
+	order.CreationTime = utils.GetCurrentTimestamp()
+	order.UpdateTime = utils.GetCurrentTimestamp()
+
+	client, err := database.GetMongoClient()
+	if err != nil {
+		return order, err
+	}
+	if err := checkKillSwitch(ctx, client); err != nil {
+		return order, err
+	}
+	if err := checkOrderAction(ctx, client, ActionPlace, order.UserID, order.Symbol); err != nil {
+		return order, err
+	}
+	if err := checkInstrument(ctx, client, order); err != nil {
+		return order, err
+	}
+	if err := checkAccount(ctx, client, order, true); err != nil {
+		return order, err
+	}
+	if err := admitOrder(order.Symbol); err != nil {
+		return order, err
+	}
+
+	// Hold the funds the order needs and store it in one transaction, so funds
+	// are never held for an order that was not stored. Orders that cannot be
+	// funded are kept as rejected so that they show up in the history.
+	var unfunded error
+	err = database.Transaction(ctx, client, func(ctx context.Context) error {
+		unfunded = reserveOrder(ctx, client, order)
+		if unfunded != nil {
+			return unfunded
+		}
+		_, err := client.Database(dbName).Collection(ordersCollection).InsertOne(ctx, order)
+		return err
+	})
+	if unfunded != nil {
+		order.Status = Rejected
+		if _, insertErr := client.Database(dbName).Collection(ordersCollection).InsertOne(ctx, order); insertErr == nil {
+			logStateChange(ctx, client, order.ID, "", Rejected, unfunded.Error())
+			publishOrder(EventRejected, order)
+		}
+		return order, invalidOrder(unfunded)
+	}
+	if err != nil {
+		return order, err
+	}
+
+	// Add initial state change
+	err = recordStateChange(ctx, client, order.ID, "", Open, "accepted")
+	publishOrder(EventAccepted, order)
+	submitOrder(ctx, commandPlace, order)
+	return order, err
+}
+
+// validateOrder checks a new order and puts its side and type in the form
+// used by the matcher. Only limit orders are supported, an order without a
+// type is one.
+func validateOrder(order *OrderModel) error {
+	var err error
+	order.Side, err = normalizeSide(order.Side)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(order.Type) {
+	case "", "limit":
+		order.Type = "Limit"
+	default:
+		return errors.New("unsupported order type " + order.Type)
+	}
+	if order.Symbol == "" {
+		return errors.New("invalid order symbol")
+	}
+	if order.Price <= 0 {
+		return errors.New("invalid order price")
+	}
+	if order.Quantity <= 0 {
+		return errors.New("invalid order quantity")
+	}
+	return nil
+}
+
+// GetOrder returns an order of the caller, or of anyone for admins
+func GetOrder(ctx context.Context, id primitive.ObjectID) (OrderModel, error) {
+	var order OrderModel
+	client, err := database.GetMongoClient()
+	if err != nil {
+		return order, err
+	}
+	err = client.Database(dbName).Collection(ordersCollection).FindOne(ctx, bson.M{"_id": id}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return order, ErrOrderNotFound
+	}
+	if err != nil {
+		return order, err
+	}
+	return order, auth.CheckUser(ctx, order.UserID)
+}
+
+// FindOrderByClientID returns the most recent order of the caller with a client order ID
+func FindOrderByClientID(ctx context.Context, clientOrderID string) (OrderModel, error) {
+	var order OrderModel
+	principal, ok := auth.FromContext(ctx)
+	if !ok {
+		return order, auth.ErrUnauthenticated
+	}
+	client, err := database.GetMongoClient()
+	if err != nil {
+		return order, err
+	}
+	err = client.Database(dbName).Collection(ordersCollection).FindOne(ctx,
+		bson.M{"userID": principal.UserID, "clientOrderID": clientOrderID},
+		options.FindOne().SetSort(bson.D{{Key: "creationTime", Value: -1}}),
+	).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return order, ErrOrderNotFound
+	}
+	return order, err
+}
+
+// AmendOrder changes the price, quantity, client order ID or expiration of an
+// open order, re-reserving its funds when the price or quantity changes. It
+// returns the order as amended.
+func AmendOrder(ctx context.Context, id primitive.ObjectID, updates bson.M) (OrderModel, error) {
+	var amended OrderModel
+	// Cancels stay allowed while not ready, amends may add risk
+	if !health.Ready() {
+		return amended, health.ErrNotReady
+	}
+	if !beginEntry() {
+		return amended, ErrShuttingDown
+	}
+	defer endEntry()
+
+	// Only these fields may be amended, the others are kept by the engine or
+	// tie the reservation to its owner, symbol and side
+	for key := range updates {
+		if !amendableFields[key] {
+			return amended, invalidOrder(errors.New(key + " cannot be updated"))
+		}
+	}
+
+	client, err := database.GetMongoClient()
+	if err != nil {
+		return amended, err
+	}
+	if err := checkKillSwitch(ctx, client); err != nil {
+		return amended, err
+	}
+
+	var previous OrderModel
+	err = client.Database(dbName).Collection(ordersCollection).FindOne(ctx, bson.M{"_id": id, "status": openStatusFilter}).Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		return amended, ErrOrderNotFound
+	}
+	if err != nil {
+		return amended, err
+	}
+	if err := auth.CheckUser(ctx, previous.UserID); err != nil {
+		return amended, err
+	}
+	if err := checkOrderAction(ctx, client, ActionAmend, previous.UserID, previous.Symbol); err != nil {
+		return amended, err
+	}
+
+	amended, err = amendedOrder(previous, updates)
+	if err != nil {
+		return previous, invalidOrder(err)
+	}
+	if err := checkInstrument(ctx, client, amended); err != nil {
+		return previous, err
+	}
+	if err := checkAccount(ctx, client, amended, false); err != nil {
+		return previous, err
+	}
+
+	// The reservation is swapped and the order amended in one transaction,
+	// and only as the order was read: a fill, cancel or other amend in between
+	// would otherwise be overwritten or settle against the wrong reservation
+	err = database.Transaction(ctx, client, func(ctx context.Context) error {
+		if amended.Price != previous.Price || amended.Quantity != previous.Quantity {
+			if err := releaseOrder(ctx, client, previous); err != nil {
+				return err
+			}
+			if err := reserveRemaining(ctx, client, amended); err != nil {
+				return invalidOrder(err)
+			}
+		}
+		filter := bson.M{"_id": id, "status": openStatusFilter, "filledQty": previous.FilledQty,
+			"price": previous.Price, "quantity": previous.Quantity}
+		result, err := client.Database(dbName).Collection(ordersCollection).UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+			"price":         amended.Price,
+			"quantity":      amended.Quantity,
+			"remainingQty":  amended.RemainingQty,
+			"clientOrderID": amended.ClientOrderID,
+			"expiration":    amended.Expiration,
+			"updateTime":    amended.UpdateTime,
+		}})
+		if err == nil && result.MatchedCount == 0 {
+			err = ErrOrderChanged
+		}
+		return err
+	})
+	if err != nil {
+		return previous, err
+	}
+	publishOrder(EventAmended, amended)
+	submitOrder(ctx, commandAmend, amended)
+	orderLog.InfoContext(ctx, "order amended", "order", amended.ID.Hex(), "symbol", amended.Symbol,
+		"price", amended.Price, "quantity", amended.Quantity)
+	return amended, nil
+}
+
+// CancelOrder moves an order to cancelled and releases the funds held for its
+// unfilled quantity. Orders in a final state return an ErrIllegalTransition.
+func CancelOrder(ctx context.Context, id primitive.ObjectID, reason string) (OrderModel, error) {
+	var order OrderModel
+	client, err := database.GetMongoClient()
+	if err != nil {
+		return order, err
+	}
+
+	err = client.Database(dbName).Collection(ordersCollection).FindOne(ctx, bson.M{"_id": id}).Decode(&order)
+	found := err == nil
+	if found {
+		if err := auth.CheckUser(ctx, order.UserID); err != nil {
+			return order, err
+		}
+		if err := checkOrderAction(ctx, client, ActionCancel, order.UserID, order.Symbol); err != nil {
+			return order, err
+		}
+	}
+	return cancelOrder(ctx, client, id, found, order, reason)
+}
+
+// ForceCancelOrders cancels the open orders matching a filter for an operator,
+// bypassing the owners' rate limits. It returns the orders it cancelled.
+func ForceCancelOrders(ctx context.Context, filter bson.M, reason string) ([]OrderModel, error) {
+	cancelled := make([]OrderModel, 0)
+	client, err := database.GetMongoClient()
+	if err != nil {
+		return cancelled, err
+	}
+
+	query := bson.M{"status": openStatusFilter}
+	for key, value := range filter {
+		query[key] = value
+	}
+	cursor, err := client.Database(dbName).Collection(ordersCollection).Find(ctx, query)
+	if err != nil {
+		return cancelled, err
+	}
+	open := make([]OrderModel, 0)
+	if err := cursor.All(ctx, &open); err != nil {
+		return cancelled, err
+	}
+
+	var errs []error
+	for _, order := range open {
+		order, err := cancelOrder(ctx, client, order.ID, true, order, reason)
+		// An order filled or cancelled in the meantime needs no cancel
+		if errors.Is(err, ErrIllegalTransition) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("order %s: %w", order.ID.Hex(), err))
+			continue
+		}
+		cancelled = append(cancelled, order)
+	}
+	return cancelled, errors.Join(errs...)
+}
+
+// cancelOrder moves an order to cancelled once the caller is authorized, found
+// tells whether the order was read before
+func cancelOrder(ctx context.Context, client *mongo.Client, id primitive.ObjectID, found bool, order OrderModel, reason string) (OrderModel, error) {
+	// Update order status to cancelled, only from states the transition table allows
+	filter := bson.M{"_id": id, "status": bson.M{"$in": statusesInto(Cancelled)}}
+	update := bson.M{"$set": bson.M{"status": Cancelled, "updateTime": utils.GetCurrentTimestamp()}}
+	err := client.Database(dbName).Collection(ordersCollection).FindOneAndUpdate(ctx, filter, update).Decode(&order)
+	if err == mongo.ErrNoDocuments && found {
+		// The order exists but its state cannot move to cancelled, e.g. it is filled
+		return order, cancelConflict(order)
+	}
+	if err == mongo.ErrNoDocuments {
+		return order, ErrOrderNotFound
+	}
+	if err != nil {
+		return order, err
+	}
+
+	err = recordStateChange(ctx, client, order.ID, order.Status.String(), Cancelled, reason)
+	if err != nil {
+		return order, err
+	}
+
+	// Return the funds held for the unfilled quantity
+	err = releaseOrder(ctx, client, order)
+	order.Status = Cancelled
+	publishOrder(EventCancelled, order)
+	submitOrder(ctx, commandCancel, order)
+	orderLog.InfoContext(ctx, "order cancelled", "order", order.ID.Hex(), "symbol", order.Symbol, "reason", reason)
+	return order, err
+}
+
+// DeleteOrder removes a cancellable order and releases its funds. It
+// reports false when there was no such order.
+func DeleteOrder(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	client, err := database.GetMongoClient()
+	if err != nil {
+		return false, err
+	}
+
+	var order OrderModel
+	err = client.Database(dbName).Collection(ordersCollection).FindOne(ctx, bson.M{"_id": id}).Decode(&order)
+	found := err == nil
+	if found {
+		if err := auth.CheckUser(ctx, order.UserID); err != nil {
+			return false, err
+		}
+		if err := checkOrderAction(ctx, client, ActionCancel, order.UserID, order.Symbol); err != nil {
+			return false, err
+		}
+	}
+
+	err = client.Database(dbName).Collection(ordersCollection).FindOneAndDelete(ctx, bson.M{"_id": id, "status": bson.M{"$in": statusesInto(Cancelled)}}).Decode(&order)
+	if err == mongo.ErrNoDocuments && found {
+		return false, cancelConflict(order)
+	}
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	logStateChange(ctx, client, order.ID, order.Status.String(), Cancelled, "deleted by user")
+	order.Status = Cancelled
+	publishOrder(EventCancelled, order)
+	submitOrder(ctx, commandCancel, order)
+	orderLog.InfoContext(ctx, "order deleted", "order", order.ID.Hex(), "symbol", order.Symbol)
+
+	// Return the funds held for the deleted order
+	return true, releaseOrder(ctx, client, order)
+}
+
+// amendedOrder applies validated updates to an order
+func amendedOrder(previous OrderModel, updates bson.M) (OrderModel, error) {
+	amended := previous
+	if value, ok := updates["price"]; ok {
+		price, ok := numberOf(value)
+		if !ok || price <= 0 {
+			return previous, errors.New("invalid order price")
+		}
+		amended.Price = price
+	}
+	if value, ok := updates["quantity"]; ok {
+		quantity, ok := numberOf(value)
+		if !ok || quantity != math.Trunc(quantity) {
+			return previous, errors.New("invalid order quantity")
+		}
+		amended.Quantity = int64(quantity)
+	}
+	if amended.Quantity <= amended.FilledQty {
+		return previous, errors.New("quantity must exceed the filled quantity")
+	}
+	amended.RemainingQty = amended.Quantity - amended.FilledQty
+	if value, ok := updates["clientOrderID"]; ok {
+		clientOrderID, ok := value.(string)
+		if !ok {
+			return previous, errors.New("invalid client order ID")
+		}
+		amended.ClientOrderID = clientOrderID
+	}
+	if value, ok := updates["expiration"]; ok {
+		expiration, ok := numberOf(value)
+		if !ok || expiration < 0 || expiration != math.Trunc(expiration) {
+			return previous, errors.New("invalid order expiration")
+		}
+		amended.Expiration = int64(expiration)
+	}
+	amended.UpdateTime = utils.GetCurrentTimestamp()
+	return amended, nil
+}
+
+// numberOf reads a numeric update value decoded from JSON or set by a gateway
+func numberOf(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,72 @@
+package orderbook
+
+import (
+	"context"
+	"mfus_OMV1/internal/logging"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var countersCollection = "counters"
+
+// recordStateChange appends a lifecycle transition to the order's history,
+// from is empty when the order has just been created. Sequence numbers are
+// allocated per order so the timeline has a total order even when
+// transitions share a timestamp.
+func recordStateChange(ctx context.Context, mongoClient *mongo.Client, orderID primitive.ObjectID, from string, to OrderStatus, reason string) error {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := mongoClient.Database(dbName).Collection(countersCollection).FindOneAndUpdate(ctx,
+		bson.M{"_id": stateChangesCollection + ":" + orderID.Hex()},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return err
+	}
+
+	stateChange := StateChange{
+		OrderID:       orderID,
+		Seq:           counter.Seq,
+		FromState:     from,
+		ToState:       to.String(),
+		Reason:        reason,
+		CorrelationID: logging.CorrelationID(ctx),
+		CreatedAt:     time.Now(),
+	}
+	_, err = mongoClient.Database(dbName).Collection(stateChangesCollection).InsertOne(ctx, stateChange)
+	return err
+}
+
+// logStateChange records a transition from a background path where there is
+// no caller to return the error to
+func logStateChange(ctx context.Context, mongoClient *mongo.Client, orderID primitive.ObjectID, from string, to OrderStatus, reason string) {
+	if err := recordStateChange(ctx, mongoClient, orderID, from, to, reason); err != nil {
+		orderLog.ErrorContext(ctx, "cannot record state change", "order", orderID.Hex(), "error", err)
+	}
+}
+
+// orderContext carries the correlation ID of the request that placed an order
+// into the changes the engine makes to it later on
+func orderContext(order OrderModel) context.Context {
+	return logging.WithCorrelationID(context.Background(), order.CorrelationID)
+}
+
+// GetOrderHistory returns the lifecycle transitions of an order in sequence
+func GetOrderHistory(ctx context.Context, mongoClient *mongo.Client, orderID primitive.ObjectID) ([]StateChange, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+	cursor, err := mongoClient.Database(dbName).Collection(stateChangesCollection).Find(ctx, bson.M{"order_id": orderID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]StateChange, 0)
+	if err := cursor.All(ctx, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
@@ -4,6 +4,7 @@ import (
 	"container/list"
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -14,13 +15,14 @@ import (
 type OrderModel struct {
 	ID            primitive.ObjectID    `json:"id" bson:"_id"`
 	UserID        string                `json:"userID" bson:"userID"`
+	ClientOrderID string                `json:"clientOrderID,omitempty" bson:"clientOrderID,omitempty"`
 	Symbol        string                `json:"symbol" bson:"symbol"`
 	Price         float64               `json:"price" bson:"price"`
 	Quantity      int64                 `json:"quantity" bson:"quantity"`
 	RemainingQty  int64                 `json:"remainingQty" bson:"remainingQty"`
 	Side          string                `json:"side" bson:"side"`
 	Type          string                `json:"type" bson:"type"`
-	Status        string                `json:"status" bson:"status"`
+	Status        OrderStatus           `json:"status" bson:"status"`
 	Expiration    int64                 `json:"expiration" bson:"expiration"`
 	CreationTime  int64                 `json:"creationTime" bson:"creationTime"`
 	UpdateTime    int64                 `json:"updateTime" bson:"updateTime"`
@@ -29,64 +31,89 @@ type OrderModel struct {
 	FilledVolume  float64               `json:"filledVolume" bson:"filledVolume"`
 	FilledAverage float64               `json:"filledAverage" bson:"filledAverage"`
 	FilledOrder   *TradeFilledInfoModel `json:"filled_order" bson:"filled_order,omitempty"`
+	CorrelationID string                `json:"correlationID,omitempty" bson:"correlationID,omitempty"`
 }
 
 type OrderManagerModel struct {
-	Symbol           string
-	Collection       *mongo.Collection
-	TradeCollection  *mongo.Collection
-	Interval         time.Duration
-	MaxTPS           int
-	Ctx              context.Context
-	Cancel           context.CancelFunc
-	Orders           map[string]*OrderModel
-	BuyOrders        []*OrderModel `json:"buyOrders"`
-	SellOrders       []*OrderModel `json:"sellOrders"`
-	FilledOrders     []*OrderModel `json:"filledOrders"`
-	OrderBookModel   OrderBookModel
-	tradeChan        chan *TradeHistoryModel
-	orderChan        chan *OrderModel
-	stopChan         chan struct{}
-	OrderMutex       sync.Mutex
+	Symbol          string
+	Collection      *mongo.Collection
+	TradeCollection *mongo.Collection
+	Interval        time.Duration
+	MaxTPS          int
+	Ctx             context.Context
+	Cancel          context.CancelFunc
+	Orders          map[string]*OrderModel
+	BuyOrders       []*OrderModel `json:"buyOrders"`
+	SellOrders      []*OrderModel `json:"sellOrders"`
+	FilledOrders    []*OrderModel `json:"filledOrders"`
+	// book is the latest view of the resting orders, replaced as a whole by
+	// the only goroutine that writes it so that readers never wait
+	book      atomic.Pointer[OrderBookModel]
+	engine    atomic.Pointer[engine]
+	tradeChan chan *TradeHistoryModel
+	stopChan  chan struct{}
+	stopOnce  sync.Once
+	// TradeMutex guards the last trade and the totals
 	TradeMutex       sync.Mutex
 	LastTradeID      string
 	LastTradePrice   float64
 	LastTradeTime    time.Time
 	TradeCount       int
 	TotalTradeVolume float64
-	orderMatchTicker *time.Ticker
-	RedisClient      *redis.Client
-	MongoClient      *mongo.Client
-	mutex            sync.Mutex
-	MarketPrice      float64
+	// recorded holds the IDs of the trades executed at LastTradeTime
+	recorded    map[string]bool
+	dispatching time.Time
+	RedisClient *redis.Client
+	MongoClient *mongo.Client
 }
 
-// OrderBook represents the order book
+// OrderBookModel is a view of the resting orders of a book, it is not changed
+// once published
 type OrderBookModel struct {
-	Bids    *list.List
-	Asks    *list.List
-	Trades  *list.List
-	Market  *MarketOrder
-	updated time.Time
+	Bids     *list.List
+	Asks     *list.List
+	Trades   *list.List
+	Market   *MarketOrder
+	Sequence uint64
+	updated  time.Time
 }
 
 type TradeHistoryModel struct {
-	Id         string
-	BuyOrder   string
-	SellOrder  string
-	Quantity   int64
-	Price      float64
-	ExecutedAt time.Time
-	Timestamp  time.Time
+	Id                string    `json:"id" bson:"_id"`
+	Symbol            string    `json:"symbol" bson:"symbol"`
+	BuyOrder          string    `json:"buyOrder" bson:"buy_order_id"`
+	SellOrder         string    `json:"sellOrder" bson:"sell_order_id"`
+	BuyUserID         string    `json:"buyUserID" bson:"buy_user_id"`
+	SellUserID        string    `json:"sellUserID" bson:"sell_user_id"`
+	Quantity          int64     `json:"quantity" bson:"quantity"`
+	Price             float64   `json:"price" bson:"price"`
+	MakerSide         string    `json:"makerSide" bson:"maker_side"`
+	BuyCorrelationID  string    `json:"buyCorrelationID,omitempty" bson:"buy_correlation_id,omitempty"`
+	SellCorrelationID string    `json:"sellCorrelationID,omitempty" bson:"sell_correlation_id,omitempty"`
+	BuyFee            float64   `json:"buyFee" bson:"buy_fee"`
+	BuyFeeRate        float64   `json:"buyFeeRate" bson:"buy_fee_rate"`
+	BuyFeeAsset       string    `json:"buyFeeAsset" bson:"buy_fee_asset"`
+	SellFee           float64   `json:"sellFee" bson:"sell_fee"`
+	SellFeeRate       float64   `json:"sellFeeRate" bson:"sell_fee_rate"`
+	SellFeeAsset      string    `json:"sellFeeAsset" bson:"sell_fee_asset"`
+	ExecutedAt        time.Time `json:"executedAt" bson:"executed_at"`
+	Timestamp         time.Time `json:"timestamp" bson:"timestamp"`
+	// Fence is the fencing token of the leader that executed the trade
+	Fence int64 `json:"-" bson:"fence,omitempty"`
 }
 
 type TradeBookModel struct {
-	ID        string     `json:"id"`
-	BuyOrder  OrderModel `json:"buyOrder"`
-	SellOrder OrderModel `json:"sellOrder"`
-	Quantity  int64      `json:"quantity"`
-	Price     float64    `json:"price"`
-	Time      int64      `json:"time"`
+	ID           string     `json:"id"`
+	BuyOrder     OrderModel `json:"buyOrder"`
+	SellOrder    OrderModel `json:"sellOrder"`
+	Quantity     int64      `json:"quantity"`
+	Price        float64    `json:"price"`
+	MakerSide    string     `json:"makerSide"`
+	BuyFee       float64    `json:"buyFee"`
+	BuyFeeAsset  string     `json:"buyFeeAsset"`
+	SellFee      float64    `json:"sellFee"`
+	SellFeeAsset string     `json:"sellFeeAsset"`
+	Time         int64      `json:"time"`
 }
 
 type TradeFilledInfoModel struct {
@@ -98,11 +125,14 @@ type TradeFilledInfoModel struct {
 
 // StateChange represents a change in order status
 type StateChange struct {
-	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	OrderID   primitive.ObjectID `json:"order_id,omitempty" bson:"order_id,omitempty"`
-	FromState string             `json:"from_state,omitempty" bson:"from_state,omitempty"`
-	ToState   string             `json:"to_state,omitempty" bson:"to_state,omitempty"`
-	CreatedAt time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	ID            primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	OrderID       primitive.ObjectID `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	Seq           int64              `json:"seq" bson:"seq"`
+	FromState     string             `json:"from_state,omitempty" bson:"from_state,omitempty"`
+	ToState       string             `json:"to_state,omitempty" bson:"to_state,omitempty"`
+	Reason        string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	CorrelationID string             `json:"correlation_id,omitempty" bson:"correlation_id,omitempty"`
+	CreatedAt     time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
 }
 
 // LimitOrder represents a limit order with time priority
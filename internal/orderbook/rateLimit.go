@@ -0,0 +1,53 @@
+package orderbook
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/internal/ratelimit"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Order entry actions, each has its own rate limit bucket
+const (
+	ActionPlace  = "place"
+	ActionCancel = "cancel"
+	ActionAmend  = "amend"
+)
+
+// RateLimitError is returned when an order action exceeds the caller's limit
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %d seconds", e.Seconds())
+}
+
+// Seconds returns the wait rounded up to whole seconds, at least one
+func (e *RateLimitError) Seconds() int {
+	seconds := int(math.Ceil(e.RetryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// checkOrderAction enforces the per user and per API key limit of an order
+// action on a symbol, the API key is taken from the principal of the context.
+// Both buckets are keyed by symbol since their rate is the symbol's.
+func checkOrderAction(ctx context.Context, mongoClient *mongo.Client, action string, userID string, symbol string) error {
+	limit := ratelimit.LimitFor(ctx, mongoClient, userID, settings.Symbol(symbol).MaxTPS)
+
+	keys := []string{"user:" + userID + ":" + symbol + ":" + action}
+	if principal, _ := auth.FromContext(ctx); principal.KeyID != "" {
+		keys = append(keys, "apikey:"+principal.KeyID+":"+symbol+":"+action)
+	}
+	if allowed, wait := ratelimit.AllowAll(ctx, keys, limit); !allowed {
+		return &RateLimitError{RetryAfter: wait}
+	}
+	return nil
+}
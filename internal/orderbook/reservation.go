@@ -0,0 +1,76 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"mfus_OMV1/internal/accounts"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// openStatusFilter matches orders that are still resting on the book
+var openStatusFilter = bson.M{"$in": []OrderStatus{Open, Partial}}
+
+// normalizeSide returns the side in the form used by the matcher
+func normalizeSide(side string) (string, error) {
+	switch strings.ToLower(side) {
+	case "buy":
+		return "Buy", nil
+	case "sell":
+		return "Sell", nil
+	}
+	return "", errors.New("invalid order side")
+}
+
+func isBuy(side string) bool {
+	return strings.EqualFold(side, "buy")
+}
+
+// reservationFor returns the asset and amount that must be held to cover qty of an order.
+// Buys hold quote currency at the limit price, sells hold the base quantity.
+func reservationFor(order OrderModel, qty int64) (string, float64, error) {
+	base, quote, err := accounts.SymbolAssets(order.Symbol)
+	if err != nil {
+		return "", 0, err
+	}
+	if isBuy(order.Side) {
+		if order.Price <= 0 {
+			return "", 0, errors.New("buy orders require a price to reserve funds")
+		}
+		return quote, float64(qty) * order.Price, nil
+	}
+	return base, float64(qty), nil
+}
+
+// reserveOrder holds the funds needed for the full quantity of a new order
+func reserveOrder(ctx context.Context, mongoClient *mongo.Client, order OrderModel) error {
+	asset, amount, err := reservationFor(order, order.Quantity)
+	if err != nil {
+		return err
+	}
+	return accounts.Reserve(ctx, mongoClient, order.UserID, asset, amount, order.ID.Hex())
+}
+
+// releaseOrder returns the funds still held for the unfilled part of an order
+func releaseOrder(ctx context.Context, mongoClient *mongo.Client, order OrderModel) error {
+	remaining := order.Quantity - order.FilledQty
+	if remaining <= 0 {
+		return nil
+	}
+	asset, amount, err := reservationFor(order, remaining)
+	if err != nil {
+		return err
+	}
+	return accounts.Release(ctx, mongoClient, order.UserID, asset, amount, order.ID.Hex())
+}
+
+// reserveRemaining holds the funds needed for the unfilled part of an amended order
+func reserveRemaining(ctx context.Context, mongoClient *mongo.Client, order OrderModel) error {
+	asset, amount, err := reservationFor(order, order.Quantity-order.FilledQty)
+	if err != nil {
+		return err
+	}
+	return accounts.Reserve(ctx, mongoClient, order.UserID, asset, amount, order.ID.Hex())
+}
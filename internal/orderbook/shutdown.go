@@ -0,0 +1,163 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mfus_OMV1/internal/health"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrShuttingDown is returned by order entry once shutdown has begun. It wraps
+// health.ErrNotReady so the APIs answer it like any other unavailability.
+var ErrShuttingDown = fmt.Errorf("%w: shutting down", health.ErrNotReady)
+
+// snapshotsCollection receives the state of every book at shutdown and when an
+// operator asks for it
+var snapshotsCollection = "book_snapshots"
+
+// entryMutex is held for reading by every order entry call in flight and for
+// writing by StopOrderEntry, which therefore waits for them to finish
+var entryMutex sync.RWMutex
+var entryStopped bool
+
+// beginEntry admits an order entry call unless shutdown has begun, a call that
+// is admitted must call endEntry when it is done
+func beginEntry() bool {
+	entryMutex.RLock()
+	if entryStopped {
+		entryMutex.RUnlock()
+		return false
+	}
+	return true
+}
+
+func endEntry() {
+	entryMutex.RUnlock()
+}
+
+// StopOrderEntry refuses new orders and amends and returns once the calls in
+// flight are persisted, so that the final matching pass sees them. Cancels
+// are still accepted.
+func StopOrderEntry() {
+	entryMutex.Lock()
+	entryStopped = true
+	entryMutex.Unlock()
+}
+
+// SnapshotOrder is one resting order of a book snapshot
+type SnapshotOrder struct {
+	OrderID      string  `bson:"order_id" json:"orderID"`
+	Price        float64 `bson:"price" json:"price"`
+	RemainingQty int64   `bson:"remaining_qty" json:"remainingQty"`
+	CreationTime int64   `bson:"creation_time" json:"creationTime"`
+}
+
+// SnapshotModel is the state of a book and its last trade, written at shutdown
+// and on demand
+type SnapshotModel struct {
+	Symbol         string          `bson:"symbol" json:"symbol"`
+	Sequence       uint64          `bson:"sequence" json:"sequence"`
+	Bids           []SnapshotOrder `bson:"bids" json:"bids"`
+	Asks           []SnapshotOrder `bson:"asks" json:"asks"`
+	LastTradeID    string          `bson:"last_trade_id" json:"lastTradeID"`
+	LastTradePrice float64         `bson:"last_trade_price" json:"lastTradePrice"`
+	LastTradeTime  time.Time       `bson:"last_trade_time" json:"lastTradeTime"`
+	TradeCount     int             `bson:"trade_count" json:"tradeCount"`
+	TradeVolume    float64         `bson:"trade_volume" json:"tradeVolume"`
+	TakenAt        time.Time       `bson:"taken_at" json:"takenAt"`
+}
+
+// Drain flushes the trades still queued for the listeners of every symbol and
+// writes a final snapshot of each book. The matcher must have stopped.
+func Drain(ctx context.Context, mongoClient *mongo.Client) error {
+	var errs []error
+	for _, manager := range OrderManagers() {
+		if err := manager.stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: flushing trades: %w", manager.Symbol, err))
+			continue
+		}
+		if _, err := manager.writeSnapshot(ctx, mongoClient); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WriteSnapshots records the current state of every book
+func WriteSnapshots(ctx context.Context, mongoClient *mongo.Client) ([]SnapshotModel, error) {
+	snapshots := make([]SnapshotModel, 0)
+	var errs []error
+	for _, manager := range OrderManagers() {
+		snapshot, err := manager.writeSnapshot(ctx, mongoClient)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, errors.Join(errs...)
+}
+
+func (m *OrderManagerModel) writeSnapshot(ctx context.Context, mongoClient *mongo.Client) (SnapshotModel, error) {
+	snapshot := m.snapshot()
+	if _, err := mongoClient.Database(dbName).Collection(snapshotsCollection).InsertOne(ctx, snapshot); err != nil {
+		return snapshot, fmt.Errorf("%s: writing snapshot: %w", m.Symbol, err)
+	}
+	matcherLog.InfoContext(ctx, "book snapshot written", "symbol", snapshot.Symbol, "sequence", snapshot.Sequence,
+		"bids", len(snapshot.Bids), "asks", len(snapshot.Asks))
+	return snapshot, nil
+}
+
+// stop asks the dispatcher to hand the queued trades to the listeners and
+// waits until it has, or until the context ends
+func (m *OrderManagerModel) stop(ctx context.Context) error {
+	m.stopOnce.Do(func() { close(m.stopChan) })
+
+	select {
+	case <-m.Ctx.Done():
+		return nil
+	case <-ctx.Done():
+		pending, _ := m.journalLag()
+		return fmt.Errorf("%d trades not dispatched: %w", pending, ctx.Err())
+	}
+}
+
+// snapshot copies the resting orders and trade totals of the manager
+func (m *OrderManagerModel) snapshot() SnapshotModel {
+	view := m.currentBook()
+	snapshot := SnapshotModel{
+		Symbol:   m.Symbol,
+		Sequence: view.Sequence,
+		Bids:     make([]SnapshotOrder, 0, view.Bids.Len()),
+		Asks:     make([]SnapshotOrder, 0, view.Asks.Len()),
+		TakenAt:  time.Now(),
+	}
+	for e := view.Bids.Front(); e != nil; e = e.Next() {
+		snapshot.Bids = append(snapshot.Bids, snapshotOrder(e.Value.(*OrderModel)))
+	}
+	for e := view.Asks.Front(); e != nil; e = e.Next() {
+		snapshot.Asks = append(snapshot.Asks, snapshotOrder(e.Value.(*OrderModel)))
+	}
+
+	m.TradeMutex.Lock()
+	snapshot.LastTradeID = m.LastTradeID
+	snapshot.LastTradePrice = m.LastTradePrice
+	snapshot.LastTradeTime = m.LastTradeTime
+	snapshot.TradeCount = m.TradeCount
+	snapshot.TradeVolume = m.TotalTradeVolume
+	m.TradeMutex.Unlock()
+	return snapshot
+}
+
+func snapshotOrder(order *OrderModel) SnapshotOrder {
+	return SnapshotOrder{
+		OrderID:      order.ID.Hex(),
+		Price:        order.Price,
+		RemainingQty: order.Quantity - order.FilledQty,
+		CreationTime: order.CreationTime,
+	}
+}
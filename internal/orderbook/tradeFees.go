@@ -0,0 +1,61 @@
+package orderbook
+
+import (
+	"context"
+	"mfus_OMV1/internal/accounts"
+	"mfus_OMV1/internal/fees"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// makerSide returns the side of the order that was resting first
+func makerSide(buyOrder *OrderModel, sellOrder *OrderModel) string {
+	if buyOrder.CreationTime < sellOrder.CreationTime {
+		return "Buy"
+	}
+	return "Sell"
+}
+
+// applyFees prices both sides of a trade against their fee tiers
+func applyFees(ctx context.Context, mongoClient *mongo.Client, trade *TradeHistoryModel) error {
+	base, quote, err := accounts.SymbolAssets(trade.Symbol)
+	if err != nil {
+		return err
+	}
+	buyTier, err := fees.TierFor(ctx, mongoClient, trade.Symbol, trade.BuyUserID)
+	if err != nil {
+		return err
+	}
+	sellTier, err := fees.TierFor(ctx, mongoClient, trade.Symbol, trade.SellUserID)
+	if err != nil {
+		return err
+	}
+	priceFees(trade, base, quote, buyTier, sellTier)
+	return nil
+}
+
+// priceFees sets the fees of a trade. The buyer pays in the base asset it
+// receives and the seller in the quote asset. A maker rebate is paid in the
+// asset the taker pays in, on the same notional, so the taker fee of the
+// trade funds it.
+func priceFees(trade *TradeHistoryModel, base string, quote string, buyTier fees.FeeTier, sellTier fees.FeeTier) {
+	buyRate, sellRate := buyTier.TakerRate, sellTier.MakerRate
+	if trade.MakerSide == "Buy" {
+		buyRate, sellRate = buyTier.MakerRate, sellTier.TakerRate
+	}
+	quantity := float64(trade.Quantity)
+	notional := quantity * trade.Price
+
+	trade.BuyFeeRate = buyRate
+	trade.BuyFee = quantity * buyRate
+	trade.BuyFeeAsset = base
+	trade.SellFeeRate = sellRate
+	trade.SellFee = notional * sellRate
+	trade.SellFeeAsset = quote
+	if trade.MakerSide == "Buy" && buyRate < 0 {
+		trade.BuyFee, trade.BuyFeeAsset = notional*buyRate, quote
+	}
+	if trade.MakerSide == "Sell" && sellRate < 0 {
+		trade.SellFee, trade.SellFeeAsset = quantity*sellRate, base
+	}
+}
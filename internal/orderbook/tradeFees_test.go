@@ -0,0 +1,51 @@
+package orderbook
+
+import (
+	"math"
+	"mfus_OMV1/internal/fees"
+	"testing"
+)
+
+func TestPriceFees(t *testing.T) {
+	regular := fees.FeeTier{Name: "T0", MakerRate: 0.001, TakerRate: 0.002}
+	rebate := fees.FeeTier{Name: "T3", MakerRate: -0.0005, TakerRate: 0.001}
+	tests := []struct {
+		name      string
+		maker     string
+		buyTier   fees.FeeTier
+		sellTier  fees.FeeTier
+		buyFee    float64
+		buyAsset  string
+		sellFee   float64
+		sellAsset string
+	}{
+		// 10 BTC at 100 USD, a notional of 1000 USD
+		{"buyer takes", "Sell", regular, regular, 10 * 0.002, "BTC", 1000 * 0.001, "USD"},
+		{"seller takes", "Buy", regular, regular, 10 * 0.001, "BTC", 1000 * 0.002, "USD"},
+		// A rebate is paid in the asset of the taker fee that funds it
+		{"maker seller rebated", "Sell", regular, rebate, 10 * 0.002, "BTC", 10 * -0.0005, "BTC"},
+		{"maker buyer rebated", "Buy", rebate, regular, 1000 * -0.0005, "USD", 1000 * 0.002, "USD"},
+		{"taker in a rebate tier pays", "Sell", rebate, regular, 10 * 0.001, "BTC", 1000 * 0.001, "USD"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trade := TradeHistoryModel{Symbol: "BTC-USD", Quantity: 10, Price: 100, MakerSide: test.maker}
+			priceFees(&trade, "BTC", "USD", test.buyTier, test.sellTier)
+			if math.Abs(trade.BuyFee-test.buyFee) > 1e-12 || trade.BuyFeeAsset != test.buyAsset {
+				t.Errorf("buy fee %v %s, want %v %s", trade.BuyFee, trade.BuyFeeAsset, test.buyFee, test.buyAsset)
+			}
+			if math.Abs(trade.SellFee-test.sellFee) > 1e-12 || trade.SellFeeAsset != test.sellAsset {
+				t.Errorf("sell fee %v %s, want %v %s", trade.SellFee, trade.SellFeeAsset, test.sellFee, test.sellAsset)
+			}
+			// Per asset the fees collected never fall below zero
+			collected := map[string]float64{}
+			collected[trade.BuyFeeAsset] += trade.BuyFee
+			collected[trade.SellFeeAsset] += trade.SellFee
+			for asset, amount := range collected {
+				if amount < 0 {
+					t.Errorf("trade pays out %v %s more than it collects", -amount, asset)
+				}
+			}
+		})
+	}
+}
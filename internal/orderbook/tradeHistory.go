@@ -0,0 +1,165 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Liquidity flags of a fill
+const (
+	LiquidityMaker = "maker"
+	LiquidityTaker = "taker"
+)
+
+// TradeQuery filters the trades collection
+type TradeQuery struct {
+	Symbol string
+	UserID string
+	From   time.Time
+	To     time.Time
+	Limit  int64
+}
+
+// FillModel is one execution of an order seen from the order's side only
+type FillModel struct {
+	TradeID    string    `json:"tradeID"`
+	OrderID    string    `json:"orderID"`
+	Symbol     string    `json:"symbol"`
+	Side       string    `json:"side"`
+	Price      float64   `json:"price"`
+	Quantity   int64     `json:"quantity"`
+	Fee        float64   `json:"fee"`
+	FeeRate    float64   `json:"feeRate"`
+	FeeAsset   string    `json:"feeAsset"`
+	Liquidity  string    `json:"liquidity"`
+	ExecutedAt time.Time `json:"executedAt"`
+}
+
+// ParseTradeQuery reads a trade query from URL query parameters, times are in milliseconds
+func ParseTradeQuery(values url.Values) (TradeQuery, error) {
+	query := TradeQuery{
+		Symbol: values.Get("symbol"),
+		UserID: values.Get("userID"),
+		Limit:  DefaultQueryLimit,
+	}
+	for name, target := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if v := values.Get(name); v != "" {
+			millis, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return TradeQuery{}, errors.New(name + " must be a timestamp in milliseconds")
+			}
+			*target = time.UnixMilli(millis)
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit <= 0 {
+			return TradeQuery{}, errors.New("limit must be a positive number")
+		}
+		if limit > MaxQueryLimit {
+			limit = MaxQueryLimit
+		}
+		query.Limit = limit
+	}
+	return query, nil
+}
+
+// QueryTrades returns trades newest first
+func QueryTrades(ctx context.Context, mongoClient *mongo.Client, query TradeQuery) ([]TradeHistoryModel, error) {
+	filter := bson.M{}
+	if query.Symbol != "" {
+		filter["symbol"] = query.Symbol
+	}
+	if query.UserID != "" {
+		filter["$or"] = bson.A{bson.M{"buy_user_id": query.UserID}, bson.M{"sell_user_id": query.UserID}}
+	}
+	if !query.From.IsZero() || !query.To.IsZero() {
+		executed := bson.M{}
+		if !query.From.IsZero() {
+			executed["$gte"] = query.From
+		}
+		if !query.To.IsZero() {
+			executed["$lt"] = query.To
+		}
+		filter["executed_at"] = executed
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "executed_at", Value: -1}}).SetLimit(query.Limit)
+	cursor, err := mongoClient.Database(dbName).Collection(tradeCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	trades := make([]TradeHistoryModel, 0)
+	if err := cursor.All(ctx, &trades); err != nil {
+		return nil, err
+	}
+	return trades, nil
+}
+
+// GetOrderFills returns the executions of an order, oldest first
+func GetOrderFills(ctx context.Context, mongoClient *mongo.Client, orderID primitive.ObjectID) ([]FillModel, error) {
+	id := orderID.Hex()
+	filter := bson.M{"$or": bson.A{bson.M{"buy_order_id": id}, bson.M{"sell_order_id": id}}}
+	opts := options.Find().SetSort(bson.D{{Key: "executed_at", Value: 1}})
+	cursor, err := mongoClient.Database(dbName).Collection(tradeCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var trades []TradeHistoryModel
+	if err := cursor.All(ctx, &trades); err != nil {
+		return nil, err
+	}
+
+	fills := make([]FillModel, 0, len(trades))
+	for _, trade := range trades {
+		side := "Sell"
+		if trade.BuyOrder == id {
+			side = "Buy"
+		}
+		fills = append(fills, fillOf(trade, side))
+	}
+	return fills, nil
+}
+
+// fillOf returns the side of a trade that belongs to one order, without the counterparty
+func fillOf(trade TradeHistoryModel, side string) FillModel {
+	fill := FillModel{
+		TradeID:    trade.Id,
+		Symbol:     trade.Symbol,
+		Side:       side,
+		Price:      trade.Price,
+		Quantity:   trade.Quantity,
+		Liquidity:  LiquidityTaker,
+		ExecutedAt: trade.ExecutedAt,
+	}
+	if trade.MakerSide == side {
+		fill.Liquidity = LiquidityMaker
+	}
+	if side == "Buy" {
+		fill.OrderID, fill.Fee, fill.FeeRate, fill.FeeAsset = trade.BuyOrder, trade.BuyFee, trade.BuyFeeRate, trade.BuyFeeAsset
+	} else {
+		fill.OrderID, fill.Fee, fill.FeeRate, fill.FeeAsset = trade.SellOrder, trade.SellFee, trade.SellFeeRate, trade.SellFeeAsset
+	}
+	return fill
+}
+
+// Anonymise removes the counterparty of userID from a trade
+func Anonymise(trade TradeHistoryModel, userID string) TradeHistoryModel {
+	if trade.BuyUserID != userID {
+		trade.BuyUserID, trade.BuyOrder, trade.BuyFee, trade.BuyFeeRate = "", "", 0, 0
+		trade.BuyCorrelationID = ""
+	}
+	if trade.SellUserID != userID {
+		trade.SellUserID, trade.SellOrder, trade.SellFee, trade.SellFeeRate = "", "", 0, 0
+		trade.SellCorrelationID = ""
+	}
+	return trade
+}
@@ -0,0 +1,62 @@
+package orderbook
+
+import (
+	"testing"
+)
+
+func TestAnonymise(t *testing.T) {
+	trade := TradeHistoryModel{
+		Id:                "trade-1",
+		Symbol:            "BTC-USD",
+		BuyOrder:          "buy-order",
+		SellOrder:         "sell-order",
+		BuyUserID:         "alice",
+		SellUserID:        "bob",
+		BuyCorrelationID:  "alice-request",
+		SellCorrelationID: "bob-request",
+		Quantity:          2,
+		Price:             100,
+		BuyFee:            0.004,
+		BuyFeeRate:        0.002,
+		BuyFeeAsset:       "BTC",
+		SellFee:           0.2,
+		SellFeeRate:       0.001,
+		SellFeeAsset:      "USD",
+	}
+	type side struct {
+		user, order, correlation string
+		fee, rate                float64
+	}
+	buy := side{"alice", "buy-order", "alice-request", 0.004, 0.002}
+	sell := side{"bob", "sell-order", "bob-request", 0.2, 0.001}
+	tests := []struct {
+		name   string
+		userID string
+		buy    side
+		sell   side
+	}{
+		{"buyer sees only its side", "alice", buy, side{}},
+		{"seller sees only its side", "bob", side{}, sell},
+		{"public sees neither side", "", side{}, side{}},
+		{"someone else sees neither side", "carol", side{}, side{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Anonymise(trade, test.userID)
+			gotBuy := side{got.BuyUserID, got.BuyOrder, got.BuyCorrelationID, got.BuyFee, got.BuyFeeRate}
+			gotSell := side{got.SellUserID, got.SellOrder, got.SellCorrelationID, got.SellFee, got.SellFeeRate}
+			if gotBuy != test.buy {
+				t.Errorf("buy side %+v, want %+v", gotBuy, test.buy)
+			}
+			if gotSell != test.sell {
+				t.Errorf("sell side %+v, want %+v", gotSell, test.sell)
+			}
+			if got.Id != trade.Id || got.Price != trade.Price || got.Quantity != trade.Quantity {
+				t.Errorf("public fields changed: %+v", got)
+			}
+		})
+	}
+	if trade.BuyUserID != "alice" || trade.SellCorrelationID != "bob-request" {
+		t.Fatal("Anonymise changed the trade it was given")
+	}
+}
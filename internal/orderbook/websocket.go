@@ -1 +1,223 @@
 package orderbook
+
+import (
+	"context"
+	"encoding/json"
+	"mfus_OMV1/internal/auth"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// StreamMessage is the envelope of every message pushed over the WebSocket feed
+type StreamMessage struct {
+	Channel string      `json:"channel"`
+	Symbol  string      `json:"symbol,omitempty"`
+	Data    interface{} `json:"data"`
+}
+
+// subscribeRequest is sent by clients to (un)subscribe from public topics.
+// A topic is a channel and a symbol joined by a colon, e.g. "trades:BTC-USD".
+type subscribeRequest struct {
+	Op     string   `json:"op"`
+	Topics []string `json:"topics"`
+}
+
+type wsClient struct {
+	conn   *websocket.Conn
+	send   chan []byte
+	userID string
+	mutex  sync.Mutex
+	topics map[string]bool
+	done   chan struct{}
+	// closeReason is sent in the close frame once the send queue is flushed
+	closeReason string
+}
+
+const wsWriteWait = 10 * time.Second
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+var wsMutex sync.RWMutex
+var wsClients = make(map[*wsClient]bool)
+var wsClosed bool
+
+// WebSocketHandler upgrades the connection and streams public topics the
+// client subscribes to, plus private messages for the authenticated user.
+func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		wsLog.WarnContext(r.Context(), "cannot upgrade connection", "error", err)
+		return
+	}
+
+	client := &wsClient{
+		conn:   conn,
+		send:   make(chan []byte, 256),
+		userID: principal.UserID,
+		topics: make(map[string]bool),
+		done:   make(chan struct{}),
+	}
+	wsMutex.Lock()
+	if wsClosed {
+		wsMutex.Unlock()
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(wsWriteWait))
+		conn.Close()
+		return
+	}
+	wsClients[client] = true
+	wsMutex.Unlock()
+
+	// A client connecting during an emergency stop learns about it first
+	if state, engaged := engagedKillSwitch(); engaged {
+		if payload, err := json.Marshal(StreamMessage{Channel: "killswitch", Data: state}); err == nil {
+			client.deliver(payload)
+		}
+	}
+
+	go client.writeLoop()
+	client.readLoop()
+}
+
+// Publish sends a message to every client subscribed to the channel of a symbol
+func Publish(channel string, symbol string, data interface{}) {
+	payload, err := json.Marshal(StreamMessage{Channel: channel, Symbol: symbol, Data: data})
+	if err != nil {
+		wsLog.Error("cannot encode message", "channel", channel, "error", err)
+		return
+	}
+	topic := channel + ":" + symbol
+
+	wsMutex.RLock()
+	defer wsMutex.RUnlock()
+	for client := range wsClients {
+		if client.subscribed(topic) {
+			client.deliver(payload)
+		}
+	}
+}
+
+// Broadcast sends a message to every client, subscribed or not
+func Broadcast(channel string, data interface{}) {
+	payload, err := json.Marshal(StreamMessage{Channel: channel, Data: data})
+	if err != nil {
+		wsLog.Error("cannot encode message", "channel", channel, "error", err)
+		return
+	}
+
+	wsMutex.RLock()
+	defer wsMutex.RUnlock()
+	for client := range wsClients {
+		client.deliver(payload)
+	}
+}
+
+// PublishPrivate sends a message to every connection of a user
+func PublishPrivate(userID string, channel string, data interface{}) {
+	if userID == "" {
+		return
+	}
+	payload, err := json.Marshal(StreamMessage{Channel: channel, Data: data})
+	if err != nil {
+		wsLog.Error("cannot encode message", "channel", channel, "error", err)
+		return
+	}
+
+	wsMutex.RLock()
+	defer wsMutex.RUnlock()
+	for client := range wsClients {
+		if client.userID == userID {
+			client.deliver(payload)
+		}
+	}
+}
+
+func (c *wsClient) subscribed(topic string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.topics[topic]
+}
+
+// deliver queues a message without blocking the publisher, slow clients are disconnected
+func (c *wsClient) deliver(payload []byte) {
+	select {
+	case c.send <- payload:
+	default:
+		go c.close()
+	}
+}
+
+func (c *wsClient) close() {
+	wsMutex.Lock()
+	if wsClients[c] {
+		delete(wsClients, c)
+		close(c.send)
+	}
+	wsMutex.Unlock()
+	c.conn.Close()
+}
+
+func (c *wsClient) readLoop() {
+	defer c.close()
+	for {
+		var req subscribeRequest
+		if err := c.conn.ReadJSON(&req); err != nil {
+			return
+		}
+		c.mutex.Lock()
+		for _, topic := range req.Topics {
+			switch req.Op {
+			case "subscribe":
+				c.topics[topic] = true
+			case "unsubscribe":
+				delete(c.topics, topic)
+			}
+		}
+		c.mutex.Unlock()
+	}
+}
+
+func (c *wsClient) writeLoop() {
+	defer close(c.done)
+	for payload := range c.send {
+		c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := c.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+			c.close()
+			return
+		}
+	}
+	if c.closeReason != "" {
+		message := websocket.FormatCloseMessage(websocket.CloseGoingAway, c.closeReason)
+		c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(wsWriteWait))
+	}
+}
+
+// CloseWebSockets refuses new connections, flushes the messages queued for
+// every connection and closes it with a reason. Connections that are still
+// flushing when the context ends are closed without one.
+func CloseWebSockets(ctx context.Context, reason string) {
+	wsMutex.Lock()
+	wsClosed = true
+	clients := wsClients
+	wsClients = make(map[*wsClient]bool)
+	for client := range clients {
+		client.closeReason = reason
+		close(client.send)
+	}
+	wsMutex.Unlock()
+
+	for client := range clients {
+		select {
+		case <-client.done:
+		case <-ctx.Done():
+		}
+		client.conn.Close()
+	}
+	wsLog.Info("websocket connections closed", "connections", len(clients), "reason", reason)
+}
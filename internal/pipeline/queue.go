@@ -0,0 +1,211 @@
+// Package pipeline provides the bounded rings the matching engine is built
+// on. A Queue carries commands from any number of goroutines to the single
+// goroutine that owns a book, a Ring carries what that goroutine produces to
+// several consumers that each read every value. Neither takes a lock on the
+// data path, a goroutine only parks on a channel when there is nothing to do.
+// Both are bounded: a full Queue refuses or delays its producers and a Ring
+// whose slowest consumer is behind by its size delays its producer, so that
+// a slow stage slows order entry down instead of growing memory.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned once a queue or ring is closed
+var ErrClosed = errors.New("pipeline closed")
+
+// spins is how often a goroutine yields before it parks
+const spins = 64
+
+// parkTimeout bounds how long a parked goroutine waits without a wakeup
+const parkTimeout = time.Millisecond
+
+// Queue is a bounded multi-producer single-consumer queue
+type Queue[T any] struct {
+	mask  uint64
+	slots []queueSlot[T]
+	// head is only written by the consumer, tail by the producers
+	head   atomic.Uint64
+	_      [56]byte
+	tail   atomic.Uint64
+	_      [56]byte
+	closed atomic.Bool
+	// ready wakes the consumer, space wakes a blocked producer
+	ready chan struct{}
+	space chan struct{}
+}
+
+// queueSlot holds one value, seq tells whether it is free for the push of
+// position seq or holds the value pushed at position seq-1
+type queueSlot[T any] struct {
+	seq   atomic.Uint64
+	value T
+}
+
+// NewQueue creates a queue holding up to size values, a power of two
+func NewQueue[T any](size int) *Queue[T] {
+	if size <= 0 || size&(size-1) != 0 {
+		panic(fmt.Sprintf("pipeline: queue size %d is not a power of two", size))
+	}
+	q := &Queue[T]{
+		mask:  uint64(size - 1),
+		slots: make([]queueSlot[T], size),
+		ready: make(chan struct{}, 1),
+		space: make(chan struct{}, 1),
+	}
+	for i := range q.slots {
+		q.slots[i].seq.Store(uint64(i))
+	}
+	return q
+}
+
+// TryPush adds a value unless the queue is full or closed
+func (q *Queue[T]) TryPush(value T) bool {
+	if q.closed.Load() {
+		return false
+	}
+	for {
+		pos := q.tail.Load()
+		slot := &q.slots[pos&q.mask]
+		seq := slot.seq.Load()
+		switch diff := int64(seq - pos); {
+		case diff == 0:
+			if !q.tail.CompareAndSwap(pos, pos+1) {
+				continue
+			}
+			slot.value = value
+			slot.seq.Store(pos + 1)
+			notify(q.ready)
+			return true
+		case diff < 0:
+			// The consumer has not freed the slot of the previous lap
+			return false
+		}
+		// Another producer took the position, try the next one
+	}
+}
+
+// Push adds a value, waiting while the queue is full until the context ends
+func (q *Queue[T]) Push(ctx context.Context, value T) error {
+	for i := 0; ; i++ {
+		if q.TryPush(value) {
+			return nil
+		}
+		if q.closed.Load() {
+			return ErrClosed
+		}
+		if i < spins {
+			runtime.Gosched()
+			continue
+		}
+		if err := park(ctx, q.space); err != nil {
+			return err
+		}
+	}
+}
+
+// TryPop removes the oldest value, it is false when the queue is empty. Only
+// the consumer may call it.
+func (q *Queue[T]) TryPop() (T, bool) {
+	var zero T
+	pos := q.head.Load()
+	slot := &q.slots[pos&q.mask]
+	if slot.seq.Load() != pos+1 {
+		return zero, false
+	}
+	value := slot.value
+	slot.value = zero
+	slot.seq.Store(pos + q.mask + 1)
+	q.head.Store(pos + 1)
+	notify(q.space)
+	return value, true
+}
+
+// Pop removes the oldest value, waiting while the queue is empty. It returns
+// ErrClosed once the queue is closed and empty.
+func (q *Queue[T]) Pop(ctx context.Context) (T, error) {
+	for i := 0; ; i++ {
+		if value, ok := q.TryPop(); ok {
+			return value, nil
+		}
+		if q.closed.Load() {
+			// A value pushed before the close may only be visible now
+			if value, ok := q.TryPop(); ok {
+				return value, nil
+			}
+			var zero T
+			return zero, ErrClosed
+		}
+		if i < spins {
+			runtime.Gosched()
+			continue
+		}
+		if err := wait(ctx, q.ready); err != nil {
+			var zero T
+			return zero, err
+		}
+	}
+}
+
+// Close refuses new values, the consumer still pops the queued ones. Values
+// pushed while Close runs may be refused.
+func (q *Queue[T]) Close() {
+	q.closed.Store(true)
+	notify(q.ready)
+	notify(q.space)
+}
+
+// Len returns the number of queued values
+func (q *Queue[T]) Len() int {
+	n := int64(q.tail.Load() - q.head.Load())
+	if n < 0 {
+		return 0
+	}
+	if n > int64(len(q.slots)) {
+		return len(q.slots)
+	}
+	return int(n)
+}
+
+// Cap returns the number of values the queue holds when full
+func (q *Queue[T]) Cap() int {
+	return len(q.slots)
+}
+
+// notify wakes the goroutine parked on a channel, if any
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// wait parks the only goroutine waiting on a channel until it is woken
+func wait(ctx context.Context, ch chan struct{}) error {
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// park waits for a wakeup. Several goroutines may park on the same channel and
+// only one is woken, the others check again after parkTimeout.
+func park(ctx context.Context, ch chan struct{}) error {
+	timer := time.NewTimer(parkTimeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+	case <-timer.C:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
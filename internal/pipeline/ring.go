@@ -0,0 +1,208 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+)
+
+// Ring is a bounded single-producer ring read by several consumers. Every
+// consumer sees every value in order, a consumer may follow others and then
+// only sees a value once they are done with it. The producer waits while the
+// slowest consumer is a full ring behind.
+type Ring[T any] struct {
+	mask   uint64
+	values []T
+	// cursor counts the published values, only the producer writes it
+	cursor    atomic.Uint64
+	_         [56]byte
+	closed    atomic.Bool
+	consumers []*Consumer[T]
+	space     chan struct{}
+}
+
+// Consumer reads the values of a ring on its own goroutine
+type Consumer[T any] struct {
+	Name string
+	ring *Ring[T]
+	// next counts the values the consumer is done with
+	next       atomic.Uint64
+	_          [56]byte
+	after      []*Consumer[T]
+	downstream []*Consumer[T]
+	ready      chan struct{}
+	done       chan struct{}
+}
+
+// NewRing creates a ring of size values, a power of two
+func NewRing[T any](size int) *Ring[T] {
+	if size <= 0 || size&(size-1) != 0 {
+		panic(fmt.Sprintf("pipeline: ring size %d is not a power of two", size))
+	}
+	return &Ring[T]{
+		mask:   uint64(size - 1),
+		values: make([]T, size),
+		space:  make(chan struct{}, 1),
+	}
+}
+
+// Subscribe adds a consumer that sees each value once the consumers it
+// follows are done with it. Consumers are added before the first Publish.
+func (r *Ring[T]) Subscribe(name string, after ...*Consumer[T]) *Consumer[T] {
+	c := &Consumer[T]{
+		Name:  name,
+		ring:  r,
+		after: after,
+		ready: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	for _, upstream := range after {
+		upstream.downstream = append(upstream.downstream, c)
+	}
+	r.consumers = append(r.consumers, c)
+	return c
+}
+
+// TryPublish adds a value unless the slowest consumer is a full ring behind
+func (r *Ring[T]) TryPublish(value T) bool {
+	seq := r.cursor.Load()
+	if seq-r.slowest() > r.mask {
+		return false
+	}
+	r.values[seq&r.mask] = value
+	r.cursor.Store(seq + 1)
+	for _, c := range r.consumers {
+		if len(c.after) == 0 {
+			notify(c.ready)
+		}
+	}
+	return true
+}
+
+// Publish adds a value, waiting for the slowest consumer until the context
+// ends. Only one goroutine may publish.
+func (r *Ring[T]) Publish(ctx context.Context, value T) error {
+	for i := 0; ; i++ {
+		if r.closed.Load() {
+			return ErrClosed
+		}
+		if r.TryPublish(value) {
+			return nil
+		}
+		if i < spins {
+			runtime.Gosched()
+			continue
+		}
+		if err := park(ctx, r.space); err != nil {
+			return err
+		}
+	}
+}
+
+// Close ends the consumers once they have read every published value, it is
+// called by the producer
+func (r *Ring[T]) Close() {
+	r.closed.Store(true)
+	for _, c := range r.consumers {
+		notify(c.ready)
+	}
+}
+
+// Lag returns how many published values the slowest consumer has not read
+func (r *Ring[T]) Lag() int {
+	return int(r.cursor.Load() - r.slowest())
+}
+
+// Size returns the number of values the ring holds
+func (r *Ring[T]) Size() int {
+	return len(r.values)
+}
+
+func (r *Ring[T]) slowest() uint64 {
+	min := r.cursor.Load()
+	for _, c := range r.consumers {
+		if next := c.next.Load(); next < min {
+			min = next
+		}
+	}
+	return min
+}
+
+// available returns how far the consumer may read
+func (c *Consumer[T]) available() uint64 {
+	limit := c.ring.cursor.Load()
+	for _, upstream := range c.after {
+		if next := upstream.next.Load(); next < limit {
+			limit = next
+		}
+	}
+	return limit
+}
+
+// finished reports whether the consumer has read everything it ever will
+func (c *Consumer[T]) finished() bool {
+	if !c.ring.closed.Load() {
+		return false
+	}
+	for _, upstream := range c.after {
+		select {
+		case <-upstream.done:
+		default:
+			return false
+		}
+	}
+	return c.next.Load() == c.ring.cursor.Load()
+}
+
+// Run hands every value to handle, in order, until the ring is closed and
+// drained or the context ends. The value must not be kept after handle returns.
+func (c *Consumer[T]) Run(ctx context.Context, handle func(value T)) error {
+	defer c.wake()
+	defer close(c.done)
+	for i := 0; ; i++ {
+		next, limit := c.next.Load(), c.available()
+		if next < limit {
+			for ; next < limit; next++ {
+				handle(c.ring.values[next&c.ring.mask])
+				c.next.Store(next + 1)
+				c.wake()
+			}
+			i = 0
+			continue
+		}
+		if c.finished() {
+			return nil
+		}
+		if i < spins {
+			runtime.Gosched()
+			continue
+		}
+		if err := wait(ctx, c.ready); err != nil {
+			return err
+		}
+	}
+}
+
+// wake tells the consumers that follow and the producer that a value is done
+func (c *Consumer[T]) wake() {
+	for _, downstream := range c.downstream {
+		notify(downstream.ready)
+	}
+	notify(c.ring.space)
+}
+
+// Position returns how many values the consumer is done with
+func (c *Consumer[T]) Position() uint64 {
+	return c.next.Load()
+}
+
+// Lag returns how many published values the consumer has not read
+func (c *Consumer[T]) Lag() int {
+	return int(c.ring.cursor.Load() - c.next.Load())
+}
+
+// Done is closed once Run returned
+func (c *Consumer[T]) Done() <-chan struct{} {
+	return c.done
+}
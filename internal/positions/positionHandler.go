@@ -0,0 +1,37 @@
+package positions
+
+import (
+	"context"
+	"encoding/json"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/pkg/database"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func GetPositionsHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	if !auth.AuthorizeUser(w, r, params["userID"]) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	positions, err := GetPositions(ctx, client, params["userID"], params["symbol"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(positions)
+}
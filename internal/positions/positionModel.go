@@ -0,0 +1,44 @@
+package positions
+
+import (
+	"mfus_OMV1/internal/config"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Define the database and collection names
+var dbName = "orderbook"
+var positionsCollection = "positions"
+
+// Configure applies the database and collection names of the loaded config
+func Configure(cfg *config.Config) {
+	dbName = cfg.Mongo.Database
+	positionsCollection = cfg.Collections.Positions
+}
+
+// PositionModel is the net position of a user in one symbol. Quantity is
+// positive for a long position and negative for a short one.
+type PositionModel struct {
+	ID            primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	UserID        string             `json:"userID" bson:"userID"`
+	Symbol        string             `json:"symbol" bson:"symbol"`
+	Quantity      int64              `json:"quantity" bson:"quantity"`
+	AvgEntryPrice float64            `json:"avgEntryPrice" bson:"avgEntryPrice"`
+	RealizedPnL   float64            `json:"realizedPnL" bson:"realizedPnL"`
+	MarkPrice     float64            `json:"markPrice" bson:"-"`
+	UnrealizedPnL float64            `json:"unrealizedPnL" bson:"-"`
+	LastTradeID   string             `json:"lastTradeID" bson:"lastTradeID"`
+	UpdateTime    int64              `json:"updateTime" bson:"updateTime"`
+	// AppliedTrades holds the latest trades folded into the position
+	AppliedTrades []string `json:"-" bson:"appliedTrades,omitempty"`
+}
+
+// applied reports whether a trade is already part of the position
+func (p PositionModel) applied(tradeID string) bool {
+	for _, id := range p.AppliedTrades {
+		if id == tradeID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package positions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mfus_OMV1/internal/logging"
+	"mfus_OMV1/internal/orderbook"
+	"mfus_OMV1/pkg/database"
+	"mfus_OMV1/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var logger = logging.Logger("positions")
+
+// appliedTradesKept bounds the trade IDs a position remembers to refuse
+// replayed trades
+const appliedTradesKept = 100
+
+// maxApplyAttempts bounds the retries of a fill that raced another update
+const maxApplyAttempts = 5
+
+// errPositionChanged is returned when a position kept changing while a fill
+// was applied to it
+var errPositionChanged = errors.New("position changed while applying the fill")
+
+// Start subscribes the positions service to engine trades
+func Start() {
+	orderbook.OnTrade(func(trade orderbook.TradeHistoryModel) {
+		if err := ApplyTrade(trade); err != nil {
+			logger.Error("cannot apply trade to positions", "trade", trade.Id, "symbol", trade.Symbol, "error", err)
+		}
+	})
+}
+
+// EnsureIndexes creates the index that keeps one position per user and symbol
+func EnsureIndexes(ctx context.Context, mongoClient *mongo.Client) error {
+	_, err := mongoClient.Database(dbName).Collection(positionsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "userID", Value: 1}, {Key: "symbol", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// ApplyTrade updates the positions of both sides of a trade and pushes them
+// to the users' private WebSocket channel. A side that cannot be updated does
+// not stop the other, the errors of both are returned.
+func ApplyTrade(trade orderbook.TradeHistoryModel) error {
+	client, err := database.GetMongoClient()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, leg := range []struct {
+		userID   string
+		quantity int64
+	}{
+		{trade.BuyUserID, trade.Quantity},
+		{trade.SellUserID, -trade.Quantity},
+	} {
+		position, err := applyFill(context.Background(), client, leg.userID, trade, leg.quantity)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user %s: %w", leg.userID, err))
+			continue
+		}
+		orderbook.PublishPrivate(leg.userID, "positions", position)
+	}
+	return errors.Join(errs...)
+}
+
+// applyFill folds a signed fill into the stored position. The update only
+// matches the position it was computed from and only if the trade has not
+// been applied yet, so a concurrent update is retried and a replayed trade
+// leaves the position as it is.
+func applyFill(ctx context.Context, mongoClient *mongo.Client, userID string, trade orderbook.TradeHistoryModel, quantity int64) (PositionModel, error) {
+	collection := mongoClient.Database(dbName).Collection(positionsCollection)
+	key := bson.M{"userID": userID, "symbol": trade.Symbol}
+
+	for attempt := 0; attempt < maxApplyAttempts; attempt++ {
+		position := PositionModel{UserID: userID, Symbol: trade.Symbol}
+		err := collection.FindOne(ctx, key).Decode(&position)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return PositionModel{}, err
+		}
+		stored := err == nil
+		if position.applied(trade.Id) {
+			return mark(position), nil
+		}
+
+		next := fold(position, trade.Price, quantity)
+		filter := bson.M{
+			"userID":        userID,
+			"symbol":        trade.Symbol,
+			"lastTradeID":   position.LastTradeID,
+			"appliedTrades": bson.M{"$ne": trade.Id},
+		}
+		update := bson.M{
+			"$inc": bson.M{
+				"quantity":    next.Quantity - position.Quantity,
+				"realizedPnL": next.RealizedPnL - position.RealizedPnL,
+			},
+			"$set": bson.M{
+				"avgEntryPrice": next.AvgEntryPrice,
+				"lastTradeID":   trade.Id,
+				"updateTime":    utils.GetCurrentTimestamp(),
+			},
+			"$push": bson.M{"appliedTrades": bson.M{"$each": bson.A{trade.Id}, "$slice": -appliedTradesKept}},
+		}
+		// Only a position that does not exist yet is inserted, the unique
+		// index refuses a second one created meanwhile
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetUpsert(!stored)
+		var updated PositionModel
+		err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+		if err == mongo.ErrNoDocuments || mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return PositionModel{}, err
+		}
+		return mark(updated), nil
+	}
+	return PositionModel{}, errPositionChanged
+}
+
+// fold returns a position after a signed fill at a price, using average cost
+func fold(position PositionModel, price float64, quantity int64) PositionModel {
+	switch {
+	case position.Quantity == 0 || (position.Quantity > 0) == (quantity > 0):
+		// Opening or adding to a position moves the average entry price
+		total := abs(position.Quantity) + abs(quantity)
+		position.AvgEntryPrice = (float64(abs(position.Quantity))*position.AvgEntryPrice + float64(abs(quantity))*price) / float64(total)
+		position.Quantity += quantity
+	default:
+		// Reducing a position realizes P&L on the closed quantity
+		closed := abs(quantity)
+		if abs(position.Quantity) < closed {
+			closed = abs(position.Quantity)
+		}
+		direction := 1.0
+		if position.Quantity < 0 {
+			direction = -1.0
+		}
+		position.RealizedPnL += float64(closed) * (price - position.AvgEntryPrice) * direction
+		position.Quantity += quantity
+		if position.Quantity == 0 {
+			position.AvgEntryPrice = 0
+		} else if (position.Quantity > 0) == (quantity > 0) {
+			// The fill flipped the position, the remainder opens at the trade price
+			position.AvgEntryPrice = price
+		}
+	}
+	return position
+}
+
+// GetPositions returns the positions of a user, all symbols when symbol is empty
+func GetPositions(ctx context.Context, mongoClient *mongo.Client, userID string, symbol string) ([]PositionModel, error) {
+	filter := bson.M{"userID": userID}
+	if symbol != "" {
+		filter["symbol"] = symbol
+	}
+	cursor, err := mongoClient.Database(dbName).Collection(positionsCollection).Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	positions := make([]PositionModel, 0)
+	if err := cursor.All(ctx, &positions); err != nil {
+		return nil, err
+	}
+	for i := range positions {
+		positions[i] = mark(positions[i])
+	}
+	return positions, nil
+}
+
+// mark values a position against the last trade price of its symbol
+func mark(position PositionModel) PositionModel {
+	manager, ok := orderbook.FindOrderManager(position.Symbol)
+	if !ok {
+		return position
+	}
+	position.MarkPrice = manager.LastPrice()
+	if position.MarkPrice > 0 {
+		position.UnrealizedPnL = float64(position.Quantity) * (position.MarkPrice - position.AvgEntryPrice)
+	}
+	return position
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,57 @@
+package positions
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		name     string
+		position PositionModel
+		price    float64
+		quantity int64
+		want     PositionModel
+	}{
+		{"open long", PositionModel{}, 100, 10,
+			PositionModel{Quantity: 10, AvgEntryPrice: 100}},
+		{"add to long", PositionModel{Quantity: 10, AvgEntryPrice: 100}, 130, 20,
+			PositionModel{Quantity: 30, AvgEntryPrice: 120}},
+		{"reduce long", PositionModel{Quantity: 10, AvgEntryPrice: 100}, 110, -4,
+			PositionModel{Quantity: 6, AvgEntryPrice: 100, RealizedPnL: 40}},
+		{"close long", PositionModel{Quantity: 10, AvgEntryPrice: 100}, 90, -10,
+			PositionModel{Quantity: 0, AvgEntryPrice: 0, RealizedPnL: -100}},
+		{"flip long to short", PositionModel{Quantity: 10, AvgEntryPrice: 100}, 105, -15,
+			PositionModel{Quantity: -5, AvgEntryPrice: 105, RealizedPnL: 50}},
+		{"reduce short", PositionModel{Quantity: -10, AvgEntryPrice: 100, RealizedPnL: 5}, 90, 5,
+			PositionModel{Quantity: -5, AvgEntryPrice: 100, RealizedPnL: 55}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := fold(test.position, test.price, test.quantity)
+			if got.Quantity != test.want.Quantity ||
+				math.Abs(got.AvgEntryPrice-test.want.AvgEntryPrice) > 1e-9 ||
+				math.Abs(got.RealizedPnL-test.want.RealizedPnL) > 1e-9 {
+				t.Fatalf("fold() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestApplied(t *testing.T) {
+	position := PositionModel{AppliedTrades: []string{"t1", "t2"}}
+	tests := []struct {
+		trade   string
+		applied bool
+	}{
+		{"t1", true},
+		{"t2", true},
+		{"t3", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := position.applied(test.trade); got != test.applied {
+			t.Errorf("applied(%q) = %v, want %v", test.trade, got, test.applied)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"context"
+	"mfus_OMV1/internal/config"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Define the database and collection names
+var dbName = "orderbook"
+var limitsCollection = "rate_limits"
+
+// Configure applies the database and collection names of the loaded config
+func Configure(cfg *config.Config) {
+	dbName = cfg.Mongo.Database
+	limitsCollection = cfg.Collections.RateLimits
+}
+
+// overrideCacheTTL bounds how stale an account override can be
+const overrideCacheTTL = 30 * time.Second
+
+// AccountLimitModel overrides the symbol default rate of a user
+type AccountLimitModel struct {
+	ID     primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	UserID string             `json:"userID" bson:"userID"`
+	MaxTPS int                `json:"maxTPS" bson:"maxTPS"`
+}
+
+type cachedOverride struct {
+	maxTPS int
+	at     time.Time
+}
+
+var overridesMutex sync.Mutex
+var overrides = make(map[string]cachedOverride)
+
+// LimitFor returns the limit of a user, defaulting to defaultTPS when the
+// account has no override
+func LimitFor(ctx context.Context, mongoClient *mongo.Client, userID string, defaultTPS int) Limit {
+	maxTPS := defaultTPS
+	if override := accountOverride(ctx, mongoClient, userID); override > 0 {
+		maxTPS = override
+	}
+	return Limit{Rate: float64(maxTPS), Burst: maxTPS}
+}
+
+// SetAccountLimit stores the override of a user, zero removes it
+func SetAccountLimit(ctx context.Context, mongoClient *mongo.Client, userID string, maxTPS int) error {
+	collection := mongoClient.Database(dbName).Collection(limitsCollection)
+	var err error
+	if maxTPS <= 0 {
+		_, err = collection.DeleteOne(ctx, bson.M{"userID": userID})
+	} else {
+		_, err = collection.ReplaceOne(ctx,
+			bson.M{"userID": userID},
+			AccountLimitModel{UserID: userID, MaxTPS: maxTPS},
+			options.Replace().SetUpsert(true),
+		)
+	}
+	if err == nil {
+		overridesMutex.Lock()
+		delete(overrides, userID)
+		overridesMutex.Unlock()
+	}
+	return err
+}
+
+func accountOverride(ctx context.Context, mongoClient *mongo.Client, userID string) int {
+	overridesMutex.Lock()
+	cached, ok := overrides[userID]
+	overridesMutex.Unlock()
+	if ok && time.Since(cached.at) < overrideCacheTTL {
+		return cached.maxTPS
+	}
+
+	var limit AccountLimitModel
+	err := mongoClient.Database(dbName).Collection(limitsCollection).FindOne(ctx, bson.M{"userID": userID}).Decode(&limit)
+	if err != nil && err != mongo.ErrNoDocuments {
+		// Keep serving the previous value rather than failing order entry
+		return cached.maxTPS
+	}
+
+	overridesMutex.Lock()
+	overrides[userID] = cachedOverride{maxTPS: limit.MaxTPS, at: time.Now()}
+	overridesMutex.Unlock()
+	return limit.MaxTPS
+}
@@ -0,0 +1,41 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"mfus_OMV1/pkg/database"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func SetAccountLimitHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	var limit AccountLimitModel
+	err := json.NewDecoder(r.Body).Decode(&limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit.UserID = params["userID"]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = SetAccountLimit(ctx, client, limit.UserID, limit.MaxTPS)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(limit)
+}
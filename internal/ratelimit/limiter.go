@@ -0,0 +1,179 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"mfus_OMV1/internal/logging"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Limit is a token bucket refilled at Rate tokens per second up to Burst tokens
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket is back to its burst if left alone
+	full time.Time
+}
+
+// sweepInterval is how often idle in-memory buckets are dropped
+const sweepInterval = time.Minute
+
+// Limiter hands out tokens from named buckets. Buckets live in Redis when a
+// client is configured so that every instance shares the same counters, and
+// in memory otherwise or while Redis is unreachable. Buckets that refilled
+// completely are dropped, Redis expires them and memory is swept.
+type Limiter struct {
+	redisClient *redis.Client
+	mutex       sync.Mutex
+	buckets     map[string]*bucket
+	swept       time.Time
+}
+
+// tokenBucketScript refills the buckets of every key and takes one token from
+// each, atomically and only if all of them have one. It returns whether the
+// tokens were granted and the wait in milliseconds otherwise.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local tokens = {}
+local wait = 0
+for i, key in ipairs(KEYS) do
+	local state = redis.call('HMGET', key, 'tokens', 'ts')
+	local available = tonumber(state[1]) or burst
+	local ts = tonumber(state[2]) or now
+	available = math.min(burst, available + math.max(0, now - ts) * rate / 1000)
+	if available < 1 then
+		wait = math.max(wait, math.ceil((1 - available) * 1000 / rate))
+	end
+	tokens[i] = available
+end
+local allowed = 0
+if wait == 0 then
+	allowed = 1
+end
+for i, key in ipairs(KEYS) do
+	tokens[i] = tokens[i] - allowed
+	redis.call('HSET', key, 'tokens', tostring(tokens[i]), 'ts', tostring(now))
+	redis.call('PEXPIRE', key, math.ceil(burst * 1000 / rate) + 1000)
+end
+return {allowed, wait}
+`)
+
+var logger = logging.Logger("ratelimit")
+
+var defaultLimiter = NewLimiter(nil)
+
+// Init makes the package level limiter share its buckets through Redis
+func Init(redisClient *redis.Client) {
+	defaultLimiter = NewLimiter(redisClient)
+}
+
+// Allow takes a token from a bucket of the package level limiter
+func Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration) {
+	return defaultLimiter.Allow(ctx, key, limit)
+}
+
+// AllowAll takes a token from each bucket of the package level limiter, or none
+func AllowAll(ctx context.Context, keys []string, limit Limit) (bool, time.Duration) {
+	return defaultLimiter.AllowAll(ctx, keys, limit)
+}
+
+// NewLimiter creates a limiter, redisClient may be nil for in-memory buckets only
+func NewLimiter(redisClient *redis.Client) *Limiter {
+	return &Limiter{
+		redisClient: redisClient,
+		buckets:     make(map[string]*bucket),
+		swept:       time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of key. When no token is available it
+// returns false and how long the caller should wait before retrying.
+func (l *Limiter) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration) {
+	return l.AllowAll(ctx, []string{key}, limit)
+}
+
+// AllowAll takes a token from the bucket of every key when each of them has
+// one, so that a request refused by one bucket does not spend the others.
+// Otherwise it returns false and how long the caller should wait.
+func (l *Limiter) AllowAll(ctx context.Context, keys []string, limit Limit) (bool, time.Duration) {
+	if limit.Rate <= 0 || len(keys) == 0 {
+		return true, 0
+	}
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+
+	if l.redisClient != nil {
+		redisKeys := make([]string, len(keys))
+		for i, key := range keys {
+			redisKeys[i] = "ratelimit:" + key
+		}
+		result, err := tokenBucketScript.Run(ctx, l.redisClient, redisKeys,
+			limit.Rate, limit.Burst, time.Now().UnixNano()/int64(time.Millisecond),
+		).Int64Slice()
+		if err == nil && len(result) == 2 {
+			return result[0] == 1, time.Duration(result[1]) * time.Millisecond
+		}
+		logger.WarnContext(ctx, "Redis rate limiter unavailable, falling back to memory", "error", err)
+	}
+
+	return l.allowLocal(keys, limit)
+}
+
+func (l *Limiter) allowLocal(keys []string, limit Limit) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+	buckets := make([]*bucket, len(keys))
+	wait := time.Duration(0)
+	for i, key := range keys {
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &bucket{tokens: float64(limit.Burst), last: now}
+			l.buckets[key] = b
+		}
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+		b.last = now
+		if b.tokens < 1 {
+			if w := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second)); w > wait {
+				wait = w
+			}
+		}
+		buckets[i] = b
+	}
+
+	allowed := wait == 0
+	for _, b := range buckets {
+		if allowed {
+			b.tokens--
+		}
+		b.full = now.Add(time.Duration((float64(limit.Burst) - b.tokens) / limit.Rate * float64(time.Second)))
+	}
+	return allowed, wait
+}
+
+// sweep drops the buckets that refilled completely, a new bucket starts full
+// so forgetting them changes nothing
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if !now.Before(b.full) {
+			delete(l.buckets, key)
+		}
+	}
+}
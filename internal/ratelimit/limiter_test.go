@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAllowAll(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 1, Burst: 2}
+	type call struct {
+		keys    []string
+		allowed bool
+	}
+	tests := []struct {
+		name  string
+		limit Limit
+		calls []call
+	}{
+		{"burst then refused", limit, []call{
+			{[]string{"a"}, true},
+			{[]string{"a"}, true},
+			{[]string{"a"}, false},
+		}},
+		{"buckets are independent", limit, []call{
+			{[]string{"a"}, true},
+			{[]string{"a"}, true},
+			{[]string{"b"}, true},
+		}},
+		{"refused by one bucket spends none", limit, []call{
+			{[]string{"a"}, true},
+			{[]string{"a"}, true},
+			{[]string{"a", "b"}, false},
+			{[]string{"b"}, true},
+			{[]string{"b"}, true},
+			{[]string{"b"}, false},
+		}},
+		{"granted takes from every bucket", limit, []call{
+			{[]string{"a", "b"}, true},
+			{[]string{"a", "b"}, true},
+			{[]string{"b"}, false},
+		}},
+		{"burst below one allows one", Limit{Rate: 1, Burst: 0}, []call{
+			{[]string{"a"}, true},
+			{[]string{"a"}, false},
+		}},
+		{"no rate is unlimited", Limit{}, []call{
+			{[]string{"a"}, true},
+			{[]string{"a"}, true},
+			{[]string{"a"}, true},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := NewLimiter(nil)
+			for i, c := range test.calls {
+				allowed, wait := limiter.AllowAll(ctx, c.keys, test.limit)
+				if allowed != c.allowed {
+					t.Fatalf("call %d on %v: allowed %v, want %v", i, c.keys, allowed, c.allowed)
+				}
+				if !allowed && (wait <= 0 || wait > time.Second) {
+					t.Fatalf("call %d on %v: wait %v, want up to a second", i, c.keys, wait)
+				}
+			}
+		})
+	}
+}
+
+func TestRefillAndSweep(t *testing.T) {
+	limiter := NewLimiter(nil)
+	limit := Limit{Rate: 1, Burst: 1}
+	if ok, _ := limiter.Allow(context.Background(), "a", limit); !ok {
+		t.Fatal("first token refused")
+	}
+
+	// Wind the clock of the bucket back instead of sleeping
+	limiter.mutex.Lock()
+	limiter.buckets["a"].last = limiter.buckets["a"].last.Add(-time.Second)
+	limiter.mutex.Unlock()
+	if ok, _ := limiter.Allow(context.Background(), "a", limit); !ok {
+		t.Fatal("token not refilled after a second")
+	}
+
+	// A bucket that refilled completely is dropped by the next sweep
+	limiter.mutex.Lock()
+	limiter.buckets["a"].full = time.Now().Add(-time.Second)
+	limiter.swept = time.Now().Add(-sweepInterval)
+	limiter.sweep(time.Now())
+	_, kept := limiter.buckets["a"]
+	limiter.mutex.Unlock()
+	if kept {
+		t.Fatal("full bucket kept by the sweep")
+	}
+}
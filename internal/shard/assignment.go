@@ -0,0 +1,238 @@
+package shard
+
+import (
+	"context"
+	"mfus_OMV1/internal/leader"
+	"mfus_OMV1/utils"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Assignment states. A symbol moves from its node to the target in two steps:
+// the node stops matching it, writes a snapshot and releases its lease
+// (handover), then the target takes it (active). No node matches the symbol in
+// between, orders placed meanwhile wait in MongoDB for the target.
+const (
+	StateActive   = "active"
+	StateMoving   = "moving"
+	StateHandover = "handover"
+)
+
+// AssignmentModel is the node owning a symbol. Epoch grows with every change
+// of node so that concurrent transitions cannot overwrite each other.
+type AssignmentModel struct {
+	Symbol           string `json:"symbol" bson:"_id"`
+	Node             string `json:"node" bson:"node"`
+	Target           string `json:"target,omitempty" bson:"target,omitempty"`
+	State            string `json:"state" bson:"state"`
+	Epoch            int64  `json:"epoch" bson:"epoch"`
+	SnapshotSequence uint64 `json:"snapshotSequence,omitempty" bson:"snapshotSequence,omitempty"`
+	UpdateTime       int64  `json:"updateTime" bson:"updateTime"`
+}
+
+// cacheTTL bounds how long a node keeps matching a symbol that started moving
+const cacheTTL = time.Second
+
+var assignmentsMutex sync.Mutex
+var assignments map[string]AssignmentModel
+var assignmentsLoaded time.Time
+
+func collection(mongoClient *mongo.Client) *mongo.Collection {
+	return mongoClient.Database(dbName).Collection(assignmentsCollection)
+}
+
+// GetAssignments returns the assignment of every symbol ordered by symbol
+func GetAssignments(ctx context.Context, mongoClient *mongo.Client) ([]AssignmentModel, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := collection(mongoClient).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]AssignmentModel, 0)
+	err = cursor.All(ctx, &result)
+	return result, err
+}
+
+// assignmentOf returns the cached assignment of a symbol
+func assignmentOf(ctx context.Context, mongoClient *mongo.Client, symbol string) (AssignmentModel, bool, error) {
+	cached, err := cachedAssignments(ctx, mongoClient)
+	if err != nil {
+		return AssignmentModel{}, false, err
+	}
+	assignment, ok := cached[symbol]
+	return assignment, ok, nil
+}
+
+// cachedAssignments returns the assignments by symbol, read again after
+// cacheTTL. The previous ones are kept while MongoDB fails.
+func cachedAssignments(ctx context.Context, mongoClient *mongo.Client) (map[string]AssignmentModel, error) {
+	assignmentsMutex.Lock()
+	cached, loaded := assignments, assignmentsLoaded
+	assignmentsMutex.Unlock()
+
+	if cached == nil || time.Since(loaded) >= cacheTTL {
+		listed, err := GetAssignments(ctx, mongoClient)
+		if err != nil && cached == nil {
+			return nil, err
+		}
+		if err == nil {
+			cached = make(map[string]AssignmentModel, len(listed))
+			for _, assignment := range listed {
+				cached[assignment.Symbol] = assignment
+			}
+			assignmentsMutex.Lock()
+			assignments, assignmentsLoaded = cached, time.Now()
+			assignmentsMutex.Unlock()
+		}
+	}
+	return cached, nil
+}
+
+// forgetAssignments makes the next lookup read the assignments again
+func forgetAssignments() {
+	assignmentsMutex.Lock()
+	assignmentsLoaded = time.Time{}
+	assignmentsMutex.Unlock()
+}
+
+// Owns reports whether this node matches a symbol. A symbol seen for the first
+// time is assigned on the spot to the owner on the ring, every node computes
+// the same one. Without sharding every symbol is owned.
+func Owns(ctx context.Context, mongoClient *mongo.Client, symbol string) bool {
+	if !settings.Enabled {
+		return true
+	}
+	assignment, ok, err := assignmentOf(ctx, mongoClient, symbol)
+	if err != nil {
+		logger.Warn("cannot load assignments", "error", err)
+		return false
+	}
+	if !ok {
+		assignment, ok = assign(ctx, mongoClient, symbol)
+		if !ok {
+			return false
+		}
+	}
+	return assignment.State == StateActive && assignment.Node == leader.InstanceID()
+}
+
+// Owned returns the symbols this node matches or hands over, the only books
+// it loads. It is false without sharding, when every symbol is owned.
+func Owned(ctx context.Context, mongoClient *mongo.Client) ([]string, bool, error) {
+	if !settings.Enabled {
+		return nil, false, nil
+	}
+	cached, err := cachedAssignments(ctx, mongoClient)
+	if err != nil {
+		return nil, true, err
+	}
+	id := leader.InstanceID()
+	symbols := make([]string, 0)
+	for symbol, assignment := range cached {
+		if assignment.Node == id && (assignment.State == StateActive || assignment.State == StateMoving) {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
+	return symbols, true, nil
+}
+
+// assign records the owner on the ring of a symbol without an assignment
+func assign(ctx context.Context, mongoClient *mongo.Client, symbol string) (AssignmentModel, bool) {
+	owner, ok := ring().Owner(symbol)
+	if !ok {
+		return AssignmentModel{}, false
+	}
+	assignment := AssignmentModel{
+		Symbol:     symbol,
+		Node:       owner,
+		State:      StateActive,
+		Epoch:      1,
+		UpdateTime: utils.GetCurrentTimestamp(),
+	}
+	_, err := collection(mongoClient).InsertOne(ctx, assignment)
+	forgetAssignments()
+	if mongo.IsDuplicateKeyError(err) {
+		// Another node assigned it first
+		assignment, ok, err = assignmentOf(ctx, mongoClient, symbol)
+		return assignment, ok && err == nil
+	}
+	if err != nil {
+		logger.Warn("cannot assign symbol", "symbol", symbol, "error", err)
+		return assignment, false
+	}
+	logger.Info("symbol assigned", "symbol", symbol, "node", owner)
+	return assignment, true
+}
+
+// HandingOver returns the assignment of a symbol this node must hand over
+func HandingOver(ctx context.Context, mongoClient *mongo.Client, symbol string) (AssignmentModel, bool) {
+	if !settings.Enabled {
+		return AssignmentModel{}, false
+	}
+	assignment, ok, err := assignmentOf(ctx, mongoClient, symbol)
+	if err != nil || !ok {
+		return assignment, false
+	}
+	return assignment, assignment.State == StateMoving && assignment.Node == leader.InstanceID()
+}
+
+// CompleteHandover records that this node stopped matching a moving symbol and
+// wrote the snapshot the target starts from
+func CompleteHandover(ctx context.Context, mongoClient *mongo.Client, assignment AssignmentModel, sequence uint64) error {
+	_, err := collection(mongoClient).UpdateOne(ctx,
+		bson.M{"_id": assignment.Symbol, "epoch": assignment.Epoch, "state": StateMoving, "node": leader.InstanceID()},
+		bson.M{"$set": bson.M{"state": StateHandover, "snapshotSequence": sequence, "updateTime": utils.GetCurrentTimestamp()}})
+	forgetAssignments()
+	if err == nil {
+		logger.Info("symbol handed over", "symbol", assignment.Symbol, "target", assignment.Target, "sequence", sequence)
+	}
+	return err
+}
+
+// activate takes over the symbols handed over to this node
+func activate(ctx context.Context, mongoClient *mongo.Client) error {
+	id := leader.InstanceID()
+	listed, err := GetAssignments(ctx, mongoClient)
+	if err != nil {
+		return err
+	}
+	for _, assignment := range listed {
+		if assignment.State != StateHandover || assignment.Target != id {
+			continue
+		}
+		result, err := collection(mongoClient).UpdateOne(ctx,
+			bson.M{"_id": assignment.Symbol, "epoch": assignment.Epoch, "state": StateHandover},
+			bson.M{
+				"$set":   bson.M{"node": id, "state": StateActive, "updateTime": utils.GetCurrentTimestamp()},
+				"$unset": bson.M{"target": ""},
+			})
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount > 0 {
+			logger.Info("symbol taken over", "symbol", assignment.Symbol, "from", assignment.Node)
+		}
+	}
+	forgetAssignments()
+	return nil
+}
+
+// Owner returns the node orders for a symbol go to, the target while the
+// symbol moves. It is false when the node is unknown or not live.
+func Owner(ctx context.Context, mongoClient *mongo.Client, symbol string) (Node, bool) {
+	assignment, ok, err := assignmentOf(ctx, mongoClient, symbol)
+	if err != nil || !ok {
+		return Node{}, false
+	}
+	id := assignment.Node
+	if assignment.State != StateActive {
+		id = assignment.Target
+	}
+	return node(id)
+}
@@ -0,0 +1,252 @@
+package shard
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mfus_OMV1/internal/auth"
+	"mfus_OMV1/internal/leader"
+	"mfus_OMV1/pkg/database"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Headers of a forwarded request. The signature is the hex encoded
+// HMAC-SHA256, under the peer secret, of the node, time, nonce, caller, method
+// and request URI joined by newlines, followed by a newline and the body.
+const (
+	HeaderForwardedBy        = "X-MFUS-Forwarded-By"
+	HeaderForwardedAt        = "X-MFUS-Forwarded-At"
+	HeaderForwardedNonce     = "X-MFUS-Forwarded-Nonce"
+	HeaderForwardedFor       = "X-MFUS-Forwarded-For"
+	HeaderForwardedSignature = "X-MFUS-Forwarded-Signature"
+)
+
+var proxiesMutex sync.Mutex
+var proxies = make(map[string]*httputil.ReverseProxy)
+
+// TrustPeers accepts the caller of a request forwarded by a live node that
+// signed it with the peer secret, the forwarding node already authenticated
+// the caller and used up its nonce. The forwarding carries a nonce of its own
+// so that it cannot be replayed either. A request claiming to be forwarded
+// that fails the check is refused.
+func TrustPeers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(HeaderForwardedBy) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		principal, err := forwardedPrincipal(r)
+		if err != nil {
+			logger.WarnContext(r.Context(), "forwarded request refused", "node", r.Header.Get(HeaderForwardedBy), "error", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(auth.WithPrincipal(r.Context(), principal), forwardedKey{}, true)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// forwardedKey marks a request a peer forwarded, it is served where it arrives
+type forwardedKey struct{}
+
+// forwardedPrincipal checks the signature of a forwarded request and returns
+// the caller it was forwarded for
+func forwardedPrincipal(r *http.Request) (auth.Principal, error) {
+	if !settings.Enabled {
+		return auth.Principal{}, errors.New("forwarded request but sharding is disabled")
+	}
+	id := r.Header.Get(HeaderForwardedBy)
+	if _, ok := node(id); !ok {
+		return auth.Principal{}, errors.New("forwarded by unknown node " + id)
+	}
+	millis, err := strconv.ParseInt(r.Header.Get(HeaderForwardedAt), 10, 64)
+	if err != nil {
+		return auth.Principal{}, errors.New("invalid forwarding time")
+	}
+	if drift := time.Since(time.UnixMilli(millis)); drift > auth.SignatureWindow || drift < -auth.SignatureWindow {
+		return auth.Principal{}, errors.New("forwarding time outside the allowed window")
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return auth.Principal{}, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	nonce := r.Header.Get(HeaderForwardedNonce)
+	if nonce == "" {
+		return auth.Principal{}, errors.New("missing forwarding nonce")
+	}
+	caller := r.Header.Get(HeaderForwardedFor)
+	expected := signForwarded(id, r.Header.Get(HeaderForwardedAt), nonce, caller, r.Method, r.URL.RequestURI(), body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(r.Header.Get(HeaderForwardedSignature)))) {
+		return auth.Principal{}, errors.New("invalid forwarding signature")
+	}
+	if err := auth.UseNonce(r.Context(), "peer:"+id, nonce); err != nil {
+		return auth.Principal{}, err
+	}
+
+	var principal auth.Principal
+	decoded, err := base64.RawURLEncoding.DecodeString(caller)
+	if err != nil || json.Unmarshal(decoded, &principal) != nil || principal.UserID == "" {
+		return auth.Principal{}, errors.New("invalid forwarded caller")
+	}
+	return principal, nil
+}
+
+func signForwarded(node string, at string, nonce string, caller string, method string, requestURI string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(settings.PeerSecret))
+	mac.Write([]byte(strings.Join([]string{node, at, nonce, caller, method, requestURI}, "\n") + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Gateway forwards order entry for a symbol owned by another node to that
+// node. symbolOf names the symbol a request acts on, or returns false for the
+// requests every node serves. It runs after authentication and forwards the
+// caller it resolved, signed for TrustPeers on the owner. A request whose
+// owner is unknown or unreachable is served here, the owner picks the order
+// up from MongoDB.
+func Gateway(symbolOf func(r *http.Request, body []byte) (string, bool)) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer, _ := r.Context().Value(forwardedKey{}).(bool)
+			if !settings.Enabled || peer || r.Method == http.MethodGet || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			principal, ok := auth.FromContext(r.Context())
+			if !ok {
+				http.Error(w, auth.ErrUnauthenticated.Error(), http.StatusUnauthorized)
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			symbol, ok := symbolOf(r, body)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+			owner, ok := ownerOf(ctx, symbol)
+			cancel()
+			if !ok || owner.ID == leader.InstanceID() || owner.URL == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			proxy, err := proxyTo(owner)
+			if err != nil {
+				logger.WarnContext(r.Context(), "cannot forward order", "symbol", symbol, "node", owner.ID, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			caller, err := json.Marshal(principal)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			random := make([]byte, 16)
+			if _, err := rand.Read(random); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			nonce := hex.EncodeToString(random)
+			forwarded := r.Clone(context.WithValue(r.Context(), fallbackKey{}, func(w http.ResponseWriter) {
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				next.ServeHTTP(w, r)
+			}))
+			forwarded.Body = io.NopCloser(bytes.NewReader(body))
+			at := strconv.FormatInt(time.Now().UnixMilli(), 10)
+			encoded := base64.RawURLEncoding.EncodeToString(caller)
+			forwarded.Header.Set(HeaderForwardedBy, leader.InstanceID())
+			forwarded.Header.Set(HeaderForwardedAt, at)
+			forwarded.Header.Set(HeaderForwardedNonce, nonce)
+			forwarded.Header.Set(HeaderForwardedFor, encoded)
+			forwarded.Header.Set(HeaderForwardedSignature,
+				signForwarded(leader.InstanceID(), at, nonce, encoded, r.Method, r.URL.RequestURI(), body))
+			proxy.ServeHTTP(w, forwarded)
+		})
+	}
+}
+
+// fallbackKey carries the local handler to a proxy whose owner is unreachable
+type fallbackKey struct{}
+
+func ownerOf(ctx context.Context, symbol string) (Node, bool) {
+	mongoClient, err := database.GetMongoClient()
+	if err != nil {
+		return Node{}, false
+	}
+	return Owner(ctx, mongoClient, symbol)
+}
+
+func proxyTo(owner Node) (*httputil.ReverseProxy, error) {
+	proxiesMutex.Lock()
+	defer proxiesMutex.Unlock()
+	if proxy, ok := proxies[owner.URL]; ok {
+		return proxy, nil
+	}
+	target, err := url.Parse(owner.URL)
+	if err != nil {
+		return nil, err
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		// Only a request that never reached the owner can be served here, any
+		// other failure may have placed the order already
+		var opErr *net.OpError
+		serve, ok := r.Context().Value(fallbackKey{}).(func(w http.ResponseWriter))
+		if ok && errors.As(err, &opErr) && opErr.Op == "dial" {
+			logger.WarnContext(r.Context(), "owner unreachable, serving order here", "node", owner.ID, "error", err)
+			serve(w)
+			return
+		}
+		logger.WarnContext(r.Context(), "cannot forward order", "node", owner.ID, "error", err)
+		http.Error(w, "order forwarded to "+owner.ID+" failed, check its status before retrying", http.StatusBadGateway)
+	}
+	proxies[owner.URL] = proxy
+	return proxy, nil
+}
+
+// GetShardsHandler shows the live nodes and the assignment of every symbol
+func GetShardsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	mongoClient, err := database.GetMongoClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	listed, err := GetAssignments(ctx, mongoClient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Enabled     bool              `json:"enabled"`
+		Nodes       []Node            `json:"nodes"`
+		Assignments []AssignmentModel `json:"assignments"`
+	}{settings.Enabled, Nodes(), listed})
+}
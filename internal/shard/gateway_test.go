@@ -0,0 +1,151 @@
+package shard
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mfus_OMV1/internal/auth"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testPeers enables sharding with a peer secret and one live peer until the
+// test ends
+func testPeers(t *testing.T) {
+	t.Helper()
+	saved := settings
+	settings.Enabled = true
+	settings.PeerSecret = "peer-secret"
+	nodesMutex.Lock()
+	liveNodes = []Node{{ID: "node-b", URL: "http://node-b:8080"}}
+	nodesMutex.Unlock()
+	t.Cleanup(func() {
+		settings = saved
+		nodesMutex.Lock()
+		liveNodes = nil
+		nodesMutex.Unlock()
+	})
+}
+
+// forwardedRequest builds a request as Gateway forwards it from node-b
+func forwardedRequest(t *testing.T, nonce string, at time.Time, body string) *http.Request {
+	t.Helper()
+	caller, err := json.Marshal(auth.Principal{UserID: "alice", Permissions: []string{auth.PermTrade}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(caller)
+	millis := strconv.FormatInt(at.UnixMilli(), 10)
+	r := httptest.NewRequest(http.MethodPost, "/v1/orders", bytes.NewReader([]byte(body)))
+	r.Header.Set(HeaderForwardedBy, "node-b")
+	r.Header.Set(HeaderForwardedAt, millis)
+	r.Header.Set(HeaderForwardedNonce, nonce)
+	r.Header.Set(HeaderForwardedFor, encoded)
+	r.Header.Set(HeaderForwardedSignature, signForwarded("node-b", millis, nonce, encoded, http.MethodPost, "/v1/orders", []byte(body)))
+	return r
+}
+
+func TestForwardedPrincipal(t *testing.T) {
+	testPeers(t)
+	const body = `{"symbol":"BTC-USD","side":"buy","price":100,"quantity":1}`
+	now := time.Now()
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		valid   bool
+	}{
+		{"signed by a live peer", func() *http.Request {
+			return forwardedRequest(t, "nonce-1", now, body)
+		}, true},
+		{"replayed nonce", func() *http.Request {
+			return forwardedRequest(t, "nonce-1", now, body)
+		}, false},
+		{"missing nonce", func() *http.Request {
+			return forwardedRequest(t, "", now, body)
+		}, false},
+		{"nonce swapped after signing", func() *http.Request {
+			r := forwardedRequest(t, "nonce-2", now, body)
+			r.Header.Set(HeaderForwardedNonce, "nonce-3")
+			return r
+		}, false},
+		{"unknown node", func() *http.Request {
+			r := forwardedRequest(t, "nonce-4", now, body)
+			r.Header.Set(HeaderForwardedBy, "node-x")
+			return r
+		}, false},
+		{"outside the signature window", func() *http.Request {
+			return forwardedRequest(t, "nonce-5", now.Add(-2*auth.SignatureWindow), body)
+		}, false},
+		{"body changed after signing", func() *http.Request {
+			r := forwardedRequest(t, "nonce-6", now, body)
+			r.Body = io.NopCloser(bytes.NewReader([]byte(`{"symbol":"BTC-USD","side":"buy","price":100,"quantity":100}`)))
+			return r
+		}, false},
+		{"caller changed after signing", func() *http.Request {
+			r := forwardedRequest(t, "nonce-7", now, body)
+			admin, _ := json.Marshal(auth.Principal{UserID: "alice", Permissions: []string{auth.PermAdmin}})
+			r.Header.Set(HeaderForwardedFor, base64.RawURLEncoding.EncodeToString(admin))
+			return r
+		}, false},
+		{"signed under another secret", func() *http.Request {
+			settings.PeerSecret = "other-secret"
+			defer func() { settings.PeerSecret = "peer-secret" }()
+			return forwardedRequest(t, "nonce-8", now, body)
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := test.request()
+			principal, err := forwardedPrincipal(r)
+			if (err == nil) != test.valid {
+				t.Fatalf("forwardedPrincipal() = %v, want valid %v", err, test.valid)
+			}
+			if !test.valid {
+				return
+			}
+			if principal.UserID != "alice" || !principal.Has(auth.PermTrade) {
+				t.Fatalf("principal %+v", principal)
+			}
+			if rest, _ := io.ReadAll(r.Body); string(rest) != body {
+				t.Fatalf("body not restored for the handler: %q", rest)
+			}
+		})
+	}
+}
+
+func TestTrustPeersRefusesReplay(t *testing.T) {
+	testPeers(t)
+	served := 0
+	handler := TrustPeers(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := auth.FromContext(r.Context()); !ok {
+			t.Error("forwarded caller not in the context")
+		}
+		served++
+	}))
+	request := forwardedRequest(t, "replayed", time.Now(), "{}")
+	replay := request.Clone(request.Context())
+	replay.Body = io.NopCloser(bytes.NewReader([]byte("{}")))
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		status  int
+	}{
+		{"first delivery", request, http.StatusOK},
+		{"replay", replay, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, test.request)
+		if recorder.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, recorder.Code, test.status)
+		}
+	}
+	if served != 1 {
+		t.Fatalf("handler served %d requests, want 1", served)
+	}
+}
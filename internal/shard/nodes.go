@@ -0,0 +1,126 @@
+package shard
+
+import (
+	"context"
+	"mfus_OMV1/internal/leader"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Node is an engine node taking part in the ring
+type Node struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+var nodesMutex sync.Mutex
+var liveNodes []Node
+
+// nodesKey is a sorted set of the node IDs scored by the expiry of their
+// heartbeat, addressesKey a hash of their advertised URLs
+func nodesKey() string {
+	return settings.KeyPrefix + "nodes"
+}
+
+func addressesKey() string {
+	return settings.KeyPrefix + "addresses"
+}
+
+// heartbeat keeps this node in the ring for another heartbeat TTL
+func heartbeat(ctx context.Context) error {
+	id := leader.InstanceID()
+	expires := time.Now().Add(settings.HeartbeatTTL).UnixMilli()
+	_, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, nodesKey(), &redis.Z{Score: float64(expires), Member: id})
+		pipe.HSet(ctx, addressesKey(), id, settings.AdvertiseURL)
+		return nil
+	})
+	return err
+}
+
+// Leave takes this node out of the ring, its symbols move to the other nodes
+// at the next rebalance instead of after its heartbeat expires
+func Leave(ctx context.Context) error {
+	if !settings.Enabled {
+		return nil
+	}
+	id := leader.InstanceID()
+	_, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, nodesKey(), id)
+		pipe.HDel(ctx, addressesKey(), id)
+		return nil
+	})
+	return err
+}
+
+// loadNodes reads the nodes whose heartbeat has not expired, ordered by ID
+func loadNodes(ctx context.Context) ([]Node, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	ids, err := redisClient.ZRangeByScore(ctx, nodesKey(), &redis.ZRangeBy{Min: "(" + now, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]Node, 0, len(ids))
+	if len(ids) > 0 {
+		urls, err := redisClient.HMGet(ctx, addressesKey(), ids...).Result()
+		if err != nil {
+			return nil, err
+		}
+		for i, id := range ids {
+			url, _ := urls[i].(string)
+			nodes = append(nodes, Node{ID: id, URL: url})
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+	nodesMutex.Lock()
+	liveNodes = nodes
+	nodesMutex.Unlock()
+	return nodes, nil
+}
+
+// pruneNodes forgets the nodes whose heartbeat expired
+func pruneNodes(ctx context.Context) error {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	expired, err := redisClient.ZRangeByScore(ctx, nodesKey(), &redis.ZRangeBy{Min: "-inf", Max: now}).Result()
+	if err != nil || len(expired) == 0 {
+		return err
+	}
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, nodesKey(), "-inf", now)
+		pipe.HDel(ctx, addressesKey(), expired...)
+		return nil
+	})
+	return err
+}
+
+// Nodes returns the live nodes as last read
+func Nodes() []Node {
+	nodesMutex.Lock()
+	defer nodesMutex.Unlock()
+	return append([]Node(nil), liveNodes...)
+}
+
+// node returns a live node by ID
+func node(id string) (Node, bool) {
+	for _, n := range Nodes() {
+		if n.ID == id {
+			return n, true
+		}
+	}
+	return Node{}, false
+}
+
+// ring builds the hash ring of the live nodes as last read
+func ring() *Ring {
+	nodes := Nodes()
+	ids := make([]string, len(nodes))
+	for i, n := range nodes {
+		ids[i] = n.ID
+	}
+	return NewRing(ids, settings.VirtualNodes)
+}
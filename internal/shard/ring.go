@@ -0,0 +1,55 @@
+package shard
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Ring is a consistent hash ring of nodes. Each node is placed on the ring
+// several times so that symbols spread evenly, and a node joining or leaving
+// only moves the symbols next to its points.
+type Ring struct {
+	points []uint64
+	owners map[uint64]string
+}
+
+// NewRing places every node virtualNodes times on the ring
+func NewRing(nodes []string, virtualNodes int) *Ring {
+	ring := &Ring{owners: make(map[uint64]string, len(nodes)*virtualNodes)}
+	for _, node := range nodes {
+		for i := 0; i < virtualNodes; i++ {
+			point := hash(node + "#" + strconv.Itoa(i))
+			// On a collision the smaller ID wins, so every node builds the same ring
+			if owner, ok := ring.owners[point]; ok && owner < node {
+				continue
+			}
+			if _, ok := ring.owners[point]; !ok {
+				ring.points = append(ring.points, point)
+			}
+			ring.owners[point] = node
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return ring
+}
+
+// Owner returns the node of the first point at or after the key, or false
+// when the ring is empty
+func (r *Ring) Owner(key string) (string, bool) {
+	if len(r.points) == 0 {
+		return "", false
+	}
+	point := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= point })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]], true
+}
+
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
@@ -0,0 +1,199 @@
+// Package shard partitions the symbols between the engine nodes. Nodes
+// register in Redis with a heartbeat, and each symbol is assigned in MongoDB
+// to the node that owns it on a consistent hash ring of the live nodes. The
+// owner leads the symbol's matcher through the leader package, the other
+// nodes forward its orders to the owner and stand by.
+//
+// One node at a time, the holder of the coordinator lease, compares the
+// assignments with the ring. A symbol whose node left is reassigned at once,
+// its lease expires and fencing refuses late writes of the old node. A symbol
+// that must move off a live node is handed over: the node stops matching it,
+// writes a snapshot and releases its lease, then the target takes it from
+// the snapshot and the journal.
+package shard
+
+import (
+	"context"
+	"mfus_OMV1/internal/config"
+	"mfus_OMV1/internal/leader"
+	"mfus_OMV1/internal/logging"
+	"mfus_OMV1/pkg/database"
+	"mfus_OMV1/utils"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// coordinatorLease is the name of the lease held by the node that rebalances
+const coordinatorLease = "coordinator"
+
+var logger = logging.Logger("shard")
+
+var settings = config.Default().Shard
+var dbName = "orderbook"
+var assignmentsCollection = "symbol_assignments"
+var redisClient *redis.Client
+
+// Configure applies the loaded config
+func Configure(cfg *config.Config) {
+	settings = cfg.Shard
+	dbName = cfg.Mongo.Database
+	assignmentsCollection = cfg.Collections.Assignments
+}
+
+// Init sets the Redis client the node registry is kept in
+func Init(client *redis.Client) {
+	redisClient = client
+}
+
+// Enabled reports whether symbols are partitioned between nodes
+func Enabled() bool {
+	return settings.Enabled
+}
+
+// Run keeps this node in the ring, takes over the symbols handed to it and, on
+// the coordinator, rebalances the assignments until the context ends. symbols
+// lists the symbols that need an owner.
+func Run(ctx context.Context, symbols func(ctx context.Context) ([]string, error)) {
+	if !settings.Enabled {
+		return
+	}
+	mongoClient, err := database.GetMongoClient()
+	if err != nil {
+		logger.Error("cannot create MongoDB client", "error", err)
+		return
+	}
+
+	ticker := time.NewTicker(settings.HeartbeatTTL / 3)
+	defer ticker.Stop()
+	var rebalanced time.Time
+	for {
+		if err := heartbeat(ctx); err != nil {
+			logger.Warn("cannot send heartbeat", "error", err)
+		}
+		if _, err := loadNodes(ctx); err != nil {
+			logger.Warn("cannot load nodes", "error", err)
+		}
+		if err := activate(ctx, mongoClient); err != nil {
+			logger.Warn("cannot take over symbols", "error", err)
+		}
+		if _, ok := leader.Lead(ctx, coordinatorLease); ok && time.Since(rebalanced) >= settings.RebalanceInterval {
+			rebalanced = time.Now()
+			if err := rebalance(ctx, mongoClient, symbols); err != nil {
+				logger.Warn("cannot rebalance symbols", "error", err)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rebalance assigns new symbols and moves the assigned ones whose owner on
+// the ring changed
+func rebalance(ctx context.Context, mongoClient *mongo.Client, symbols func(ctx context.Context) ([]string, error)) error {
+	if err := pruneNodes(ctx); err != nil {
+		return err
+	}
+	nodes, err := loadNodes(ctx)
+	if err != nil || len(nodes) == 0 {
+		return err
+	}
+	live := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		live[n.ID] = true
+	}
+	r := ring()
+
+	listed, err := GetAssignments(ctx, mongoClient)
+	if err != nil {
+		return err
+	}
+	assigned := make(map[string]bool, len(listed))
+	for _, assignment := range listed {
+		assigned[assignment.Symbol] = true
+	}
+	names, err := symbols(ctx)
+	if err != nil {
+		return err
+	}
+	for _, symbol := range names {
+		if !assigned[symbol] {
+			assign(ctx, mongoClient, symbol)
+		}
+	}
+
+	now := utils.GetCurrentTimestamp()
+	for _, assignment := range listed {
+		owner, _ := r.Owner(assignment.Symbol)
+		switch {
+		case assignment.State == StateActive && !live[assignment.Node]:
+			err = transition(ctx, mongoClient, assignment, owner, StateActive, "", "node left the ring")
+		case assignment.State == StateActive && assignment.Node != owner:
+			err = transition(ctx, mongoClient, assignment, assignment.Node, StateMoving, owner, "ring changed")
+		case assignment.State == StateActive:
+			continue
+		case !live[assignment.Target]:
+			err = transition(ctx, mongoClient, assignment, owner, StateActive, "", "target left the ring")
+		case assignment.State == StateMoving && !live[assignment.Node]:
+			err = transition(ctx, mongoClient, assignment, assignment.Target, StateActive, "", "node left the ring")
+		case time.Duration(now-assignment.UpdateTime)*time.Millisecond > settings.HandoverTimeout:
+			err = transition(ctx, mongoClient, assignment, assignment.Target, StateActive, "", "handover timed out")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	forgetAssignments()
+	return nil
+}
+
+// transition moves a symbol to a new node or state unless its assignment
+// changed since it was read
+func transition(ctx context.Context, mongoClient *mongo.Client, assignment AssignmentModel, node string, state string, target string, reason string) error {
+	set := bson.M{"node": node, "state": state, "epoch": assignment.Epoch + 1, "updateTime": utils.GetCurrentTimestamp()}
+	update := bson.M{"$set": set}
+	if target != "" {
+		set["target"] = target
+	} else {
+		update["$unset"] = bson.M{"target": ""}
+	}
+	result, err := collection(mongoClient).UpdateOne(ctx, bson.M{"_id": assignment.Symbol, "epoch": assignment.Epoch}, update)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		logger.Info("symbol reassigned", "symbol", assignment.Symbol, "from", assignment.Node, "node", node,
+			"state", state, "target", target, "reason", reason)
+	}
+	return nil
+}
+
+// Details is shown next to the shard check
+type Details struct {
+	Enabled bool     `json:"enabled"`
+	Node    string   `json:"node"`
+	Nodes   []Node   `json:"nodes"`
+	Owned   []string `json:"owned"`
+}
+
+// CheckShard shows the live nodes and the symbols this node owns. It never
+// fails, a node without symbols still forwards orders.
+func CheckShard(ctx context.Context) (interface{}, error) {
+	details := Details{Enabled: settings.Enabled, Node: leader.InstanceID(), Nodes: Nodes(), Owned: make([]string, 0)}
+	assignmentsMutex.Lock()
+	for symbol, assignment := range assignments {
+		if assignment.Node == details.Node && assignment.State == StateActive {
+			details.Owned = append(details.Owned, symbol)
+		}
+	}
+	assignmentsMutex.Unlock()
+	sort.Strings(details.Owned)
+	return details, nil
+}
@@ -2,13 +2,20 @@ package database
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"mfus_OMV1/internal/config"
+	"mfus_OMV1/internal/logging"
+	"mfus_OMV1/internal/metrics"
+	"mfus_OMV1/utils"
 	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 // DBConn is a MongoDB connection struct
@@ -17,26 +24,73 @@ type DBConn struct {
 	Collection *mongo.Collection
 }
 
-var mongoOnce sync.Once
+var logger = logging.Logger("database")
+
+var mongoMutex sync.Mutex
 var mongoClient *mongo.Client
 
+var redisOnce sync.Once
+var redisClient *redis.Client
+
+// settings holds the connection settings, Configure replaces the defaults
+var settings = config.Default()
+
+// Configure applies the loaded config, it must be called before the first
+// client is created
+func Configure(cfg *config.Config) {
+	settings = cfg
+}
+
+// GetMongoClient returns the shared MongoDB client. The driver connects in
+// the background and reconnects on its own, so an unreachable server shows up
+// as failing operations and pings rather than as an error here.
 func GetMongoClient() (*mongo.Client, error) {
-	var err error
-	mongoOnce.Do(func() {
-		mongoClientOptions := options.Client().ApplyURI("mongodb://localhost:27017")
-		mongoClientOptions.SetMaxPoolSize(100)
-		mongoClient, err = mongo.NewClient(mongoClientOptions)
-		if err != nil {
-			log.Fatal(err)
-		}
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		err = mongoClient.Connect(ctx)
-		if err != nil {
-			log.Fatal(err)
-		}
+	mongoMutex.Lock()
+	defer mongoMutex.Unlock()
+	if mongoClient != nil {
+		return mongoClient, nil
+	}
+
+	mongoClientOptions := options.Client().ApplyURI(settings.Mongo.URI)
+	mongoClientOptions.SetMinPoolSize(settings.Mongo.MinPoolSize)
+	mongoClientOptions.SetMaxPoolSize(settings.Mongo.MaxPoolSize)
+	mongoClientOptions.SetMonitor(commandMonitor)
+	client, err := mongo.NewClient(mongoClientOptions)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), settings.Mongo.ConnectTimeout)
+	defer cancel()
+	if err := client.Connect(ctx); err != nil {
+		return nil, err
+	}
+	mongoClient = client
+	return mongoClient, nil
+}
+
+// Transaction runs fn in a MongoDB transaction, committed only if fn returns
+// nil. The operations of fn must use the context it is given. Called with the
+// context of a transaction in progress, fn joins that transaction instead.
+// Transactions need a replica set, a single node one is enough.
+func Transaction(ctx context.Context, client *mongo.Client, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+	return client.UseSession(ctx, func(sc mongo.SessionContext) error {
+		_, err := sc.WithTransaction(sc, func(sc mongo.SessionContext) (interface{}, error) {
+			return nil, fn(sc)
+		})
+		return err
 	})
-	return mongoClient, err
+}
+
+// PingMongo checks that the primary answers
+func PingMongo(ctx context.Context) error {
+	client, err := GetMongoClient()
+	if err != nil {
+		return err
+	}
+	return client.Ping(ctx, readpref.Primary())
 }
 
 // To Do: Need to remove this method after verifing the  NewRedisClient
@@ -77,18 +131,103 @@ func RedisClient(key string, value string) {
 	return nil
 } */
 
-func NewRedisClient() (*redis.Client, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "", // no password set
-		DB:       0,  // use default DB
+// GetRedisClient returns the shared Redis client. Connections are opened per
+// command, so the client recovers by itself once Redis is back.
+func GetRedisClient() *redis.Client {
+	redisOnce.Do(func() {
+		redisClient = redis.NewClient(&redis.Options{
+			Addr:     settings.Redis.Addr,
+			Password: settings.Redis.Password,
+			DB:       settings.Redis.DB,
+			PoolSize: settings.Redis.PoolSize,
+		})
+		redisClient.AddHook(redisHook{})
 	})
+	return redisClient
+}
 
-	// test connection
-	_, err := client.Ping(context.Background()).Result()
-	if err != nil {
-		return nil, err
+// PingRedis checks that Redis answers
+func PingRedis(ctx context.Context) error {
+	return GetRedisClient().Ping(ctx).Err()
+}
+
+// Disconnect closes the shared MongoDB and Redis clients at shutdown. Later
+// operations on them fail instead of connecting again.
+func Disconnect(ctx context.Context) error {
+	var errs []error
+	mongoMutex.Lock()
+	if mongoClient != nil {
+		if err := mongoClient.Disconnect(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("mongo: %w", err))
+		}
+	}
+	mongoMutex.Unlock()
+	if err := GetRedisClient().Close(); err != nil {
+		errs = append(errs, fmt.Errorf("redis: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// WaitFor retries a ping with backoff until it succeeds or the context ends
+func WaitFor(ctx context.Context, name string, ping func(ctx context.Context) error) error {
+	backoff := utils.Backoff{Min: settings.Health.RetryMinDelay, Max: settings.Health.RetryMaxDelay}
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, settings.Health.Timeout)
+		err := ping(pingCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		delay := backoff.Next()
+		logger.Warn("dependency unavailable, retrying", "dependency", name, "retryIn", delay.String(), "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// commandMonitor records the latency and failures of every MongoDB command
+var commandMonitor = &event.CommandMonitor{
+	Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+		metrics.ObserveMongo(e.CommandName, time.Duration(e.DurationNanos), false)
+	},
+	Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+		metrics.ObserveMongo(e.CommandName, time.Duration(e.DurationNanos), true)
+	},
+}
+
+type redisStartKey struct{}
+
+// redisHook records the latency and failures of every Redis command
+type redisHook struct{}
+
+func (redisHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		err := cmd.Err()
+		metrics.ObserveRedis(cmd.Name(), time.Since(start), err != nil && err != redis.Nil)
 	}
+	return nil
+}
 
-	return client, nil
+func (redisHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		failed := false
+		for _, cmd := range cmds {
+			if err := cmd.Err(); err != nil && err != redis.Nil {
+				failed = true
+			}
+		}
+		metrics.ObserveRedis("pipeline", time.Since(start), failed)
+	}
+	return nil
 }
@@ -0,0 +1,5 @@
+// Package orderbookpb holds the protobuf messages and gRPC stubs of the
+// order entry and market data service defined in proto/orderbook/v1.
+package orderbookpb
+
+//go:generate protoc -I ../../proto --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative orderbook/v1/orderbook.proto
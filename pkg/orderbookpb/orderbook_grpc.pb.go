@@ -0,0 +1,456 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v24.4.0
+// source: orderbook/v1/orderbook.proto
+
+package orderbookpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	OrderBook_PlaceOrder_FullMethodName   = "/orderbook.v1.OrderBook/PlaceOrder"
+	OrderBook_AmendOrder_FullMethodName   = "/orderbook.v1.OrderBook/AmendOrder"
+	OrderBook_CancelOrder_FullMethodName  = "/orderbook.v1.OrderBook/CancelOrder"
+	OrderBook_GetOrder_FullMethodName     = "/orderbook.v1.OrderBook/GetOrder"
+	OrderBook_QueryOrders_FullMethodName  = "/orderbook.v1.OrderBook/QueryOrders"
+	OrderBook_StreamOrders_FullMethodName = "/orderbook.v1.OrderBook/StreamOrders"
+	OrderBook_StreamTrades_FullMethodName = "/orderbook.v1.OrderBook/StreamTrades"
+	OrderBook_StreamDepth_FullMethodName  = "/orderbook.v1.OrderBook/StreamDepth"
+)
+
+// OrderBookClient is the client API for OrderBook service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type OrderBookClient interface {
+	PlaceOrder(ctx context.Context, in *PlaceOrderRequest, opts ...grpc.CallOption) (*Order, error)
+	AmendOrder(ctx context.Context, in *AmendOrderRequest, opts ...grpc.CallOption) (*Order, error)
+	CancelOrder(ctx context.Context, in *CancelOrderRequest, opts ...grpc.CallOption) (*Order, error)
+	GetOrder(ctx context.Context, in *GetOrderRequest, opts ...grpc.CallOption) (*Order, error)
+	QueryOrders(ctx context.Context, in *QueryOrdersRequest, opts ...grpc.CallOption) (*QueryOrdersResponse, error)
+	// StreamOrders pushes every change to the caller's orders
+	StreamOrders(ctx context.Context, in *StreamOrdersRequest, opts ...grpc.CallOption) (OrderBook_StreamOrdersClient, error)
+	// StreamTrades pushes the public trades of a symbol
+	StreamTrades(ctx context.Context, in *StreamTradesRequest, opts ...grpc.CallOption) (OrderBook_StreamTradesClient, error)
+	// StreamDepth sends the current L2 book of a symbol, then every change
+	StreamDepth(ctx context.Context, in *StreamDepthRequest, opts ...grpc.CallOption) (OrderBook_StreamDepthClient, error)
+}
+
+type orderBookClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewOrderBookClient(cc grpc.ClientConnInterface) OrderBookClient {
+	return &orderBookClient{cc}
+}
+
+func (c *orderBookClient) PlaceOrder(ctx context.Context, in *PlaceOrderRequest, opts ...grpc.CallOption) (*Order, error) {
+	out := new(Order)
+	err := c.cc.Invoke(ctx, OrderBook_PlaceOrder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderBookClient) AmendOrder(ctx context.Context, in *AmendOrderRequest, opts ...grpc.CallOption) (*Order, error) {
+	out := new(Order)
+	err := c.cc.Invoke(ctx, OrderBook_AmendOrder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderBookClient) CancelOrder(ctx context.Context, in *CancelOrderRequest, opts ...grpc.CallOption) (*Order, error) {
+	out := new(Order)
+	err := c.cc.Invoke(ctx, OrderBook_CancelOrder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderBookClient) GetOrder(ctx context.Context, in *GetOrderRequest, opts ...grpc.CallOption) (*Order, error) {
+	out := new(Order)
+	err := c.cc.Invoke(ctx, OrderBook_GetOrder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderBookClient) QueryOrders(ctx context.Context, in *QueryOrdersRequest, opts ...grpc.CallOption) (*QueryOrdersResponse, error) {
+	out := new(QueryOrdersResponse)
+	err := c.cc.Invoke(ctx, OrderBook_QueryOrders_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderBookClient) StreamOrders(ctx context.Context, in *StreamOrdersRequest, opts ...grpc.CallOption) (OrderBook_StreamOrdersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &OrderBook_ServiceDesc.Streams[0], OrderBook_StreamOrders_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &orderBookStreamOrdersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type OrderBook_StreamOrdersClient interface {
+	Recv() (*Order, error)
+	grpc.ClientStream
+}
+
+type orderBookStreamOrdersClient struct {
+	grpc.ClientStream
+}
+
+func (x *orderBookStreamOrdersClient) Recv() (*Order, error) {
+	m := new(Order)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *orderBookClient) StreamTrades(ctx context.Context, in *StreamTradesRequest, opts ...grpc.CallOption) (OrderBook_StreamTradesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &OrderBook_ServiceDesc.Streams[1], OrderBook_StreamTrades_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &orderBookStreamTradesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type OrderBook_StreamTradesClient interface {
+	Recv() (*Trade, error)
+	grpc.ClientStream
+}
+
+type orderBookStreamTradesClient struct {
+	grpc.ClientStream
+}
+
+func (x *orderBookStreamTradesClient) Recv() (*Trade, error) {
+	m := new(Trade)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *orderBookClient) StreamDepth(ctx context.Context, in *StreamDepthRequest, opts ...grpc.CallOption) (OrderBook_StreamDepthClient, error) {
+	stream, err := c.cc.NewStream(ctx, &OrderBook_ServiceDesc.Streams[2], OrderBook_StreamDepth_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &orderBookStreamDepthClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type OrderBook_StreamDepthClient interface {
+	Recv() (*Depth, error)
+	grpc.ClientStream
+}
+
+type orderBookStreamDepthClient struct {
+	grpc.ClientStream
+}
+
+func (x *orderBookStreamDepthClient) Recv() (*Depth, error) {
+	m := new(Depth)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// OrderBookServer is the server API for OrderBook service.
+// All implementations must embed UnimplementedOrderBookServer
+// for forward compatibility
+type OrderBookServer interface {
+	PlaceOrder(context.Context, *PlaceOrderRequest) (*Order, error)
+	AmendOrder(context.Context, *AmendOrderRequest) (*Order, error)
+	CancelOrder(context.Context, *CancelOrderRequest) (*Order, error)
+	GetOrder(context.Context, *GetOrderRequest) (*Order, error)
+	QueryOrders(context.Context, *QueryOrdersRequest) (*QueryOrdersResponse, error)
+	// StreamOrders pushes every change to the caller's orders
+	StreamOrders(*StreamOrdersRequest, OrderBook_StreamOrdersServer) error
+	// StreamTrades pushes the public trades of a symbol
+	StreamTrades(*StreamTradesRequest, OrderBook_StreamTradesServer) error
+	// StreamDepth sends the current L2 book of a symbol, then every change
+	StreamDepth(*StreamDepthRequest, OrderBook_StreamDepthServer) error
+	mustEmbedUnimplementedOrderBookServer()
+}
+
+// UnimplementedOrderBookServer must be embedded to have forward compatible implementations.
+type UnimplementedOrderBookServer struct {
+}
+
+func (UnimplementedOrderBookServer) PlaceOrder(context.Context, *PlaceOrderRequest) (*Order, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PlaceOrder not implemented")
+}
+func (UnimplementedOrderBookServer) AmendOrder(context.Context, *AmendOrderRequest) (*Order, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AmendOrder not implemented")
+}
+func (UnimplementedOrderBookServer) CancelOrder(context.Context, *CancelOrderRequest) (*Order, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelOrder not implemented")
+}
+func (UnimplementedOrderBookServer) GetOrder(context.Context, *GetOrderRequest) (*Order, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetOrder not implemented")
+}
+func (UnimplementedOrderBookServer) QueryOrders(context.Context, *QueryOrdersRequest) (*QueryOrdersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryOrders not implemented")
+}
+func (UnimplementedOrderBookServer) StreamOrders(*StreamOrdersRequest, OrderBook_StreamOrdersServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamOrders not implemented")
+}
+func (UnimplementedOrderBookServer) StreamTrades(*StreamTradesRequest, OrderBook_StreamTradesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamTrades not implemented")
+}
+func (UnimplementedOrderBookServer) StreamDepth(*StreamDepthRequest, OrderBook_StreamDepthServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamDepth not implemented")
+}
+func (UnimplementedOrderBookServer) mustEmbedUnimplementedOrderBookServer() {}
+
+// UnsafeOrderBookServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to OrderBookServer will
+// result in compilation errors.
+type UnsafeOrderBookServer interface {
+	mustEmbedUnimplementedOrderBookServer()
+}
+
+func RegisterOrderBookServer(s grpc.ServiceRegistrar, srv OrderBookServer) {
+	s.RegisterService(&OrderBook_ServiceDesc, srv)
+}
+
+func _OrderBook_PlaceOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PlaceOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderBookServer).PlaceOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrderBook_PlaceOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderBookServer).PlaceOrder(ctx, req.(*PlaceOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderBook_AmendOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AmendOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderBookServer).AmendOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrderBook_AmendOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderBookServer).AmendOrder(ctx, req.(*AmendOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderBook_CancelOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderBookServer).CancelOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrderBook_CancelOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderBookServer).CancelOrder(ctx, req.(*CancelOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderBook_GetOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderBookServer).GetOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrderBook_GetOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderBookServer).GetOrder(ctx, req.(*GetOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderBook_QueryOrders_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryOrdersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderBookServer).QueryOrders(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrderBook_QueryOrders_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderBookServer).QueryOrders(ctx, req.(*QueryOrdersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderBook_StreamOrders_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamOrdersRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OrderBookServer).StreamOrders(m, &orderBookStreamOrdersServer{stream})
+}
+
+type OrderBook_StreamOrdersServer interface {
+	Send(*Order) error
+	grpc.ServerStream
+}
+
+type orderBookStreamOrdersServer struct {
+	grpc.ServerStream
+}
+
+func (x *orderBookStreamOrdersServer) Send(m *Order) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _OrderBook_StreamTrades_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamTradesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OrderBookServer).StreamTrades(m, &orderBookStreamTradesServer{stream})
+}
+
+type OrderBook_StreamTradesServer interface {
+	Send(*Trade) error
+	grpc.ServerStream
+}
+
+type orderBookStreamTradesServer struct {
+	grpc.ServerStream
+}
+
+func (x *orderBookStreamTradesServer) Send(m *Trade) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _OrderBook_StreamDepth_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamDepthRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OrderBookServer).StreamDepth(m, &orderBookStreamDepthServer{stream})
+}
+
+type OrderBook_StreamDepthServer interface {
+	Send(*Depth) error
+	grpc.ServerStream
+}
+
+type orderBookStreamDepthServer struct {
+	grpc.ServerStream
+}
+
+func (x *orderBookStreamDepthServer) Send(m *Depth) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// OrderBook_ServiceDesc is the grpc.ServiceDesc for OrderBook service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var OrderBook_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "orderbook.v1.OrderBook",
+	HandlerType: (*OrderBookServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PlaceOrder",
+			Handler:    _OrderBook_PlaceOrder_Handler,
+		},
+		{
+			MethodName: "AmendOrder",
+			Handler:    _OrderBook_AmendOrder_Handler,
+		},
+		{
+			MethodName: "CancelOrder",
+			Handler:    _OrderBook_CancelOrder_Handler,
+		},
+		{
+			MethodName: "GetOrder",
+			Handler:    _OrderBook_GetOrder_Handler,
+		},
+		{
+			MethodName: "QueryOrders",
+			Handler:    _OrderBook_QueryOrders_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamOrders",
+			Handler:       _OrderBook_StreamOrders_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamTrades",
+			Handler:       _OrderBook_StreamTrades_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamDepth",
+			Handler:       _OrderBook_StreamDepth_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "orderbook/v1/orderbook.proto",
+}
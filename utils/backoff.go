@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff computes exponentially growing retry delays with jitter
+type Backoff struct {
+	Min     time.Duration
+	Max     time.Duration
+	attempt int
+}
+
+// Next returns the delay before the next attempt
+func (b *Backoff) Next() time.Duration {
+	delay := b.Min << b.attempt
+	if delay <= 0 || delay > b.Max {
+		delay = b.Max
+	} else {
+		b.attempt++
+	}
+	// Up to 20% jitter keeps instances from retrying in lockstep
+	return delay - time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+// Reset starts over from the minimum delay after a success
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}